@@ -7,16 +7,23 @@ package client
 
 import (
     "bytes"
+    "crypto/sha256"
     "crypto/tls"
+    "encoding/base64"
+    "encoding/hex"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
     "io/ioutil"
     "mime/multipart"
     "net/http"
+    neturl "net/url"
     "os"
     "path/filepath"
+    "strconv"
     "strings"
+    "time"
 )
 
 type Client struct {
@@ -24,14 +31,73 @@ type Client struct {
 
 const (
    listURI  string = "api/v1/file/list"
+   pageListURI string = "api/v1/file/pagelist"
    putURI   string = "api/v1/file/put"
    getURI   string = "api/v1/file/get"
+   archiveURI string = "api/v1/file/archive"
+   extractURI string = "api/v1/file/extract"
+   copyURI    string = "api/v1/file/copy"
+   moveURI    string = "api/v1/file/move"
    deleteURI  string = "api/v1/file/delete"
+   uploadURI  string = "api/v1/upload"
+   versionsURI  string = "api/v1/file/versions"
+   restoreURI   string = "api/v1/file/restore"
+   purgeURI     string = "api/v1/file/purge"
+   versioningURI  string = "api/v1/bucket/versioning"
+   compressionURI string = "api/v1/bucket/compression"
+   encryptionURI  string = "api/v1/bucket/encryption"
+   tagsURI      string = "api/v1/file/tags"
+   bucketCreateURI  string = "api/v1/bucket/create"
+   bucketDeleteURI  string = "api/v1/bucket/delete"
+   bucketRenameURI  string = "api/v1/bucket/rename"
+   bucketInfoURI    string = "api/v1/bucket/info"
+   quotaUsageURI    string = "api/v1/quota/usage"
+   quotaListURI     string = "api/v1/quota/list"
+   quotaSetURI      string = "api/v1/quota/set"
+   lifecycleURI     string = "api/v1/bucket/lifecycle"
+   dryrunURI        string = "api/v1/bucket/lifecycle/dryrun"
+   dedupURI         string = "api/v1/status/dedup"
+   masterKeyURI     string = "api/v1/status/encryption"
+   scrubURI         string = "api/v1/status/scrub"
+
+   metaPrefix       string = "x-meta-"
+
+   tusVersion       string = "1.0.0"
+   uploadChunkSize  int64 = 64 * 1024 * 1024
+   uploadRetries    int = 5
 )
 
 type ListForm struct {
     Bucket      string  `json:"bucket"`
     Pattern     string  `json:"pattern"`
+    Tag         string  `json:"tag,omitempty"`
+}
+
+/* Listing by key prefix, keys are grouped to common prefixes
+ * up to the delimiter, slash by default, unless recursive.
+ * Files are sorted by name, size or mtime and filtered by size
+ * and modification time, cursor pages continue with the token */
+type TreeForm struct {
+    Bucket      string  `json:"bucket"`
+    Pattern     string  `json:"pattern,omitempty"`
+    Tag         string  `json:"tag,omitempty"`
+    Prefix      string  `json:"prefix,omitempty"`
+    Delimiter   string  `json:"delimiter,omitempty"`
+    Recursive   bool    `json:"recursive,omitempty"`
+    Sort        string  `json:"sort,omitempty"`
+    Order       string  `json:"order,omitempty"`
+    MinSize     int64   `json:"minsize,omitempty"`
+    MaxSize     int64   `json:"maxsize,omitempty"`
+    Since       string  `json:"since,omitempty"`
+    Cursor      bool    `json:"cursor,omitempty"`
+    Token       string  `json:"token,omitempty"`
+    Offset      int     `json:"offset"`
+    Limit       int     `json:"limit"`
+}
+
+/* List files and common prefixes of the bucket, zero limit lists all files */
+func (this *Client) Tree(hostname, username, password string, form TreeForm) (string, error) {
+    return this.post(hostname, username, password, pageListURI, form)
 }
 
 type File struct {
@@ -47,6 +113,11 @@ type ListResult struct {
 }
 
 func (this *Client) List(hostname, username, password, bucket, pattern string) (string, error) {
+    return this.ListTag(hostname, username, password, bucket, pattern, "")
+}
+
+/* List files with the tag: key=value, or key for any value */
+func (this *Client) ListTag(hostname, username, password, bucket, pattern, tag string) (string, error) {
 
     var err error
     url := fmt.Sprintf("https://%s:%s@%s/%s", username, password, hostname, listURI)
@@ -54,6 +125,7 @@ func (this *Client) List(hostname, username, password, bucket, pattern string) (
     form := ListForm{
         Bucket: bucket,
         Pattern: pattern,
+        Tag: tag,
     }
 
     data, _ := json.Marshal(form)
@@ -78,103 +150,440 @@ func (this *Client) List(hostname, username, password, bucket, pattern string) (
     return string(body), nil
 }
 
-//type PutForm struct {
-//    Bucket      string  `json:"bucket"  form:"bucket"`
-//    Filename    string  `json:"pattern" form:"filename"`
-//}
+/* Upload state file name for the file, the same file to the same place
+ * resumes the upload */
+func uploadStatePath(hostname, username, bucket, filename string, fileInfo os.FileInfo) string {
+    cacheDir, err := os.UserCacheDir()
+    if err != nil {
+        cacheDir = os.TempDir()
+    }
+    absName, _ := filepath.Abs(filename)
+    key := fmt.Sprintf("%s\n%s\n%s\n%s\n%d\n%d", hostname, username, bucket, absName,
+                                    fileInfo.Size(), fileInfo.ModTime().UnixNano())
+    sum := sha256.Sum256([]byte(key))
+    return filepath.Join(cacheDir, "s2cli", hex.EncodeToString(sum[:]) + ".upload")
+}
 
-func (this *Client) Put(hostname, username, password, bucket, filename string) (string, error) {
+func newTransport() *http.Transport {
+    return &http.Transport{
+         TLSClientConfig: &tls.Config{ InsecureSkipVerify: true },
+    }
+}
 
-    var err error
-    url := fmt.Sprintf("https://%s:%s@%s/%s", username, password, hostname, putURI)
+func uploadRequest(method, url string, body io.Reader) (*http.Request, error) {
+    req, err := http.NewRequest(method, url, body)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Tus-Resumable", tusVersion)
+    return req, nil
+}
 
-    pipeOut, pipeIn := io.Pipe()
-    writer := multipart.NewWriter(pipeIn)
+/* Return offset and length of the upload */
+func uploadOffset(client *http.Client, url string) (int64, int64, error) {
+    req, err := uploadRequest("HEAD", url, nil)
+    if err != nil {
+        return 0, 0, err
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return 0, 0, err
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return 0, 0, errors.New(resp.Status)
+    }
+    offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+    if err != nil {
+        return 0, 0, err
+    }
+    length, err := strconv.ParseInt(resp.Header.Get("Upload-Length"), 10, 64)
+    return offset, length, err
+}
 
-    go func() {
-        defer pipeIn.Close()
-        defer writer.Close()
+/* Create upload, return upload path. Server verifies the checksum
+ * of the file when upload is completed */
+func uploadCreate(client *http.Client, url, bucket string, file *os.File, length int64,
+                                            meta map[string]string, tags string) (string, error) {
+    hash := sha256.New()
+    if _, err := io.Copy(hash, file); err != nil {
+        return "", err
+    }
+    if _, err := file.Seek(0, io.SeekStart); err != nil {
+        return "", err
+    }
+    req, err := uploadRequest("POST", url, nil)
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Upload-Length", strconv.FormatInt(length, 10))
+    metadata := []string{
+        "bucket " + base64.StdEncoding.EncodeToString([]byte(bucket)),
+        "filename " + base64.StdEncoding.EncodeToString([]byte(filepath.Base(file.Name()))),
+        "sha256 " + base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(hash.Sum(nil)))),
+    }
+    for key, value := range meta {
+        metadata = append(metadata, metaPrefix + key + " " + base64.StdEncoding.EncodeToString([]byte(value)))
+    }
+    if len(tags) > 0 {
+        metadata = append(metadata, "tags " + base64.StdEncoding.EncodeToString([]byte(tags)))
+    }
+    req.Header.Set("Upload-Metadata", strings.Join(metadata, ","))
+    resp, err := client.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated {
+        body, _ := ioutil.ReadAll(resp.Body)
+        return "", errors.New(fmt.Sprintf("%s %s", resp.Status, body))
+    }
+    location, err := neturl.Parse(resp.Header.Get("Location"))
+    if err != nil || len(location.Path) == 0 {
+        return "", errors.New("wrong upload location")
+    }
+    return location.Path, nil
+}
 
-        _ = writer.WriteField("filename", filepath.Base(filename))
-        _ = writer.WriteField("bucket", bucket)
+/* Send part of the file from the offset, return new offset */
+func uploadPatch(client *http.Client, url string, file *os.File, offset, length int64) (int64, error) {
+    size := length - offset
+    if size > uploadChunkSize {
+        size = uploadChunkSize
+    }
+    if _, err := file.Seek(offset, io.SeekStart); err != nil {
+        return offset, err
+    }
+    req, err := uploadRequest("PATCH", url, io.LimitReader(file, size))
+    if err != nil {
+        return offset, err
+    }
+    req.ContentLength = size
+    req.Header.Set("Content-Type", "application/offset+octet-stream")
+    req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
 
-        part, err := writer.CreateFormFile("file", filepath.Base(filename))
-        if err != nil {
-            return
-        }
-        file, err := os.Open(filename)
-        if err != nil {
-            return
-        }
-        defer file.Close()
-        if _, err = io.Copy(part, file); err != nil {
-            return
-        }
-    }()
+    resp, err := client.Do(req)
+    if err != nil {
+        return offset, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusNoContent {
+        body, _ := ioutil.ReadAll(resp.Body)
+        return offset, errors.New(fmt.Sprintf("%s %s", resp.Status, body))
+    }
+    return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
 
+/* Upload file with resumable upload protocol. Interrupted upload
+ * of the file is resumed from the last received byte */
+func (this *Client) Put(hostname, username, password, bucket, filename string) (string, error) {
+    return this.PutAttributes(hostname, username, password, bucket, filename, nil, "")
+}
 
-    transCfg := &http.Transport{
-         TLSClientConfig: &tls.Config{ InsecureSkipVerify: true },
-    }
-    client := &http.Client{ Transport: transCfg }
-    resp, err := client.Post(url, writer.FormDataContentType(), pipeOut)
+/* Upload file with user metadata and tags in query format: channel=stable&build=42 */
+func (this *Client) PutAttributes(hostname, username, password, bucket, filename string,
+                                            meta map[string]string, tags string) (string, error) {
+
+    file, err := os.Open(filename)
     if err != nil {
         return "", err
     }
+    defer file.Close()
 
-    body, err := ioutil.ReadAll(resp.Body)
+    fileInfo, err := file.Stat()
     if err != nil {
         return "", err
     }
-    return string(body), err
+    length := fileInfo.Size()
+
+    baseURL := fmt.Sprintf("https://%s:%s@%s", username, password, hostname)
+    client := &http.Client{ Transport: newTransport() }
+
+    /* Resume previous upload of the file */
+    statePath := uploadStatePath(hostname, username, bucket, filename, fileInfo)
+    var offset int64
+    var uploadPath string
+    if data, err := ioutil.ReadFile(statePath); err == nil {
+        uploadPath = strings.TrimSpace(string(data))
+        var uploadLength int64
+        offset, uploadLength, err = uploadOffset(client, baseURL + uploadPath)
+        if err != nil || uploadLength != length {
+            uploadPath = ""
+            offset = 0
+        }
+    }
+
+    if len(uploadPath) == 0 {
+        uploadPath, err = uploadCreate(client, baseURL + "/" + uploadURI, bucket, file, length, meta, tags)
+        if err != nil {
+            return "", err
+        }
+        if length == 0 {
+            return "201 Created", nil
+        }
+        _ = os.MkdirAll(filepath.Dir(statePath), 0700)
+        if err := ioutil.WriteFile(statePath, []byte(uploadPath), 0600); err != nil {
+            return "", err
+        }
+    }
+
+    retries := 0
+    for offset < length {
+        var newOffset int64
+        newOffset, err = uploadPatch(client, baseURL + uploadPath, file, offset, length)
+        if err == nil {
+            offset = newOffset
+            retries = 0
+            continue
+        }
+        retries++
+        if retries > uploadRetries {
+            return "", err
+        }
+        time.Sleep(time.Duration(retries) * time.Second)
+        if newOffset, _, err := uploadOffset(client, baseURL + uploadPath); err == nil {
+            offset = newOffset
+        }
+    }
+    os.Remove(statePath)
+    return "204 No Content", nil
 }
 
 type GetForm struct {
     Bucket      string  `json:"bucket"  form:"bucket"`
     Filename    string  `json:"filename" form:"filename"`
+    Version     string  `json:"version,omitempty" form:"version"`
 }
 
 func (this *Client) Get(hostname, username, password, bucket, filename string) (string, error) {
+    return this.GetVersion(hostname, username, password, bucket, filename, "")
+}
 
-   var err error
-    url := fmt.Sprintf("https://%s:%s@%s/%s", username, password, hostname,  getURI)
+/* Download state file name keeping entity tag of the partial download */
+func downloadStatePath(hostname, username, bucket, filename, version, outName string) string {
+    cacheDir, err := os.UserCacheDir()
+    if err != nil {
+        cacheDir = os.TempDir()
+    }
+    absName, _ := filepath.Abs(outName)
+    key := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s", hostname, username, bucket, filename, version, absName)
+    sum := sha256.Sum256([]byte(key))
+    return filepath.Join(cacheDir, "s2cli", hex.EncodeToString(sum[:]) + ".download")
+}
 
-    form := GetForm{
-        Bucket: bucket,
-        Filename: filename,
+/* Download the version of the file, current data if version is empty.
+ * Data is received into .part file, interrupted download is resumed
+ * with Range request while the file is not changed on the server */
+func (this *Client) GetVersion(hostname, username, password, bucket, filename, version string) (string, error) {
+
+    query := neturl.Values{}
+    query.Set("bucket", bucket)
+    query.Set("filename", filename)
+    if len(version) > 0 {
+        query.Set("version", version)
     }
+    url := fmt.Sprintf("https://%s:%s@%s/%s?%s", username, password, hostname, getURI, query.Encode())
 
-    data, _ := json.Marshal(form)
-    reader := bytes.NewReader([]byte(data))
+    outName := filepath.Base(filename)
+    partName := outName + ".part"
+    statePath := downloadStatePath(hostname, username, bucket, filename, version, outName)
 
+    out, err := os.OpenFile(partName, os.O_RDWR | os.O_CREATE, 0644)
+    if err != nil {
+        return "", err
+    }
+    defer out.Close()
 
-    transCfg := &http.Transport{
-         TLSClientConfig: &tls.Config{ InsecureSkipVerify: true },
+    /* Resume from the end of partial data of the same entity */
+    hash := sha256.New()
+    var offset int64
+    etag, _ := ioutil.ReadFile(statePath)
+    if len(etag) > 0 {
+        offset, err = io.Copy(hash, out)
+        if err != nil {
+            return "", err
+        }
     }
-    client := &http.Client{ Transport: transCfg }
 
-    resp, err := client.Post(url, "application/json", reader)
+    req, err := http.NewRequest(http.MethodGet, url, nil)
+    if err != nil {
+        return "", err
+    }
+    if offset > 0 {
+        req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+        req.Header.Set("If-Range", string(etag))
+    }
+
+    client := &http.Client{ Transport: newTransport() }
+    resp, err := client.Do(req)
     if err != nil {
         return "", err
     }
     defer resp.Body.Close()
 
-    if resp.StatusCode != http.StatusOK {
-        return resp.Status, nil
+    switch resp.StatusCode {
+        case http.StatusPartialContent:
+        case http.StatusOK:
+            /* Whole file is sent, partial data is outdated */
+            hash.Reset()
+            if err := out.Truncate(0); err != nil {
+                return "", err
+            }
+            if _, err := out.Seek(0, io.SeekStart); err != nil {
+                return "", err
+            }
+            etag := resp.Header.Get("ETag")
+            if len(etag) > 0 {
+                _ = os.MkdirAll(filepath.Dir(statePath), 0700)
+                _ = ioutil.WriteFile(statePath, []byte(etag), 0600)
+            }
+        default:
+            out.Close()
+            os.Remove(partName)
+            os.Remove(statePath)
+            return resp.Status, nil
     }
 
-    out, err := os.Create(filepath.Base(filename))
+    buf := make([]byte,  128 * 1024)
+    _, err = io.CopyBuffer(io.MultiWriter(out, hash), resp.Body, buf)
     if err != nil {
         return "", err
     }
-    defer out.Close()
-    //_, err = io.Copy(out, resp.Body)
+    out.Close()
+    os.Remove(statePath)
+
+    /* Verify checksum from Digest header */
+    expected := digestSHA256(resp.Header.Get("Digest"))
+    if len(expected) > 0 && !bytes.Equal(expected, hash.Sum(nil)) {
+        os.Remove(partName)
+        return "", errors.New("checksum mismatch of " + outName)
+    }
+    if err := os.Rename(partName, outName); err != nil {
+        return "", err
+    }
+    return resp.Status, nil
+}
+
+/* Archive format by the file name extension: zip, tar or tar.gz */
+func ArchiveFormat(outName string) (string, error) {
+    switch {
+        case strings.HasSuffix(outName, ".zip"):
+            return "zip", nil
+        case strings.HasSuffix(outName, ".tar"):
+            return "tar", nil
+        case strings.HasSuffix(outName, ".tar.gz"), strings.HasSuffix(outName, ".tgz"):
+            return "tar.gz", nil
+    }
+    return "", errors.New("unknown archive format of " + outName)
+}
+
+/* Download files of the bucket matching the pattern, all files if the
+ * pattern is empty, as archive streamed into .part file */
+func (this *Client) Archive(hostname, username, password, bucket, pattern string, recursive bool, outName string) (string, error) {
+    format, err := ArchiveFormat(outName)
+    if err != nil {
+        return "", err
+    }
+    query := neturl.Values{}
+    query.Set("bucket", bucket)
+    query.Set("format", format)
+    if len(pattern) > 0 {
+        query.Set("pattern", pattern)
+    }
+    if recursive {
+        query.Set("recursive", "true")
+    }
+    url := fmt.Sprintf("https://%s:%s@%s/%s?%s", username, password, hostname, archiveURI, query.Encode())
+
+    client := &http.Client{ Transport: newTransport() }
+    resp, err := client.Get(url)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        body, _ := ioutil.ReadAll(resp.Body)
+        return resp.Status + " " + string(body), nil
+    }
 
+    partName := outName + ".part"
+    out, err := os.Create(partName)
+    if err != nil {
+        return "", err
+    }
     buf := make([]byte,  128 * 1024)
-    _, _ = io.CopyBuffer(out, resp.Body, buf)
+    _, err = io.CopyBuffer(out, resp.Body, buf)
+    if err == nil {
+        err = out.Close()
+    }
+    if err != nil {
+        out.Close()
+        os.Remove(partName)
+        return "", err
+    }
+    if err := os.Rename(partName, outName); err != nil {
+        return "", err
+    }
     return resp.Status, nil
 }
 
+/* Upload tar, tar.gz or zip archive extracted by the server
+ * into the prefix of the bucket, all files or none are written */
+func (this *Client) Extract(hostname, username, password, bucket, prefix, filename string) (string, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+
+    /* Stream the multipart body from the file */
+    reader, writer := io.Pipe()
+    form := multipart.NewWriter(writer)
+    go func() {
+        err := form.WriteField("bucket", bucket)
+        if err == nil {
+            err = form.WriteField("prefix", prefix)
+        }
+        var part io.Writer
+        if err == nil {
+            part, err = form.CreateFormFile("file", filepath.Base(filename))
+        }
+        if err == nil {
+            _, err = io.Copy(part, file)
+        }
+        if err == nil {
+            err = form.Close()
+        }
+        writer.CloseWithError(err)
+    }()
+
+    url := fmt.Sprintf("https://%s:%s@%s/%s", username, password, hostname, extractURI)
+    client := &http.Client{ Transport: newTransport() }
+    resp, err := client.Post(url, form.FormDataContentType(), reader)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return "", err
+    }
+    return string(body), nil
+}
+
+/* Return SHA256 sum from Digest header */
+func digestSHA256(header string) []byte {
+    for _, item := range strings.Split(header, ",") {
+        item = strings.TrimSpace(item)
+        if strings.HasPrefix(strings.ToLower(item), "sha-256=") {
+            sum, _ := base64.StdEncoding.DecodeString(item[len("sha-256="):])
+            return sum
+        }
+    }
+    return nil
+}
+
 type DropForm struct {
     Bucket      string  `json:"bucket"   form:"bucket"`
     Filename    string  `json:"filename" form:"filename"`
@@ -209,6 +618,208 @@ func (this *Client) Drop(hostname, username, password, bucket, filename string)
     return string(body), nil
 }
 
+/* Post form as JSON and return response body */
+func (this *Client) post(hostname, username, password, uri string, form interface{}) (string, error) {
+    url := fmt.Sprintf("https://%s:%s@%s/%s", username, password, hostname, uri)
+
+    data, _ := json.Marshal(form)
+    client := &http.Client{ Transport: newTransport() }
+
+    resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return "", err
+    }
+    return string(body), nil
+}
+
+type VersionForm struct {
+    Bucket      string  `json:"bucket"`
+    Filename    string  `json:"filename"`
+    Version     string  `json:"version,omitempty"`
+}
+
+func (this *Client) Versions(hostname, username, password, bucket, filename string) (string, error) {
+    form := VersionForm{ Bucket: bucket, Filename: filename }
+    return this.post(hostname, username, password, versionsURI, form)
+}
+
+func (this *Client) Restore(hostname, username, password, bucket, filename, version string) (string, error) {
+    form := VersionForm{ Bucket: bucket, Filename: filename, Version: version }
+    return this.post(hostname, username, password, restoreURI, form)
+}
+
+/* Remove the version, all versions of the file if version is empty */
+func (this *Client) Purge(hostname, username, password, bucket, filename, version string) (string, error) {
+    form := VersionForm{ Bucket: bucket, Filename: filename, Version: version }
+    return this.post(hostname, username, password, purgeURI, form)
+}
+
+type CopyForm struct {
+    Bucket      string  `json:"bucket"`
+    Filename    string  `json:"filename"`
+    ToBucket    string  `json:"tobucket,omitempty"`
+    ToFilename  string  `json:"tofilename,omitempty"`
+    Overwrite   bool    `json:"overwrite,omitempty"`
+}
+
+/* Copy the file on the server, existing file is replaced with overwrite */
+func (this *Client) Copy(hostname, username, password string, form CopyForm) (string, error) {
+    return this.post(hostname, username, password, copyURI, form)
+}
+
+/* Move the file on the server, existing file is replaced with overwrite */
+func (this *Client) Move(hostname, username, password string, form CopyForm) (string, error) {
+    return this.post(hostname, username, password, moveURI, form)
+}
+
+type BucketForm struct {
+    Bucket      string  `json:"bucket"`
+    Force       bool    `json:"force,omitempty"`
+    NewName     string  `json:"newname,omitempty"`
+}
+
+func (this *Client) BucketCreate(hostname, username, password, bucket string) (string, error) {
+    return this.post(hostname, username, password, bucketCreateURI, BucketForm{ Bucket: bucket })
+}
+
+/* Delete empty bucket, with force files of the bucket are deleted */
+func (this *Client) BucketDelete(hostname, username, password, bucket string, force bool) (string, error) {
+    return this.post(hostname, username, password, bucketDeleteURI, BucketForm{ Bucket: bucket, Force: force })
+}
+
+func (this *Client) BucketRename(hostname, username, password, bucket, newName string) (string, error) {
+    return this.post(hostname, username, password, bucketRenameURI, BucketForm{ Bucket: bucket, NewName: newName })
+}
+
+/* Return count and size of files and creation time of the bucket */
+func (this *Client) BucketInfo(hostname, username, password, bucket string) (string, error) {
+    return this.post(hostname, username, password, bucketInfoURI, BucketForm{ Bucket: bucket })
+}
+
+/* Usage and quota of the user and quotas of the bucket,
+ * without bucket quotas of all buckets */
+func (this *Client) Usage(hostname, username, password, bucket string) (string, error) {
+    return this.post(hostname, username, password, quotaUsageURI, BucketForm{ Bucket: bucket })
+}
+
+type QuotaForm struct {
+    Kind        string  `json:"kind"`
+    Name        string  `json:"name"`
+    MaxBytes    int64   `json:"maxbytes"`
+    MaxObjects  int64   `json:"maxobjects"`
+}
+
+/* Set quota of the user or the bucket, zero limits remove the quota. Requires admin */
+func (this *Client) SetQuota(hostname, username, password string, form QuotaForm) (string, error) {
+    return this.post(hostname, username, password, quotaSetURI, form)
+}
+
+func (this *Client) Quotas(hostname, username, password string) (string, error) {
+    return this.post(hostname, username, password, quotaListURI, struct{}{})
+}
+
+type DedupForm struct {
+    Collect     bool    `json:"collect"`
+}
+
+/* Logical and physical bytes of the dedup store, collect
+ * removes unreferenced blobs. Requires admin */
+func (this *Client) Dedup(hostname, username, password string, collect bool) (string, error) {
+    return this.post(hostname, username, password, dedupURI, DedupForm{ Collect: collect })
+}
+
+type MasterKeyForm struct {
+    Rotate      bool    `json:"rotate"`
+}
+
+/* Master key and data keys of encrypted files, rotate replaces
+ * the master key and wraps data keys again. Requires admin */
+func (this *Client) MasterKey(hostname, username, password string, rotate bool) (string, error) {
+    return this.post(hostname, username, password, masterKeyURI, MasterKeyForm{ Rotate: rotate })
+}
+
+type ScrubForm struct {
+    Run         bool    `json:"run"`
+}
+
+/* Progress and last result of the integrity scrub, run
+ * starts the scrub now. Requires admin */
+func (this *Client) Scrub(hostname, username, password string, run bool) (string, error) {
+    return this.post(hostname, username, password, scrubURI, ScrubForm{ Run: run })
+}
+
+type LifecycleRule struct {
+    Pattern     string  `json:"pattern"`
+    ExpireDays  int     `json:"expiredays"`
+    KeepLast    int     `json:"keeplast"`
+    DeleteEmpty bool    `json:"deleteempty"`
+}
+
+/* Lifecycle rules of the bucket, nil rules are not changed */
+type LifecycleForm struct {
+    Bucket      string              `json:"bucket"`
+    Rules       *[]LifecycleRule    `json:"rules,omitempty"`
+}
+
+func (this *Client) Lifecycle(hostname, username, password string, form LifecycleForm) (string, error) {
+    return this.post(hostname, username, password, lifecycleURI, form)
+}
+
+/* Report deletions of the rules, current rules of the bucket if rules are nil */
+func (this *Client) LifecycleDryRun(hostname, username, password string, form LifecycleForm) (string, error) {
+    return this.post(hostname, username, password, dryrunURI, form)
+}
+
+type TagsForm struct {
+    Bucket      string              `json:"bucket"`
+    Filename    string              `json:"filename"`
+    Tags        map[string]string   `json:"tags"`
+}
+
+/* Replace tags of the stored file */
+func (this *Client) Tags(hostname, username, password, bucket, filename string, tags map[string]string) (string, error) {
+    form := TagsForm{ Bucket: bucket, Filename: filename, Tags: tags }
+    return this.post(hostname, username, password, tagsURI, form)
+}
+
+/* Versioning settings of the bucket, nil values are not changed */
+type VersioningForm struct {
+    Bucket          string  `json:"bucket"`
+    Versioning      *bool   `json:"versioning,omitempty"`
+    KeepVersions    *int    `json:"keepversions,omitempty"`
+    KeepDays        *int    `json:"keepdays,omitempty"`
+}
+
+func (this *Client) Versioning(hostname, username, password string, form VersioningForm) (string, error) {
+    return this.post(hostname, username, password, versioningURI, form)
+}
+
+/* Compression of new files of the bucket, nil value shows settings */
+type CompressionForm struct {
+    Bucket          string  `json:"bucket"`
+    Compression     *string `json:"compression,omitempty"`
+}
+
+func (this *Client) Compression(hostname, username, password string, form CompressionForm) (string, error) {
+    return this.post(hostname, username, password, compressionURI, form)
+}
+
+/* Encryption of new files of the bucket, nil value shows settings */
+type EncryptionForm struct {
+    Bucket          string  `json:"bucket"`
+    Encryption      *string `json:"encryption,omitempty"`
+}
+
+func (this *Client) Encryption(hostname, username, password string, form EncryptionForm) (string, error) {
+    return this.post(hostname, username, password, encryptionURI, form)
+}
+
 func printHeader(header http.Header) {
     for key, val := range header {
         fmt.Printf("%s: %s\n", key, strings.Join(val, " "))
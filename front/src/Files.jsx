@@ -23,6 +23,16 @@ class Files extends Component {
             limit: 10,
             total: 0,
             pattern: "*",
+            prefix: "",
+            prefixes: [],
+            recursive: false,
+            sort: "name",
+            order: "asc",
+            tab: "files",
+            trash: [],
+            preview: null,
+            selected: {},
+            archiveFormat: "zip",
             alertMessage: ""
         }
     }
@@ -33,6 +43,10 @@ class Files extends Component {
                 limit: this.state.limit,
                 offset: this.state.offset,
                 pattern: this.state.pattern,
+                prefix: this.state.prefix,
+                recursive: this.state.recursive,
+                sort: this.state.sort,
+                order: this.state.order,
                 bucket: this.props.match.params[0]
         }).then((res) => {
             if (res.data.error != null) {
@@ -41,6 +55,7 @@ class Files extends Component {
                     this.setState({
                         //files: res.data.result
                         files: res.data.result.files,
+                        prefixes: res.data.result.prefixes || [],
                         total: res.data.result.total,
                         offset: res.data.result.offset,
                         limit: res.data.result.limit,
@@ -59,6 +74,80 @@ class Files extends Component {
         })
     }
 
+    @autobind
+    listTrash() {
+        axios.post('/api/v1/trash/list', {
+                bucket: this.props.match.params[0]
+        }).then((res) => {
+            if (res.data.error != null) {
+                if (!res.data.error) {
+                    this.setState({
+                        trash: res.data.result
+                    })
+                } else {
+                    this.setState({
+                        alertMessage: "Backend error"
+                    })
+                }
+            }
+        }).catch((err) => {
+            this.setState({
+                alertMessage: "Communication error"
+            })
+        })
+    }
+
+    @autobind
+    restoreTrash(id) {
+        axios.post('/api/v1/trash/restore', {
+                bucket: this.props.match.params[0],
+                id: id
+        }).then((res) => {
+            if (res.data.error) {
+                this.setState({
+                    alertMessage: res.data.message
+                })
+            }
+            this.listTrash()
+        }).catch((err) => {
+            this.setState({
+                alertMessage: "Communication error"
+            })
+        })
+    }
+
+    @autobind
+    emptyTrash() {
+        axios.post('/api/v1/trash/empty', {
+                bucket: this.props.match.params[0]
+        }).then((res) => {
+            if (res.data.error) {
+                this.setState({
+                    alertMessage: res.data.message
+                })
+            }
+            this.listTrash()
+        }).catch((err) => {
+            this.setState({
+                alertMessage: "Communication error"
+            })
+        })
+    }
+
+    @autobind
+    changeTab(tab) {
+        this.setState({ tab: tab, alertMessage: "" }, () => { this.refresh() })
+    }
+
+    @autobind
+    refresh() {
+        if (this.state.tab == "trash") {
+            this.listTrash()
+        } else {
+            this.listFiles()
+        }
+    }
+
     @autobind
     onChangeLimit(event) {
         event.preventDefault()
@@ -72,7 +161,78 @@ class Files extends Component {
     onChangePattern(event) {
         event.preventDefault()
         const newPattern = event.target.value
-        this.setState({ pattern: newPattern }, () => { this.listFiles() })
+        this.setState({ pattern: newPattern, selected: {} }, () => { this.listFiles() })
+    }
+
+    /* Browse nested keys of the common prefix */
+    @autobind
+    changePrefix(newPrefix) {
+        this.setState({ prefix: newPrefix, offset: 0, selected: {} }, () => { this.listFiles() })
+    }
+
+    @autobind
+    parentPrefix() {
+        const parts = this.state.prefix.split("/").filter((part) => part.length > 0)
+        parts.pop()
+        return parts.length > 0 ? parts.join("/") + "/" : ""
+    }
+
+    @autobind
+    onChangeRecursive(event) {
+        this.setState({ recursive: event.target.checked, offset: 0, selected: {} }, () => { this.listFiles() })
+    }
+
+    /* Sort value is the key and the order, as "size desc" */
+    @autobind
+    onChangeSort(event) {
+        const [ sort, order ] = event.target.value.split(" ")
+        this.setState({ sort: sort, order: order, offset: 0 }, () => { this.listFiles() })
+    }
+
+    @autobind
+    toggleSelected(name) {
+        let selected = Object.assign({}, this.state.selected)
+        if (selected[name]) {
+            delete selected[name]
+        } else {
+            selected[name] = true
+        }
+        this.setState({ selected: selected })
+    }
+
+    @autobind
+    onChangeArchiveFormat(event) {
+        this.setState({ archiveFormat: event.target.value })
+    }
+
+    /* Download selected files, or files matching the pattern if none is selected,
+     * as archive streamed by the server. The form is posted to keep the long
+     * list of files out of the URL */
+    @autobind
+    downloadArchive() {
+        const files = Object.keys(this.state.selected)
+        const form = document.createElement("form")
+        form.method = "POST"
+        form.action = "/api/v1/file/archive"
+        const fields = [ [ "format", this.state.archiveFormat ] ]
+        if (files.length == 0) {
+            fields.push([ "bucket", this.state.bucket + "/" + this.state.prefix ])
+            fields.push([ "pattern", this.state.pattern ])
+            fields.push([ "recursive", this.state.recursive ])
+        } else {
+            fields.push([ "bucket", this.state.bucket ])
+        }
+        files.forEach((name) => { fields.push([ "files", name ]) })
+        fields.forEach(([ name, value ]) => {
+            const input = document.createElement("input")
+            input.type = "hidden"
+            input.name = name
+            input.value = value
+            form.appendChild(input)
+        })
+        document.body.appendChild(form)
+        form.submit()
+        document.body.removeChild(form)
     }
 
 
@@ -81,16 +241,124 @@ class Files extends Component {
         this.setState({ offset: newOffset }, () => { this.listFiles() })
     }
 
+    /* Images and text are previewed, other types are downloaded */
+    previewKind(contentType) {
+        if (contentType == null) {
+            return null
+        }
+        if (contentType.startsWith("image/") && !contentType.startsWith("image/svg")) {
+            return "image"
+        }
+        if (contentType.startsWith("text/plain") || contentType.startsWith("application/json")) {
+            return "text"
+        }
+        return null
+    }
+
+    @autobind
+    showPreview(item) {
+        const url = "/api/v1/file/down/" + this.state.bucket + "/" + item.name + "?disposition=inline"
+        const kind = this.previewKind(item.contenttype)
+        if (kind == "image") {
+            this.setState({ preview: { name: item.name, kind: kind, url: url } })
+            return
+        }
+        axios.get(url, {
+                responseType: 'text',
+                transformResponse: [ (data) => data ],
+                headers: { Range: "bytes=0-65535" }
+        }).then((res) => {
+            this.setState({ preview: { name: item.name, kind: kind, text: res.data } })
+        }).catch((err) => {
+            this.setState({
+                alertMessage: "Communication error"
+            })
+        })
+    }
+
+    @autobind
+    hidePreview() {
+        this.setState({ preview: null })
+    }
+
+    renderPreview() {
+        const preview = this.state.preview
+        if (preview == null) {
+            return null
+        }
+        return (
+            <div className="card mb-2">
+                <div className="card-header p-1">
+                    {preview.name}
+                    <i className="fas fa-times float-right" onClick={this.hidePreview}></i>
+                </div>
+                <div className="card-body p-1">
+                    {preview.kind == "image" ?
+                        <img className="img-fluid" src={preview.url} alt={preview.name} />
+                        :
+                        <pre className="mb-0">{preview.text}</pre>
+                    }
+                </div>
+            </div>
+        )
+    }
+
+    @autobind
+    renderPrefixes() {
+        let rows = this.state.prefixes.map((prefix) => {
+            return (
+                <tr key={"prefix:" + prefix}>
+                    <td></td>
+                    <td></td>
+                    <td colSpan="4"><a href="#" onClick={(event) => { event.preventDefault(); this.changePrefix(prefix) }}>
+                        <i className="far fa-folder"></i> {prefix.substring(this.state.prefix.length)}</a></td>
+                </tr>
+            )
+        })
+        if (this.state.prefix.length > 0) {
+            rows.unshift(
+                <tr key="prefix:..">
+                    <td></td>
+                    <td></td>
+                    <td colSpan="4"><a href="#" onClick={(event) => { event.preventDefault(); this.changePrefix(this.parentPrefix()) }}>
+                        <i className="fas fa-level-up-alt"></i> ..</a></td>
+                </tr>
+            )
+        }
+        return rows
+    }
+
     @autobind
     renderTable() {
         return this.state.files.map((item, index) => {
             const theItem = item
             return (
                 <tr key={index}>
+                    <td><input type="checkbox" checked={this.state.selected[theItem.name] == true}
+                        onChange={() => { this.toggleSelected(theItem.name) }} /></td>
                     <td>{index + 1}</td>
                     <td><Link to={"/api/v1/file/down/" + this.state.bucket + "/" + theItem.name} target="_blank" download>{theItem.name}</Link></td>
                     <td>{Humanize.fileSize(theItem.size)}</td>
                     <td>{moment(theItem.modtime).format('YYYY-MM-DD HH:MMZ')}</td>
+                    <td>{this.previewKind(theItem.contenttype) != null &&
+                        <i className="fas fa-eye" title="preview" onClick={() => { this.showPreview(theItem) }}></i>
+                    }</td>
+                </tr>
+            )
+        })
+    }
+
+    @autobind
+    renderTrash() {
+        return this.state.trash.map((item, index) => {
+            const theItem = item
+            return (
+                <tr key={index}>
+                    <td>{index + 1}</td>
+                    <td>{theItem.name}</td>
+                    <td>{Humanize.fileSize(theItem.size)}</td>
+                    <td>{moment.unix(theItem.deleted).format('YYYY-MM-DD HH:mmZ')}</td>
+                    <td><i className="fas fa-undo" title="restore" onClick={() => { this.restoreTrash(theItem.id) }}></i></td>
                 </tr>
             )
         })
@@ -101,55 +369,135 @@ class Files extends Component {
             <Fragment>
                 <Layout>
 
-                    <h5><Link to="/"><i className="fas fa-folder-open"></i></Link> /{this.props.match.params[0]}
-                            <i className="fas fa-sync fa-xs ml-3" onClick={this.listFiles}></i>
+                    <h5><Link to="/"><i className="fas fa-folder-open"></i></Link> /{this.props.match.params[0]}/{this.state.prefix}
+                            <i className="fas fa-sync fa-xs ml-3" onClick={this.refresh}></i>
                     </h5>
 
-                    <div className="row mb-2">
-                        <div className="col">
-                            <div className="input-group input-group-sm flex-nowrap">
+                    <ul className="nav nav-tabs mb-2">
+                        <li className="nav-item">
+                            <a className={this.state.tab == "files" ? "nav-link active" : "nav-link"} href="#"
+                                onClick={(event) => { event.preventDefault(); this.changeTab("files") }}>Files</a>
+                        </li>
+                        <li className="nav-item">
+                            <a className={this.state.tab == "trash" ? "nav-link active" : "nav-link"} href="#"
+                                onClick={(event) => { event.preventDefault(); this.changeTab("trash") }}>Trash</a>
+                        </li>
+                    </ul>
+
+                    {this.state.alertMessage.length > 0 &&
+                        <div className="alert alert-warning p-1">{this.state.alertMessage}</div>
+                    }
+
+                    {this.state.tab == "trash" ?
+                    <Fragment>
+                        <div className="mb-2">
+                            <button type="button" className="btn btn-sm btn-outline-danger" onClick={this.emptyTrash}>Empty trash</button>
+                        </div>
+
+                        <table className="table table-striped table-hover table-sm">
+                            <thead className="thead-light">
+                                <tr>
+                                    <th>#</th>
+                                    <th>name</th>
+                                    <th>size</th>
+                                    <th>deleted</th>
+                                    <th></th>
+                                </tr>
+                            </thead>
+                            <tbody>
+                                 {this.renderTrash()}
+                            </tbody>
+                        </table>
+                    </Fragment>
+                    :
+                    <Fragment>
+
+                        <div className="row mb-2">
+                            <div className="col">
+                                <div className="input-group input-group-sm flex-nowrap">
+
+                                    <div className="input-group-prepend">
+                                        <div className="input-group-text">{this.state.total}</div>
+                                    </div>
+
+                                    <input type="text" className="form-control" id="file-pattern"  value={this.state.pattern} onChange={this.onChangePattern} />
+
+                                    <div className="input-group-append">
+                                        <div className="input-group-text">
+                                            <input type="checkbox" className="mr-1" id="file-recursive" checked={this.state.recursive} onChange={this.onChangeRecursive} />
+                                            <label className="mb-0" htmlFor="file-recursive">recursive</label>
+                                        </div>
+                                    </div>
+
+                                    <div className="input-group-append">
+                                        <select className="custom-select" id="file-sort" value={this.state.sort + " " + this.state.order} onChange={this.onChangeSort}>
+                                            <option value="name asc">name &uarr;</option>
+                                            <option value="name desc">name &darr;</option>
+                                            <option value="size asc">size &uarr;</option>
+                                            <option value="size desc">size &darr;</option>
+                                            <option value="mtime asc">mtime &uarr;</option>
+                                            <option value="mtime desc">mtime &darr;</option>
+                                        </select>
+                                    </div>
+
+                                    <div className="input-group-append">
+                                        <select className="custom-select" id="page-limit" value={this.state.limit} onChange={this.onChangeLimit}>
+                                            <option value="5">5</option>
+                                            <option value="10">10</option>
+                                            <option value="25">25</option>
+                                            <option value="50">50</option>
+                                            <option value="100">100</option>
+                                            <option value="200">200</option>
+                                            <option value="500">500</option>
+                                        </select>
+                                    </div>
 
-                                <div className="input-group-prepend">
-                                    <div className="input-group-text">{this.state.total}</div>
                                 </div>
+                            </div>
 
-                                <input type="text" className="form-control" id="file-pattern"  value={this.state.pattern} onChange={this.onChangePattern} />
-
-                                <div className="input-group-append">
-                                    <select className="custom-select" id="page-limit" value={this.state.limit} onChange={this.onChangeLimit}>
-                                        <option value="5">5</option>
-                                        <option value="10">10</option>
-                                        <option value="25">25</option>
-                                        <option value="50">50</option>
-                                        <option value="100">100</option>
-                                        <option value="200">200</option>
-                                        <option value="500">500</option>
+                            <div className="col-auto">
+                                <div className="input-group input-group-sm flex-nowrap">
+                                    <select className="custom-select" id="archive-format" value={this.state.archiveFormat} onChange={this.onChangeArchiveFormat}>
+                                        <option value="zip">zip</option>
+                                        <option value="tar.gz">tar.gz</option>
+                                        <option value="tar">tar</option>
                                     </select>
+                                    <div className="input-group-append">
+                                        <button type="button" className="btn btn-outline-secondary" onClick={this.downloadArchive}>
+                                            <i className="fas fa-file-archive"></i> {Object.keys(this.state.selected).length > 0 ?
+                                                "Download selected (" + Object.keys(this.state.selected).length + ")" : "Download all"}
+                                        </button>
+                                    </div>
                                 </div>
-
                             </div>
+
                         </div>
 
-                    </div>
+                        <table className="table table-striped table-hover table-sm">
 
-                    <table className="table table-striped table-hover table-sm">
+                            <thead className="thead-light">
+                                <tr>
+                                    <th></th>
+                                    <th>#</th>
+                                    <th>name</th>
+                                    <th>size</th>
+                                    <th>mtime</th>
+                                    <th></th>
+                                </tr>
+                            </thead>
 
-                        <thead className="thead-light">
-                            <tr>
-                                <th>#</th>
-                                <th>name</th>
-                                <th>size</th>
-                                <th>mtime</th>
-                            </tr>
-                        </thead>
+                            <tbody>
+                                 {this.renderPrefixes()}
+                                 {this.renderTable()}
+                            </tbody>
 
-                        <tbody>
-                             {this.renderTable()}
-                        </tbody>
+                        </table>
 
-                    </table>
+                        <Pager total={this.state.total} limit={this.state.limit} offset={this.state.offset} callback={this.changeOffset} />
 
-                    <Pager total={this.state.total} limit={this.state.limit} offset={this.state.offset} callback={this.changeOffset} />
+                        {this.renderPreview()}
+                    </Fragment>
+                    }
 
                 </Layout>
             </Fragment>
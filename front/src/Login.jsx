@@ -28,7 +28,7 @@ export class Login extends React.Component {
                 console.log("login: ", res.data)
                 if (!res.data.error) {
                     this.setState({alertMessage: ""})
-                    store.login(res.data.result)
+                    store.login(res.data.result.username, res.data.result.isadmin)
 
                     this.props.history.push('/')
                 } else {
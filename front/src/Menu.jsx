@@ -2,6 +2,7 @@ import React, { Component, Fragment } from 'react'
 import { Link } from 'react-router-dom'
 
 import { store } from './main'
+import { UserPassword } from './UserPassword'
 
 
 export class Menu extends Component {
@@ -14,7 +15,11 @@ export class Menu extends Component {
                     <div className="dropdown-item active"><i className="fas fa-hammer"></i> Menu </div>
 
                     <Link className="dropdown-item" to="/"><i className="far fa-folder-open"></i> Buckets </Link>
-                    <Link className="dropdown-item" to="/users"><i className="fas fa-users"></i> Users </Link>
+                    <Link className="dropdown-item" to="/usage"><i className="fas fa-chart-pie"></i> Usage </Link>
+                    {store.isadmin &&
+                        <Link className="dropdown-item" to="/users"><i className="fas fa-users"></i> Users </Link>
+                    }
+                    <UserPassword />
 
                     <div className="dropdown-divider mb-3"></div>
 
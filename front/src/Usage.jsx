@@ -0,0 +1,150 @@
+import React, { Component, Fragment } from 'react'
+
+import autobind from 'autobind-decorator'
+import axios from 'axios'
+import dayjs from 'dayjs'
+import Humanize from 'humanize-plus'
+
+import { store } from './main'
+import { Layout } from './Layout'
+import { checkLogin } from './main'
+
+/* Usage versus quota of the user and buckets, admin sees all quotas */
+export class Usage extends Component {
+
+    constructor(props) {
+        super(props)
+
+        this.state = {
+            quotas: [],
+            alertMessage: ""
+        }
+    }
+
+    @autobind
+    listUsage() {
+        axios.post('/api/v1/quota/usage', {}).then((res) => {
+            if (res.data.error != null) {
+                if (!res.data.error) {
+                    let quotas = [ res.data.result.user ].concat(res.data.result.buckets || [])
+                    this.setState({ quotas: quotas })
+                    if (store.isadmin) {
+                        this.listQuotas(res.data.result.user)
+                    }
+                } else {
+                    this.setState({
+                        alertMessage: "Backend error. " + res.data.message
+                    })
+                }
+            }
+        }).catch((err) => {
+            this.setState({
+                alertMessage: "Communication error"
+            })
+        })
+    }
+
+    @autobind
+    listQuotas(user) {
+        axios.post('/api/v1/quota/list', {}).then((res) => {
+            if (res.data.error != null && !res.data.error) {
+                let quotas = res.data.result || []
+                if (!quotas.some((quota) => quota.kind == user.kind && quota.name == user.name)) {
+                    quotas = [ user ].concat(quotas)
+                }
+                this.setState({ quotas: quotas })
+            }
+        }).catch((err) => {
+            this.setState({
+                alertMessage: "Communication error"
+            })
+        })
+    }
+
+    showAlert() {
+        if (this.state.alertMessage != "") {
+            return (
+                <div className="alert alert-warning border mx-4" role="alert">
+                  <div className="text-center">{this.state.alertMessage}</div>
+                </div>
+            )
+        }
+    }
+
+    renderLimit(used, max, format) {
+        if (max == 0) {
+            return <span>{format(used)} <small className="text-muted">of unlimited</small></span>
+        }
+        const percent = Math.min(100, Math.round(used * 100 / max))
+        let color = "bg-success"
+        if (percent >= 90) {
+            color = "bg-danger"
+        } else if (percent >= 75) {
+            color = "bg-warning"
+        }
+        return (
+            <Fragment>
+                <span>{format(used)} <small className="text-muted">of {format(max)}</small></span>
+                <div className="progress" style={{ height: "4px" }}>
+                    <div className={"progress-bar " + color} role="progressbar" style={{ width: percent + "%" }}></div>
+                </div>
+            </Fragment>
+        )
+    }
+
+    @autobind
+    renderTable() {
+        return this.state.quotas.map((quota, index) => {
+            return (
+                <tr key={quota.kind + ":" + quota.name}>
+                    <td>{index + 1}</td>
+                    <td>{quota.kind == "user" ? <i className="fas fa-user"></i> : <i className="far fa-folder"></i>} {quota.name}</td>
+                    <td>{this.renderLimit(quota.bytes, quota.maxbytes, Humanize.fileSize)}</td>
+                    <td>{this.renderLimit(quota.objects, quota.maxobjects, Humanize.intComma)}</td>
+                </tr>
+            )
+        })
+    }
+
+    render() {
+        return (
+            <Fragment>
+                <Layout>
+                    <div className="container-fluid">
+                        <div className="row mb-3">
+                                <h5>
+                                    <i className="fas fa-chart-pie"></i>
+                                    <span> Usage </span>
+                                    <a onClick={this.listUsage}><i className="fas fa-sync fa-sm"></i></a>
+                                    <small> {dayjs().format('hh:mm:ss')}</small>
+                                </h5>
+                        </div>
+                    </div>
+
+                    {this.showAlert()}
+
+                    <table className="table table-striped table-hover table-sm">
+                        <thead className="thead-light">
+                            <tr>
+                                <th>#</th>
+                                <th>user or bucket</th>
+                                <th>size</th>
+                                <th>files</th>
+                            </tr>
+                        </thead>
+                        <tbody>
+                            {this.renderTable()}
+                        </tbody>
+                    </table>
+                </Layout>
+            </Fragment>
+        )
+    }
+
+    componentDidMount() {
+        checkLogin()
+        this.listUsage()
+    }
+}
+
+export default Usage
@@ -4,6 +4,8 @@ import { trim, ltrim } from 'validator'
 import autobind from 'autobind-decorator'
 import axios from 'axios'
 
+import { store } from './main'
+
 export class UserCreate extends React.Component {
 
     constructor(props) {
@@ -154,6 +156,9 @@ export class UserCreate extends React.Component {
     }
 
     render() {
+        if (!store.isadmin) {
+            return null
+        }
         return (
             <React.Fragment>
 
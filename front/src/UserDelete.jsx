@@ -4,6 +4,8 @@ import { trim, ltrim } from 'validator'
 import autobind from 'autobind-decorator'
 import axios from 'axios'
 
+import { store } from './main'
+
 export class UserDelete extends Component {
 
     constructor(props) {
@@ -88,6 +90,9 @@ export class UserDelete extends Component {
     }
 
     render() {
+        if (!store.isadmin) {
+            return null
+        }
         return (
             <Fragment>
                 <a onClick={this.showForm}  className="dropdown-item">
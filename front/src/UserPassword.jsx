@@ -0,0 +1,148 @@
+import React, { Fragment, Component } from 'react'
+import $ from 'jquery'
+import { trim } from 'validator'
+import autobind from 'autobind-decorator'
+import axios from 'axios'
+
+export class UserPassword extends Component {
+
+    constructor(props) {
+        super(props)
+        this.state = {
+            password: "",
+            newPassword: "",
+            formIsValid: false,
+            passwordMessage: "",
+            alertMessage: ""
+        }
+
+        this.minPasswordLength = 4
+    }
+
+    @autobind
+    showForm() {
+        this.setState({ password: "", newPassword: "", alertMessage: "" }, () => { this.validateForm() })
+        $("#user-password").modal('show')
+    }
+
+    @autobind
+    hideForm() {
+        $("#user-password").modal('hide')
+    }
+
+    @autobind
+    changePassword() {
+        axios.post('/api/v2/user/password', {
+                password: this.state.password,
+                newpassword: this.state.newPassword
+        }).then((res) => {
+            if (res.data.error != null) {
+                if (!res.data.error) {
+                    this.hideForm()
+                } else {
+                    this.setState({
+                        alertMessage: "Backend error. " + res.data.message
+                    })
+                }
+            }
+        }).catch((err) => {
+            this.setState({
+                alertMessage: "Communication error"
+            })
+        })
+    }
+
+    @autobind
+    onSubmit(event) {
+        event.preventDefault()
+        if (!this.state.formIsValid) {
+            return
+        }
+        this.changePassword()
+    }
+
+    validateForm() {
+        if (this.state.password.length > 0 && this.state.newPassword.length > this.minPasswordLength) {
+            this.setState({ formIsValid: true, passwordMessage: "" })
+        } else {
+            this.setState({ formIsValid: false, passwordMessage: "The new password is too short" })
+        }
+    }
+
+    @autobind
+    onChangePassword(event) {
+        event.preventDefault()
+        this.setState({ password: trim(event.target.value) }, () => { this.validateForm() })
+    }
+
+    @autobind
+    onChangeNewPassword(event) {
+        event.preventDefault()
+        this.setState({ newPassword: trim(event.target.value) }, () => { this.validateForm() })
+    }
+
+    showAlert() {
+        if (this.state.alertMessage != "") {
+            return (
+                <div className="alert alert-warning border mx-4" role="alert">
+                  <div className="text-center">{this.state.alertMessage}</div>
+                </div>
+            )
+        }
+    }
+
+    render() {
+        return (
+            <Fragment>
+                <a onClick={this.showForm} className="dropdown-item">
+                    <i className="fas fa-key"></i> Password
+                </a>
+
+                <div className="modal fade" id="user-password" tabIndex="-1" role="dialog">
+                    <div className="modal-dialog" role="document">
+                        <div className="modal-content">
+
+                            <form acceptCharset="UTF-8" onSubmit={this.onSubmit}>
+
+                                <div className="modal-header">
+                                    <h5 className="modal-title">Change password</h5>
+                                    <button type="button" className="close" onClick={this.hideForm}>
+                                        <span>&times;</span>
+                                    </button>
+                                </div>
+
+                                <div className="modal-body">
+
+                                    <div className="form-group">
+                                        <label htmlFor="user-password-current">Current password:</label>
+                                        <input type="password" className="form-control" id="user-password-current"
+                                                    value={this.state.password} onChange={this.onChangePassword}/>
+                                    </div>
+
+                                    <div className="form-group">
+                                        <label htmlFor="user-password-new">New password:</label>
+                                        <input type="password" className="form-control" id="user-password-new"
+                                                    value={this.state.newPassword} onChange={this.onChangeNewPassword}/>
+                                        <small className="form-text text-muted">{this.state.passwordMessage}</small>
+                                    </div>
+
+                                </div>
+
+                                {this.showAlert()}
+
+                                <div className="modal-footer">
+                                    <button type="button" className="btn btn-sm btn-secondary" onClick={this.hideForm}>Close</button>
+                                    <button type="submit" className={this.state.formIsValid ? "btn btn-sm btn-primary" : "btn btn-sm btn-primary disabled"} onClick={this.onSubmit}>Change</button>
+                                </div>
+
+                            </form>
+
+                        </div>
+                    </div>
+                </div>
+            </Fragment>
+        )
+    }
+}
+
+export default UserPassword
@@ -4,6 +4,8 @@ import { trim, ltrim } from 'validator'
 import autobind from 'autobind-decorator'
 import axios from 'axios'
 
+import { store } from './main'
+
 export class UserUpdate extends React.Component {
 
     constructor(props) {
@@ -165,6 +167,9 @@ export class UserUpdate extends React.Component {
     }
 
     render() {
+        if (!store.isadmin) {
+            return null
+        }
         return (
             <Fragment>
                 <a onClick={this.showForm} className="dropdown-item">
@@ -12,12 +12,14 @@ import { Provider } from 'mobx-react';
 import * as Cookies from 'js-cookie'
 
 import { decorate, observable, action } from "mobx"
+import axios from 'axios'
 
 import Buckets from './Buckets'
 import Files from './Files'
 import NotFound from './NotFound'
 import Login from './Login'
 import Users from './Users'
+import Usage from './Usage'
 
 
 export const history = createBrowserHistory()
@@ -26,6 +28,7 @@ const cookieName = "session"
 
 class Store {
     @observable username
+    @observable isadmin
     @observable limit
 
     constructor() {
@@ -37,8 +40,9 @@ class Store {
         this.bucketPattern = "*"
     }
 
-    @action login = (username) => {
+    @action login = (username, isadmin) => {
         this.username = username
+        this.isadmin = isadmin == true
     }
     @action logout = () => {
         this.username = ""
@@ -50,10 +54,33 @@ class Store {
 
 export const store = new Store()
 
+/* Restore session user and role from backend */
+export function whoami() {
+    return axios.get('/api/v2/user/whoami').then((res) => {
+        if (res.data.error != null && !res.data.error) {
+            store.login(res.data.result.username, res.data.result.isadmin)
+        }
+        return store.username
+    })
+}
+
 export function checkLogin(level) {
     let cookie = Cookies.get(cookieName)
-    if (store.username == "" || cookie == null) {
+    if (cookie == null) {
         history.push("/login")
+        return
+    }
+    if (store.username == "") {
+        whoami().then((username) => {
+            if (username == "") {
+                history.push("/login")
+            } else if (level == "admin" && store.isadmin == false) {
+                history.goBack()
+            }
+        }).catch((err) => {
+            history.push("/login")
+        })
+        return
     }
     if (level == "admin" && store.isadmin == false) {
         //history.push("/login")
@@ -66,6 +93,7 @@ ReactDOM.render(
         <Switch>
             <Route exact path="/login" component={Login} />
             <Route exact path="/users" component={Users} />
+            <Route exact path="/usage" component={Usage} />
             <Route exact path="/" component={Buckets} />
             <Route path="/files/*" component={Files} />
             <Route path="*" component={NotFound} />
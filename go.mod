@@ -11,5 +11,6 @@ require (
 	github.com/jessevdk/go-assets v0.0.0-20160921144138-4f4301a06e15
 	github.com/jessevdk/go-flags v1.4.0 // indirect
 	github.com/jmoiron/sqlx v1.2.0
+	github.com/klauspost/compress v1.11.13
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 )
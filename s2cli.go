@@ -8,6 +8,7 @@ import (
     "store/client"
     "fmt"
     "flag"
+    "net/url"
     "os"
     "path/filepath"
     "strings"
@@ -23,14 +24,62 @@ func main() {
     listCommands := flag.NewFlagSet("list", flag.ExitOnError)
         optListBucket := listCommands.String("bucket", "", "bucket name")
         optListPattern := listCommands.String("pattern", "*", "name pattern")
+        optListTag := listCommands.String("tag", "", "tag filter: key=value or key")
+        optListPrefix := listCommands.String("prefix", "", "key prefix, lists common prefixes of nested keys")
+        optListDelimiter := listCommands.String("delimiter", "", "delimiter of common prefixes, slash by default")
+        optListRecursive := listCommands.Bool("recursive", false, "list all keys of nested buckets")
+        optListSort := listCommands.String("sort", "", "sort by name, size or mtime")
+        optListOrder := listCommands.String("order", "", "sort order: asc or desc")
+        optListMinSize := listCommands.Int64("minsize", 0, "minimal file size")
+        optListMaxSize := listCommands.Int64("maxsize", 0, "maximal file size")
+        optListSince := listCommands.String("since", "", "files modified since the time, RFC3339")
+        optListLimit := listCommands.Int("limit", 0, "page size, next page is listed with the token")
+        optListToken := listCommands.String("token", "", "token of the next page")
 
     putCommands := flag.NewFlagSet("put", flag.ExitOnError)
         optPutBucket := putCommands.String("bucket", "", "bucket name")
         optPutFileName := putCommands.String("file", "", "file name")
+        optPutMeta := putCommands.String("meta", "", "metadata: key=value,key=value")
+        optPutTags := putCommands.String("tags", "", "tags: key=value&key=value")
+        optPutArchive := putCommands.String("archive", "", "tar, tar.gz or zip archive extracted into the bucket")
+        optPutPrefix := putCommands.String("prefix", "", "nested bucket of extracted archive")
+
+    tagCommands := flag.NewFlagSet("tag", flag.ExitOnError)
+        optTagBucket := tagCommands.String("bucket", "", "bucket name")
+        optTagFileName := tagCommands.String("file", "", "file name")
+        optTagTags := tagCommands.String("tags", "", "new tags: key=value&key=value")
 
     getCommands := flag.NewFlagSet("get", flag.ExitOnError)
         optGetBucket := getCommands.String("bucket", "", "bucket name")
-        optGetFileName := getCommands.String("file", "", "file name")
+        optGetFileName := getCommands.String("file", "", "file name, glob pattern with -archive")
+        optGetVersion := getCommands.String("version", "", "version id")
+        optGetArchive := getCommands.String("archive", "", "download bucket as archive: out.zip, out.tar or out.tar.gz")
+        optGetRecursive := getCommands.Bool("recursive", false, "include nested buckets into archive")
+
+    versionCommands := flag.NewFlagSet("version", flag.ExitOnError)
+        optVersionBucket := versionCommands.String("bucket", "", "bucket name")
+        optVersionFileName := versionCommands.String("file", "", "file name")
+        optVersionId := versionCommands.String("version", "", "version id, all versions for purge if empty")
+
+    versioningCommands := flag.NewFlagSet("versioning", flag.ExitOnError)
+        optVersioningBucket := versioningCommands.String("bucket", "", "bucket name")
+        optVersioningEnable := versioningCommands.String("enable", "", "enable versioning: true or false")
+        optVersioningKeep := versioningCommands.Int("keep", -1, "keep last N versions, 0 for all")
+        optVersioningDays := versioningCommands.Int("days", -1, "keep versions younger than D days, 0 for all")
+
+    compressionCommands := flag.NewFlagSet("compression", flag.ExitOnError)
+        optCompressionBucket := compressionCommands.String("bucket", "", "bucket name")
+        optCompressionSet := compressionCommands.String("set", "", "compression of new files: gzip, zstd or none, show if empty")
+
+    encryptionCommands := flag.NewFlagSet("encryption", flag.ExitOnError)
+        optEncryptionBucket := encryptionCommands.String("bucket", "", "bucket name")
+        optEncryptionSet := encryptionCommands.String("set", "", "encryption of new files: aes-256-gcm or none, show if empty")
+
+    masterKeyCommands := flag.NewFlagSet("masterkey", flag.ExitOnError)
+        optMasterKeyRotate := masterKeyCommands.Bool("rotate", false, "replace master key and wrap data keys by it")
+
+    scrubCommands := flag.NewFlagSet("scrub", flag.ExitOnError)
+        optScrubRun := scrubCommands.Bool("run", false, "start integrity scrub now")
 
     deleteCommands := flag.NewFlagSet("delete", flag.ExitOnError)
         optDropBucket := deleteCommands.String("bucket", "", "bucket name")
@@ -38,12 +87,47 @@ func main() {
 
     listBucketsCommands := flag.NewFlagSet("listb", flag.ExitOnError)
 
+    copyCommands := flag.NewFlagSet("copy", flag.ExitOnError)
+        optCopyBucket := copyCommands.String("bucket", "", "bucket name")
+        optCopyFileName := copyCommands.String("file", "", "file name")
+        optCopyToBucket := copyCommands.String("tobucket", "", "destination bucket name, same bucket if empty")
+        optCopyToFileName := copyCommands.String("tofile", "", "destination file name, same name if empty")
+        optCopyOverwrite := copyCommands.Bool("overwrite", false, "replace existing destination file")
+
+    bucketCommands := flag.NewFlagSet("bucket", flag.ExitOnError)
+        optBucketName := bucketCommands.String("bucket", "", "bucket name")
+        optBucketNewName := bucketCommands.String("newname", "", "new bucket name for brename")
+        optBucketForce := bucketCommands.Bool("force", false, "delete files of not empty bucket for bdelete")
+
+    usageCommands := flag.NewFlagSet("usage", flag.ExitOnError)
+        optUsageBucket := usageCommands.String("bucket", "", "bucket name, all bucket quotas if empty")
+
+    quotaCommands := flag.NewFlagSet("quota", flag.ExitOnError)
+        optQuotaKind := quotaCommands.String("kind", "bucket", "quota of user or bucket")
+        optQuotaName := quotaCommands.String("name", "", "user or bucket name, list quotas if empty")
+        optQuotaBytes := quotaCommands.Int64("bytes", 0, "max bytes, 0 for unlimited")
+        optQuotaObjects := quotaCommands.Int64("objects", 0, "max objects, 0 for unlimited")
+
+    dedupCommands := flag.NewFlagSet("dedup", flag.ExitOnError)
+        optDedupCollect := dedupCommands.Bool("collect", false, "remove unreferenced blobs now")
+
+    lifecycleCommands := flag.NewFlagSet("lifecycle", flag.ExitOnError)
+        optLifecycleBucket := lifecycleCommands.String("bucket", "", "bucket name")
+        optLifecyclePattern := lifecycleCommands.String("pattern", "", "file name pattern, all files if empty")
+        optLifecycleExpire := lifecycleCommands.Int("expire", 0, "delete files older than D days")
+        optLifecycleKeep := lifecycleCommands.Int("keep", 0, "keep newest N files of the bucket")
+        optLifecycleEmpty := lifecycleCommands.Bool("empty", false, "delete empty nested buckets")
+        optLifecycleClear := lifecycleCommands.Bool("clear", false, "remove rules of the bucket")
+        optLifecycleDryRun := lifecycleCommands.Bool("dryrun", false, "report deletions without deleting")
+
     exeName := filepath.Base(os.Args[0])
     flag.Usage = func() {
         fmt.Printf("usage: %s [global option] command [command option]\n", exeName)
 
         fmt.Println("")
-        fmt.Println("commands: list, put, get, delete, listb")
+        fmt.Println("commands: list, put, get, delete, listb, versions, restore, purge, versioning, tag,")
+        fmt.Println("          bcreate, bdelete, brename, binfo, usage, quota, lifecycle, copy, move, dedup,")
+        fmt.Println("          compression, encryption, masterkey, scrub")
         fmt.Println("")
 
         fmt.Println("global option:")
@@ -73,6 +157,58 @@ func main() {
         fmt.Println("listb option:")
         listBucketsCommands.PrintDefaults()
         fmt.Println("")
+
+        fmt.Println("tag option:")
+        tagCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("versions|restore|purge option:")
+        versionCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("versioning option:")
+        versioningCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("compression option:")
+        compressionCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("encryption option:")
+        encryptionCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("masterkey option:")
+        masterKeyCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("scrub option:")
+        scrubCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("bcreate|bdelete|brename|binfo option:")
+        bucketCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("usage option:")
+        usageCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("quota option:")
+        quotaCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("lifecycle option:")
+        lifecycleCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("dedup option:")
+        dedupCommands.PrintDefaults()
+        fmt.Println("")
+
+        fmt.Println("copy|move option:")
+        copyCommands.PrintDefaults()
+        fmt.Println("")
     }
 
     flag.Parse()
@@ -94,11 +230,290 @@ func main() {
 
     localArgs = localArgs[1:]
 
-    if strings.HasPrefix(command, "list") {
+    if strings.HasPrefix(command, "bcreate") || strings.HasPrefix(command, "bdelete") ||
+            strings.HasPrefix(command, "brename") || strings.HasPrefix(command, "binfo") {
+
+        bucketCommands.Parse(localArgs)
+        client := client.New()
+        var res string
+        var err error
+        switch {
+            case strings.HasPrefix(command, "bcreate"):
+                res, err = client.BucketCreate(*optNode, *optUserName, *optPassword, *optBucketName)
+            case strings.HasPrefix(command, "bdelete"):
+                res, err = client.BucketDelete(*optNode, *optUserName, *optPassword, *optBucketName, *optBucketForce)
+            case strings.HasPrefix(command, "brename"):
+                res, err = client.BucketRename(*optNode, *optUserName, *optPassword, *optBucketName, *optBucketNewName)
+            default:
+                res, err = client.BucketInfo(*optNode, *optUserName, *optPassword, *optBucketName)
+        }
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "copy") || strings.HasPrefix(command, "move") {
+
+        copyCommands.Parse(localArgs)
+        form := client.CopyForm{
+            Bucket: *optCopyBucket,
+            Filename: *optCopyFileName,
+            ToBucket: *optCopyToBucket,
+            ToFilename: *optCopyToFileName,
+            Overwrite: *optCopyOverwrite,
+        }
+        client := client.New()
+        var res string
+        var err error
+        if strings.HasPrefix(command, "move") {
+            res, err = client.Move(*optNode, *optUserName, *optPassword, form)
+        } else {
+            res, err = client.Copy(*optNode, *optUserName, *optPassword, form)
+        }
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "usage") {
+
+        usageCommands.Parse(localArgs)
+        client := client.New()
+        res, err := client.Usage(*optNode, *optUserName, *optPassword, *optUsageBucket)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "quota") {
+
+        quotaCommands.Parse(localArgs)
+        form := client.QuotaForm{
+            Kind: *optQuotaKind,
+            Name: *optQuotaName,
+            MaxBytes: *optQuotaBytes,
+            MaxObjects: *optQuotaObjects,
+        }
+        client := client.New()
+        var res string
+        var err error
+        if len(form.Name) == 0 {
+            res, err = client.Quotas(*optNode, *optUserName, *optPassword)
+        } else {
+            res, err = client.SetQuota(*optNode, *optUserName, *optPassword, form)
+        }
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "dedup") {
+
+        dedupCommands.Parse(localArgs)
+        client := client.New()
+        res, err := client.Dedup(*optNode, *optUserName, *optPassword, *optDedupCollect)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "lifecycle") {
+
+        lifecycleCommands.Parse(localArgs)
+        form := client.LifecycleForm{ Bucket: *optLifecycleBucket }
+        rule := client.LifecycleRule{
+            Pattern: *optLifecyclePattern,
+            ExpireDays: *optLifecycleExpire,
+            KeepLast: *optLifecycleKeep,
+            DeleteEmpty: *optLifecycleEmpty,
+        }
+        if *optLifecycleClear {
+            form.Rules = &[]client.LifecycleRule{}
+        } else if rule.ExpireDays > 0 || rule.KeepLast > 0 || rule.DeleteEmpty {
+            form.Rules = &[]client.LifecycleRule{ rule }
+        }
+        client := client.New()
+        var res string
+        var err error
+        if *optLifecycleDryRun {
+            res, err = client.LifecycleDryRun(*optNode, *optUserName, *optPassword, form)
+        } else {
+            res, err = client.Lifecycle(*optNode, *optUserName, *optPassword, form)
+        }
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "compression") {
+
+        compressionCommands.Parse(localArgs)
+        form := client.CompressionForm{ Bucket: *optCompressionBucket }
+        if len(*optCompressionSet) > 0 {
+            compression := *optCompressionSet
+            if compression == "none" {
+                compression = ""
+            }
+            form.Compression = &compression
+        }
+        client := client.New()
+        res, err := client.Compression(*optNode, *optUserName, *optPassword, form)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "encryption") {
+
+        encryptionCommands.Parse(localArgs)
+        form := client.EncryptionForm{ Bucket: *optEncryptionBucket }
+        if len(*optEncryptionSet) > 0 {
+            encryption := *optEncryptionSet
+            if encryption == "none" {
+                encryption = ""
+            }
+            form.Encryption = &encryption
+        }
+        client := client.New()
+        res, err := client.Encryption(*optNode, *optUserName, *optPassword, form)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "masterkey") {
+
+        masterKeyCommands.Parse(localArgs)
+        client := client.New()
+        res, err := client.MasterKey(*optNode, *optUserName, *optPassword, *optMasterKeyRotate)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "scrub") {
+
+        scrubCommands.Parse(localArgs)
+        client := client.New()
+        res, err := client.Scrub(*optNode, *optUserName, *optPassword, *optScrubRun)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "versioning") {
+
+        versioningCommands.Parse(localArgs)
+        form := client.VersioningForm{ Bucket: *optVersioningBucket }
+        if len(*optVersioningEnable) > 0 {
+            enable := *optVersioningEnable == "true"
+            form.Versioning = &enable
+        }
+        if *optVersioningKeep >= 0 {
+            form.KeepVersions = optVersioningKeep
+        }
+        if *optVersioningDays >= 0 {
+            form.KeepDays = optVersioningDays
+        }
+        client := client.New()
+        res, err := client.Versioning(*optNode, *optUserName, *optPassword, form)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "versions") {
+
+        versionCommands.Parse(localArgs)
+        client := client.New()
+        res, err := client.Versions(*optNode, *optUserName, *optPassword, *optVersionBucket, *optVersionFileName)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "restore") {
+
+        versionCommands.Parse(localArgs)
+        client := client.New()
+        res, err := client.Restore(*optNode, *optUserName, *optPassword, *optVersionBucket, *optVersionFileName, *optVersionId)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "purge") {
+
+        versionCommands.Parse(localArgs)
+        client := client.New()
+        res, err := client.Purge(*optNode, *optUserName, *optPassword, *optVersionBucket, *optVersionFileName, *optVersionId)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "tag") {
+
+        tagCommands.Parse(localArgs)
+        query, err := url.ParseQuery(*optTagTags)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        tags := make(map[string]string)
+        for key := range query {
+            tags[key] = query.Get(key)
+        }
+        client := client.New()
+        res, err := client.Tags(*optNode, *optUserName, *optPassword, *optTagBucket, *optTagFileName, tags)
+        if err != nil {
+            fmt.Println("error:", err)
+            os.Exit(1)
+        }
+        fmt.Println(res)
+
+    } else if strings.HasPrefix(command, "list") {
 
         listCommands.Parse(localArgs)
+        form := client.TreeForm{
+            Bucket: *optListBucket,
+            Pattern: *optListPattern,
+            Tag: *optListTag,
+            Prefix: *optListPrefix,
+            Delimiter: *optListDelimiter,
+            Recursive: *optListRecursive,
+            Sort: *optListSort,
+            Order: *optListOrder,
+            MinSize: *optListMinSize,
+            MaxSize: *optListMaxSize,
+            Since: *optListSince,
+            Cursor: *optListLimit > 0 || len(*optListToken) > 0,
+            Token: *optListToken,
+            Limit: *optListLimit,
+        }
+        tree := form != client.TreeForm{ Bucket: form.Bucket, Pattern: form.Pattern, Tag: form.Tag }
         client := client.New()
-        res, err := client.List(*optNode, *optUserName, *optPassword, *optListBucket, *optListPattern)
+        var res string
+        var err error
+        if tree {
+            res, err = client.Tree(*optNode, *optUserName, *optPassword, form)
+        } else {
+            res, err = client.ListTag(*optNode, *optUserName, *optPassword, *optListBucket, *optListPattern, *optListTag)
+        }
         if err != nil {
             fmt.Println("error:", err)
             os.Exit(1)
@@ -108,8 +523,21 @@ func main() {
     } else if strings.HasPrefix(command, "put") {
 
         putCommands.Parse(localArgs)
+        meta := make(map[string]string)
+        for _, item := range strings.Split(*optPutMeta, ",") {
+            if pair := strings.SplitN(item, "=", 2); len(pair) == 2 {
+                meta[pair[0]] = pair[1]
+            }
+        }
         client := client.New()
-        res, err := client.Put(*optNode, *optUserName, *optPassword, *optPutBucket, *optPutFileName)
+        var res string
+        var err error
+        if len(*optPutArchive) > 0 {
+            res, err = client.Extract(*optNode, *optUserName, *optPassword, *optPutBucket, *optPutPrefix, *optPutArchive)
+        } else {
+            res, err = client.PutAttributes(*optNode, *optUserName, *optPassword, *optPutBucket, *optPutFileName,
+                                            meta, *optPutTags)
+        }
         if err != nil {
             fmt.Println("error:", err)
             os.Exit(1)
@@ -120,7 +548,14 @@ func main() {
 
         getCommands.Parse(localArgs)
         client := client.New()
-        res, err := client.Get(*optNode, *optUserName, *optPassword, *optGetBucket, *optGetFileName)
+        var res string
+        var err error
+        if len(*optGetArchive) > 0 {
+            res, err = client.Archive(*optNode, *optUserName, *optPassword, *optGetBucket, *optGetFileName,
+                                        *optGetRecursive, *optGetArchive)
+        } else {
+            res, err = client.GetVersion(*optNode, *optUserName, *optPassword, *optGetBucket, *optGetFileName, *optGetVersion)
+        }
         if err != nil {
             fmt.Println("error:", err)
             os.Exit(1)
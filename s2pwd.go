@@ -9,8 +9,12 @@ import (
     "strings"
     "encoding/json"
     "errors"
+    "time"
 
     "store/server/user-model"
+    "store/server/s3key-model"
+    "store/server/acl-model"
+    "store/server/apikey-model"
     "store/config"
 
     "github.com/jmoiron/sqlx"
@@ -38,12 +42,35 @@ func main() {
     deleteCommands := flag.NewFlagSet("delete", flag.ExitOnError)
     optDeleteName := deleteCommands.String("name", "", "user name")
 
+    s3Commands := flag.NewFlagSet("s3key", flag.ExitOnError)
+    optS3Name := s3Commands.String("name", "", "user name")
+    optS3Key := s3Commands.String("key", "", "access key")
+
+    aclCommands := flag.NewFlagSet("acl", flag.ExitOnError)
+    optAclName := aclCommands.String("name", "", "user name")
+    optAclGroup := aclCommands.String("group", "", "group name")
+    optAclBucket := aclCommands.String("bucket", "", "bucket name or pattern")
+    optAclPerm := aclCommands.String("perm", "", "permissions: r(ead), w(rite), d(elete), l(ist)")
+
+    keyCommands := flag.NewFlagSet("key", flag.ExitOnError)
+    optKeyName := keyCommands.String("name", "", "user name")
+    optKeyKeyName := keyCommands.String("keyname", "", "key name")
+    optKeyScope := keyCommands.String("scope", "full", "key scope: read, write, full or permission letters")
+    optKeyBucket := keyCommands.String("bucket", "", "restrict key to bucket name or pattern")
+    optKeyDays := keyCommands.Int("days", 0, "key expiration in days, 0 for never")
+    optKeyId := keyCommands.Int("id", 0, "key id")
+
+    groupCommands := flag.NewFlagSet("group", flag.ExitOnError)
+    optGroupName := groupCommands.String("name", "", "user name")
+    optGroupGroup := groupCommands.String("group", "", "group name")
+
     exeName := filepath.Base(os.Args[0])
 
     flag.Usage = func() {
         fmt.Printf("usage: %s [global option] command [command option]\n", exeName)
         fmt.Println("")
-        fmt.Println("commands: migrate, create, update, auth, delete, list")
+        fmt.Println("commands: migrate, create, update, auth, delete, list, s3create, s3list, s3delete,")
+        fmt.Println("          grant, revoke, acls, join, leave, members, keycreate, keylist, keyrevoke")
         fmt.Println("")
 
         fmt.Println("global option:")
@@ -62,6 +89,22 @@ func main() {
         fmt.Println("delete option:")
         deleteCommands.PrintDefaults()
 
+        fmt.Println("")
+        fmt.Println("s3create|s3list|s3delete option:")
+        s3Commands.PrintDefaults()
+
+        fmt.Println("")
+        fmt.Println("grant|revoke|acls option:")
+        aclCommands.PrintDefaults()
+
+        fmt.Println("")
+        fmt.Println("join|leave|members option:")
+        groupCommands.PrintDefaults()
+
+        fmt.Println("")
+        fmt.Println("keycreate|keylist|keyrevoke option:")
+        keyCommands.PrintDefaults()
+
         os.Exit(0)
     }
 
@@ -95,6 +138,17 @@ func main() {
     }
 
     user := userModel.New(db)
+    s3key := s3keyModel.New(db)
+    acl := aclModel.New(db)
+    apikey := apikeyModel.New(db)
+
+    /* Create tables added after initial migration */
+    for _, migrate := range []func() error{ s3key.Migrate, acl.Migrate, apikey.Migrate } {
+        if err = migrate(); err != nil {
+            printError(err)
+            os.Exit(1)
+        }
+    }
 
     if strings.HasPrefix(command, "mig") {
         err = user.Migrate()
@@ -156,6 +210,150 @@ func main() {
         }
         err = user.Check(&_user)
 
+    /* Create S3 access key for user */
+    } else if strings.HasPrefix(command, "s3c") {
+
+        s3Commands.Parse(localArgs)
+        _user := userModel.User{
+            Username: *optS3Name,
+        }
+
+        var res userModel.User
+        res, err = user.Find(_user)
+        if err == nil {
+            var key s3keyModel.Key
+            key, err = s3key.Create(res.Id)
+            if err == nil {
+                key.Username = res.Username
+                key.IsAdmin = res.IsAdmin
+                printKey(key)
+            }
+        }
+
+    } else if strings.HasPrefix(command, "s3l") {
+
+        s3Commands.Parse(localArgs)
+        _user := userModel.User{
+            Username: *optS3Name,
+        }
+
+        var res userModel.User
+        res, err = user.Find(_user)
+        if err == nil {
+            var keys []s3keyModel.Key
+            keys, err = s3key.List(res.Id)
+            for _, item := range keys {
+                printKey(item)
+            }
+        }
+
+    } else if strings.HasPrefix(command, "s3d") {
+
+        s3Commands.Parse(localArgs)
+        if len(*optS3Key) == 0 {
+            err = errors.New("access key is empty")
+        } else {
+            err = s3key.Delete(*optS3Key)
+        }
+
+    /* Bucket access rules */
+    } else if strings.HasPrefix(command, "gra") || strings.HasPrefix(command, "rev") {
+
+        aclCommands.Parse(localArgs)
+        rule := aclModel.Rule{
+            Kind: aclModel.KindUser,
+            Subject: *optAclName,
+            Bucket: *optAclBucket,
+            Perms: *optAclPerm,
+        }
+        if len(*optAclGroup) > 0 {
+            rule.Kind = aclModel.KindGroup
+            rule.Subject = *optAclGroup
+        }
+        if strings.HasPrefix(command, "gra") {
+            err = acl.Grant(rule)
+        } else {
+            err = acl.Revoke(rule)
+        }
+
+    } else if strings.HasPrefix(command, "acl") {
+
+        aclCommands.Parse(localArgs)
+        var rules []aclModel.Rule
+        if len(*optAclGroup) > 0 {
+            rules, err = acl.List(aclModel.KindGroup, *optAclGroup)
+        } else {
+            rules, err = acl.List(aclModel.KindUser, *optAclName)
+        }
+        for _, item := range rules {
+            printJSON(item)
+        }
+
+    /* Group membership */
+    } else if strings.HasPrefix(command, "joi") {
+
+        groupCommands.Parse(localArgs)
+        err = acl.Join(*optGroupGroup, *optGroupName)
+
+    } else if strings.HasPrefix(command, "lea") {
+
+        groupCommands.Parse(localArgs)
+        err = acl.Leave(*optGroupGroup, *optGroupName)
+
+    } else if strings.HasPrefix(command, "mem") {
+
+        groupCommands.Parse(localArgs)
+        var members []aclModel.Member
+        members, err = acl.Members(*optGroupGroup)
+        for _, item := range members {
+            printJSON(item)
+        }
+
+    /* API keys */
+    } else if strings.HasPrefix(command, "keyc") {
+
+        keyCommands.Parse(localArgs)
+        var res userModel.User
+        res, err = user.Find(userModel.User{ Username: *optKeyName })
+        if err == nil {
+            key := apikeyModel.Key{
+                UserId: res.Id,
+                Username: res.Username,
+                Name: *optKeyKeyName,
+                Perms: *optKeyScope,
+                Bucket: *optKeyBucket,
+            }
+            if *optKeyDays > 0 {
+                key.Expires = time.Now().AddDate(0, 0, *optKeyDays).Unix()
+            }
+            key, err = apikey.Create(key)
+            if err == nil {
+                printJSON(key)
+            }
+        }
+
+    } else if strings.HasPrefix(command, "keyl") {
+
+        keyCommands.Parse(localArgs)
+        var userId int
+        if len(*optKeyName) > 0 {
+            var res userModel.User
+            res, err = user.Find(userModel.User{ Username: *optKeyName })
+            userId = res.Id
+        }
+        if err == nil {
+            var keys []apikeyModel.Key
+            keys, err = apikey.List(userId)
+            for _, item := range keys {
+                printJSON(item)
+            }
+        }
+
+    } else if strings.HasPrefix(command, "keyr") {
+
+        keyCommands.Parse(localArgs)
+        err = apikey.Revoke(*optKeyId, 0)
+
     } else if strings.HasPrefix(command, "lis") {
         _page := userModel.Page{
             Limit: 1000,
@@ -184,6 +382,16 @@ func printUser(user userModel.User) {
     fmt.Println(string(data))
 }
 
+func printJSON(item interface{}) {
+    data, _ := json.Marshal(item)
+    fmt.Println(string(data))
+}
+
+func printKey(key s3keyModel.Key) {
+    data, _ := json.Marshal(key)
+    fmt.Println(string(data))
+}
+
 func printError(err error) {
     fmt.Printf(`{"error":true, "message":"%s"}` + "\n", err)
 }
@@ -0,0 +1,282 @@
+package aclModel
+
+import (
+    "errors"
+    "log"
+    "path/filepath"
+    "strings"
+
+    "github.com/jmoiron/sqlx"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS acls (
+        id          INTEGER PRIMARY KEY,
+        kind        VARCHAR(16) NOT NULL,
+        subject     VARCHAR(255) NOT NULL,
+        bucket      VARCHAR(255) NOT NULL,
+        perms       VARCHAR(16) NOT NULL,
+        UNIQUE (kind, subject, bucket)
+    );
+    CREATE TABLE IF NOT EXISTS members (
+        id          INTEGER PRIMARY KEY,
+        groupname   VARCHAR(255) NOT NULL,
+        username    VARCHAR(255) NOT NULL,
+        UNIQUE (groupname, username)
+    );`
+
+/* Permission letters of the rule */
+const (
+    PermRead    string = "r"
+    PermWrite   string = "w"
+    PermDelete  string = "d"
+    PermList    string = "l"
+    PermAll     string = "rwdl"
+)
+
+/* Rule subject kinds */
+const (
+    KindUser    string = "user"
+    KindGroup   string = "group"
+)
+
+type Model struct {
+    db *sqlx.DB
+}
+
+/* Access rule of the user or group to buckets matching the pattern */
+type Rule struct {
+    Id          int     `db:"id"         json:"id"`
+    Kind        string  `db:"kind"       json:"kind"`
+    Subject     string  `db:"subject"    json:"subject"`
+    Bucket      string  `db:"bucket"     json:"bucket"`
+    Perms       string  `db:"perms"      json:"perms"`
+}
+
+type Member struct {
+    Id          int     `db:"id"         json:"id"`
+    Groupname   string  `db:"groupname"  json:"groupname"`
+    Username    string  `db:"username"   json:"username"`
+}
+
+/* Return perms in canonical order, without duplicates */
+func NormalizePerms(perms string) (string, error) {
+    var out string
+    for _, perm := range strings.Split(PermAll, "") {
+        if strings.Contains(perms, perm) {
+            out += perm
+        }
+    }
+    for _, char := range perms {
+        if !strings.ContainsRune(PermAll, char) {
+            return "", errors.New("wrong permission, allowed letters are " + PermAll)
+        }
+    }
+    return out, nil
+}
+
+/* Bucket pattern matches the bucket itself and buckets nested in it */
+func MatchBucket(pattern, bucket string) bool {
+    pattern = strings.Trim(pattern, "/")
+    bucket = strings.Trim(bucket, "/")
+    if pattern == "*" {
+        return true
+    }
+    for {
+        if match, _ := filepath.Match(pattern, bucket); match {
+            return true
+        }
+        index := strings.LastIndex(bucket, "/")
+        if index < 0 {
+            return false
+        }
+        bucket = bucket[:index]
+    }
+}
+
+func (this *Model) Migrate() error {
+    _, err := this.db.Exec(schema)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func (this *Model) find(rule Rule) (Rule, error) {
+    request := `SELECT * FROM acls WHERE kind = $1 AND subject = $2 AND bucket = $3 LIMIT 1`
+    var out Rule
+    err := this.db.Get(&out, request, rule.Kind, rule.Subject, rule.Bucket)
+    return out, err
+}
+
+func validateRule(rule *Rule) error {
+    var err error
+    if rule.Kind != KindUser && rule.Kind != KindGroup {
+        return errors.New("wrong rule kind")
+    }
+    if len(rule.Subject) == 0 {
+        return errors.New("rule subject is empty")
+    }
+    rule.Bucket = strings.Trim(rule.Bucket, "/")
+    if len(rule.Bucket) == 0 {
+        return errors.New("rule bucket is empty")
+    }
+    if _, err := filepath.Match(rule.Bucket, ""); err != nil {
+        return err
+    }
+    rule.Perms, err = NormalizePerms(rule.Perms)
+    return err
+}
+
+/* Add permissions to the rule, create the rule if not exists */
+func (this *Model) Grant(rule Rule) error {
+    if err := validateRule(&rule); err != nil {
+        return err
+    }
+    exists, err := this.find(rule)
+    if err != nil {
+        request := `INSERT INTO acls(kind, subject, bucket, perms) VALUES ($1, $2, $3, $4)`
+        _, err = this.db.Exec(request, rule.Kind, rule.Subject, rule.Bucket, rule.Perms)
+    } else {
+        perms, _ := NormalizePerms(exists.Perms + rule.Perms)
+        request := `UPDATE acls SET perms = $1 WHERE id = $2`
+        _, err = this.db.Exec(request, perms, exists.Id)
+    }
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Remove permissions from the rule, drop the rule if no permissions left.
+ * Empty perms drop the rule completely */
+func (this *Model) Revoke(rule Rule) error {
+    if len(rule.Perms) == 0 {
+        rule.Perms = PermAll
+    }
+    if err := validateRule(&rule); err != nil {
+        return err
+    }
+    exists, err := this.find(rule)
+    if err != nil {
+        return errors.New("rule not found")
+    }
+
+    var perms string
+    for _, char := range exists.Perms {
+        if !strings.ContainsRune(rule.Perms, char) {
+            perms += string(char)
+        }
+    }
+    if len(perms) == 0 {
+        request := `DELETE FROM acls WHERE id = $1`
+        _, err = this.db.Exec(request, exists.Id)
+    } else {
+        request := `UPDATE acls SET perms = $1 WHERE id = $2`
+        _, err = this.db.Exec(request, perms, exists.Id)
+    }
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* List rules of the subject, all rules if subject is empty */
+func (this *Model) List(kind, subject string) ([]Rule, error) {
+    rules := []Rule{}
+    var err error
+    if len(subject) == 0 {
+        request := `SELECT * FROM acls ORDER BY kind, subject, bucket`
+        err = this.db.Select(&rules, request)
+    } else {
+        request := `SELECT * FROM acls WHERE kind = $1 AND subject = $2 ORDER BY bucket`
+        err = this.db.Select(&rules, request, kind, subject)
+    }
+    if err != nil {
+        log.Println(err)
+        return rules, err
+    }
+    return rules, nil
+}
+
+func (this *Model) Join(groupname, username string) error {
+    if len(groupname) == 0 || len(username) == 0 {
+        return errors.New("group or user name is empty")
+    }
+    request := `INSERT INTO members(groupname, username) VALUES ($1, $2)`
+    _, err := this.db.Exec(request, groupname, username)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func (this *Model) Leave(groupname, username string) error {
+    request := `DELETE FROM members WHERE groupname = $1 AND username = $2`
+    _, err := this.db.Exec(request, groupname, username)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* List group members, all memberships if group is empty */
+func (this *Model) Members(groupname string) ([]Member, error) {
+    members := []Member{}
+    var err error
+    if len(groupname) == 0 {
+        request := `SELECT * FROM members ORDER BY groupname, username`
+        err = this.db.Select(&members, request)
+    } else {
+        request := `SELECT * FROM members WHERE groupname = $1 ORDER BY username`
+        err = this.db.Select(&members, request, groupname)
+    }
+    if err != nil {
+        log.Println(err)
+        return members, err
+    }
+    return members, nil
+}
+
+/* Return true if the user has the permission on the bucket.
+ * Admin users bypass the rules */
+func (this *Model) Check(username, bucket, perm string) (bool, error) {
+    var isAdmin bool
+    request := `SELECT isadmin FROM users WHERE username = $1 LIMIT 1`
+    err := this.db.Get(&isAdmin, request, username)
+    if err != nil {
+        log.Println(err)
+        return false, err
+    }
+    if isAdmin {
+        return true, nil
+    }
+
+    var rules []Rule
+    request = `SELECT * FROM acls
+                WHERE (kind = $1 AND subject = $2)
+                    OR (kind = $3 AND subject IN (SELECT groupname FROM members WHERE username = $2))`
+    err = this.db.Select(&rules, request, KindUser, username, KindGroup)
+    if err != nil {
+        log.Println(err)
+        return false, err
+    }
+    for _, rule := range rules {
+        if strings.Contains(rule.Perms, perm) && MatchBucket(rule.Bucket, bucket) {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+func New(db *sqlx.DB) *Model {
+    model := Model{
+        db: db,
+    }
+    return &model
+}
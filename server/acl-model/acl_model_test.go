@@ -0,0 +1,41 @@
+package aclModel
+
+import (
+    "testing"
+)
+
+func TestMatchBucket(t *testing.T) {
+    cases := []struct {
+        pattern string
+        bucket  string
+        match   bool
+    }{
+        { "*", "foo", true },
+        { "*", "foo/bar", true },
+        { "foo", "foo", true },
+        { "foo", "foo/bar", true },
+        { "foo", "foobar", false },
+        { "foo/bar", "foo", false },
+        { "team-*", "team-a/nightly", true },
+        { "team-*", "other", false },
+    }
+    for _, item := range cases {
+        if MatchBucket(item.pattern, item.bucket) != item.match {
+            t.Errorf("pattern %s bucket %s: expected %v", item.pattern, item.bucket, item.match)
+        }
+    }
+}
+
+func TestNormalizePerms(t *testing.T) {
+    perms, err := NormalizePerms("lrwr")
+    if err != nil {
+        t.Error(err)
+    }
+    if perms != "rwl" {
+        t.Errorf("wrong perms %s", perms)
+    }
+    _, err = NormalizePerms("rx")
+    if err == nil {
+        t.Error("wrong permission letter is accepted")
+    }
+}
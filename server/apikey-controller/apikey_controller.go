@@ -0,0 +1,167 @@
+package apikeyController
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+
+    "store/config"
+    "store/server/apikey-model"
+    "store/server/user-model"
+)
+
+type Controller struct {
+    config *config.Config
+    db *sqlx.DB
+    key *apikeyModel.Model
+    user *userModel.Model
+}
+
+type Response struct {
+    Error       bool        `json:"error"`
+    Message     string      `json:"message,omitempty"`
+    Result      interface{} `json:"result,omitempty"`
+}
+
+func sendError(context *gin.Context, err error) {
+    if err == nil {
+        err = errors.New("undefined")
+    }
+    log.Printf("%s\n", err)
+    response := Response{
+        Error: true,
+        Message: fmt.Sprintf("%s", err),
+        Result: nil,
+    }
+    context.JSON(http.StatusOK, response)
+}
+
+func sendOk(context *gin.Context) {
+    response := Response{
+        Error: false,
+        Message: "",
+        Result: nil,
+    }
+    context.JSON(http.StatusOK, response)
+}
+
+func sendResult(context *gin.Context, result interface{}) {
+    response := Response{
+        Error: false,
+        Message: "",
+        Result: result,
+    }
+    context.JSON(http.StatusOK, &response)
+}
+
+/* Return the session user or, for admins, the user named in the request */
+func (this *Controller) owner(context *gin.Context, username string) (userModel.User, error) {
+    session, err := this.user.Find(userModel.User{ Username: context.GetString("username") })
+    if err != nil {
+        return session, err
+    }
+    if len(username) == 0 || username == session.Username {
+        return session, nil
+    }
+    if !session.IsAdmin {
+        return session, errors.New("only admin can manage keys of other users")
+    }
+    return this.user.Find(userModel.User{ Username: username })
+}
+
+type createForm struct {
+    Username    string  `form:"username" json:"username"`
+    Name        string  `form:"name"     json:"name"     binding:"required"`
+    Scope       string  `form:"scope"    json:"scope"`
+    Bucket      string  `form:"bucket"   json:"bucket"`
+    Days        int     `form:"days"     json:"days"`
+}
+
+func (this *Controller) Create(context *gin.Context) {
+    var form createForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    user, err := this.owner(context, form.Username)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+
+    key := apikeyModel.Key{
+        UserId: user.Id,
+        Username: user.Username,
+        Name: form.Name,
+        Perms: form.Scope,
+        Bucket: form.Bucket,
+    }
+    if form.Days > 0 {
+        key.Expires = time.Now().AddDate(0, 0, form.Days).Unix()
+    }
+    key, err = this.key.Create(key)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, &key)
+}
+
+type listForm struct {
+    Username    string  `form:"username" json:"username"`
+}
+
+func (this *Controller) List(context *gin.Context) {
+    var form listForm
+    _ = context.ShouldBind(&form)
+
+    user, err := this.owner(context, form.Username)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    keys, err := this.key.List(user.Id)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, keys)
+}
+
+type revokeForm struct {
+    Username    string  `form:"username" json:"username"`
+    Id          int     `form:"id"       json:"id"       binding:"required"`
+}
+
+func (this *Controller) Revoke(context *gin.Context) {
+    var form revokeForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    user, err := this.owner(context, form.Username)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    err = this.key.Revoke(form.Id, user.Id)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendOk(context)
+}
+
+func New(config *config.Config, db *sqlx.DB) *Controller {
+    return &Controller{
+        config: config,
+        db: db,
+        key: apikeyModel.New(db),
+        user: userModel.New(db),
+    }
+}
@@ -0,0 +1,225 @@
+package apikeyModel
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/jmoiron/sqlx"
+
+    "store/server/acl-model"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS apikeys (
+        id          INTEGER PRIMARY KEY,
+        userid      INTEGER NOT NULL,
+        name        VARCHAR(255) NOT NULL,
+        prefix      VARCHAR(32) NOT NULL,
+        hash        VARCHAR(64) NOT NULL UNIQUE,
+        perms       VARCHAR(16) NOT NULL,
+        bucket      VARCHAR(255) NOT NULL DEFAULT '',
+        created     INTEGER NOT NULL DEFAULT 0,
+        expires     INTEGER NOT NULL DEFAULT 0,
+        lastused    INTEGER NOT NULL DEFAULT 0
+    );`
+
+const (
+    tokenPrefix     string = "s2k_"
+    /* Do not write last used time on every request */
+    lastUsedPeriod  int64 = 60
+)
+
+/* Key scopes as permission sets */
+var Scopes = map[string]string{
+    "read":     aclModel.PermRead + aclModel.PermList,
+    "write":    aclModel.PermRead + aclModel.PermWrite + aclModel.PermList,
+    "full":     aclModel.PermAll,
+}
+
+type Model struct {
+    db *sqlx.DB
+}
+
+/* API key of the user. Only the SHA256 hash of the token is stored,
+ * the token itself is returned once on creation */
+type Key struct {
+    Id          int     `db:"id"         json:"id"`
+    UserId      int     `db:"userid"     json:"userid"`
+    Username    string  `db:"username"   json:"username"`
+    Name        string  `db:"name"       json:"name"`
+    Prefix      string  `db:"prefix"     json:"prefix"`
+    Hash        string  `db:"hash"       json:"-"`
+    Perms       string  `db:"perms"      json:"perms"`
+    Bucket      string  `db:"bucket"     json:"bucket"`
+    Created     int64   `db:"created"    json:"created"`
+    Expires     int64   `db:"expires"    json:"expires"`
+    LastUsed    int64   `db:"lastused"   json:"lastused"`
+    Token       string  `db:"-"          json:"token,omitempty"`
+}
+
+/* Return true if the key scope allows the operation on the bucket */
+func (this Key) Allow(bucket, perm string) bool {
+    if !strings.Contains(this.Perms, perm) {
+        return false
+    }
+    if len(this.Bucket) > 0 && !aclModel.MatchBucket(this.Bucket, bucket) {
+        return false
+    }
+    return true
+}
+
+/* Return true if the key has all permissions on all buckets, only
+ * such keys act for the admin owner on server wide operations */
+func (this Key) Unscoped() bool {
+    return this.Perms == aclModel.PermAll && len(this.Bucket) == 0
+}
+
+func (this Key) Expired(now time.Time) bool {
+    return this.Expires > 0 && now.Unix() >= this.Expires
+}
+
+func hashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+    arr := make([]byte, 32)
+    if _, err := rand.Read(arr); err != nil {
+        return "", err
+    }
+    return tokenPrefix + base64.RawURLEncoding.EncodeToString(arr), nil
+}
+
+/* Resolve scope name or permission letters to permissions */
+func ScopePerms(scope string) (string, error) {
+    if len(scope) == 0 {
+        scope = "full"
+    }
+    if perms, exists := Scopes[scope]; exists {
+        return perms, nil
+    }
+    return aclModel.NormalizePerms(scope)
+}
+
+func (this *Model) Migrate() error {
+    _, err := this.db.Exec(schema)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Create key for the user, returned key carries plain token */
+func (this *Model) Create(key Key) (Key, error) {
+    var err error
+    if len(key.Name) == 0 {
+        return key, errors.New("key name is empty")
+    }
+    key.Perms, err = ScopePerms(key.Perms)
+    if err != nil {
+        return key, err
+    }
+    if len(key.Perms) == 0 {
+        return key, errors.New("key scope is empty")
+    }
+    key.Bucket = strings.Trim(key.Bucket, "/")
+
+    key.Token, err = newToken()
+    if err != nil {
+        return key, err
+    }
+    key.Prefix = key.Token[:len(tokenPrefix) + 6]
+    key.Hash = hashToken(key.Token)
+    key.Created = time.Now().Unix()
+
+    request := `INSERT INTO apikeys(userid, name, prefix, hash, perms, bucket, created, expires)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+    result, err := this.db.Exec(request, key.UserId, key.Name, key.Prefix, key.Hash,
+                                    key.Perms, key.Bucket, key.Created, key.Expires)
+    if err != nil {
+        log.Println(err)
+        return key, err
+    }
+    id, _ := result.LastInsertId()
+    key.Id = int(id)
+    return key, nil
+}
+
+/* List keys of the user, all keys if user id is zero */
+func (this *Model) List(userId int) ([]Key, error) {
+    keys := []Key{}
+    var err error
+    request := `SELECT k.*, u.username FROM apikeys k JOIN users u ON u.id = k.userid`
+    if userId == 0 {
+        err = this.db.Select(&keys, request + ` ORDER BY u.username, k.id`)
+    } else {
+        err = this.db.Select(&keys, request + ` WHERE k.userid = $1 ORDER BY k.id`, userId)
+    }
+    if err != nil {
+        log.Println(err)
+        return keys, err
+    }
+    return keys, nil
+}
+
+/* Delete key of the user, any key if user id is zero */
+func (this *Model) Revoke(id int, userId int) error {
+    var result sql.Result
+    var err error
+    if userId == 0 {
+        result, err = this.db.Exec(`DELETE FROM apikeys WHERE id = $1`, id)
+    } else {
+        result, err = this.db.Exec(`DELETE FROM apikeys WHERE id = $1 AND userid = $2`, id, userId)
+    }
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    if count, _ := result.RowsAffected(); count == 0 {
+        return errors.New("key not found")
+    }
+    return nil
+}
+
+/* Find key by token, check expiration and update last used time */
+func (this *Model) Authenticate(token string) (Key, error) {
+    var key Key
+    if !strings.HasPrefix(token, tokenPrefix) {
+        return key, errors.New("wrong token format")
+    }
+    request := `SELECT k.*, u.username FROM apikeys k JOIN users u ON u.id = k.userid
+                WHERE k.hash = $1 LIMIT 1`
+    err := this.db.Get(&key, request, hashToken(token))
+    if err != nil {
+        return key, errors.New("api key not found")
+    }
+
+    now := time.Now()
+    if key.Expired(now) {
+        return key, errors.New("api key " + key.Prefix + " is expired")
+    }
+    if now.Unix() - key.LastUsed > lastUsedPeriod {
+        key.LastUsed = now.Unix()
+        _, err = this.db.Exec(`UPDATE apikeys SET lastused = $1 WHERE id = $2`, key.LastUsed, key.Id)
+        if err != nil {
+            log.Println(err)
+        }
+    }
+    return key, nil
+}
+
+func New(db *sqlx.DB) *Model {
+    model := Model{
+        db: db,
+    }
+    return &model
+}
@@ -0,0 +1,75 @@
+package apikeyModel
+
+import (
+    "testing"
+    "time"
+)
+
+func TestScopePerms(t *testing.T) {
+    tests := map[string]string{
+        "":         "rwdl",
+        "read":     "rl",
+        "write":    "rwl",
+        "full":     "rwdl",
+        "lr":       "rl",
+    }
+    for scope, want := range tests {
+        perms, err := ScopePerms(scope)
+        if err != nil {
+            t.Errorf("scope %s: %s", scope, err)
+        }
+        if perms != want {
+            t.Errorf("scope %s: got %s, want %s", scope, perms, want)
+        }
+    }
+    if _, err := ScopePerms("admin"); err == nil {
+        t.Error("wrong scope is accepted")
+    }
+}
+
+func TestKeyAllow(t *testing.T) {
+    key := Key{ Perms: "rl", Bucket: "team-*" }
+    if !key.Allow("team-a", "r") {
+        t.Error("read in scope bucket is denied")
+    }
+    if !key.Allow("team-a/nested", "l") {
+        t.Error("list in nested bucket is denied")
+    }
+    if key.Allow("team-a", "w") {
+        t.Error("write is allowed for read key")
+    }
+    if key.Allow("other", "r") {
+        t.Error("read is allowed out of bucket scope")
+    }
+    key.Bucket = ""
+    if !key.Allow("other", "r") {
+        t.Error("read is denied for unbound key")
+    }
+}
+
+func TestKeyUnscoped(t *testing.T) {
+    if !(Key{ Perms: "rwdl" }).Unscoped() {
+        t.Error("full key is scoped")
+    }
+    if (Key{ Perms: "rl" }).Unscoped() {
+        t.Error("read key is unscoped")
+    }
+    if (Key{ Perms: "rwdl", Bucket: "team-*" }).Unscoped() {
+        t.Error("bucket key is unscoped")
+    }
+}
+
+func TestKeyExpired(t *testing.T) {
+    now := time.Now()
+    key := Key{}
+    if key.Expired(now) {
+        t.Error("key without expiration is expired")
+    }
+    key.Expires = now.Add(time.Hour).Unix()
+    if key.Expired(now) {
+        t.Error("key is expired before time")
+    }
+    if !key.Expired(now.Add(2 * time.Hour)) {
+        t.Error("key is not expired after time")
+    }
+}
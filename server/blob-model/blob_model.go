@@ -0,0 +1,139 @@
+package blobModel
+
+import (
+    "log"
+    "time"
+
+    "github.com/jmoiron/sqlx"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS blobs (
+        id          INTEGER PRIMARY KEY,
+        sha256      VARCHAR(64) NOT NULL UNIQUE,
+        size        INTEGER NOT NULL DEFAULT 0,
+        refs        INTEGER NOT NULL DEFAULT 0,
+        created     INTEGER NOT NULL DEFAULT 0,
+        released    INTEGER NOT NULL DEFAULT 0
+    );
+    CREATE INDEX IF NOT EXISTS blobs_refs ON blobs (refs, released);`
+
+type Model struct {
+    db *sqlx.DB
+}
+
+/* Data stored once by the content hash, refs counts files referencing it */
+type Blob struct {
+    Id          int     `db:"id"         json:"-"`
+    SHA256      string  `db:"sha256"     json:"sha256"`
+    Size        int64   `db:"size"       json:"size"`
+    Refs        int64   `db:"refs"       json:"refs"`
+    Created     int64   `db:"created"    json:"created"`
+    Released    int64   `db:"released"   json:"released"`
+}
+
+/* Logical bytes are sizes of referencing files, physical are sizes of
+ * blobs, unreferenced blobs wait for garbage collection */
+type Stats struct {
+    Blobs               int64   `db:"blobs"              json:"blobs"`
+    Refs                int64   `db:"refs"               json:"refs"`
+    Unreferenced        int64   `db:"unreferenced"       json:"unreferenced"`
+    LogicalBytes        int64   `db:"logical"            json:"logicalbytes"`
+    PhysicalBytes       int64   `db:"physical"           json:"physicalbytes"`
+    UnreferencedBytes   int64   `db:"unreferencedbytes"  json:"unreferencedbytes"`
+    SavedBytes          int64   `db:"-"                  json:"savedbytes"`
+}
+
+func (this *Model) Migrate() error {
+    _, err := this.db.Exec(schema)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Count the new reference of the blob, the blob is added on first reference */
+func (this *Model) AddRef(sum string, size int64) error {
+    request := `INSERT INTO blobs(sha256, size, refs, created) VALUES ($1, $2, 1, $3)
+                ON CONFLICT(sha256) DO UPDATE SET refs = refs + 1`
+    _, err := this.db.Exec(request, sum, size, time.Now().Unix())
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Drop the reference of the blob, the blob without
+ * references is removed by garbage collection */
+func (this *Model) Release(sum string) error {
+    request := `UPDATE blobs SET refs = MAX(refs - 1, 0), released = $1 WHERE sha256 = $2`
+    _, err := this.db.Exec(request, time.Now().Unix(), sum)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Blob of the hash, false if the blob is unknown */
+func (this *Model) Find(sum string) (Blob, bool, error) {
+    var blob Blob
+    request := `SELECT * FROM blobs WHERE sha256 = $1 LIMIT 1`
+    blobs := []Blob{}
+    if err := this.db.Select(&blobs, request, sum); err != nil {
+        log.Println(err)
+        return blob, false, err
+    }
+    if len(blobs) == 0 {
+        return blob, false, nil
+    }
+    return blobs[0], true, nil
+}
+
+/* Blobs without references released before the time */
+func (this *Model) Unreferenced(before time.Time) ([]Blob, error) {
+    blobs := []Blob{}
+    request := `SELECT * FROM blobs WHERE refs = 0 AND released < $1 ORDER BY id`
+    if err := this.db.Select(&blobs, request, before.Unix()); err != nil {
+        log.Println(err)
+        return blobs, err
+    }
+    return blobs, nil
+}
+
+/* Remove the blob if it has no references, false if it is referenced again */
+func (this *Model) Delete(sum string) (bool, error) {
+    request := `DELETE FROM blobs WHERE sha256 = $1 AND refs = 0`
+    result, err := this.db.Exec(request, sum)
+    if err != nil {
+        log.Println(err)
+        return false, err
+    }
+    count, err := result.RowsAffected()
+    return count > 0, err
+}
+
+func (this *Model) Stats() (Stats, error) {
+    var stats Stats
+    request := `SELECT COUNT(*) AS blobs,
+                    COALESCE(SUM(refs), 0) AS refs,
+                    COALESCE(SUM(refs = 0), 0) AS unreferenced,
+                    COALESCE(SUM(size * refs), 0) AS logical,
+                    COALESCE(SUM(size), 0) AS physical,
+                    COALESCE(SUM(CASE WHEN refs = 0 THEN size ELSE 0 END), 0) AS unreferencedbytes
+                FROM blobs`
+    if err := this.db.Get(&stats, request); err != nil {
+        log.Println(err)
+        return stats, err
+    }
+    stats.SavedBytes = stats.LogicalBytes - stats.PhysicalBytes + stats.UnreferencedBytes
+    return stats, nil
+}
+
+func New(db *sqlx.DB) *Model {
+    return &Model{
+        db: db,
+    }
+}
@@ -11,8 +11,18 @@ import (
     "strings"
 
     "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
 
     "store/config"
+    "store/server/acl-model"
+    "store/server/apikey-model"
+    "store/server/bucket-model"
+    "store/server/lifecycle-model"
+    "store/server/object-model"
+    "store/server/quota-model"
+    "store/server/storage-backend"
+    "store/server/trash-model"
+    "store/server/version-model"
     "store/tools"
 )
 
@@ -23,6 +33,7 @@ const (
 type Bucket struct {
     Name string     `json:"name"`
     Size int64      `json:"size"`
+    StoredSize int64    `json:"storedsize"`
 }
 
 type Response struct {
@@ -41,6 +52,15 @@ type Page struct {
 
 type Controller struct {
     config *config.Config
+    db *sqlx.DB
+    store storageBackend.Backend
+    acl *aclModel.Model
+    buckets *bucketModel.Model
+    objects *objectModel.Model
+    versions *versionModel.Model
+    trash *trashModel.Model
+    quotas *quotaModel.Model
+    lifecycle *lifecycleModel.Model
 }
 
 func sendError(context *gin.Context, err error) {
@@ -75,49 +95,76 @@ func sendResult(context *gin.Context, result interface{}) {
     context.JSON(http.StatusOK, responce)
 }
 
+/* Return true if the authenticated user can list the bucket */
+func (this *Controller) allow(context *gin.Context, bucketName string) bool {
+    return this.allowPerm(context, bucketName, aclModel.PermList)
+}
+
+/* Return true if the authenticated user has the permission on the bucket */
+func (this *Controller) allowPerm(context *gin.Context, bucketName, perm string) bool {
+    if value, exists := context.Get("apikey"); exists {
+        if !value.(apikeyModel.Key).Allow(bucketName, perm) {
+            return false
+        }
+    }
+    if !this.config.ACLEnable {
+        return true
+    }
+    ok, err := this.acl.Check(context.GetString("username"), bucketName, perm)
+    if err != nil {
+        return false
+    }
+    return ok
+}
+
+/* Store relative names of bucket directories up to the depth limit,
+ * the store root is the empty name */
+func (this *Controller) bucketNames() ([]string, error) {
+    names := []string{}
+    err := storageBackend.Walk(this.store, "", func(key string, fileInfo os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if !fileInfo.IsDir() {
+            return nil
+        }
+        if config.IsSystemName(key) || tools.PathLength(key) > MaxBucketDepth {
+            return filepath.SkipDir
+        }
+        names = append(names, key)
+        return nil
+    })
+    return names, err
+}
+
 func (this *Controller) PageList(context *gin.Context) {
 
     var page Page
     _ = context.Bind(&page)
 
-    storeDir, _ := this.config.GetStoreDir()
-
-    _, err := os.Stat(storeDir)
+    nameList, err := this.bucketNames()
     if err != nil {
         sendError(context, err)
         return
     }
 
-    directoryNameList, err := tools.PathWalkDir(storeDir, MaxBucketDepth)
+    /* Sizes are taken from usage of stored files */
+    usage, err := this.objects.ListUsage()
     if err != nil {
         sendError(context, err)
         return
     }
 
     list := []Bucket{}
-    for i := range directoryNameList {
-        directoryName := directoryNameList[i]
-        fi, err := os.Stat(directoryName)
-        if err != nil {
-            log.Printf("%s\n", err)
-            continue
-        }
-        if fi.Mode().IsRegular() {
-            continue
-        }
-        size, err := tools.BucketSize(directoryName)
-        if err != nil {
-            continue
-        }
-        name := strings.TrimPrefix(directoryName, storeDir)
-        name = strings.TrimLeft(name, "/")
+    for _, name := range nameList {
 
         pattern := "*" + page.Pattern + "*"
         match, _ := filepath.Match(pattern, name)
-        if match {
+        if match && this.allow(context, name) {
             list = append(list, Bucket{
                     Name: name,
-                    Size: size,
+                    Size: usage[name].Bytes,
+                    StoredSize: usage[name].StoredBytes,
             })
 
         }
@@ -145,51 +192,213 @@ func (this *Controller) PageList(context *gin.Context) {
 
 func (this *Controller) List(context *gin.Context) {
 
-    storeDir, _ := this.config.GetStoreDir()
-
-    _, err := os.Stat(storeDir)
+    nameList, err := this.bucketNames()
     if err != nil {
         sendError(context, err)
         return
     }
 
-    directoryNameList, err := tools.PathWalkDir(storeDir, MaxBucketDepth)
+    /* Sizes are taken from usage of stored files */
+    usage, err := this.objects.ListUsage()
     if err != nil {
         sendError(context, err)
         return
     }
 
     list := []Bucket{}
-    for i := range directoryNameList {
-        directoryName := directoryNameList[i]
-        fi, err := os.Stat(directoryName)
-        if err != nil {
-            log.Printf("%s\n", err)
-            continue
-        }
-        if fi.Mode().IsRegular() {
-            continue
-        }
-        size, err := tools.BucketSize(directoryName)
-        if err != nil {
+    for _, name := range nameList {
+        if !this.allow(context, name) {
             continue
         }
-        name := strings.TrimLeft(directoryName, storeDir)
         list = append(list, Bucket{
                 Name: name,
-                Size: size,
+                Size: usage[name].Bytes,
+                StoredSize: usage[name].StoredBytes,
         })
     }
     sendResult(context, list)
 }
 
+type versioningForm struct {
+    Bucket          string  `form:"bucket"           json:"bucket"   binding:"required"`
+    Versioning      *bool   `form:"versioning"       json:"versioning"`
+    KeepVersions    *int    `form:"keepversions"     json:"keepversions"`
+    KeepDays        *int    `form:"keepdays"         json:"keepdays"`
+}
+
+/* Show or change versioning and retention of the bucket.
+ * Omitted fields are not changed */
+func (this *Controller) Versioning(context *gin.Context) {
+    var form versioningForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    name, err := this.bucketName(form.Bucket)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    form.Bucket = name
+    if !this.allow(context, form.Bucket) {
+        sendForbidden(context, form.Bucket)
+        return
+    }
+    if _, err := this.store.Stat(storageBackend.Key(form.Bucket)); err != nil {
+        sendError(context, err)
+        return
+    }
+
+    bucket := this.buckets.Settings(form.Bucket)
+    if form.Versioning == nil && form.KeepVersions == nil && form.KeepDays == nil {
+        sendResult(context, bucket)
+        return
+    }
+
+    if !this.allowPerm(context, form.Bucket, aclModel.PermWrite) ||
+            !this.allowPerm(context, form.Bucket, aclModel.PermDelete) {
+        sendForbidden(context, form.Bucket)
+        return
+    }
+    if form.Versioning != nil {
+        bucket.Versioning = *form.Versioning
+    }
+    if form.KeepVersions != nil {
+        bucket.KeepVersions = *form.KeepVersions
+    }
+    if form.KeepDays != nil {
+        bucket.KeepDays = *form.KeepDays
+    }
+    if err := this.buckets.Save(bucket); err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, bucket)
+}
+
+type compressionForm struct {
+    Bucket          string  `form:"bucket"           json:"bucket"   binding:"required"`
+    Compression     *string `form:"compression"      json:"compression"`
+}
+
+/* Show or change compression of new files of the bucket,
+ * empty compression stores new files as is */
+func (this *Controller) Compression(context *gin.Context) {
+    var form compressionForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    name, err := this.bucketName(form.Bucket)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    form.Bucket = name
+    if !this.allow(context, form.Bucket) {
+        sendForbidden(context, form.Bucket)
+        return
+    }
+    if _, err := this.store.Stat(storageBackend.Key(form.Bucket)); err != nil {
+        sendError(context, err)
+        return
+    }
+
+    bucket := this.buckets.Settings(form.Bucket)
+    if form.Compression == nil {
+        sendResult(context, bucket)
+        return
+    }
+
+    if !this.allowPerm(context, form.Bucket, aclModel.PermWrite) {
+        sendForbidden(context, form.Bucket)
+        return
+    }
+    compression := strings.ToLower(strings.TrimSpace(*form.Compression))
+    if !bucketModel.ValidCompression(compression) {
+        sendError(context, errors.New(fmt.Sprintf("unknown compression %s", compression)))
+        return
+    }
+    bucket.Compression = compression
+    if err := this.buckets.Save(bucket); err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, bucket)
+}
+
+type encryptionForm struct {
+    Bucket          string  `form:"bucket"           json:"bucket"   binding:"required"`
+    Encryption      *string `form:"encryption"       json:"encryption"`
+}
+
+/* Show or change encryption of new files of the bucket, empty
+ * encryption stores new files as is, files stay encrypted */
+func (this *Controller) Encryption(context *gin.Context) {
+    var form encryptionForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    name, err := this.bucketName(form.Bucket)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    form.Bucket = name
+    if !this.allow(context, form.Bucket) {
+        sendForbidden(context, form.Bucket)
+        return
+    }
+    if _, err := this.store.Stat(storageBackend.Key(form.Bucket)); err != nil {
+        sendError(context, err)
+        return
+    }
+
+    bucket := this.buckets.Settings(form.Bucket)
+    if form.Encryption == nil {
+        sendResult(context, bucket)
+        return
+    }
+
+    if !this.allowPerm(context, form.Bucket, aclModel.PermWrite) ||
+            !this.allowPerm(context, form.Bucket, aclModel.PermDelete) {
+        sendForbidden(context, form.Bucket)
+        return
+    }
+    encryption := strings.ToLower(strings.TrimSpace(*form.Encryption))
+    if !bucketModel.ValidEncryption(encryption) {
+        sendError(context, errors.New(fmt.Sprintf("unknown encryption %s", encryption)))
+        return
+    }
+    if store, _ := storageBackend.FindEncrypt(this.store); len(encryption) > 0 && !store.Ready() {
+        sendError(context, storageBackend.ErrNoMasterKey)
+        return
+    }
+    bucket.Encryption = encryption
+    if err := this.buckets.Save(bucket); err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, bucket)
+}
+
 func (this *Controller) Hello(context *gin.Context) {
     sendMessage(context, "hello")
 }
 
 
-func New(config *config.Config) *Controller {
+func New(config *config.Config, db *sqlx.DB) *Controller {
     return &Controller{
         config: config,
+        db: db,
+        store: storageBackend.Open(config, db),
+        acl: aclModel.New(db),
+        buckets: bucketModel.New(db),
+        objects: objectModel.New(db),
+        versions: versionModel.New(config, db),
+        trash: trashModel.New(config, db),
+        quotas: quotaModel.New(db),
+        lifecycle: lifecycleModel.New(config, db),
     }
 }
@@ -0,0 +1,263 @@
+package bucketController
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+
+    "store/config"
+    "store/server/acl-model"
+    "store/server/bucket-model"
+    "store/server/object-model"
+    "store/server/storage-backend"
+)
+
+/* Description of the bucket */
+type Info struct {
+    Name            string  `json:"name"`
+    Count           int     `json:"count"`
+    Size            int64   `json:"size"`
+    StoredSize      int64   `json:"storedsize"`
+    Compression     string  `json:"compression,omitempty"`
+    Encryption      string  `json:"encryption,omitempty"`
+    Created         string  `json:"created"`
+    Versioning      bool    `json:"versioning"`
+}
+
+/* Return the bucket name trimmed of slashes, names with empty or dot
+ * components are rejected, so ACL checks and service data of the bucket
+ * use the same name as the store key */
+func (this *Controller) bucketName(name string) (string, error) {
+    name, err := bucketModel.CleanName(name)
+    if err != nil {
+        return "", err
+    }
+    if config.IsSystemName(name) {
+        return "", errors.New("wrong bucket name")
+    }
+    return name, nil
+}
+
+func sendForbidden(context *gin.Context, bucketName string) {
+    context.JSON(http.StatusForbidden, Response{
+        Error: true,
+        Message: fmt.Sprintf("access denied to bucket %s", bucketName),
+    })
+}
+
+func (this *Controller) info(name, dirKey string) (Info, error) {
+    info := Info{ Name: name }
+    fileInfo, err := this.store.Stat(dirKey)
+    if err != nil {
+        return info, err
+    }
+    if !fileInfo.IsDir() {
+        return info, errors.New(fmt.Sprintf("%s is not a bucket", name))
+    }
+    files, err := storageBackend.ReadDir(this.store, dirKey)
+    if err != nil {
+        return info, err
+    }
+    for _, file := range files {
+        if file.Mode().IsRegular() {
+            info.Count++
+            info.Size += file.Size()
+            info.StoredSize += objectModel.StoredSize(file)
+        }
+    }
+    /* Implicit buckets have no creation record */
+    created := fileInfo.ModTime()
+    if bucket, err := this.buckets.Find(name); err == nil && bucket.Created > 0 {
+        created = time.Unix(bucket.Created, 0)
+    }
+    info.Created = created.Format(time.RFC3339)
+    settings := this.buckets.Settings(name)
+    info.Versioning = settings.Versioning
+    info.Compression = settings.Compression
+    info.Encryption = settings.Encryption
+    return info, nil
+}
+
+type bucketForm struct {
+    Bucket      string  `form:"bucket"   json:"bucket"   binding:"required"`
+    Force       bool    `form:"force"    json:"force"`
+}
+
+func (this *Controller) bindBucket(context *gin.Context, perm string) (bucketForm, string, bool) {
+    var form bucketForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return form, "", false
+    }
+    bucket, err := this.bucketName(form.Bucket)
+    if err != nil {
+        sendError(context, err)
+        return form, "", false
+    }
+    form.Bucket = bucket
+    if !this.allowPerm(context, form.Bucket, perm) {
+        sendForbidden(context, form.Bucket)
+        return form, "", false
+    }
+    return form, storageBackend.Key(bucket), true
+}
+
+/* Describe the bucket: count and size of files, creation time */
+func (this *Controller) Info(context *gin.Context) {
+    form, dirKey, ok := this.bindBucket(context, aclModel.PermList)
+    if !ok {
+        return
+    }
+    info, err := this.info(form.Bucket, dirKey)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, info)
+}
+
+func (this *Controller) Create(context *gin.Context) {
+    form, dirKey, ok := this.bindBucket(context, aclModel.PermWrite)
+    if !ok {
+        return
+    }
+    if _, err := this.store.Stat(dirKey); err == nil {
+        sendError(context, errors.New(fmt.Sprintf("bucket %s already exists", form.Bucket)))
+        return
+    }
+    if err := this.store.MakeDir(dirKey); err != nil {
+        sendError(context, err)
+        return
+    }
+    if err := this.buckets.Create(form.Bucket, time.Now().Unix()); err != nil {
+        sendError(context, err)
+        return
+    }
+    info, err := this.info(form.Bucket, dirKey)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, info)
+}
+
+/* Delete empty bucket. With force files of the bucket are deleted as by
+ * file delete, the bucket with nested buckets is not deleted */
+func (this *Controller) Delete(context *gin.Context) {
+    form, dirKey, ok := this.bindBucket(context, aclModel.PermDelete)
+    if !ok {
+        return
+    }
+    files, err := storageBackend.ReadDir(this.store, dirKey)
+    if os.IsNotExist(err) {
+        sendError(context, errors.New(fmt.Sprintf("bucket %s not found", form.Bucket)))
+        return
+    }
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    for _, file := range files {
+        if file.IsDir() {
+            sendError(context, errors.New(fmt.Sprintf("bucket %s has nested buckets", form.Bucket)))
+            return
+        }
+    }
+    if len(files) > 0 && !form.Force {
+        sendError(context, errors.New(fmt.Sprintf("bucket %s is not empty", form.Bucket)))
+        return
+    }
+    for _, file := range files {
+        fileKey := storageBackend.Join(dirKey, file.Name())
+        if file.Mode().IsRegular() {
+            err = this.versions.Remove(fileKey)
+        } else {
+            err = this.store.Delete(fileKey)
+        }
+        if err != nil {
+            sendError(context, err)
+            return
+        }
+    }
+    if err := this.store.Delete(dirKey); err != nil {
+        sendError(context, err)
+        return
+    }
+    if err := this.buckets.Delete(form.Bucket); err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, []Bucket{})
+}
+
+type renameForm struct {
+    Bucket      string  `form:"bucket"   json:"bucket"   binding:"required"`
+    NewName     string  `form:"newname"  json:"newname"  binding:"required"`
+}
+
+/* Rename the bucket with nested buckets, versions, trash, metadata,
+ * quotas and lifecycle rules */
+func (this *Controller) Rename(context *gin.Context) {
+    var form renameForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    var err error
+    if form.Bucket, err = this.bucketName(form.Bucket); err != nil {
+        sendError(context, err)
+        return
+    }
+    if form.NewName, err = this.bucketName(form.NewName); err != nil {
+        sendError(context, err)
+        return
+    }
+    dirKey := storageBackend.Key(form.Bucket)
+    newKey := storageBackend.Key(form.NewName)
+    if strings.HasPrefix(form.NewName + "/", form.Bucket + "/") {
+        sendError(context, errors.New("bucket can not be moved into itself"))
+        return
+    }
+    if !this.allowPerm(context, form.Bucket, aclModel.PermDelete) {
+        sendForbidden(context, form.Bucket)
+        return
+    }
+    if !this.allowPerm(context, form.NewName, aclModel.PermWrite) {
+        sendForbidden(context, form.NewName)
+        return
+    }
+
+    if fileInfo, err := this.store.Stat(dirKey); err != nil || !fileInfo.IsDir() {
+        sendError(context, errors.New(fmt.Sprintf("bucket %s not found", form.Bucket)))
+        return
+    }
+    if _, err := this.store.Stat(newKey); err == nil {
+        sendError(context, errors.New(fmt.Sprintf("bucket %s already exists", form.NewName)))
+        return
+    }
+    if err := this.store.Move(dirKey, newKey, true); err != nil {
+        sendError(context, err)
+        return
+    }
+
+    /* Move service data of the bucket */
+    for _, rename := range []func(string, string) error{
+                this.objects.Rename, this.versions.Rename, this.trash.Rename, this.buckets.Rename,
+                this.quotas.Rename, this.lifecycle.Rename } {
+        if err := rename(form.Bucket, form.NewName); err != nil {
+            sendError(context, err)
+            return
+        }
+    }
+    info, err := this.info(form.NewName, newKey)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, info)
+}
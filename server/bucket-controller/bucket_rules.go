@@ -0,0 +1,119 @@
+package bucketController
+
+import (
+    "strings"
+
+    "github.com/gin-gonic/gin"
+
+    "store/config"
+    "store/server/acl-model"
+    "store/server/lifecycle-model"
+    "store/server/storage-backend"
+)
+
+/* Lifecycle rules of the bucket set by API and read-only rules of the config */
+type Lifecycle struct {
+    Bucket      string                  `json:"bucket"`
+    Rules       []config.LifecycleRule  `json:"rules"`
+    ConfigRules []config.LifecycleRule  `json:"configrules,omitempty"`
+}
+
+type lifecycleForm struct {
+    Bucket      string                  `form:"bucket"   json:"bucket"   binding:"required"`
+    Rules       *[]config.LifecycleRule `json:"rules"`
+}
+
+func (this *Controller) bindLifecycle(context *gin.Context) (lifecycleForm, bool) {
+    var form lifecycleForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return form, false
+    }
+    bucket, err := this.bucketName(form.Bucket)
+    if err != nil {
+        sendError(context, err)
+        return form, false
+    }
+    form.Bucket = bucket
+    if _, err := this.store.Stat(storageBackend.Key(bucket)); err != nil {
+        sendError(context, err)
+        return form, false
+    }
+    if !this.allowPerm(context, form.Bucket, aclModel.PermList) {
+        sendForbidden(context, form.Bucket)
+        return form, false
+    }
+    return form, true
+}
+
+/* Rules of the bucket from the config */
+func (this *Controller) configRules(bucket string) []config.LifecycleRule {
+    var rules []config.LifecycleRule
+    for _, rule := range this.config.Lifecycle {
+        if strings.Trim(rule.Bucket, "/") == bucket && lifecycleModel.Validate(rule) == nil {
+            rules = append(rules, rule)
+        }
+    }
+    return rules
+}
+
+/* Show or replace lifecycle rules of the bucket, empty list removes the rules */
+func (this *Controller) Lifecycle(context *gin.Context) {
+    form, ok := this.bindLifecycle(context)
+    if !ok {
+        return
+    }
+    if form.Rules != nil {
+        if !this.allowPerm(context, form.Bucket, aclModel.PermDelete) {
+            sendForbidden(context, form.Bucket)
+            return
+        }
+        if err := this.lifecycle.Set(form.Bucket, *form.Rules); err != nil {
+            sendError(context, err)
+            return
+        }
+    }
+    rules, err := this.lifecycle.List(form.Bucket)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, Lifecycle{
+        Bucket: form.Bucket,
+        Rules: rules,
+        ConfigRules: this.configRules(form.Bucket),
+    })
+}
+
+/* Report files and buckets deleted by the rules of the request
+ * or by current rules of the bucket, nothing is deleted */
+func (this *Controller) LifecycleDryRun(context *gin.Context) {
+    form, ok := this.bindLifecycle(context)
+    if !ok {
+        return
+    }
+    var rules []config.LifecycleRule
+    if form.Rules != nil {
+        rules = *form.Rules
+        for i := range rules {
+            rules[i].Bucket = form.Bucket
+            if err := lifecycleModel.Validate(rules[i]); err != nil {
+                sendError(context, err)
+                return
+            }
+        }
+    } else {
+        list, err := this.lifecycle.List(form.Bucket)
+        if err != nil {
+            sendError(context, err)
+            return
+        }
+        rules = append(this.configRules(form.Bucket), list...)
+    }
+    actions, err := this.lifecycle.Plan(rules)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, actions)
+}
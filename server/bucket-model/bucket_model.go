@@ -0,0 +1,224 @@
+package bucketModel
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "path"
+    "regexp"
+    "strings"
+
+    "github.com/jmoiron/sqlx"
+
+    "store/tools"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS buckets (
+        id              INTEGER PRIMARY KEY,
+        name            VARCHAR(1024) NOT NULL UNIQUE,
+        versioning      BOOLEAN NOT NULL DEFAULT FALSE,
+        keepversions    INTEGER NOT NULL DEFAULT 0,
+        keepdays        INTEGER NOT NULL DEFAULT 0,
+        created         INTEGER NOT NULL DEFAULT 0,
+        compression     VARCHAR(16) NOT NULL DEFAULT '',
+        encryption      VARCHAR(16) NOT NULL DEFAULT ''
+    );`
+
+type Model struct {
+    db *sqlx.DB
+}
+
+/* Settings of the bucket, nested buckets inherit settings of the parent */
+type Bucket struct {
+    Id              int     `db:"id"             json:"-"`
+    Name            string  `db:"name"           json:"name"`
+    Versioning      bool    `db:"versioning"     json:"versioning"`
+    KeepVersions    int     `db:"keepversions"   json:"keepversions"`
+    KeepDays        int     `db:"keepdays"       json:"keepdays"`
+    Created         int64   `db:"created"        json:"-"`
+    Compression     string  `db:"compression"    json:"compression"`
+    Encryption      string  `db:"encryption"     json:"encryption"`
+}
+
+const (
+    MaxNameLength   int = 1024
+    MaxDepth        int = 64
+)
+
+/* Compression of new files of the bucket, empty is off */
+const (
+    CompressionGzip string = "gzip"
+    CompressionZstd string = "zstd"
+)
+
+func ValidCompression(compression string) bool {
+    return len(compression) == 0 || compression == CompressionGzip || compression == CompressionZstd
+}
+
+/* Encryption of new files of the bucket, empty is off */
+const EncryptionAES256GCM string = "aes-256-gcm"
+
+func ValidEncryption(encryption string) bool {
+    return len(encryption) == 0 || encryption == EncryptionAES256GCM
+}
+
+/* Bucket name is slash separated path of components of letters, digits,
+ * dots, dashes and underscores, starting with letter or digit, up to 63
+ * characters each */
+var componentRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,62}$`)
+
+func ValidateName(name string) error {
+    if len(name) == 0 || len(name) > MaxNameLength {
+        return errors.New("wrong bucket name length")
+    }
+    components := strings.Split(name, "/")
+    if len(components) > MaxDepth {
+        return errors.New("too deep bucket name")
+    }
+    for _, component := range components {
+        if !componentRegexp.MatchString(component) {
+            return errors.New(fmt.Sprintf("wrong bucket name %s", name))
+        }
+    }
+    return nil
+}
+
+/* Return the bucket name trimmed of slashes, the name with empty or
+ * dot components is rejected, so the name is the key of the store */
+func CleanName(name string) (string, error) {
+    name = strings.Trim(name, "/")
+    if path.Clean(name) != name {
+        return "", errors.New(fmt.Sprintf("wrong bucket name %s", name))
+    }
+    return name, ValidateName(name)
+}
+
+
+func (this *Model) Migrate() error {
+    _, err := this.db.Exec(schema)
+    if err == nil {
+        _, err = tools.AddColumn(this.db, "buckets", "compression", "VARCHAR(16) NOT NULL DEFAULT ''")
+    }
+    if err == nil {
+        _, err = tools.AddColumn(this.db, "buckets", "encryption", "VARCHAR(16) NOT NULL DEFAULT ''")
+    }
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Find own settings of the bucket */
+func (this *Model) Find(name string) (Bucket, error) {
+    var bucket Bucket
+    request := `SELECT * FROM buckets WHERE name = $1 LIMIT 1`
+    err := this.db.Get(&bucket, request, strings.Trim(name, "/"))
+    return bucket, err
+}
+
+/* Return settings of the bucket or the nearest parent bucket */
+func (this *Model) Settings(name string) Bucket {
+    name = strings.Trim(name, "/")
+    for path := name; ; {
+        bucket, err := this.Find(path)
+        if err == nil {
+            bucket.Name = name
+            return bucket
+        }
+        index := strings.LastIndex(path, "/")
+        if index < 0 {
+            break
+        }
+        path = path[:index]
+    }
+    return Bucket{ Name: name }
+}
+
+/* Create or replace settings of the bucket */
+func (this *Model) Save(bucket Bucket) error {
+    bucket.Name = strings.Trim(bucket.Name, "/")
+    if len(bucket.Name) == 0 {
+        return errors.New("bucket name is empty")
+    }
+    if bucket.KeepVersions < 0 || bucket.KeepDays < 0 {
+        return errors.New("wrong retention value")
+    }
+    if !ValidCompression(bucket.Compression) {
+        return errors.New("wrong compression " + bucket.Compression)
+    }
+    if !ValidEncryption(bucket.Encryption) {
+        return errors.New("wrong encryption " + bucket.Encryption)
+    }
+    request := `INSERT INTO buckets(name, versioning, keepversions, keepdays, compression, encryption)
+                VALUES ($1, $2, $3, $4, $5, $6)
+                ON CONFLICT(name) DO UPDATE SET
+                    versioning = excluded.versioning,
+                    keepversions = excluded.keepversions,
+                    keepdays = excluded.keepdays,
+                    compression = excluded.compression,
+                    encryption = excluded.encryption`
+    _, err := this.db.Exec(request, bucket.Name, bucket.Versioning, bucket.KeepVersions, bucket.KeepDays,
+                                bucket.Compression, bucket.Encryption)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Record creation time of the new bucket */
+func (this *Model) Create(name string, created int64) error {
+    request := `INSERT INTO buckets(name, created) VALUES ($1, $2)
+                ON CONFLICT(name) DO UPDATE SET created = excluded.created`
+    _, err := this.db.Exec(request, strings.Trim(name, "/"), created)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Rename settings of the bucket and nested buckets */
+func (this *Model) Rename(oldName, newName string) error {
+    oldName = strings.Trim(oldName, "/")
+    newName = strings.Trim(newName, "/")
+    request := `UPDATE buckets SET name = $1 || substr(name, length($2) + 1)
+                WHERE name = $2 OR substr(name, 1, length($2) + 1) = $2 || '/'`
+    _, err := this.db.Exec(request, newName, oldName)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func (this *Model) List() ([]Bucket, error) {
+    buckets := []Bucket{}
+    request := `SELECT * FROM buckets ORDER BY name`
+    err := this.db.Select(&buckets, request)
+    if err != nil {
+        log.Println(err)
+        return buckets, err
+    }
+    return buckets, nil
+}
+
+/* Delete settings of the bucket and nested buckets */
+func (this *Model) Delete(name string) error {
+    request := `DELETE FROM buckets WHERE name = $1 OR substr(name, 1, length($1) + 1) = $1 || '/'`
+    _, err := this.db.Exec(request, strings.Trim(name, "/"))
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func New(db *sqlx.DB) *Model {
+    model := Model{
+        db: db,
+    }
+    return &model
+}
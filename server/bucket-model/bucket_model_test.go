@@ -0,0 +1,31 @@
+package bucketModel
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestValidateName(t *testing.T) {
+    for _, name := range []string{ "foobar", "releases/v1.2", "a_b-c.d", "0" } {
+        if err := ValidateName(name); err != nil {
+            t.Errorf("name %s: %s", name, err)
+        }
+    }
+    for _, name := range []string{ "", ".m2store", "foo/../bar", "foo//bar", "bad name", "-foo",
+                                    strings.Repeat("a", 64), strings.Repeat("a/", 65) + "a" } {
+        if err := ValidateName(name); err == nil {
+            t.Errorf("wrong name %s is valid", name)
+        }
+    }
+}
+
+func TestCleanName(t *testing.T) {
+    if name, err := CleanName("/releases/v1.2/"); err != nil || name != "releases/v1.2" {
+        t.Errorf("wrong clean name %s: %v", name, err)
+    }
+    for _, name := range []string{ "a/../b", "a/./b", "a//b", "./a", "..", "/" } {
+        if _, err := CleanName(name); err == nil {
+            t.Errorf("wrong name %s is clean", name)
+        }
+    }
+}
@@ -0,0 +1,153 @@
+package datakeyModel
+
+import (
+    "database/sql"
+    "log"
+    "time"
+
+    "github.com/jmoiron/sqlx"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS datakeys (
+        id          INTEGER PRIMARY KEY,
+        keyid       VARCHAR(32) NOT NULL UNIQUE,
+        master      VARCHAR(16) NOT NULL,
+        wrapped     VARCHAR(160) NOT NULL,
+        refs        INTEGER NOT NULL DEFAULT 1,
+        created     INTEGER NOT NULL DEFAULT 0
+    );
+    CREATE INDEX IF NOT EXISTS datakeys_master ON datakeys (master);`
+
+type Model struct {
+    db *sqlx.DB
+}
+
+/* Key of encrypted file data, wrapped by the master key of the id,
+ * refs counts files sharing the key through copies */
+type DataKey struct {
+    Id          int     `db:"id"         json:"-"`
+    KeyId       string  `db:"keyid"      json:"keyid"`
+    Master      string  `db:"master"     json:"master"`
+    Wrapped     string  `db:"wrapped"    json:"-"`
+    Refs        int64   `db:"refs"       json:"refs"`
+    Created     int64   `db:"created"    json:"created"`
+}
+
+/* Stale keys are wrapped by master keys other than the current one */
+type Stats struct {
+    DataKeys    int64   `db:"datakeys"   json:"datakeys"`
+    Stale       int64   `db:"stale"      json:"stale"`
+}
+
+func (this *Model) Migrate() error {
+    _, err := this.db.Exec(schema)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Save the new data key referenced by one file */
+func (this *Model) Save(keyId, master, wrapped string) error {
+    request := `INSERT INTO datakeys(keyid, master, wrapped, refs, created) VALUES ($1, $2, $3, 1, $4)`
+    _, err := this.db.Exec(request, keyId, master, wrapped, time.Now().Unix())
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Data key of the id, false if the key is unknown */
+func (this *Model) Find(keyId string) (DataKey, bool, error) {
+    var key DataKey
+    request := `SELECT * FROM datakeys WHERE keyid = $1 LIMIT 1`
+    keys := []DataKey{}
+    if err := this.db.Select(&keys, request, keyId); err != nil {
+        log.Println(err)
+        return key, false, err
+    }
+    if len(keys) == 0 {
+        return key, false, nil
+    }
+    return keys[0], true, nil
+}
+
+/* Count the new file sharing the data key */
+func (this *Model) AddRef(keyId string) error {
+    request := `UPDATE datakeys SET refs = refs + 1 WHERE keyid = $1`
+    _, err := this.db.Exec(request, keyId)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Drop the reference of the removed file, the key without references
+ * is deleted, return true if the key is deleted */
+func (this *Model) Release(keyId string) (bool, error) {
+    tx, err := this.db.Beginx()
+    if err != nil {
+        return false, err
+    }
+    request := `UPDATE datakeys SET refs = refs - 1 WHERE keyid = $1`
+    _, err = tx.Exec(request, keyId)
+    var count int64
+    if err == nil {
+        var result sql.Result
+        request = `DELETE FROM datakeys WHERE keyid = $1 AND refs <= 0`
+        result, err = tx.Exec(request, keyId)
+        if err == nil {
+            count, err = result.RowsAffected()
+        }
+    }
+    if err != nil {
+        log.Println(err)
+        tx.Rollback()
+        return false, err
+    }
+    return count > 0, tx.Commit()
+}
+
+/* Data keys wrapped by master keys other than the master */
+func (this *Model) Stale(master string, limit int) ([]DataKey, error) {
+    keys := []DataKey{}
+    request := `SELECT * FROM datakeys WHERE master <> $1 ORDER BY id LIMIT $2`
+    if err := this.db.Select(&keys, request, master, limit); err != nil {
+        log.Println(err)
+        return keys, err
+    }
+    return keys, nil
+}
+
+/* Replace the wrapped data key by one wrapped with the master */
+func (this *Model) Rewrap(keyId, master, wrapped string) error {
+    request := `UPDATE datakeys SET master = $1, wrapped = $2 WHERE keyid = $3`
+    _, err := this.db.Exec(request, master, wrapped, keyId)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func (this *Model) Stats(master string) (Stats, error) {
+    var stats Stats
+    request := `SELECT COUNT(*) AS datakeys,
+                    COALESCE(SUM(master <> $1), 0) AS stale
+                FROM datakeys`
+    if err := this.db.Get(&stats, request, master); err != nil {
+        log.Println(err)
+        return stats, err
+    }
+    return stats, nil
+}
+
+func New(db *sqlx.DB) *Model {
+    return &Model{
+        db: db,
+    }
+}
@@ -0,0 +1,259 @@
+package fileController
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
+    "errors"
+    "io"
+    "log"
+    "mime"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+
+    "store/config"
+    "store/server/acl-model"
+    "store/server/storage-backend"
+)
+
+const (
+    FormatZip   string = "zip"
+    FormatTar   string = "tar"
+    FormatTgz   string = "tar.gz"
+)
+
+type archiveForm struct {
+    BucketName  string      `form:"bucket"       json:"bucket"`
+    Pattern     string      `form:"pattern"      json:"pattern"`
+    Files       []string    `form:"files"        json:"files"`
+    Format      string      `form:"format"       json:"format"`
+    Recursive   bool        `form:"recursive"    json:"recursive"`
+}
+
+/* File of the archive, name is relative to the bucket */
+type archiveEntry struct {
+    name        string
+    filePath    string
+}
+
+/* Archive writer of the format */
+type archiveWriter interface {
+    add(name string, file io.Reader, fileInfo os.FileInfo) error
+    Close() error
+}
+
+type zipWriter struct {
+    writer      *zip.Writer
+}
+
+func (this *zipWriter) add(name string, file io.Reader, fileInfo os.FileInfo) error {
+    header, err := zip.FileInfoHeader(fileInfo)
+    if err != nil {
+        return err
+    }
+    header.Name = name
+    header.Method = zip.Deflate
+    out, err := this.writer.CreateHeader(header)
+    if err != nil {
+        return err
+    }
+    _, err = io.CopyN(out, file, fileInfo.Size())
+    return err
+}
+
+func (this *zipWriter) Close() error {
+    return this.writer.Close()
+}
+
+type tarWriter struct {
+    writer      *tar.Writer
+    gzip        *gzip.Writer
+}
+
+func (this *tarWriter) add(name string, file io.Reader, fileInfo os.FileInfo) error {
+    header := &tar.Header{
+        Typeflag: tar.TypeReg,
+        Name: name,
+        Size: fileInfo.Size(),
+        Mode: int64(fileInfo.Mode().Perm()),
+        ModTime: fileInfo.ModTime(),
+    }
+    if err := this.writer.WriteHeader(header); err != nil {
+        return err
+    }
+    _, err := io.CopyN(this.writer, file, fileInfo.Size())
+    return err
+}
+
+func (this *tarWriter) Close() error {
+    if err := this.writer.Close(); err != nil {
+        return err
+    }
+    if this.gzip != nil {
+        return this.gzip.Close()
+    }
+    return nil
+}
+
+/* Content type and file name extension of the archive format */
+func archiveFormat(format string) (string, string, error) {
+    switch strings.ToLower(format) {
+        case "", FormatZip:
+            return "application/zip", ".zip", nil
+        case FormatTar:
+            return "application/x-tar", ".tar", nil
+        case FormatTgz, "tgz":
+            return "application/gzip", ".tar.gz", nil
+    }
+    return "", "", errors.New("wrong archive format " + format)
+}
+
+func newArchiveWriter(format string, writer io.Writer) archiveWriter {
+    switch strings.ToLower(format) {
+        case FormatTar:
+            return &tarWriter{ writer: tar.NewWriter(writer) }
+        case FormatTgz, "tgz":
+            gzipWriter := gzip.NewWriter(writer)
+            return &tarWriter{ writer: tar.NewWriter(gzipWriter), gzip: gzipWriter }
+    }
+    return &zipWriter{ writer: zip.NewWriter(writer) }
+}
+
+/* Collect files of the bucket directory matching the pattern. Nested buckets
+ * are included if recursive, nested buckets without read permission are skipped */
+func (this *Controller) archiveDir(context *gin.Context, prefix, directoryPath, pattern string,
+                                        recursive bool, entries *[]archiveEntry) error {
+    files, err := storageBackend.ReadDir(this.store, this.storePath(directoryPath))
+    if err != nil {
+        return err
+    }
+    for _, file := range files {
+        filePath := filepath.Join(directoryPath, file.Name())
+        if file.IsDir() {
+            if !recursive || config.IsSystemName(this.storePath(filePath)) {
+                continue
+            }
+            if err := this.allow(context, filepath.Join(filePath, "*"), aclModel.PermRead); err != nil {
+                continue
+            }
+            err := this.archiveDir(context, prefix + file.Name() + "/", filePath, pattern, recursive, entries)
+            if err != nil {
+                return err
+            }
+            continue
+        }
+        if !file.Mode().IsRegular() {
+            continue
+        }
+        if match, _ := filepath.Match(pattern, file.Name()); match {
+            *entries = append(*entries, archiveEntry{ name: prefix + file.Name(), filePath: filePath })
+        }
+    }
+    return nil
+}
+
+/* Stream the bucket, files of the bucket matching the glob pattern or
+ * listed files as zip, tar or tar.gz archive without staging on disk */
+func (this *Controller) Archive(context *gin.Context) {
+    var form archiveForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    contentType, extension, err := archiveFormat(form.Format)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+
+    /* Validate bucket */
+    directoryPath, err := this.ValidateBucketPath(form.BucketName)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if fileInfo, err := this.store.Stat(this.storePath(directoryPath)); err != nil || !fileInfo.IsDir() {
+        sendStatus(context, http.StatusNotFound, errors.New("bucket not found " + form.BucketName))
+        return
+    }
+
+    /* Collect files, listed files must exist */
+    entries := []archiveEntry{}
+    if len(form.Files) > 0 {
+        for _, fileName := range form.Files {
+            filePath, err := this.ValidateFilePath(form.BucketName, fileName)
+            if err != nil || !strings.HasPrefix(filePath, directoryPath + "/") {
+                sendError(context, errors.New("wrong file name " + fileName))
+                return
+            }
+            if err := this.allow(context, filePath, aclModel.PermRead); err != nil {
+                sendForbidden(context, err)
+                return
+            }
+            if !storageBackend.FileExists(this.store, this.storePath(filePath)) {
+                sendStatus(context, http.StatusNotFound, errors.New("file not found " + fileName))
+                return
+            }
+            name := strings.TrimPrefix(filePath, directoryPath + "/")
+            entries = append(entries, archiveEntry{ name: name, filePath: filePath })
+        }
+    } else {
+        pattern := "*"
+        if len(form.Pattern) > 0 {
+            pattern = form.Pattern
+        }
+        if _, err := filepath.Match(pattern, ""); err != nil || strings.Contains(pattern, "/") {
+            sendError(context, errors.New("wrong pattern " + pattern))
+            return
+        }
+        if err := this.allow(context, filepath.Join(directoryPath, "*"), aclModel.PermRead); err != nil {
+            sendForbidden(context, err)
+            return
+        }
+        err := this.archiveDir(context, "", directoryPath, pattern, form.Recursive, &entries)
+        if err != nil {
+            sendError(context, err)
+            return
+        }
+    }
+
+    /* Stream the archive, failed archive is left unfinished
+     * so the client does not take it for complete */
+    name := filepath.Base(directoryPath)
+    if len(this.storePath(directoryPath)) == 0 {
+        name = "store"
+    }
+    context.Header("Content-Type", contentType)
+    context.Header("X-Content-Type-Options", "nosniff")
+    context.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{ "filename": name + extension }))
+    context.Status(http.StatusOK)
+
+    writer := newArchiveWriter(form.Format, context.Writer)
+    for _, entry := range entries {
+        if err := this.archiveFile(writer, entry); err != nil {
+            log.Printf("archive of %s: %s\n", this.storePath(directoryPath), err)
+            return
+        }
+    }
+    if err := writer.Close(); err != nil {
+        log.Println(err)
+    }
+}
+
+/* Write the file into the archive, size is taken from the open file */
+func (this *Controller) archiveFile(writer archiveWriter, entry archiveEntry) error {
+    file, err := this.store.Open(this.storePath(entry.filePath))
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    fileInfo, err := file.Stat()
+    if err != nil {
+        return err
+    }
+    return writer.add(entry.name, file, fileInfo)
+}
@@ -0,0 +1,117 @@
+package fileController
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "bytes"
+    "compress/gzip"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "reflect"
+    "sort"
+    "strings"
+    "testing"
+)
+
+/* Names and data of files of the archive */
+func readArchive(t *testing.T, format string, data []byte) map[string]string {
+    files := make(map[string]string)
+    switch format {
+        case FormatZip:
+            reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+            if err != nil {
+                t.Fatal(err)
+            }
+            for _, file := range reader.File {
+                in, _ := file.Open()
+                content, _ := ioutil.ReadAll(in)
+                in.Close()
+                files[file.Name] = string(content)
+            }
+        default:
+            var in io.Reader = bytes.NewReader(data)
+            if format == FormatTgz {
+                gzipReader, err := gzip.NewReader(in)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                in = gzipReader
+            }
+            reader := tar.NewReader(in)
+            for {
+                header, err := reader.Next()
+                if err == io.EOF {
+                    break
+                }
+                if err != nil {
+                    t.Fatal(err)
+                }
+                content, _ := ioutil.ReadAll(reader)
+                files[header.Name] = string(content)
+            }
+    }
+    return files
+}
+
+func TestArchive(t *testing.T) {
+    controller := testController(t)
+    putTestFile(t, controller, "site/index.txt", "index")
+    putTestFile(t, controller, "site/error.log", "error")
+    putTestFile(t, controller, "site/docs/api.txt", "api")
+    putTestFile(t, controller, "other/secret.txt", "secret")
+    archive := func(body string) (int, string, []byte) {
+        request, _ := http.NewRequest(http.MethodPost, "/api/v1/file/archive", strings.NewReader(body))
+        request.Header.Set("Content-Type", "application/json")
+        response := serveTest(controller.Archive, request)
+        return response.Code, response.Header().Get("Content-Disposition"), response.Body.Bytes()
+    }
+    names := func(files map[string]string) []string {
+        list := []string{}
+        for name := range files {
+            list = append(list, name)
+        }
+        sort.Strings(list)
+        return list
+    }
+
+    status, disposition, data := archive(`{ "bucket": "site" }`)
+    if status != http.StatusOK || !strings.Contains(disposition, `filename=site.zip`) {
+        t.Fatalf("wrong response %d %s", status, disposition)
+    }
+    files := readArchive(t, FormatZip, data)
+    if !reflect.DeepEqual(names(files), []string{ "error.log", "index.txt" }) || files["index.txt"] != "index" {
+        t.Errorf("wrong files of the bucket %v", files)
+    }
+
+    status, _, data = archive(`{ "bucket": "site", "format": "tar.gz", "recursive": true }`)
+    files = readArchive(t, FormatTgz, data)
+    if status != http.StatusOK || !reflect.DeepEqual(names(files), []string{ "docs/api.txt", "error.log", "index.txt" }) ||
+            files["docs/api.txt"] != "api" {
+        t.Errorf("wrong files of the recursive archive %d %v", status, files)
+    }
+
+    status, _, data = archive(`{ "bucket": "site", "format": "tar", "pattern": "*.txt" }`)
+    files = readArchive(t, FormatTar, data)
+    if status != http.StatusOK || !reflect.DeepEqual(names(files), []string{ "index.txt" }) {
+        t.Errorf("wrong files of the pattern %d %v", status, files)
+    }
+
+    status, _, data = archive(`{ "bucket": "site", "files": [ "docs/api.txt", "error.log" ] }`)
+    files = readArchive(t, FormatZip, data)
+    if status != http.StatusOK || !reflect.DeepEqual(names(files), []string{ "docs/api.txt", "error.log" }) {
+        t.Errorf("wrong listed files %d %v", status, files)
+    }
+
+    /* Wrong requests fail before the archive is sent */
+    for body, expected := range map[string]int{
+            `{ "bucket": "site", "files": [ "../other/secret.txt" ] }`: http.StatusBadRequest,
+            `{ "bucket": "site", "files": [ "missing.txt" ] }`: http.StatusNotFound,
+            `{ "bucket": "missing" }`: http.StatusNotFound,
+            `{ "bucket": "site", "pattern": "docs/*" }`: http.StatusBadRequest,
+            `{ "bucket": "site", "format": "rar" }`: http.StatusBadRequest } {
+        if status, _, _ := archive(body); status != expected {
+            t.Errorf("wrong status %d of %s, expected %d", status, body, expected)
+        }
+    }
+}
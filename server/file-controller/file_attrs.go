@@ -0,0 +1,91 @@
+package fileController
+
+import (
+    "errors"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+
+    "store/server/acl-model"
+    "store/server/object-model"
+)
+
+const metaFormPrefix string = "x-meta-"
+
+/* Metadata from X-Meta-* headers or x-meta-* form fields
+ * and tags from X-Tags header or tags form field */
+func requestAttributes(context *gin.Context) (objectModel.Attributes, error) {
+    var attrs objectModel.Attributes
+    attrs.Meta = objectModel.ParseMetaHeaders(context.Request.Header, objectModel.MetaHeaderPrefix)
+    if form := context.Request.MultipartForm; form != nil {
+        for name, values := range form.Value {
+            if len(name) > len(metaFormPrefix) && strings.EqualFold(name[:len(metaFormPrefix)], metaFormPrefix) {
+                attrs.Meta[strings.ToLower(name[len(metaFormPrefix):])] = values[0]
+            }
+        }
+    }
+
+    tags := context.GetHeader(objectModel.TagsHeader)
+    if value, exists := context.GetPostForm("tags"); exists {
+        tags = value
+    }
+    var err error
+    attrs.Tags, err = objectModel.ParseTags(tags)
+    if err != nil {
+        return attrs, err
+    }
+    return attrs, attrs.Validate()
+}
+
+/* Set metadata and tags headers of the file for download */
+func (this *Controller) setAttributeHeaders(context *gin.Context, filePath string) {
+    attrs, err := this.objects.Attributes(this.storePath(filePath))
+    if err != nil {
+        return
+    }
+    attrs.SetHeaders(context.Writer.Header(), objectModel.MetaHeaderPrefix)
+}
+
+type tagsForm struct {
+    FileName    string              `form:"filename" json:"filename" binding:"required"`
+    BucketName  string              `form:"bucket"   json:"bucket"`
+    Tags        map[string]string   `json:"tags"`
+}
+
+/* Replace tags of the stored file without upload */
+func (this *Controller) Tags(context *gin.Context) {
+    var form tagsForm
+    if err := context.ShouldBindJSON(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    filePath, err := this.ValidateFilePath(form.BucketName, form.FileName)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if err := this.allow(context, filePath, aclModel.PermWrite); err != nil {
+        sendForbidden(context, err)
+        return
+    }
+    if len(this.fileETag(filePath)) == 0 {
+        sendError(context, errors.New("file not found"))
+        return
+    }
+    if !this.ifMatch(context, filePath) {
+        sendStatus(context, http.StatusPreconditionFailed, errors.New("etag does not match"))
+        return
+    }
+
+    tags := make(map[string]string)
+    for key, value := range form.Tags {
+        tags[strings.ToLower(key)] = value
+    }
+    if err := this.objects.SetTags(this.storePath(filePath), tags); err != nil {
+        sendError(context, err)
+        return
+    }
+    attrs, _ := this.objects.Attributes(this.storePath(filePath))
+    sendResult(context, attrs)
+}
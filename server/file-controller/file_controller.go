@@ -2,20 +2,34 @@
 package fileController
 
 import (
+    "bytes"
     "errors"
     "fmt"
+    "io"
+    "io/ioutil"
     "log"
+    "mime"
     "mime/multipart"
     "net/http"
     "os"
+    "path"
     "path/filepath"
+    "strconv"
     "strings"
-    "syscall"
+    "sync"
     "time"
 
     "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
 
     "store/config"
+    "store/server/acl-model"
+    "store/server/apikey-model"
+    "store/server/object-model"
+    "store/server/quota-model"
+    "store/server/storage-backend"
+    "store/server/trash-model"
+    "store/server/version-model"
     "store/tools"
 )
 
@@ -26,7 +40,15 @@ const (
 type File struct {
     Name string     `json:"name"`
     Size int64      `json:"size"`
+    StoredSize int64    `json:"storedsize"`
+    Encoding string     `json:"encoding,omitempty"`
     ModTime string  `json:"modtime"`
+    SHA256 string   `json:"sha256,omitempty"`
+    MD5 string      `json:"md5,omitempty"`
+    ContentType string  `json:"contenttype,omitempty"`
+    Meta map[string]string  `json:"meta,omitempty"`
+    Tags map[string]string  `json:"tags,omitempty"`
+    Versions *[]versionModel.Version `json:"versions,omitempty"`
 }
 
 type Response struct {
@@ -41,11 +63,34 @@ type Page struct {
     Limit           int         `json:"limit"`
     Bucket          string      `json:"bucket"`
     Pattern         string      `json:"pattern,omitempty"`
+    Tag             string      `json:"tag,omitempty"`
+    Versions        bool        `json:"versions,omitempty"`
+    Prefix          string      `json:"prefix,omitempty"`
+    Delimiter       string      `json:"delimiter,omitempty"`
+    Recursive       bool        `json:"recursive,omitempty"`
+    Sort            string      `json:"sort,omitempty"`
+    Order           string      `json:"order,omitempty"`
+    MinSize         int64       `json:"minsize,omitempty"`
+    MaxSize         int64       `json:"maxsize,omitempty"`
+    Since           string      `json:"since,omitempty"`
+    Cursor          bool        `json:"cursor,omitempty"`
+    Token           string      `json:"token,omitempty"`
+    NextToken       string      `json:"nexttoken,omitempty"`
     Files           *[]File     `json:"files,omitempty"`
+    Prefixes        *[]string   `json:"prefixes,omitempty"`
 }
 
 type Controller struct {
     config *config.Config
+    db *sqlx.DB
+    store storageBackend.Backend
+    acl *aclModel.Model
+    objects *objectModel.Model
+    versions *versionModel.Model
+    trash *trashModel.Model
+    quotas *quotaModel.Model
+    uploadLock sync.Mutex
+    uploadBusy map[string]bool
 }
 
 func sendError(context *gin.Context, err error) {
@@ -61,6 +106,38 @@ func sendError(context *gin.Context, err error) {
     context.JSON(http.StatusBadRequest, response)
 }
 
+func sendForbidden(context *gin.Context, err error) {
+    log.Printf("%s\n", err)
+    response := Response{
+        Error: true,
+        Message: fmt.Sprintf("%s", err),
+        Result: nil,
+    }
+    context.JSON(http.StatusForbidden, response)
+}
+
+func sendStatus(context *gin.Context, status int, err error) {
+    log.Printf("%s\n", err)
+    response := Response{
+        Error: true,
+        Message: fmt.Sprintf("%s", err),
+        Result: nil,
+    }
+    context.JSON(status, response)
+}
+
+/* Status of the write error: 413 if the write is larger than the quota,
+ * 507 if the quota is exhausted, the status for other errors */
+func quotaStatus(err error, status int) int {
+    if quotaErr, ok := err.(quotaModel.ExceededError); ok {
+        if quotaErr.TooLarge {
+            return http.StatusRequestEntityTooLarge
+        }
+        return http.StatusInsufficientStorage
+    }
+    return status
+}
+
 func sendMessage(context *gin.Context, message string) {
     log.Printf("%s\n", message)
     response := Response{
@@ -80,6 +157,252 @@ func sendResult(context *gin.Context, result interface{}) {
     context.JSON(http.StatusOK, response)
 }
 
+/* Check permission of the authenticated user
+ * to the bucket containing validated file path */
+func (this *Controller) allow(context *gin.Context, filePath, perm string) error {
+    storeDir, _ := this.config.GetStoreDir()
+    bucketName := strings.Trim(strings.TrimPrefix(filepath.Dir(filePath), storeDir), "/")
+    username := context.GetString("username")
+
+    /* API key scope */
+    if value, exists := context.Get("apikey"); exists {
+        key := value.(apikeyModel.Key)
+        if !key.Allow(bucketName, perm) {
+            return errors.New(fmt.Sprintf("api key %s scope does not allow bucket %s", key.Prefix, bucketName))
+        }
+    }
+
+    if !this.config.ACLEnable {
+        return nil
+    }
+    ok, err := this.acl.Check(username, bucketName, perm)
+    if err != nil || !ok {
+        return errors.New(fmt.Sprintf("access denied for %s to bucket %s", username, bucketName))
+    }
+    return nil
+}
+
+/* Return store relative path of validated file path, the key of the file */
+func (this *Controller) storePath(filePath string) string {
+    storeDir, _ := this.config.GetStoreDir()
+    return strings.TrimLeft(strings.TrimPrefix(filePath, storeDir), "/")
+}
+
+/* Compute checksums of the stored file */
+func (this *Controller) hashFile(key string) (*objectModel.Hash, error) {
+    file, err := this.store.Open(key)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return objectModel.HashReader(file)
+}
+
+/* Make file description with checksums from valid metadata and attributes */
+func newFile(fileInfo os.FileInfo, objects map[string]objectModel.Object, attrs objectModel.Attributes) File {
+    file := File{
+        Name: fileInfo.Name(),
+        Size: fileInfo.Size(),
+        StoredSize: objectModel.StoredSize(fileInfo),
+        Encoding: objectModel.Encoding(fileInfo),
+        ModTime: fileInfo.ModTime().Format(time.RFC3339),
+        Meta: attrs.Meta,
+        Tags: attrs.Tags,
+    }
+    if object, exists := objects[fileInfo.Name()]; exists && object.Valid(fileInfo) {
+        file.SHA256 = object.SHA256
+        file.MD5 = object.MD5
+        file.ContentType = object.ContentType
+    }
+    return file
+}
+
+/* Set entity tag and checksum headers of the file for download,
+ * return stored content type */
+func (this *Controller) setDigestHeaders(context *gin.Context, filePath string, fileInfo os.FileInfo) string {
+    if object, valid := this.objects.Stat(this.storePath(filePath), fileInfo); valid {
+        context.Header("ETag", object.ETag())
+        context.Header("Digest", object.Digest())
+        return object.ContentType
+    }
+    context.Header("ETag", objectModel.StatETag(fileInfo))
+    return ""
+}
+
+/* Return entity tag of the current data of the file, empty if file does not exist */
+func (this *Controller) fileETag(filePath string) string {
+    fileInfo, err := this.store.Stat(this.storePath(filePath))
+    if err != nil || !fileInfo.Mode().IsRegular() {
+        return ""
+    }
+    if object, valid := this.objects.Stat(this.storePath(filePath), fileInfo); valid {
+        return object.ETag()
+    }
+    return objectModel.StatETag(fileInfo)
+}
+
+/* Check If-Match header of the write against current data of the file,
+ * request without the header always matches */
+func (this *Controller) ifMatch(context *gin.Context, filePath string) bool {
+    header := context.GetHeader("If-Match")
+    if len(header) == 0 {
+        return true
+    }
+    etag := this.fileETag(filePath)
+    if len(etag) == 0 {
+        return false
+    }
+    for _, item := range strings.Split(header, ",") {
+        item = strings.TrimSpace(item)
+        if item == "*" || item == etag {
+            return true
+        }
+    }
+    return false
+}
+
+/* Return true if the bucket of the validated file path may show active content inline */
+func (this *Controller) trusted(filePath string) bool {
+    bucket := path.Dir(this.storePath(filePath))
+    for _, name := range this.config.TrustedBuckets {
+        name = strings.Trim(name, "/")
+        if len(name) > 0 && (bucket == name || strings.HasPrefix(bucket, name + "/")) {
+            return true
+        }
+    }
+    return false
+}
+
+/* Serve the data of the store key as attachment or inline. Range, conditional
+ * and HEAD requests are handled against ETag and Last-Modified of the data */
+func (this *Controller) serveData(context *gin.Context, filePath, dataKey, contentType string, inline bool) {
+    file, err := this.store.Open(dataKey)
+    if err != nil {
+        log.Println(err)
+        context.Status(http.StatusNotFound)
+        return
+    }
+    defer file.Close()
+    fileInfo, err := file.Stat()
+    if err != nil || !fileInfo.Mode().IsRegular() {
+        context.Status(http.StatusNotFound)
+        return
+    }
+    name := filepath.Base(filePath)
+    /* Encrypted data without the key fails before headers are sent */
+    head := make([]byte, 512)
+    size, err := file.ReadAt(head, 0)
+    if err != nil && err != io.EOF {
+        log.Println(err)
+        context.Status(http.StatusInternalServerError)
+        return
+    }
+    if len(contentType) == 0 {
+        contentType = objectModel.DetectContentType(name, "", head[:size])
+    }
+
+    /* Scripts and markup are shown inline only from trusted buckets */
+    disposition := "attachment"
+    if inline && (!objectModel.IsActiveContentType(contentType) || this.trusted(filePath)) {
+        disposition = "inline"
+    }
+    if disposition == "inline" && !this.trusted(filePath) {
+        context.Header("Content-Security-Policy", "default-src 'none'; img-src 'self'; style-src 'unsafe-inline'; sandbox")
+    }
+    context.Header("Content-Type", contentType)
+    context.Header("X-Content-Type-Options", "nosniff")
+    context.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{ "filename": name }))
+
+    /* Compressed data is sent as is to the client accepting the coding */
+    if len(objectModel.Encoding(fileInfo)) > 0 {
+        context.Header("Vary", "Accept-Encoding")
+        if len(context.GetHeader("Range")) == 0 {
+            encoded, encoding, err := storageBackend.OpenEncoded(this.store, dataKey)
+            if err == nil && encoded != nil {
+                defer encoded.Close()
+                if acceptsEncoding(context.GetHeader("Accept-Encoding"), encoding) {
+                    if etag := context.Writer.Header().Get("ETag"); strings.HasSuffix(etag, `"`) {
+                        context.Header("ETag", strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`)
+                    }
+                    context.Writer.Header().Del("Digest")
+                    context.Header("Content-Encoding", encoding)
+                    http.ServeContent(context.Writer, context.Request, name, fileInfo.ModTime(), encoded)
+                    return
+                }
+            }
+        }
+    }
+    http.ServeContent(context.Writer, context.Request, name, fileInfo.ModTime(), file)
+}
+
+/* Check the coding is accepted by the Accept-Encoding header,
+ * the coding named explicitly takes precedence over the wildcard */
+func acceptsEncoding(header, encoding string) bool {
+    accepted := false
+    for _, item := range strings.Split(header, ",") {
+        params := strings.Split(item, ";")
+        coding := strings.ToLower(strings.TrimSpace(params[0]))
+        if coding != encoding && coding != "*" {
+            continue
+        }
+        quality := 1.0
+        for _, param := range params[1:] {
+            param = strings.TrimSpace(param)
+            if strings.HasPrefix(param, "q=") {
+                value, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+                if err != nil {
+                    value = 0
+                }
+                quality = value
+            }
+        }
+        if coding == encoding {
+            return quality > 0
+        }
+        accepted = quality > 0
+    }
+    return accepted
+}
+
+/* Send current data or the version of the validated file */
+func (this *Controller) sendFile(context *gin.Context, filePath, versionId string, inline bool) {
+    if len(versionId) > 0 {
+        version, dataKey, err := this.versions.Open(this.storePath(filePath), versionId)
+        if err != nil {
+            log.Println(err)
+            context.Status(http.StatusNotFound)
+            return
+        }
+        object := objectModel.Object{ SHA256: version.SHA256, MD5: version.MD5 }
+        if len(object.MD5) > 0 {
+            context.Header("ETag", object.ETag())
+            context.Header("Digest", object.Digest())
+        } else {
+            context.Header("ETag", `"` + version.VersionId + `"`)
+        }
+        this.serveData(context, filePath, dataKey, "", inline)
+        return
+    }
+
+    /* Check real file */
+    fileInfo, err := this.store.Stat(this.storePath(filePath))
+    if err != nil || !fileInfo.Mode().IsRegular() {
+        err := errors.New(fmt.Sprintf("file path not found %s\n", filePath))
+        log.Println(err)
+        context.Status(http.StatusNotFound)
+        return
+    }
+    contentType := this.setDigestHeaders(context, filePath, fileInfo)
+    this.setAttributeHeaders(context, filePath)
+    this.serveData(context, filePath, this.storePath(filePath), contentType, inline)
+}
+
+/* Existing files are not overwritten in write once mode
+ * or by request with If-None-Match: * header */
+func (this *Controller) noReplace(context *gin.Context) bool {
+    return this.config.WriteOnce || context.GetHeader("If-None-Match") == "*"
+}
+
 func (this *Controller) ValidateFilePath(bucketName, fileName string) (string, error) {
     storeDir, _ := this.config.GetStoreDir()
 
@@ -92,6 +415,9 @@ func (this *Controller) ValidateFilePath(bucketName, fileName string) (string, e
     if !strings.HasPrefix(filePath, storeDir) {
         return "", errors.New("wrong backet or file name")
     }
+    if config.IsSystemName(strings.TrimPrefix(filePath, storeDir)) {
+        return "", errors.New("wrong bucket name")
+    }
     return filePath, nil
 }
 
@@ -103,6 +429,9 @@ func (this *Controller) ValidateBucketPath(bucketName string) (string, error) {
     if !strings.HasPrefix(directoryPath, storeDir) {
         return "", errors.New("wrong bucket name")
     }
+    if config.IsSystemName(strings.TrimPrefix(directoryPath, storeDir)) {
+        return "", errors.New("wrong bucket name")
+    }
     return directoryPath, nil
 }
 
@@ -115,63 +444,57 @@ func (this *Controller) PageList(context *gin.Context) {
         return
     }
 
-    /* Validate bucket */
-    directoryPath, err := this.ValidateFilePath(page.Bucket, "")
-    if err != nil {
-        sendError(context, err)
-        return
-    }
-
-    /* Check file path for existing */
-    _, err = os.Stat(directoryPath)
-    if err != nil {
-        sendError(context, err)
+    /* Validate bucket, pattern, prefix, sort and filters */
+    query := treeQuery{
+        Bucket: page.Bucket,
+        Pattern: page.Pattern,
+        Tag: page.Tag,
+        Prefix: page.Prefix,
+        Delimiter: page.Delimiter,
+        Recursive: page.Recursive,
+        Versions: page.Versions,
+        Sort: page.Sort,
+        Order: page.Order,
+        MinSize: page.MinSize,
+        MaxSize: page.MaxSize,
+        Since: page.Since,
+        Token: page.Token,
+    }
+    directoryPath, ok := this.bindTree(context, &query)
+    if !ok {
         return
     }
+    page.Delimiter = query.Delimiter
+    page.Sort = query.Sort
+    page.Order = query.Order
 
-    /* Validate pattern */
-    pattern := "*"
-    if page.Pattern != "" {
-            if tools.PathLength(page.Pattern) > 1 {
-                sendError(context, err)
-                return
-            }
-            pattern = page.Pattern
-    }
-
-    filePath, err := this.ValidateFilePath(page.Bucket, pattern)
-    if err != nil {
-        sendError(context, err)
+    /* Continuation token pages are read up to the limit,
+     * total is not counted */
+    if page.Cursor || len(page.Token) > 0 {
+        list, prefixes, next, err := this.listTree(context, directoryPath, query, page.Limit)
+        if err != nil {
+            sendError(context, err)
+            return
+        }
+        page.Cursor = true
+        page.Offset = 0
+        page.Files = &list
+        page.Prefixes = &prefixes
+        page.NextToken = next
+        page.Total = -1
+        sendResult(context, &page)
         return
     }
 
-    /* List directory by pattern */
-    fileNameList, err := filepath.Glob(filePath)
+    list, prefixes, _, err := this.listTree(context, directoryPath, query, 0)
     if err != nil {
         sendError(context, err)
         return
     }
 
-    list := []File{}
-    for _, fileName := range fileNameList {
-        fi, err := os.Stat(fileName)
-        if err != nil {
-            log.Printf("%s\n", err)
-            continue
-        }
-        if !fi.Mode().IsRegular() {
-            continue
-        }
-        list = append(list, File{
-                Name: filepath.Base(fileName),
-                Size: fi.Size(),
-                ModTime: fi.ModTime().Format(time.RFC3339),
-            })
-    }
-
-    /* Send result */
+    /* Send result, zero limit is all files */
     up := page.Offset + page.Limit
-    if (up > len(list)) {
+    if (up > len(list) || page.Limit <= 0) {
         up = len(list)
     }
     down := page.Offset
@@ -184,6 +507,7 @@ func (this *Controller) PageList(context *gin.Context) {
 
     subList := list[down:up]
     page.Files = &subList
+    page.Prefixes = &prefixes
     page.Total = len(list)
     sendResult(context, &page)
 }
@@ -192,8 +516,13 @@ func (this *Controller) PageList(context *gin.Context) {
 type listForm struct {
     Bucket  string  `form:"bucket"  json:"bucket"`
     Pattern string  `form:"pattern" json:"pattern"`
+    Tag     string  `form:"tag"     json:"tag"`
+    Versions bool   `form:"versions" json:"versions"`
+    Prefix  string  `form:"prefix"  json:"prefix"`
+    Recursive bool  `form:"recursive" json:"recursive"`
 }
 
+/* List files of the bucket, files of nested buckets if recursive */
 func (this *Controller) List(context *gin.Context) {
 
     /* Bind form */
@@ -203,106 +532,222 @@ func (this *Controller) List(context *gin.Context) {
         return
     }
 
-    /* Validate bucket */
-    directoryPath, err := this.ValidateFilePath(form.Bucket, "")
+    /* Validate bucket, pattern and prefix */
+    query := treeQuery{
+        Bucket: form.Bucket,
+        Pattern: form.Pattern,
+        Tag: form.Tag,
+        Prefix: form.Prefix,
+        Recursive: form.Recursive,
+        Versions: form.Versions,
+    }
+    directoryPath, ok := this.bindTree(context, &query)
+    if !ok {
+        return
+    }
+    list, _, _, err := this.listTree(context, directoryPath, query, 0)
     if err != nil {
         sendError(context, err)
         return
     }
+    /* Send result */
+    sendResult(context, list)
+}
 
-    /* Check file path for existing */
-    _, err = os.Stat(directoryPath)
+type putForm struct {
+    FileName    string          `form:"filename" binding:"required"`
+    BucketName  string          `form:"bucket"`
+    SHA256      string          `form:"sha256"`
+    MD5         string          `form:"md5"`
+    ContentType string          `form:"contenttype"`
+    File *multipart.FileHeader  `form:"file"     binding:"required"`
+}
+
+/* Multipart fields peeked before the file part */
+const maxPeekSize int64 = 64 * 1024
+
+type peekedBody struct {
+    io.Reader
+    io.Closer
+}
+
+/* Values of multipart fields sent before the file part. Read data is
+ * put back to the body, so the form is bound as usual. Fields after
+ * the file part or beyond the peek size are not seen */
+func peekFormFields(request *http.Request) map[string]string {
+    fields := make(map[string]string)
+    mediaType, params, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+    if err != nil || mediaType != "multipart/form-data" || len(params["boundary"]) == 0 || request.Body == nil {
+        return fields
+    }
+    var head bytes.Buffer
+    reader := multipart.NewReader(io.TeeReader(io.LimitReader(request.Body, maxPeekSize), &head), params["boundary"])
+    for {
+        part, err := reader.NextPart()
+        if err != nil || len(part.FileName()) > 0 {
+            break
+        }
+        value, err := ioutil.ReadAll(part)
+        if err != nil {
+            break
+        }
+        fields[part.FormName()] = string(value)
+    }
+    request.Body = peekedBody{ io.MultiReader(&head, request.Body), request.Body }
+    return fields
+}
+
+func (this *Controller) Put(context *gin.Context) {
+    username := context.GetString("username")
+
+    /* Request larger than the user quota is rejected before reading the body */
+    limit, err := this.quotas.UserLimit(username)
     if err != nil {
         sendError(context, err)
         return
     }
+    if limit.Bytes >= 0 && context.Request.ContentLength > limit.Bytes {
+        sendStatus(context, quotaStatus(limit.Err, http.StatusBadRequest), limit.Err)
+        return
+    }
 
-    /* Validate pattern */
-    pattern := "*"
-    if form.Pattern != "" {
-            if tools.PathLength(form.Pattern) > 1 {
-                sendError(context, err)
-                return
+    /* Request over the bucket quota is rejected before the body is spooled,
+     * if bucket and file name are sent before the file */
+    if context.Request.ContentLength > 0 {
+        fields := peekFormFields(context.Request)
+        if len(fields["filename"]) > 0 {
+            filePath, err := this.ValidateFilePath(fields["bucket"], fields["filename"])
+            if err == nil {
+                _, err = this.quotas.Check(username, this.storePath(filePath), context.Request.ContentLength)
+                if _, exceeded := err.(quotaModel.ExceededError); exceeded {
+                    sendStatus(context, quotaStatus(err, http.StatusBadRequest), err)
+                    return
+                }
             }
-            pattern = form.Pattern
+        }
+    }
+    body := limit.Reader(context.Request.Body)
+    context.Request.Body = body
+
+    /* Bind form */
+    form := putForm{}
+    if err := context.ShouldBind(&form); err != nil {
+        if body.Err() != nil {
+            err = body.Err()
+        }
+        sendStatus(context, quotaStatus(err, http.StatusBadRequest), err)
+        return
     }
 
-    filePath, err := this.ValidateFilePath(form.Bucket, pattern)
+    /* Validate bucket name */
+    directoryPath, err := this.ValidateBucketPath(form.BucketName)
     if err != nil {
         sendError(context, err)
         return
     }
 
-    /* List directory by pattern */
-    fileNameList, err := filepath.Glob(filePath)
+    /* Validate file name */
+    filePath, err := this.ValidateFilePath(form.BucketName, form.FileName)
     if err != nil {
         sendError(context, err)
         return
     }
 
-    list := []File{}
-    for _, fileName := range fileNameList {
-        fi, err := os.Stat(fileName)
-        if err != nil {
-            log.Printf("%s\n", err)
-            continue
-        }
-        if !fi.Mode().IsRegular() {
-            continue
-        }
-        list = append(list, File{
-                Name: filepath.Base(fileName),
-                Size: fi.Size(),
-                ModTime: fi.ModTime().Format(time.RFC3339),
-            })
+    /* Check permission */
+    if err := this.allow(context, filePath, aclModel.PermWrite); err != nil {
+        sendForbidden(context, err)
+        return
     }
-    /* Send result */
-    sendResult(context, list)
-}
-
-type putForm struct {
-    FileName    string          `form:"filename" binding:"required"`
-    BucketName  string          `form:"bucket"`
-    File *multipart.FileHeader  `form:"file"     binding:"required"`
-}
 
-func (this *Controller) Put(context *gin.Context) {
+    /* Check quotas of the user and the bucket */
+    allowance, err := this.quotas.Check(username, this.storePath(filePath), form.File.Size)
+    if err != nil {
+        sendStatus(context, quotaStatus(err, http.StatusBadRequest), err)
+        return
+    }
 
-    /* Bind form */
-    form := putForm{}
-    if err := context.ShouldBind(&form); err != nil {
+    /* Store file through temporary data */
+    if err := this.store.MakeDir(this.storePath(directoryPath)); err != nil {
         sendError(context, err)
         return
     }
 
-    /* Validate bucket name */
-    directoryPath, err := this.ValidateBucketPath(form.BucketName)
+    /* Expected checksums from form or Digest header */
+    sha256Sum, md5Sum, err := objectModel.ParseDigest(context.GetHeader("Digest"))
     if err != nil {
         sendError(context, err)
         return
     }
+    if len(form.SHA256) > 0 {
+        sha256Sum = form.SHA256
+    }
+    if len(form.MD5) > 0 {
+        md5Sum = form.MD5
+    }
 
-    /* Validate file name */
-    filePath, err := this.ValidateFilePath(form.BucketName, form.FileName)
+    /* User metadata and tags */
+    attrs, err := requestAttributes(context)
     if err != nil {
         sendError(context, err)
         return
     }
 
-    /* Store file */
-    if err := os.MkdirAll(directoryPath, os.ModeDir | 0750); err != nil {
+    key := this.storePath(filePath)
+    noReplace := this.noReplace(context)
+    if noReplace && storageBackend.FileExists(this.store, key) {
+        sendStatus(context, http.StatusPreconditionFailed, tools.ErrFileExists)
+        return
+    }
+    if !this.ifMatch(context, filePath) {
+        sendStatus(context, http.StatusPreconditionFailed, errors.New("etag does not match"))
+        return
+    }
+
+    file, err := form.File.Open()
+    if err != nil {
         sendError(context, err)
         return
     }
+    defer file.Close()
 
-    file := form.File
-    if err := context.SaveUploadedFile(file, filePath); err != nil {
+    tmpKey, err := storageBackend.TempKey("put-")
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    hash := objectModel.NewHash()
+    _, err = this.store.Put(tmpKey, io.TeeReader(allowance.Reader(file), hash), false)
+    if err == nil {
+        err = hash.Verify(sha256Sum, md5Sum)
+    }
+    if err != nil {
+        this.store.Delete(tmpKey)
+        sendStatus(context, quotaStatus(err, http.StatusBadRequest), err)
+        return
+    }
+    /* Keep replaced data as version */
+    commit := func() error {
+        return this.store.Move(tmpKey, key, noReplace)
+    }
+    if noReplace {
+        err = commit()
+    } else {
+        err = this.versions.Replace(commit, key)
+    }
+    if err != nil {
+        this.store.Delete(tmpKey)
+    }
+    if err == tools.ErrFileExists {
+        sendStatus(context, http.StatusPreconditionFailed, err)
+        return
+    }
+    if err != nil {
         sendError(context, err)
         return
     }
 
     /* Check uploaded file */
-    fileInfo, err := os.Stat(filePath)
+    fileInfo, err := this.store.Stat(key)
     if err != nil {
         sendError(context, err)
         return
@@ -313,13 +758,22 @@ func (this *Controller) Put(context *gin.Context) {
         return
     }
 
+    /* Content type from the form or the file part, detected if not specific */
+    contentType := form.ContentType
+    if len(contentType) == 0 {
+        contentType = form.File.Header.Get("Content-Type")
+    }
+    object, err := this.objects.SaveFile(key, fileInfo, hash, contentType, username)
+    if err != nil {
+        log.Println(err)
+    }
+    if err := this.objects.SetAttributes(key, attrs); err != nil {
+        log.Println(err)
+    }
+
     /* Send file info */
     var list []File
-    list = append(list, File{
-                Name: filepath.Base(filePath),
-                Size: fileInfo.Size(),
-                ModTime: fileInfo.ModTime().Format(time.RFC3339),
-            })
+    list = append(list, newFile(fileInfo, map[string]objectModel.Object{ object.Name: object }, attrs))
 
     sendResult(context, list)
 }
@@ -327,6 +781,8 @@ func (this *Controller) Put(context *gin.Context) {
 type getForm struct {
     FileName    string  `form:"filename" json:"filename" binding:"required" `
     BucketName  string  `form:"bucket"   json:"bucket"`
+    Version     string  `form:"version"  json:"version"`
+    Disposition string  `form:"disposition" json:"disposition"`
 }
 
 func (this *Controller) Get(context *gin.Context) {
@@ -346,14 +802,14 @@ func (this *Controller) Get(context *gin.Context) {
         return
     }
 
-    /* Check real file */
-    if !tools.FileExists(filePath) {
-        err := errors.New(fmt.Sprintf("file path not found %s\n", filePath))
+    /* Check permission */
+    if err := this.allow(context, filePath, aclModel.PermRead); err != nil {
         log.Println(err)
-        context.Status(http.StatusNotFound)
+        context.Status(http.StatusForbidden)
         return
     }
-    context.FileAttachment(filePath, filepath.Base(filePath))
+
+    this.sendFile(context, filePath, form.Version, form.Disposition == "inline")
 }
 
 func (this *Controller) Down(context *gin.Context) {
@@ -367,13 +823,14 @@ func (this *Controller) Down(context *gin.Context) {
         return
     }
 
-    if !tools.FileExists(filePath) {
-        err := errors.New(fmt.Sprintf("file path not found %s\n", filePath))
+    /* Check permission */
+    if err := this.allow(context, filePath, aclModel.PermRead); err != nil {
         log.Println(err)
-        context.Status(http.StatusNotFound)
+        context.Status(http.StatusForbidden)
         return
     }
-    context.FileAttachment(filePath, filepath.Base(filePath))
+
+    this.sendFile(context, filePath, context.Query("version"), context.Query("disposition") == "inline")
 }
 
 type deleteForm struct {
@@ -393,34 +850,43 @@ func (this *Controller) Delete(context *gin.Context) {
     reqPath := filepath.Join(form.BucketName, form.FileName)
     fullPath := filepath.Clean(filepath.Join(storePath, reqPath))
 
-    if !strings.HasPrefix(fullPath, storePath) {
+    if !strings.HasPrefix(fullPath, storePath) || config.IsSystemName(strings.TrimPrefix(fullPath, storePath)) {
         err := errors.New(fmt.Sprintf("wrong file name %s", reqPath))
         sendError(context, err)
         return
     }
-    if !tools.FileExists(fullPath) {
+
+    /* Check permission */
+    if err := this.allow(context, fullPath, aclModel.PermDelete); err != nil {
+        sendForbidden(context, err)
+        return
+    }
+
+    key := this.storePath(fullPath)
+    if !storageBackend.FileExists(this.store, key) {
         err := errors.New(fmt.Sprintf("wrong file name %s", reqPath))
         sendError(context, err)
         return
     }
+    if !this.ifMatch(context, fullPath) {
+        sendStatus(context, http.StatusPreconditionFailed, errors.New("etag does not match"))
+        return
+    }
 
-    /* Real revive file */
-    err := os.Remove(fullPath)
+    /* Move file to the trash, versioned file leaves delete marker */
+    err := this.versions.Remove(key)
     if err != nil {
         sendError(context, err)
         return
     }
 
     /* Validate operation */
-    if tools.FileExists(fullPath) {
+    if storageBackend.FileExists(this.store, key) {
         err := errors.New(fmt.Sprintf("wrong file name %s", reqPath))
         sendError(context, err)
         return
     }
 
-    /* Clean directory if empty */
-    _ = syscall.Rmdir(storePath)
-
     sendResult(context, []File{})
 }
 
@@ -429,8 +895,16 @@ func (this *Controller) Hello(context *gin.Context) {
     sendMessage(context, "hello")
 }
 
-func New(config *config.Config) *Controller {
+func New(config *config.Config, db *sqlx.DB) *Controller {
     return &Controller{
         config: config,
+        db: db,
+        store: storageBackend.Open(config, db),
+        acl: aclModel.New(db),
+        objects: objectModel.New(db),
+        versions: versionModel.New(config, db),
+        trash: trashModel.New(config, db),
+        quotas: quotaModel.New(db),
+        uploadBusy: make(map[string]bool),
     }
 }
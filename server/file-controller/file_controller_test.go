@@ -0,0 +1,172 @@
+package fileController
+
+import (
+    "bytes"
+    "io/ioutil"
+    "mime/multipart"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+    _ "github.com/mattn/go-sqlite3"
+
+    "store/config"
+    "store/server/bucket-model"
+    "store/server/object-model"
+    "store/server/storage-backend"
+)
+
+/* Controller over the memory store and the database in memory */
+func testController(t *testing.T) *Controller {
+    db, err := sqlx.Open("sqlite3", ":memory:")
+    if err != nil {
+        t.Fatal(err)
+    }
+    db.SetMaxOpenConns(1)
+    controller := New(&config.Config{ Storage: storageBackend.StorageMemory, StoreDir: "/store" }, db)
+    for _, migrate := range []func() error{ controller.objects.Migrate, controller.versions.Migrate,
+                                controller.trash.Migrate, controller.quotas.Migrate, bucketModel.New(db).Migrate } {
+        if err := migrate(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return controller
+}
+
+/* Write the file with metadata as put does */
+func putTestFile(t *testing.T, controller *Controller, key, data string) {
+    if _, err := controller.store.Put(key, strings.NewReader(data), false); err != nil {
+        t.Fatal(err)
+    }
+    fileInfo, _ := controller.store.Stat(key)
+    hash, _ := objectModel.HashReader(strings.NewReader(data))
+    if _, err := controller.objects.SaveFile(key, fileInfo, hash, "", "alice"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+/* Serve the request by the handler as the user */
+func serveTest(handler gin.HandlerFunc, request *http.Request) *httptest.ResponseRecorder {
+    gin.SetMode(gin.TestMode)
+    router := gin.New()
+    router.Use(func(context *gin.Context) {
+        context.Set("username", "alice")
+    })
+    router.Handle(request.Method, request.URL.Path, handler)
+    recorder := httptest.NewRecorder()
+    router.ServeHTTP(recorder, request)
+    return recorder
+}
+
+func TestPeekFormFields(t *testing.T) {
+    var body bytes.Buffer
+    writer := multipart.NewWriter(&body)
+    writer.WriteField("bucket", "photos")
+    writer.WriteField("filename", "a.jpg")
+    part, _ := writer.CreateFormFile("file", "a.jpg")
+    part.Write(bytes.Repeat([]byte("x"), 100000))
+    writer.WriteField("md5", "after")
+    writer.Close()
+    sent := body.Bytes()
+
+    request, _ := http.NewRequest(http.MethodPost, "/api/v1/file/put", bytes.NewReader(sent))
+    request.Header.Set("Content-Type", writer.FormDataContentType())
+    fields := peekFormFields(request)
+    if fields["bucket"] != "photos" || fields["filename"] != "a.jpg" {
+        t.Errorf("wrong fields %v", fields)
+    }
+    if _, exists := fields["md5"]; exists {
+        t.Error("field after the file is peeked")
+    }
+    if read, _ := ioutil.ReadAll(request.Body); !bytes.Equal(read, sent) {
+        t.Error("body is not restored")
+    }
+
+    request, _ = http.NewRequest(http.MethodPost, "/api/v1/file/put", bytes.NewReader([]byte("{}")))
+    request.Header.Set("Content-Type", "application/json")
+    if fields := peekFormFields(request); len(fields) != 0 {
+        t.Errorf("fields of json body %v", fields)
+    }
+}
+
+func TestGetRange(t *testing.T) {
+    controller := testController(t)
+    putTestFile(t, controller, "docs/data.txt", "0123456789")
+    get := func(method string, headers map[string]string) *httptest.ResponseRecorder {
+        request, _ := http.NewRequest(method, "/api/v1/file/get?bucket=docs&filename=data.txt", nil)
+        for name, value := range headers {
+            request.Header.Set(name, value)
+        }
+        return serveTest(controller.Get, request)
+    }
+
+    response := get(http.MethodGet, nil)
+    etag := response.Header().Get("ETag")
+    if response.Code != http.StatusOK || response.Body.String() != "0123456789" || len(etag) == 0 {
+        t.Fatalf("wrong response %d %q etag %s", response.Code, response.Body.String(), etag)
+    }
+    if response.Header().Get("Accept-Ranges") != "bytes" || len(response.Header().Get("Last-Modified")) == 0 {
+        t.Errorf("wrong headers %v", response.Header())
+    }
+    response = get(http.MethodHead, nil)
+    if response.Code != http.StatusOK || response.Body.Len() > 0 || response.Header().Get("Content-Length") != "10" {
+        t.Errorf("wrong HEAD response %d %q", response.Code, response.Body.String())
+    }
+
+    response = get(http.MethodGet, map[string]string{ "Range": "bytes=2-5" })
+    if response.Code != http.StatusPartialContent || response.Body.String() != "2345" ||
+            response.Header().Get("Content-Range") != "bytes 2-5/10" {
+        t.Errorf("wrong range response %d %q", response.Code, response.Body.String())
+    }
+    response = get(http.MethodGet, map[string]string{ "Range": "bytes=-3" })
+    if response.Code != http.StatusPartialContent || response.Body.String() != "789" {
+        t.Errorf("wrong suffix range response %d %q", response.Code, response.Body.String())
+    }
+    response = get(http.MethodGet, map[string]string{ "Range": "bytes=20-30" })
+    if response.Code != http.StatusRequestedRangeNotSatisfiable {
+        t.Errorf("wrong status %d of unsatisfiable range", response.Code)
+    }
+
+    /* Conditions by ETag and modification time */
+    response = get(http.MethodGet, map[string]string{ "If-None-Match": etag })
+    if response.Code != http.StatusNotModified || response.Body.Len() > 0 {
+        t.Errorf("wrong status %d of matching If-None-Match", response.Code)
+    }
+    response = get(http.MethodGet, map[string]string{ "If-None-Match": `"other"` })
+    if response.Code != http.StatusOK {
+        t.Errorf("wrong status %d of other If-None-Match", response.Code)
+    }
+    response = get(http.MethodGet, map[string]string{ "If-Match": `"other"` })
+    if response.Code != http.StatusPreconditionFailed {
+        t.Errorf("wrong status %d of other If-Match", response.Code)
+    }
+    response = get(http.MethodGet, map[string]string{ "If-Match": etag, "Range": "bytes=0-0" })
+    if response.Code != http.StatusPartialContent || response.Body.String() != "0" {
+        t.Errorf("wrong status %d of matching If-Match", response.Code)
+    }
+    future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+    response = get(http.MethodGet, map[string]string{ "If-Modified-Since": future })
+    if response.Code != http.StatusNotModified {
+        t.Errorf("wrong status %d of If-Modified-Since", response.Code)
+    }
+    past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+    response = get(http.MethodGet, map[string]string{ "If-Unmodified-Since": past })
+    if response.Code != http.StatusPreconditionFailed {
+        t.Errorf("wrong status %d of If-Unmodified-Since", response.Code)
+    }
+
+    /* Range of the changed file is sent whole */
+    response = get(http.MethodGet, map[string]string{ "Range": "bytes=0-1", "If-Range": `"other"` })
+    if response.Code != http.StatusOK || response.Body.String() != "0123456789" {
+        t.Errorf("wrong status %d of other If-Range", response.Code)
+    }
+
+    request, _ := http.NewRequest(http.MethodGet, "/api/v1/file/get?bucket=docs&filename=missing.txt", nil)
+    if response = serveTest(controller.Get, request); response.Code != http.StatusNotFound {
+        t.Errorf("wrong status %d of missing file", response.Code)
+    }
+}
@@ -0,0 +1,185 @@
+package fileController
+
+import (
+    "errors"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+
+    "store/server/acl-model"
+    "store/server/object-model"
+    "store/tools"
+)
+
+type copyForm struct {
+    BucketName  string  `form:"bucket"       json:"bucket"`
+    FileName    string  `form:"filename"     json:"filename"     binding:"required"`
+    ToBucket    string  `form:"tobucket"     json:"tobucket"`
+    ToFileName  string  `form:"tofilename"   json:"tofilename"`
+    Overwrite   bool    `form:"overwrite"    json:"overwrite"`
+}
+
+/* Copy the file within the store, data is shared by hard link if possible */
+func (this *Controller) Copy(context *gin.Context) {
+    this.transfer(context, false)
+}
+
+/* Move the file within the store by rename */
+func (this *Controller) Move(context *gin.Context) {
+    this.transfer(context, true)
+}
+
+/* Copy or move the file with checksums, content type, metadata and tags.
+ * Destination bucket and name are the source ones if empty.
+ * Existing destination is replaced only with overwrite flag */
+func (this *Controller) transfer(context *gin.Context, move bool) {
+    username := context.GetString("username")
+
+    form := copyForm{}
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    if len(form.ToBucket) == 0 {
+        form.ToBucket = form.BucketName
+    }
+    if len(form.ToFileName) == 0 {
+        form.ToFileName = form.FileName
+    }
+
+    /* Validate file names */
+    srcPath, err := this.ValidateFilePath(form.BucketName, form.FileName)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    dstPath, err := this.ValidateFilePath(form.ToBucket, form.ToFileName)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if srcPath == dstPath {
+        sendError(context, errors.New("source and destination are the same file"))
+        return
+    }
+
+    /* Check permissions */
+    if err := this.allow(context, srcPath, aclModel.PermRead); err != nil {
+        sendForbidden(context, err)
+        return
+    }
+    if move {
+        if err := this.allow(context, srcPath, aclModel.PermDelete); err != nil {
+            sendForbidden(context, err)
+            return
+        }
+    }
+    if err := this.allow(context, dstPath, aclModel.PermWrite); err != nil {
+        sendForbidden(context, err)
+        return
+    }
+
+    srcKey, dstKey := this.storePath(srcPath), this.storePath(dstPath)
+    fileInfo, err := this.store.Stat(srcKey)
+    if err != nil || !fileInfo.Mode().IsRegular() {
+        sendStatus(context, http.StatusNotFound, errors.New("file not found " + srcKey))
+        return
+    }
+
+    /* Overwrite protection */
+    noReplace := !form.Overwrite || this.noReplace(context)
+    if dstInfo, err := this.store.Stat(dstKey); err == nil {
+        if !dstInfo.Mode().IsRegular() {
+            sendError(context, errors.New("destination is not a file " + dstKey))
+            return
+        }
+        if noReplace {
+            sendStatus(context, http.StatusPreconditionFailed, tools.ErrFileExists)
+            return
+        }
+    }
+    if !this.ifMatch(context, dstPath) {
+        sendStatus(context, http.StatusPreconditionFailed, errors.New("etag does not match"))
+        return
+    }
+
+    /* Moved file stays in usage of the owner, only bucket quotas
+     * of another bucket are affected */
+    srcBucket, _ := objectModel.Split(srcKey)
+    dstBucket, _ := objectModel.Split(dstKey)
+    if !move || srcBucket != dstBucket {
+        if _, err := this.quotas.Check(username, dstKey, fileInfo.Size()); err != nil {
+            sendStatus(context, quotaStatus(err, http.StatusBadRequest), err)
+            return
+        }
+    }
+
+    object, valid := this.objects.Stat(srcKey, fileInfo)
+    attrs, err := this.objects.Attributes(srcKey)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+
+    /* Keep replaced data as version */
+    commit := func() error {
+        if move {
+            return this.store.Move(srcKey, dstKey, noReplace)
+        }
+        return this.store.Copy(srcKey, dstKey, noReplace)
+    }
+    if noReplace {
+        err = commit()
+    } else {
+        err = this.versions.Replace(commit, dstKey)
+    }
+    if err == tools.ErrFileExists {
+        sendStatus(context, http.StatusPreconditionFailed, err)
+        return
+    }
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+
+    /* Record metadata of the destination, copy is owned by the user */
+    dstInfo, err := this.store.Stat(dstKey)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    owner := username
+    if move && valid {
+        owner = object.Owner
+    }
+    if valid {
+        object.Bucket, object.Name = objectModel.Split(dstKey)
+        object.Size = dstInfo.Size()
+        object.Stored = objectModel.StoredSize(dstInfo)
+        object.ModTime = dstInfo.ModTime().UnixNano()
+        object.Owner = owner
+        err = this.objects.Save(object)
+    } else {
+        var hash *objectModel.Hash
+        if hash, err = this.hashFile(dstKey); err == nil {
+            object, err = this.objects.SaveFile(dstKey, dstInfo, hash, "", owner)
+        }
+    }
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if err := this.objects.SetAttributes(dstKey, attrs); err != nil {
+        sendError(context, err)
+        return
+    }
+    if move {
+        if err := this.objects.Delete(srcKey); err != nil {
+            sendError(context, err)
+            return
+        }
+    }
+
+    file := newFile(dstInfo, map[string]objectModel.Object{ object.Name: object }, attrs)
+    sendResult(context, []File{ file })
+}
@@ -0,0 +1,92 @@
+package fileController
+
+import (
+    "net/http"
+    "strings"
+    "testing"
+
+    "store/server/object-model"
+    "store/server/quota-model"
+    "store/server/storage-backend"
+)
+
+func TestCopyMove(t *testing.T) {
+    controller := testController(t)
+    putTestFile(t, controller, "src/a.txt", "data of a")
+    object, _ := controller.objects.Find("src/a.txt")
+    object.Owner = "bob"
+    controller.objects.Save(object)
+    controller.objects.SetAttributes("src/a.txt", objectModel.Attributes{ Tags: map[string]string{ "stage": "test" } })
+    transfer := func(move bool, body string) int {
+        handler, path := controller.Copy, "/api/v1/file/copy"
+        if move {
+            handler, path = controller.Move, "/api/v1/file/move"
+        }
+        request, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(body))
+        request.Header.Set("Content-Type", "application/json")
+        return serveTest(handler, request).Code
+    }
+    check := func(key, data, owner string) {
+        if read, err := storageBackend.ReadFile(controller.store, key); err != nil || string(read) != data {
+            t.Errorf("wrong data of %s %q %v", key, read, err)
+        }
+        copied, err := controller.objects.Find(key)
+        if err != nil || copied.SHA256 != object.SHA256 || copied.Owner != owner {
+            t.Errorf("wrong metadata of %s %v %v", key, copied, err)
+        }
+        if attrs, _ := controller.objects.Attributes(key); attrs.Tags["stage"] != "test" {
+            t.Errorf("attributes of %s are not copied %v", key, attrs)
+        }
+    }
+
+    /* Copy is owned by the user, the source is kept */
+    if status := transfer(false, `{ "bucket": "src", "filename": "a.txt", "tobucket": "dst", "tofilename": "b.txt" }`); status != http.StatusOK {
+        t.Fatalf("wrong status %d of copy", status)
+    }
+    check("dst/b.txt", "data of a", "alice")
+    check("src/a.txt", "data of a", "bob")
+
+    /* Destination is replaced only with overwrite */
+    putTestFile(t, controller, "dst/c.txt", "data of c")
+    if status := transfer(false, `{ "bucket": "src", "filename": "a.txt", "tobucket": "dst", "tofilename": "c.txt" }`); status != http.StatusPreconditionFailed {
+        t.Errorf("wrong status %d of copy over the file", status)
+    }
+    if read, _ := storageBackend.ReadFile(controller.store, "dst/c.txt"); string(read) != "data of c" {
+        t.Errorf("destination is replaced without overwrite %q", read)
+    }
+    if status := transfer(false, `{ "bucket": "src", "filename": "a.txt", "tobucket": "dst", "tofilename": "c.txt", "overwrite": true }`); status != http.StatusOK {
+        t.Errorf("wrong status %d of copy with overwrite", status)
+    }
+    check("dst/c.txt", "data of a", "alice")
+
+    /* Moved file keeps the owner, the source is removed with metadata */
+    if status := transfer(true, `{ "bucket": "src", "filename": "a.txt", "tobucket": "moved" }`); status != http.StatusOK {
+        t.Fatalf("wrong status %d of move", status)
+    }
+    check("moved/a.txt", "data of a", "bob")
+    if storageBackend.FileExists(controller.store, "src/a.txt") {
+        t.Error("moved file is kept")
+    }
+    if _, err := controller.objects.Find("src/a.txt"); err == nil {
+        t.Error("metadata of the moved file is kept")
+    }
+
+    /* Copy over the bucket quota is refused */
+    controller.quotas.Set(quotaModel.Quota{ Kind: quotaModel.KindBucket, Name: "small", MaxBytes: 4 })
+    if status := transfer(false, `{ "bucket": "moved", "filename": "a.txt", "tobucket": "small" }`); status != http.StatusRequestEntityTooLarge {
+        t.Errorf("wrong status %d of copy over the quota", status)
+    }
+    if storageBackend.FileExists(controller.store, "small/a.txt") {
+        t.Error("file is copied over the quota")
+    }
+
+    for body, expected := range map[string]int{
+            `{ "bucket": "moved", "filename": "a.txt" }`: http.StatusBadRequest,
+            `{ "bucket": "moved", "filename": "missing.txt", "tobucket": "dst" }`: http.StatusNotFound,
+            `{ "bucket": "moved", "filename": "a.txt", "tobucket": "../../etc" }`: http.StatusBadRequest,
+            `{ "bucket": "moved", "filename": "a.txt", "tobucket": ".m2store" }`: http.StatusBadRequest } {
+        if status := transfer(false, body); status != expected {
+            t.Errorf("wrong status %d of %s, expected %d", status, body, expected)
+        }
+    }
+}
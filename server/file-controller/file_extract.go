@@ -0,0 +1,417 @@
+package fileController
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "bytes"
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "math"
+    "mime/multipart"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+
+    "store/server/acl-model"
+    "store/server/object-model"
+    "store/server/storage-backend"
+    "store/tools"
+)
+
+type extractForm struct {
+    BucketName  string          `form:"bucket"`
+    Prefix      string          `form:"prefix"`
+    Format      string          `form:"format"`
+    File *multipart.FileHeader  `form:"file"     binding:"required"`
+}
+
+/* Files written from the archive, names are relative to the bucket */
+type Extracted struct {
+    Bucket      string      `json:"bucket"`
+    Prefix      string      `json:"prefix,omitempty"`
+    Files       []File      `json:"files"`
+    Skipped     []string    `json:"skipped,omitempty"`
+}
+
+/* Archive entry extracted into the staging directory */
+type stagedFile struct {
+    name        string
+    filePath    string
+    key         string
+    stageKey    string
+    backupKey   string
+    hash        *objectModel.Hash
+    size        int64
+}
+
+/* Extraction of the archive into the target directory through the staging
+ * directory, files are moved into the target when all entries are read */
+type extraction struct {
+    controller  *Controller
+    bucketName  string
+    targetPath  string
+    stageDir    string
+    bytesLeft   int64
+    filesLeft   int
+    staged      map[string]*stagedFile
+    order       []*stagedFile
+    skipped     []string
+}
+
+/* Archive exceeds extraction limits of the config */
+type extractLimitError struct {
+    message     string
+}
+
+func (this extractLimitError) Error() string {
+    return this.message
+}
+
+/* Archive format from the form or by magic bytes of the data */
+func extractFormat(file io.ReaderAt, format string) (string, error) {
+    if len(format) > 0 {
+        if _, _, err := archiveFormat(format); err != nil {
+            return "", err
+        }
+        return strings.ToLower(format), nil
+    }
+    magic := make([]byte, 4)
+    file.ReadAt(magic, 0)
+    switch {
+        case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+            return FormatZip, nil
+        case bytes.HasPrefix(magic, []byte{ 0x1f, 0x8b }):
+            return FormatTgz, nil
+    }
+    return FormatTar, nil
+}
+
+/* Count the archive entry against the limit of files */
+func (this *extraction) count() error {
+    this.filesLeft--
+    if this.filesLeft < 0 {
+        return extractLimitError{ fmt.Sprintf("archive has more than %d entries", this.controller.config.ExtractMaxFiles) }
+    }
+    return nil
+}
+
+/* Validate the entry name as file name of the target directory,
+ * names leaving the directory are rejected */
+func (this *extraction) stage(name string) (*stagedFile, error) {
+    filePath, err := this.controller.ValidateFilePath("", filepath.Join(this.controller.storePath(this.targetPath), name))
+    if err != nil || filepath.IsAbs(name) || !strings.HasPrefix(filePath, this.targetPath + "/") {
+        return nil, errors.New("wrong file name in archive " + name)
+    }
+    relName := strings.TrimPrefix(filePath, this.targetPath + "/")
+    if staged, exists := this.staged[relName]; exists {
+        return staged, nil
+    }
+    staged := &stagedFile{
+        name: relName,
+        filePath: filePath,
+        key: this.controller.storePath(filePath),
+        stageKey: storageBackend.Join(this.stageDir, "data", relName),
+    }
+    if err := this.controller.store.MakeDir(storageBackend.Dir(staged.stageKey)); err != nil {
+        return nil, errors.New("wrong file name in archive " + name)
+    }
+    this.staged[relName] = staged
+    this.order = append(this.order, staged)
+    return staged, nil
+}
+
+/* Write data of the entry into the staging directory, data is counted
+ * as read so declared sizes of the archive are not trusted */
+func (this *extraction) write(name string, reader io.Reader) error {
+    staged, err := this.stage(name)
+    if err != nil {
+        return err
+    }
+    staged.hash = objectModel.NewHash()
+    reader = io.TeeReader(io.LimitReader(reader, this.bytesLeft + 1), staged.hash)
+    staged.size, err = this.controller.store.Put(staged.stageKey, reader, false)
+    if err == nil && staged.size > this.bytesLeft {
+        err = extractLimitError{ fmt.Sprintf("archive data exceeds %d bytes", this.controller.config.ExtractMaxBytes) }
+    }
+    this.bytesLeft -= staged.size
+    return err
+}
+
+func (this *extraction) readZip(reader io.ReaderAt, size int64) error {
+    archive, err := zip.NewReader(reader, size)
+    if err != nil {
+        return err
+    }
+    for _, entry := range archive.File {
+        if err := this.count(); err != nil {
+            return err
+        }
+        mode := entry.Mode()
+        if mode.IsDir() {
+            continue
+        }
+        if !mode.IsRegular() {
+            this.skipped = append(this.skipped, entry.Name)
+            continue
+        }
+        data, err := entry.Open()
+        if err != nil {
+            return err
+        }
+        err = this.write(entry.Name, data)
+        data.Close()
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (this *extraction) readTar(reader io.Reader) error {
+    archive := tar.NewReader(reader)
+    for {
+        header, err := archive.Next()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        if err := this.count(); err != nil {
+            return err
+        }
+        switch header.Typeflag {
+            case tar.TypeReg, tar.TypeRegA:
+                if err := this.write(header.Name, archive); err != nil {
+                    return err
+                }
+            case tar.TypeDir, tar.TypeXGlobalHeader:
+            default:
+                this.skipped = append(this.skipped, header.Name)
+        }
+    }
+}
+
+/* Return not existing directories of the key, top first */
+func (this *extraction) missingDirs(dirKey string) []string {
+    var dirs []string
+    for {
+        if _, err := this.controller.store.Stat(dirKey); !os.IsNotExist(err) {
+            return dirs
+        }
+        dirs = append([]string{ dirKey }, dirs...)
+        dirKey = storageBackend.Dir(dirKey)
+    }
+}
+
+/* Move staged files into the target. On failure moved files are
+ * put back, replaced files are restored from backup links */
+func (this *extraction) commit(noReplace bool) error {
+    store := this.controller.store
+    var done []*stagedFile
+    var created []string
+    var err error
+    for _, staged := range this.order {
+        if storageBackend.FileExists(store, staged.key) {
+            staged.backupKey = storageBackend.Join(this.stageDir, "backup", staged.name)
+            if err = store.Copy(staged.key, staged.backupKey, false); err != nil {
+                staged.backupKey = ""
+                break
+            }
+        }
+        dirs := this.missingDirs(storageBackend.Dir(staged.key))
+        err = store.Move(staged.stageKey, staged.key, noReplace)
+        created = append(created, dirs...)
+        if err != nil {
+            break
+        }
+        done = append(done, staged)
+    }
+    if err == nil {
+        return nil
+    }
+    for i := len(done) - 1; i >= 0; i-- {
+        if len(done[i].backupKey) > 0 {
+            store.Move(done[i].backupKey, done[i].key, false)
+        } else {
+            store.Delete(done[i].key)
+        }
+    }
+    for i := len(created) - 1; i >= 0; i-- {
+        store.Delete(created[i])
+    }
+    return err
+}
+
+/* Extract tar, tar.gz or zip archive into the bucket or the prefix of the bucket.
+ * All files are written or none, the report lists written and skipped entries */
+func (this *Controller) Extract(context *gin.Context) {
+    username := context.GetString("username")
+
+    /* Request larger than the user quota is rejected before reading the body */
+    limit, err := this.quotas.UserLimit(username)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if limit.Bytes >= 0 && context.Request.ContentLength > limit.Bytes {
+        sendStatus(context, quotaStatus(limit.Err, http.StatusBadRequest), limit.Err)
+        return
+    }
+    body := limit.Reader(context.Request.Body)
+    context.Request.Body = body
+
+    form := extractForm{}
+    if err := context.ShouldBind(&form); err != nil {
+        if body.Err() != nil {
+            err = body.Err()
+        }
+        sendStatus(context, quotaStatus(err, http.StatusBadRequest), err)
+        return
+    }
+
+    /* Validate target directory and permission */
+    targetPath, err := this.ValidateBucketPath(filepath.Join(form.BucketName, form.Prefix))
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if err := this.allow(context, filepath.Join(targetPath, "*"), aclModel.PermWrite); err != nil {
+        sendForbidden(context, err)
+        return
+    }
+
+    file, err := form.File.Open()
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    defer file.Close()
+    format, err := extractFormat(file, form.Format)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+
+    /* Extract into the staging directory */
+    stageDir, err := storageBackend.TempKey("extract-")
+    if err == nil {
+        err = this.store.MakeDir(stageDir)
+    }
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    defer this.store.DeleteAll(stageDir)
+
+    extract := &extraction{
+        controller: this,
+        bucketName: strings.Trim(filepath.Clean("/" + form.BucketName), "/"),
+        targetPath: targetPath,
+        stageDir: stageDir,
+        bytesLeft: this.config.ExtractMaxBytes,
+        filesLeft: this.config.ExtractMaxFiles,
+        staged: make(map[string]*stagedFile),
+    }
+    if extract.bytesLeft <= 0 {
+        extract.bytesLeft = math.MaxInt64 - 1
+    }
+    if extract.filesLeft <= 0 {
+        extract.filesLeft = math.MaxInt32
+    }
+    switch format {
+        case FormatZip:
+            err = extract.readZip(file, form.File.Size)
+        case FormatTgz, "tgz":
+            var reader *gzip.Reader
+            if reader, err = gzip.NewReader(file); err == nil {
+                err = extract.readTar(reader)
+            }
+        default:
+            err = extract.readTar(file)
+    }
+    if _, ok := err.(extractLimitError); ok {
+        sendStatus(context, http.StatusRequestEntityTooLarge, err)
+        return
+    }
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+
+    /* Check all files before writing any */
+    noReplace := this.noReplace(context)
+    sizes := make(map[string]int64)
+    for _, staged := range extract.order {
+        if err := this.allow(context, staged.filePath, aclModel.PermWrite); err != nil {
+            sendForbidden(context, err)
+            return
+        }
+        if fileInfo, err := this.store.Stat(staged.key); err == nil {
+            if !fileInfo.Mode().IsRegular() {
+                sendError(context, errors.New("file name of archive is not a file " + staged.name))
+                return
+            }
+            if noReplace {
+                sendStatus(context, http.StatusPreconditionFailed, tools.ErrFileExists)
+                return
+            }
+        }
+        sizes[staged.key] = staged.size
+    }
+    if err := this.quotas.CheckFiles(username, sizes); err != nil {
+        sendStatus(context, quotaStatus(err, http.StatusBadRequest), err)
+        return
+    }
+
+    /* Keep replaced data as versions */
+    commit := func() error {
+        return extract.commit(noReplace)
+    }
+    if noReplace {
+        err = commit()
+    } else {
+        keys := make([]string, 0, len(extract.order))
+        for _, staged := range extract.order {
+            keys = append(keys, staged.key)
+        }
+        err = this.versions.Replace(commit, keys...)
+    }
+    if err == tools.ErrFileExists {
+        sendStatus(context, http.StatusPreconditionFailed, err)
+        return
+    }
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+
+    /* Record written files */
+    result := Extracted{
+        Bucket: extract.bucketName,
+        Prefix: strings.Trim(form.Prefix, "/"),
+        Files: []File{},
+        Skipped: extract.skipped,
+    }
+    for _, staged := range extract.order {
+        fileInfo, err := this.store.Stat(staged.key)
+        if err != nil {
+            log.Println(err)
+            continue
+        }
+        object, err := this.objects.SaveFile(staged.key, fileInfo, staged.hash, "", username)
+        if err != nil {
+            log.Println(err)
+        }
+        file := newFile(fileInfo, map[string]objectModel.Object{ object.Name: object }, objectModel.Attributes{})
+        file.Name = strings.TrimPrefix(staged.key, result.Bucket + "/")
+        result.Files = append(result.Files, file)
+    }
+    sendResult(context, result)
+}
@@ -0,0 +1,60 @@
+package fileController
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "store/config"
+    "store/server/storage-backend"
+)
+
+func TestExtractFormat(t *testing.T) {
+    formats := map[string]string{
+        "PK\x03\x04data": FormatZip,
+        "\x1f\x8b\x08\x00": FormatTgz,
+        "README.md\x00\x00": FormatTar,
+    }
+    for data, expected := range formats {
+        if format, _ := extractFormat(strings.NewReader(data), ""); format != expected {
+            t.Errorf("wrong format %s of %q", format, data)
+        }
+    }
+    if _, err := extractFormat(strings.NewReader(""), "rar"); err == nil {
+        t.Error("wrong format is accepted")
+    }
+}
+
+func TestExtractStage(t *testing.T) {
+    storeDir, err := ioutil.TempDir("", "store-")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(storeDir)
+
+    controller := &Controller{ config: &config.Config{ StoreDir: storeDir }, store: storageBackend.NewMemory() }
+    extract := &extraction{
+        controller: controller,
+        bucketName: "site",
+        targetPath: filepath.Join(storeDir, "site/docs"),
+        stageDir: storageBackend.Join(config.SystemDirName, "tmp/extract"),
+        staged: make(map[string]*stagedFile),
+    }
+    staged, err := extract.stage("./css/main.css")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if staged.name != "css/main.css" || staged.filePath != filepath.Join(storeDir, "site/docs/css/main.css") {
+        t.Errorf("wrong staged file %s %s", staged.name, staged.filePath)
+    }
+    if staged.key != "site/docs/css/main.css" || staged.stageKey != ".m2store/tmp/extract/data/css/main.css" {
+        t.Errorf("wrong staged keys %s %s", staged.key, staged.stageKey)
+    }
+    for _, name := range []string{ "../index.html", "css/../../../etc/passwd", "/etc/passwd", ".", "../../.m2store/x" } {
+        if _, err := extract.stage(name); err == nil {
+            t.Errorf("entry %s outside of the target is accepted", name)
+        }
+    }
+}
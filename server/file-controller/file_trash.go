@@ -0,0 +1,86 @@
+package fileController
+
+import (
+    "net/http"
+    "path/filepath"
+
+    "github.com/gin-gonic/gin"
+
+    "store/server/acl-model"
+    "store/server/storage-backend"
+)
+
+type trashForm struct {
+    BucketName  string  `form:"bucket"   json:"bucket"`
+    Id          string  `form:"id"       json:"id"`
+}
+
+/* Bind trash form, validate the bucket and check permission */
+func (this *Controller) bindTrash(context *gin.Context, perm string) (trashForm, string, bool) {
+    var form trashForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return form, "", false
+    }
+    directoryPath, err := this.ValidateBucketPath(form.BucketName)
+    if err != nil {
+        sendError(context, err)
+        return form, "", false
+    }
+    if err := this.allow(context, filepath.Join(directoryPath, "*"), perm); err != nil {
+        sendForbidden(context, err)
+        return form, "", false
+    }
+    return form, directoryPath, true
+}
+
+/* List deleted files of the bucket, newest first */
+func (this *Controller) Trash(context *gin.Context) {
+    _, directoryPath, ok := this.bindTrash(context, aclModel.PermList)
+    if !ok {
+        return
+    }
+    items, err := this.trash.List(this.storePath(directoryPath))
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, items)
+}
+
+/* Move deleted file back to the bucket */
+func (this *Controller) TrashRestore(context *gin.Context) {
+    form, directoryPath, ok := this.bindTrash(context, aclModel.PermWrite)
+    if !ok {
+        return
+    }
+    /* Restored data counts to quotas as uploaded data */
+    bucket := this.storePath(directoryPath)
+    if item, err := this.trash.Find(bucket, form.Id); err == nil {
+        path := storageBackend.Join(item.Bucket, item.Name)
+        if _, err := this.quotas.Check(context.GetString("username"), path, item.Size); err != nil {
+            sendStatus(context, quotaStatus(err, http.StatusBadRequest), err)
+            return
+        }
+    }
+    item, err := this.trash.Restore(bucket, form.Id)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, item)
+}
+
+/* Remove deleted files of the bucket permanently */
+func (this *Controller) TrashEmpty(context *gin.Context) {
+    _, directoryPath, ok := this.bindTrash(context, aclModel.PermDelete)
+    if !ok {
+        return
+    }
+    count, err := this.trash.Empty(this.storePath(directoryPath))
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, count)
+}
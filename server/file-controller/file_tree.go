@@ -0,0 +1,462 @@
+package fileController
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+
+    "store/config"
+    "store/server/acl-model"
+    "store/server/object-model"
+)
+
+const DefaultDelimiter string = "/"
+
+/* Sort keys of the listing */
+const (
+    SortName    string = "name"
+    SortSize    string = "size"
+    SortTime    string = "mtime"
+)
+
+const (
+    OrderAsc    string = "asc"
+    OrderDesc   string = "desc"
+)
+
+/* Listing of the bucket tree. Keys are paths relative to the bucket, files
+ * with the key prefix are listed, the pattern matches the file base name.
+ * Keys are grouped to common prefixes up to the delimiter after the prefix,
+ * recursive listing has no delimiter. Files are sorted by name, size or
+ * modification time, the token continues the listing after the last entry */
+type treeQuery struct {
+    Bucket      string
+    Pattern     string
+    Tag         string
+    Prefix      string
+    Delimiter   string
+    Recursive   bool
+    Versions    bool
+    Sort        string
+    Order       string
+    MinSize     int64
+    MaxSize     int64
+    Since       string
+    Token       string
+    since       time.Time
+    cursor      *treeCursor
+}
+
+/* Entry of the bucket tree, directory key ends with slash */
+type treeEntry struct {
+    key         string
+    filePath    string
+    fileInfo    os.FileInfo
+}
+
+/* Position of the last listed entry in the sort order */
+type treeCursor struct {
+    Sort        string  `json:"s"`
+    Desc        bool    `json:"d,omitempty"`
+    Key         string  `json:"k"`
+    Value       int64   `json:"v,omitempty"`
+}
+
+/* Cursor of the entry in the sort order */
+func newCursor(entry treeEntry, sortBy string, desc bool) *treeCursor {
+    return &treeCursor{ Sort: sortBy, Desc: desc, Key: entry.key, Value: sortValue(entry, sortBy) }
+}
+
+func parseCursor(token string) (*treeCursor, error) {
+    cursor := &treeCursor{}
+    data, err := base64.RawURLEncoding.DecodeString(token)
+    if err == nil {
+        err = json.Unmarshal(data, cursor)
+    }
+    if err != nil {
+        return nil, errors.New("wrong token")
+    }
+    return cursor, nil
+}
+
+func (this *treeCursor) Token() string {
+    data, _ := json.Marshal(this)
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+/* Entry of the value and key is after the cursor in the sort order */
+func (this *treeCursor) before(value int64, key string) bool {
+    return sortBefore(this.Value, this.Key, value, key, this.Desc)
+}
+
+/* First entry is before the second in the sort order, key is the secondary order */
+func sortBefore(value1 int64, key1 string, value2 int64, key2 string, desc bool) bool {
+    if value1 != value2 {
+        return (value1 < value2) != desc
+    }
+    return key1 != key2 && (key1 < key2) != desc
+}
+
+/* Value of the entry for sort */
+func sortValue(entry treeEntry, sortBy string) int64 {
+    switch sortBy {
+        case SortSize:
+            return entry.fileInfo.Size()
+        case SortTime:
+            return entry.fileInfo.ModTime().UnixNano()
+    }
+    return 0
+}
+
+/* Validate the query and check list permission of the bucket,
+ * error response is sent if the query is not valid */
+func (this *Controller) bindTree(context *gin.Context, query *treeQuery) (string, bool) {
+    directoryPath, err := this.ValidateBucketPath(query.Bucket)
+    if err != nil {
+        sendError(context, err)
+        return "", false
+    }
+    if _, err := this.store.Stat(this.storePath(directoryPath)); err != nil {
+        sendError(context, err)
+        return "", false
+    }
+    if len(query.Pattern) == 0 {
+        query.Pattern = "*"
+    }
+    if _, err := filepath.Match(query.Pattern, ""); err != nil || strings.Contains(query.Pattern, "/") {
+        sendError(context, errors.New("wrong pattern " + query.Pattern))
+        return "", false
+    }
+    if strings.HasPrefix(query.Prefix, "/") || strings.Contains("/" + query.Prefix + "/", "/../") {
+        sendError(context, errors.New("wrong prefix " + query.Prefix))
+        return "", false
+    }
+    if query.Recursive {
+        query.Delimiter = ""
+    } else if len(query.Delimiter) == 0 {
+        query.Delimiter = DefaultDelimiter
+    }
+
+    /* Sort, filters and continuation token */
+    if len(query.Sort) == 0 {
+        query.Sort = SortName
+    }
+    if query.Sort != SortName && query.Sort != SortSize && query.Sort != SortTime {
+        sendError(context, errors.New("wrong sort " + query.Sort))
+        return "", false
+    }
+    if len(query.Order) == 0 {
+        query.Order = OrderAsc
+    }
+    if query.Order != OrderAsc && query.Order != OrderDesc {
+        sendError(context, errors.New("wrong order " + query.Order))
+        return "", false
+    }
+    if query.MinSize < 0 || query.MaxSize < 0 {
+        sendError(context, errors.New("wrong size range"))
+        return "", false
+    }
+    if len(query.Since) > 0 {
+        if query.since, err = time.Parse(time.RFC3339, query.Since); err != nil {
+            sendError(context, errors.New("wrong time " + query.Since))
+            return "", false
+        }
+    }
+    if len(query.Token) > 0 {
+        if query.cursor, err = parseCursor(query.Token); err != nil {
+            sendError(context, err)
+            return "", false
+        }
+        if query.cursor.Sort != query.Sort || query.cursor.Desc != (query.Order == OrderDesc) {
+            sendError(context, errors.New("token does not match the sort order"))
+            return "", false
+        }
+    }
+
+    if err := this.allow(context, filepath.Join(directoryPath, "*"), aclModel.PermList); err != nil {
+        sendForbidden(context, err)
+        return "", false
+    }
+    return directoryPath, true
+}
+
+/* Scan of the bucket tree in key order. Directories of the delimiter are
+ * visited as entries, other directories are nested into the scan */
+type treeScan struct {
+    prefix      string
+    after       string
+    nested      bool
+    visit       func(entry treeEntry) bool
+}
+
+/* Name of the directory may be or contain a key after the cursor with the prefix */
+func (this *treeScan) want(key string) bool {
+    if !strings.HasPrefix(key, this.prefix) && !strings.HasPrefix(this.prefix, key + "/") {
+        return false
+    }
+    return key + "/" >= this.after || strings.HasPrefix(this.after, key + "/")
+}
+
+/* Scan files and nested buckets with keys starting with the prefix after the
+ * cursor key, nested buckets without list permission are skipped. Names are
+ * listed without stat and entries are stat'ed in name order, so entries before
+ * the cursor are not stat'ed and the scan stops when the visitor returns false */
+func (this *Controller) scanTree(context *gin.Context, directoryPath string, scan treeScan) error {
+
+    /* Start from the deepest directory covered by prefix */
+    root, rootKey := directoryPath, ""
+    if index := strings.LastIndex(scan.prefix, "/"); index >= 0 {
+        root, rootKey = filepath.Join(directoryPath, scan.prefix[:index]), scan.prefix[:index + 1]
+        if fileInfo, err := this.store.Stat(this.storePath(root)); err != nil || !fileInfo.IsDir() {
+            return nil
+        }
+        if config.IsSystemName(this.storePath(root)) {
+            return nil
+        }
+        if err := this.allow(context, filepath.Join(root, "*"), aclModel.PermList); err != nil {
+            return nil
+        }
+    }
+    _, err := this.scanDir(context, root, rootKey, &scan)
+    return err
+}
+
+func (this *Controller) scanDir(context *gin.Context, dirPath, dirKey string, scan *treeScan) (bool, error) {
+    names, err := this.store.List(this.storePath(dirPath), "")
+    if err != nil {
+        return false, err
+    }
+
+    /* Key of directory ends with slash, so the entry is in key order
+     * when following names are not before the key */
+    pending := []treeEntry{}
+    flush := func(bound string) (bool, error) {
+        for len(pending) > 0 && (len(bound) == 0 || pending[0].key < bound) {
+            entry := pending[0]
+            pending = pending[1:]
+            if next, err := this.scanEntry(context, entry, scan); !next || err != nil {
+                return next, err
+            }
+        }
+        return true, nil
+    }
+    for _, name := range names {
+        key := dirKey + name
+        if next, err := flush(key); !next || err != nil {
+            return next, err
+        }
+        if !scan.want(key) {
+            continue
+        }
+        filePath := filepath.Join(dirPath, name)
+        fileInfo, err := this.store.Stat(this.storePath(filePath))
+        if err != nil {
+            continue
+        }
+        if fileInfo.IsDir() {
+            key += "/"
+        } else if !fileInfo.Mode().IsRegular() {
+            continue
+        }
+        index := sort.Search(len(pending), func(i int) bool { return pending[i].key > key })
+        pending = append(pending, treeEntry{})
+        copy(pending[index + 1:], pending[index:])
+        pending[index] = treeEntry{ key: key, filePath: filePath, fileInfo: fileInfo }
+    }
+    return flush("")
+}
+
+func (this *Controller) scanEntry(context *gin.Context, entry treeEntry, scan *treeScan) (bool, error) {
+    if !entry.fileInfo.IsDir() {
+        if entry.key > scan.after && strings.HasPrefix(entry.key, scan.prefix) {
+            return scan.visit(entry), nil
+        }
+        return true, nil
+    }
+    if config.IsSystemName(this.storePath(entry.filePath)) {
+        return true, nil
+    }
+    if err := this.allow(context, filepath.Join(entry.filePath, "*"), aclModel.PermList); err != nil {
+        return true, nil
+    }
+    if !scan.nested && strings.HasPrefix(entry.key, scan.prefix) {
+        if entry.key > scan.after {
+            return scan.visit(entry), nil
+        }
+        return true, nil
+    }
+    return this.scanDir(context, entry.filePath, entry.key, scan)
+}
+
+/* Grouping of entries in key order to files and common prefixes of the delimiter.
+ * Directories make common prefixes with slash delimiter, so empty nested buckets are shown */
+type treeGroup struct {
+    prefix      string
+    delimiter   string
+    last        string
+    files       []treeEntry
+    prefixes    []string
+}
+
+/* Common prefix of the key, empty if the key is not grouped */
+func (this *treeGroup) common(key string) string {
+    if len(this.delimiter) == 0 {
+        return ""
+    }
+    rest := strings.TrimPrefix(key, this.prefix)
+    if index := strings.Index(rest, this.delimiter); index >= 0 {
+        return this.prefix + rest[:index + len(this.delimiter)]
+    }
+    return ""
+}
+
+/* Add the entry as file or common prefix, return false if the entry
+ * is not listed. Entries of a common prefix follow each other in key order */
+func (this *treeGroup) add(entry treeEntry) bool {
+    isDir := strings.HasSuffix(entry.key, "/")
+    if common := this.common(entry.key); len(common) > 0 {
+        if (isDir && this.delimiter != DefaultDelimiter) || common <= this.last {
+            return false
+        }
+        this.prefixes = append(this.prefixes, common)
+        this.last = common
+        return true
+    }
+    if isDir {
+        return false
+    }
+    this.files = append(this.files, entry)
+    this.last = entry.key
+    return true
+}
+
+/* Metadata and attributes of listed files, read once per directory
+ * for whole listings and per file for pages */
+type treeMeta struct {
+    controller  *Controller
+    perFile     bool
+    objects     map[string]map[string]objectModel.Object
+    attrs       map[string]map[string]objectModel.Attributes
+}
+
+func (this *treeMeta) load(entry treeEntry) (map[string]objectModel.Object, objectModel.Attributes) {
+    name := entry.fileInfo.Name()
+    if this.perFile {
+        path := this.controller.storePath(entry.filePath)
+        object, _ := this.controller.objects.Find(path)
+        attrs, _ := this.controller.objects.Attributes(path)
+        return map[string]objectModel.Object{ name: object }, attrs
+    }
+    bucket := this.controller.storePath(filepath.Dir(entry.filePath))
+    if _, exists := this.objects[bucket]; !exists {
+        this.objects[bucket], _ = this.controller.objects.List(bucket)
+        this.attrs[bucket], _ = this.controller.objects.ListAttributes(bucket)
+    }
+    return this.objects[bucket], this.attrs[bucket][name]
+}
+
+/* File of the entry matches pattern, size, time and tag filters of the query */
+func (this *treeMeta) match(entry treeEntry, query treeQuery) bool {
+    fileInfo := entry.fileInfo
+    if match, _ := filepath.Match(query.Pattern, fileInfo.Name()); !match {
+        return false
+    }
+    if fileInfo.Size() < query.MinSize || (query.MaxSize > 0 && fileInfo.Size() > query.MaxSize) {
+        return false
+    }
+    if fileInfo.ModTime().Before(query.since) {
+        return false
+    }
+    if len(query.Tag) > 0 {
+        _, attrs := this.load(entry)
+        return attrs.Match(query.Tag)
+    }
+    return true
+}
+
+/* List files and common prefixes of the validated query, up to limit entries
+ * if limit is positive. Listing by name ascending reads the tree up to the
+ * page only, other orders read the whole tree. Common prefixes are listed in
+ * key order and counted in the page by name, by other orders they are listed
+ * on the first page. Token of the next page is empty after the last page */
+func (this *Controller) listTree(context *gin.Context, directoryPath string, query treeQuery, limit int) ([]File, []string, string, error) {
+    meta := &treeMeta{
+        controller: this,
+        perFile: limit > 0 && query.Sort == SortName && query.Order == OrderAsc,
+        objects: make(map[string]map[string]objectModel.Object),
+        attrs: make(map[string]map[string]objectModel.Attributes),
+    }
+    group := &treeGroup{ prefix: query.Prefix, delimiter: query.Delimiter, files: []treeEntry{}, prefixes: []string{} }
+    desc := query.Order == OrderDesc
+    stream := query.Sort == SortName && !desc
+    scan := treeScan{
+        prefix: query.Prefix,
+        nested: query.Delimiter != DefaultDelimiter,
+    }
+    if stream && query.cursor != nil {
+        scan.after = query.cursor.Key
+        group.last = query.cursor.Key
+    }
+
+    /* Stop after one entry more than the page to know there is a next page */
+    more := false
+    scan.visit = func(entry treeEntry) bool {
+        if !entry.fileInfo.IsDir() && !meta.match(entry, query) {
+            return true
+        }
+        if stream && limit > 0 && len(group.files) + len(group.prefixes) == limit {
+            if len(group.common(entry.key)) == 0 || group.common(entry.key) > group.last {
+                more = true
+                return false
+            }
+        }
+        group.add(entry)
+        return true
+    }
+    if err := this.scanTree(context, directoryPath, scan); err != nil {
+        return nil, nil, "", err
+    }
+
+    entries, prefixes := group.files, group.prefixes
+    next := ""
+    if stream {
+        if more {
+            next = (&treeCursor{ Sort: SortName, Key: group.last }).Token()
+        }
+    } else {
+        sort.Slice(entries, func(i, j int) bool {
+            return sortBefore(sortValue(entries[i], query.Sort), entries[i].key, sortValue(entries[j], query.Sort), entries[j].key, desc)
+        })
+        if query.cursor != nil {
+            index := sort.Search(len(entries), func(i int) bool {
+                return query.cursor.before(sortValue(entries[i], query.Sort), entries[i].key)
+            })
+            entries = entries[index:]
+            prefixes = []string{}
+        }
+        if limit > 0 && len(entries) > limit {
+            entries = entries[:limit]
+            next = newCursor(entries[limit - 1], query.Sort, desc).Token()
+        }
+    }
+
+    files := []File{}
+    for _, entry := range entries {
+        objects, attrs := meta.load(entry)
+        file := newFile(entry.fileInfo, objects, attrs)
+        file.Name = entry.key
+        if query.Versions {
+            versions, _ := this.versions.List(this.storePath(entry.filePath))
+            file.Versions = &versions
+        }
+        files = append(files, file)
+    }
+    return files, prefixes, next, nil
+}
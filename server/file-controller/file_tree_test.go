@@ -0,0 +1,86 @@
+package fileController
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestGroupTree(t *testing.T) {
+    var entries []treeEntry
+    for _, key := range []string{ "a.txt", "docs/", "docs/api/", "docs/api/v1.html", "docs/index.html", "empty/", "z.txt" } {
+        entries = append(entries, treeEntry{ key: key })
+    }
+    keys := func(files []treeEntry) []string {
+        list := []string{}
+        for _, file := range files {
+            list = append(list, file.key)
+        }
+        return list
+    }
+    groupTree := func(entries []treeEntry, prefix, delimiter string) ([]treeEntry, []string) {
+        group := &treeGroup{ prefix: prefix, delimiter: delimiter }
+        for _, entry := range entries {
+            group.add(entry)
+        }
+        return group.files, group.prefixes
+    }
+
+    files, prefixes := groupTree(entries, "", "/")
+    if !reflect.DeepEqual(keys(files), []string{ "a.txt", "z.txt" }) {
+        t.Errorf("wrong top files %v", keys(files))
+    }
+    if !reflect.DeepEqual(prefixes, []string{ "docs/", "empty/" }) {
+        t.Errorf("wrong top prefixes %v", prefixes)
+    }
+
+    files, prefixes = groupTree(entries[1:5], "docs/", "/")
+    if !reflect.DeepEqual(keys(files), []string{ "docs/index.html" }) || !reflect.DeepEqual(prefixes, []string{ "docs/api/" }) {
+        t.Errorf("wrong nested listing %v %v", keys(files), prefixes)
+    }
+
+    files, prefixes = groupTree(entries, "", "")
+    if len(files) != 4 || len(prefixes) != 0 {
+        t.Errorf("wrong recursive listing %v %v", keys(files), prefixes)
+    }
+
+    files, prefixes = groupTree(entries, "", ".")
+    if len(files) != 0 || !reflect.DeepEqual(prefixes, []string{ "a.", "docs/api/v1.", "docs/index.", "z." }) {
+        t.Errorf("wrong listing of other delimiter %v %v", keys(files), prefixes)
+    }
+}
+
+func TestTreeCursor(t *testing.T) {
+    cursor := &treeCursor{ Sort: SortSize, Desc: true, Key: "docs/b.txt", Value: 100 }
+    parsed, err := parseCursor(cursor.Token())
+    if err != nil || !reflect.DeepEqual(parsed, cursor) {
+        t.Fatalf("wrong parsed token %v %v", parsed, err)
+    }
+    if _, err := parseCursor("not a token"); err == nil {
+        t.Error("wrong token is accepted")
+    }
+
+    /* Descending size, then descending key */
+    if !cursor.before(99, "z.txt") || !cursor.before(100, "docs/a.txt") {
+        t.Error("following entry is not after the cursor")
+    }
+    if cursor.before(101, "a.txt") || cursor.before(100, "docs/c.txt") || cursor.before(100, "docs/b.txt") {
+        t.Error("preceding entry is after the cursor")
+    }
+}
+
+func TestTreeScanWant(t *testing.T) {
+    scan := treeScan{ prefix: "docs/", after: "docs/api/v1.html" }
+    for key, expected := range map[string]bool{
+        "docs": true,
+        "doc": false,
+        "index.html": false,
+        "docs/api": true,
+        "docs/api.txt": false,
+        "docs/index.html": true,
+        "docs/a": false,
+    } {
+        if scan.want(key) != expected {
+            t.Errorf("wrong scan of %s", key)
+        }
+    }
+}
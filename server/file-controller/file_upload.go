@@ -0,0 +1,565 @@
+package fileController
+
+/* Resumable uploads, tus.io 1.0 core protocol with creation,
+ * creation-with-upload, expiration and termination extensions.
+ * Upload data is written in the internal directory of the store
+ * and moved into the bucket when the last byte is received */
+
+import (
+    "bytes"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+
+    "store/config"
+    "store/server/acl-model"
+    "store/server/object-model"
+    "store/server/storage-backend"
+    "store/tools"
+)
+
+const (
+    tusVersion          string = "1.0.0"
+    tusExtensions       string = "creation,creation-with-upload,expiration,termination"
+    tusContentType      string = "application/offset+octet-stream"
+    uploadDirName       string = "uploads"
+    uploadInfoExt       string = ".json"
+    /* Status of checksum extension */
+    statusChecksumMismatch  int = 460
+    UploadURI           string = "/api/v1/upload/"
+)
+
+var (
+    errUploadOffset     = errors.New("upload offset mismatch")
+    errUploadLength     = errors.New("upload data exceeds upload length")
+)
+
+/* State of the upload, stored in json file beside the data */
+type upload struct {
+    Id          string              `json:"id"`
+    Username    string              `json:"username"`
+    BucketName  string              `json:"bucket"`
+    FileName    string              `json:"filename"`
+    Length      int64               `json:"length"`
+    Created     int64               `json:"created"`
+    Expires     int64               `json:"expires"`
+    Metadata    string              `json:"metadata,omitempty"`
+    NoReplace   bool                `json:"noreplace"`
+    SHA256      string              `json:"sha256,omitempty"`
+    MD5         string              `json:"md5,omitempty"`
+    Attributes  objectModel.Attributes  `json:"attributes"`
+    ContentType string              `json:"contenttype,omitempty"`
+}
+
+/* Parse Upload-Metadata header: comma separated pairs of key and base64 value */
+func parseUploadMetadata(header string) (map[string]string, error) {
+    metadata := make(map[string]string)
+    for _, pair := range strings.Split(header, ",") {
+        fields := strings.Fields(pair)
+        if len(fields) == 0 {
+            continue
+        }
+        if len(fields) > 2 {
+            return metadata, errors.New("wrong upload metadata")
+        }
+        var value []byte
+        if len(fields) == 2 {
+            var err error
+            value, err = base64.StdEncoding.DecodeString(fields[1])
+            if err != nil {
+                return metadata, errors.New("wrong upload metadata value of " + fields[0])
+            }
+        }
+        metadata[fields[0]] = string(value)
+    }
+    return metadata, nil
+}
+
+func newUploadId() (string, error) {
+    arr := make([]byte, 16)
+    if _, err := rand.Read(arr); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(arr), nil
+}
+
+func validUploadId(id string) bool {
+    if len(id) != 32 {
+        return false
+    }
+    _, err := hex.DecodeString(id)
+    return err == nil
+}
+
+func (this *Controller) uploadDir() (string, error) {
+    uploadDir := storageBackend.Join(config.SystemDirName, uploadDirName)
+    return uploadDir, this.store.MakeDir(uploadDir)
+}
+
+func (this *Controller) uploadExpire() time.Duration {
+    hours := this.config.UploadExpire
+    if hours <= 0 {
+        hours = 24
+    }
+    return time.Duration(hours) * time.Hour
+}
+
+func (this *Controller) readUpload(id string) (upload, string, error) {
+    var up upload
+    if !validUploadId(id) {
+        return up, "", errors.New("wrong upload id")
+    }
+    uploadDir, err := this.uploadDir()
+    if err != nil {
+        return up, "", err
+    }
+    dataKey := storageBackend.Join(uploadDir, id)
+    data, err := storageBackend.ReadFile(this.store, dataKey + uploadInfoExt)
+    if err != nil {
+        return up, dataKey, err
+    }
+    err = json.Unmarshal(data, &up)
+    return up, dataKey, err
+}
+
+func (this *Controller) writeUpload(up upload) error {
+    uploadDir, err := this.uploadDir()
+    if err != nil {
+        return err
+    }
+    data, err := json.Marshal(up)
+    if err != nil {
+        return err
+    }
+    _, err = this.store.Put(storageBackend.Join(uploadDir, up.Id + uploadInfoExt), bytes.NewReader(data), false)
+    return err
+}
+
+func (this *Controller) removeUpload(id string) {
+    uploadDir, _ := this.uploadDir()
+    this.store.Delete(storageBackend.Join(uploadDir, id))
+    this.store.Delete(storageBackend.Join(uploadDir, id + uploadInfoExt))
+}
+
+/* Mark upload as busy, only one request can write the upload at time */
+func (this *Controller) lockUpload(id string) bool {
+    this.uploadLock.Lock()
+    defer this.uploadLock.Unlock()
+    if this.uploadBusy[id] {
+        return false
+    }
+    this.uploadBusy[id] = true
+    return true
+}
+
+func (this *Controller) unlockUpload(id string) {
+    this.uploadLock.Lock()
+    defer this.uploadLock.Unlock()
+    delete(this.uploadBusy, id)
+}
+
+/* Find upload of the authenticated user by request path */
+func (this *Controller) findUpload(context *gin.Context) (upload, string, int, error) {
+    up, dataKey, err := this.readUpload(context.Param("id"))
+    if err != nil || up.Username != context.GetString("username") {
+        return up, dataKey, http.StatusNotFound, errors.New("upload not found")
+    }
+    if time.Now().Unix() > up.Expires {
+        this.removeUpload(up.Id)
+        return up, dataKey, http.StatusGone, errors.New("upload is expired")
+    }
+    return up, dataKey, http.StatusOK, nil
+}
+
+func setTusHeaders(context *gin.Context) {
+    context.Header("Tus-Resumable", tusVersion)
+    context.Header("Cache-Control", "no-store")
+}
+
+func checkTusVersion(context *gin.Context) bool {
+    if context.GetHeader("Tus-Resumable") != tusVersion {
+        context.Header("Tus-Version", tusVersion)
+        sendStatus(context, http.StatusPreconditionFailed, errors.New("unsupported tus version"))
+        return false
+    }
+    return true
+}
+
+/* Append request body to the upload data at the offset */
+func (this *Controller) writeUploadData(context *gin.Context, up upload, dataKey string, offset int64) (int64, error) {
+    /* Read one byte over the rest to detect too long body,
+     * the whole chunk is dropped in this case */
+    reader := io.LimitReader(context.Request.Body, up.Length - offset + 1)
+    size, err := this.store.Append(dataKey, offset, reader)
+    if err == storageBackend.ErrOffset {
+        return size, errUploadOffset
+    }
+    if size > up.Length {
+        if err := this.store.Truncate(dataKey, offset); err != nil {
+            return size, err
+        }
+        return offset, errUploadLength
+    }
+    return size, err
+}
+
+/* Move completed upload data into the bucket */
+func (this *Controller) commitUpload(context *gin.Context, up upload, dataKey string) (int, error) {
+    if _, err := this.ValidateBucketPath(up.BucketName); err != nil {
+        return http.StatusBadRequest, err
+    }
+    filePath, err := this.ValidateFilePath(up.BucketName, up.FileName)
+    if err != nil {
+        return http.StatusBadRequest, err
+    }
+    if err := this.allow(context, filePath, aclModel.PermWrite); err != nil {
+        return http.StatusForbidden, err
+    }
+    /* Usage could grow while the upload was in progress, complete
+     * upload is kept and committed by empty patch after cleanup */
+    if _, err := this.quotas.Check(up.Username, this.storePath(filePath), up.Length); err != nil {
+        return quotaStatus(err, http.StatusInternalServerError), err
+    }
+
+    /* Verify expected checksums, damaged upload can not be continued */
+    hash, err := this.hashFile(dataKey)
+    if err != nil {
+        return http.StatusInternalServerError, err
+    }
+    if err := hash.Verify(up.SHA256, up.MD5); err != nil {
+        this.removeUpload(up.Id)
+        return statusChecksumMismatch, err
+    }
+
+    key := this.storePath(filePath)
+    noReplace := up.NoReplace || this.config.WriteOnce
+    /* Keep replaced data as version */
+    commit := func() error {
+        return this.store.Move(dataKey, key, noReplace)
+    }
+    if noReplace {
+        err = commit()
+    } else {
+        err = this.versions.Replace(commit, key)
+    }
+    if err == tools.ErrFileExists {
+        this.removeUpload(up.Id)
+        return http.StatusPreconditionFailed, err
+    }
+    if err != nil {
+        return http.StatusInternalServerError, err
+    }
+    this.removeUpload(up.Id)
+    fileInfo, err := this.store.Stat(key)
+    if err != nil {
+        return http.StatusInternalServerError, err
+    }
+    if _, err := this.objects.SaveFile(key, fileInfo, hash, up.ContentType, up.Username); err != nil {
+        log.Println(err)
+    }
+    if err := this.objects.SetAttributes(key, up.Attributes); err != nil {
+        log.Println(err)
+    }
+    log.Printf("upload %s is stored to %s\n", up.Id, filePath)
+    return http.StatusOK, nil
+}
+
+/* Send status of the write, commit upload when all data is received */
+func (this *Controller) finishWrite(context *gin.Context, up upload, dataKey string,
+                                        offset int64, status int, err error) {
+    context.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+    switch err {
+        case nil:
+        case errUploadOffset:
+            sendStatus(context, http.StatusConflict, err)
+            return
+        case errUploadLength:
+            sendStatus(context, http.StatusRequestEntityTooLarge, err)
+            return
+        default:
+            sendStatus(context, http.StatusInternalServerError, err)
+            return
+    }
+
+    if offset == up.Length {
+        if commitStatus, err := this.commitUpload(context, up, dataKey); err != nil {
+            sendStatus(context, commitStatus, err)
+            return
+        }
+    } else {
+        up.Expires = time.Now().Add(this.uploadExpire()).Unix()
+        if err := this.writeUpload(up); err != nil {
+            log.Println(err)
+        }
+    }
+    context.Header("Upload-Expires", time.Unix(up.Expires, 0).UTC().Format(http.TimeFormat))
+    context.Status(status)
+}
+
+func (this *Controller) UploadOptions(context *gin.Context) {
+    setTusHeaders(context)
+    context.Header("Tus-Version", tusVersion)
+    context.Header("Tus-Extension", tusExtensions)
+    context.Status(http.StatusNoContent)
+}
+
+func (this *Controller) UploadCreate(context *gin.Context) {
+    setTusHeaders(context)
+    if !checkTusVersion(context) {
+        return
+    }
+
+    length, err := strconv.ParseInt(context.GetHeader("Upload-Length"), 10, 64)
+    if err != nil || length < 0 {
+        sendStatus(context, http.StatusBadRequest, errors.New("wrong upload length"))
+        return
+    }
+    metadata, err := parseUploadMetadata(context.GetHeader("Upload-Metadata"))
+    if err != nil {
+        sendStatus(context, http.StatusBadRequest, err)
+        return
+    }
+
+    /* Validate file name */
+    bucketName := metadata["bucket"]
+    fileName := metadata["filename"]
+    if len(fileName) == 0 || strings.HasSuffix(fileName, "/") {
+        sendStatus(context, http.StatusBadRequest, errors.New("wrong file name"))
+        return
+    }
+    if _, err := this.ValidateBucketPath(bucketName); err != nil {
+        sendStatus(context, http.StatusBadRequest, err)
+        return
+    }
+    filePath, err := this.ValidateFilePath(bucketName, fileName)
+    if err != nil {
+        sendStatus(context, http.StatusBadRequest, err)
+        return
+    }
+
+    /* Check permission */
+    if err := this.allow(context, filePath, aclModel.PermWrite); err != nil {
+        sendForbidden(context, err)
+        return
+    }
+    if _, err := this.quotas.Check(context.GetString("username"), this.storePath(filePath), length); err != nil {
+        sendStatus(context, quotaStatus(err, http.StatusInternalServerError), err)
+        return
+    }
+    noReplace := this.noReplace(context)
+    if noReplace && storageBackend.FileExists(this.store, this.storePath(filePath)) {
+        sendStatus(context, http.StatusPreconditionFailed, tools.ErrFileExists)
+        return
+    }
+
+    /* Expected checksums of the whole file from metadata or Digest header */
+    sha256Sum, md5Sum, err := objectModel.ParseDigest(context.GetHeader("Digest"))
+    if err != nil {
+        sendStatus(context, http.StatusBadRequest, err)
+        return
+    }
+    if value, exists := metadata["sha256"]; exists {
+        sha256Sum = value
+    }
+    if value, exists := metadata["md5"]; exists {
+        md5Sum = value
+    }
+
+    /* User metadata from x-meta-* keys and tags from tags key */
+    attrs := objectModel.Attributes{ Meta: make(map[string]string) }
+    for key, value := range metadata {
+        if len(key) > len(metaFormPrefix) && strings.EqualFold(key[:len(metaFormPrefix)], metaFormPrefix) {
+            attrs.Meta[strings.ToLower(key[len(metaFormPrefix):])] = value
+        }
+    }
+    attrs.Tags, err = objectModel.ParseTags(metadata["tags"])
+    if err == nil {
+        err = attrs.Validate()
+    }
+    if err != nil {
+        sendStatus(context, http.StatusBadRequest, err)
+        return
+    }
+
+    /* Create upload */
+    id, err := newUploadId()
+    if err != nil {
+        sendStatus(context, http.StatusInternalServerError, err)
+        return
+    }
+    now := time.Now()
+    up := upload{
+        Id: id,
+        Username: context.GetString("username"),
+        BucketName: bucketName,
+        FileName: fileName,
+        Length: length,
+        Created: now.Unix(),
+        Expires: now.Add(this.uploadExpire()).Unix(),
+        Metadata: context.GetHeader("Upload-Metadata"),
+        NoReplace: noReplace,
+        SHA256: sha256Sum,
+        MD5: md5Sum,
+        Attributes: attrs,
+        ContentType: metadata["filetype"],
+    }
+
+    uploadDir, err := this.uploadDir()
+    if err != nil {
+        sendStatus(context, http.StatusInternalServerError, err)
+        return
+    }
+    dataKey := storageBackend.Join(uploadDir, id)
+    if _, err := this.store.Put(dataKey, bytes.NewReader(nil), true); err != nil {
+        sendStatus(context, http.StatusInternalServerError, err)
+        return
+    }
+
+    if err := this.writeUpload(up); err != nil {
+        this.removeUpload(id)
+        sendStatus(context, http.StatusInternalServerError, err)
+        return
+    }
+    log.Printf("upload %s of %s/%s created by %s, length %d\n", id, bucketName, fileName, up.Username, length)
+
+    this.lockUpload(id)
+    defer this.unlockUpload(id)
+
+    context.Header("Location", UploadURI + id)
+
+    /* Creation with upload */
+    var offset int64
+    if context.ContentType() == tusContentType {
+        offset, err = this.writeUploadData(context, up, dataKey, 0)
+    }
+    this.finishWrite(context, up, dataKey, offset, http.StatusCreated, err)
+}
+
+func (this *Controller) UploadHead(context *gin.Context) {
+    setTusHeaders(context)
+    if !checkTusVersion(context) {
+        return
+    }
+    up, dataKey, status, err := this.findUpload(context)
+    if err != nil {
+        sendStatus(context, status, err)
+        return
+    }
+    fileInfo, err := this.store.Stat(dataKey)
+    if err != nil {
+        sendStatus(context, http.StatusNotFound, err)
+        return
+    }
+    context.Header("Upload-Offset", strconv.FormatInt(fileInfo.Size(), 10))
+    context.Header("Upload-Length", strconv.FormatInt(up.Length, 10))
+    context.Header("Upload-Expires", time.Unix(up.Expires, 0).UTC().Format(http.TimeFormat))
+    if len(up.Metadata) > 0 {
+        context.Header("Upload-Metadata", up.Metadata)
+    }
+    context.Status(http.StatusOK)
+}
+
+func (this *Controller) UploadPatch(context *gin.Context) {
+    setTusHeaders(context)
+    if !checkTusVersion(context) {
+        return
+    }
+    if context.ContentType() != tusContentType {
+        sendStatus(context, http.StatusUnsupportedMediaType, errors.New("wrong content type"))
+        return
+    }
+    offset, err := strconv.ParseInt(context.GetHeader("Upload-Offset"), 10, 64)
+    if err != nil || offset < 0 {
+        sendStatus(context, http.StatusBadRequest, errors.New("wrong upload offset"))
+        return
+    }
+
+    up, dataKey, status, err := this.findUpload(context)
+    if err != nil {
+        sendStatus(context, status, err)
+        return
+    }
+    if !this.lockUpload(up.Id) {
+        sendStatus(context, http.StatusLocked, errors.New("upload is in progress"))
+        return
+    }
+    defer this.unlockUpload(up.Id)
+
+    offset, err = this.writeUploadData(context, up, dataKey, offset)
+    this.finishWrite(context, up, dataKey, offset, http.StatusNoContent, err)
+}
+
+func (this *Controller) UploadDelete(context *gin.Context) {
+    setTusHeaders(context)
+    if !checkTusVersion(context) {
+        return
+    }
+    up, _, status, err := this.findUpload(context)
+    if err != nil {
+        sendStatus(context, status, err)
+        return
+    }
+    if !this.lockUpload(up.Id) {
+        sendStatus(context, http.StatusLocked, errors.New("upload is in progress"))
+        return
+    }
+    defer this.unlockUpload(up.Id)
+
+    this.removeUpload(up.Id)
+    log.Printf("upload %s is terminated\n", up.Id)
+    context.Status(http.StatusNoContent)
+}
+
+/* Remove expired and orphaned uploads */
+func (this *Controller) CleanUploads() {
+    uploadDir, err := this.uploadDir()
+    if err != nil {
+        log.Println(err)
+        return
+    }
+    files, err := storageBackend.ReadDir(this.store, uploadDir)
+    if err != nil {
+        log.Println(err)
+        return
+    }
+    now := time.Now()
+    for _, file := range files {
+        id := strings.TrimSuffix(file.Name(), uploadInfoExt)
+        if !validUploadId(id) {
+            continue
+        }
+        up, _, err := this.readUpload(id)
+        if err != nil {
+            /* Data without state, keep it while it can be written */
+            if file.ModTime().Add(this.uploadExpire()).After(now) {
+                continue
+            }
+        } else if up.Expires > now.Unix() {
+            continue
+        }
+        if !this.lockUpload(id) {
+            continue
+        }
+        this.removeUpload(id)
+        this.unlockUpload(id)
+        log.Printf("upload %s is expired and removed\n", id)
+    }
+}
+
+/* Run cleaning of uploads periodically */
+func (this *Controller) UploadJanitor(period time.Duration) {
+    for {
+        this.CleanUploads()
+        time.Sleep(period)
+    }
+}
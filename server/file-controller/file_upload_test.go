@@ -0,0 +1,82 @@
+package fileController
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+
+    "store/config"
+    "store/server/storage-backend"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+    metadata, err := parseUploadMetadata("bucket Zm9vYmFy, filename ZGF0YS5iaW4=,is_confidential")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if metadata["bucket"] != "foobar" {
+        t.Errorf("wrong bucket %s", metadata["bucket"])
+    }
+    if metadata["filename"] != "data.bin" {
+        t.Errorf("wrong filename %s", metadata["filename"])
+    }
+    if value, exists := metadata["is_confidential"]; !exists || value != "" {
+        t.Error("key without value is lost")
+    }
+    if _, err := parseUploadMetadata("filename !!!"); err == nil {
+        t.Error("wrong base64 value is accepted")
+    }
+}
+
+func TestValidUploadId(t *testing.T) {
+    id, err := newUploadId()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !validUploadId(id) {
+        t.Errorf("new id %s is not valid", id)
+    }
+    if validUploadId("../../etc/passwd") {
+        t.Error("path is accepted as id")
+    }
+}
+
+func TestUploadData(t *testing.T) {
+    controller := &Controller{ config: &config.Config{}, store: storageBackend.NewMemory() }
+    id, _ := newUploadId()
+    up := upload{ Id: id, Username: "alice", Length: 6 }
+    if err := controller.writeUpload(up); err != nil {
+        t.Fatal(err)
+    }
+    found, dataKey, err := controller.readUpload(id)
+    if err != nil || found.Username != "alice" {
+        t.Fatalf("upload state is not read %v", err)
+    }
+    controller.store.Put(dataKey, strings.NewReader(""), true)
+
+    write := func(offset int64, data string) (int64, error) {
+        context, _ := gin.CreateTestContext(httptest.NewRecorder())
+        context.Request = httptest.NewRequest(http.MethodPatch, UploadURI + id, strings.NewReader(data))
+        return controller.writeUploadData(context, up, dataKey, offset)
+    }
+    if offset, err := write(0, "abc"); err != nil || offset != 3 {
+        t.Errorf("chunk is not written %d %v", offset, err)
+    }
+    if offset, err := write(1, "x"); err != errUploadOffset || offset != 3 {
+        t.Errorf("wrong offset is accepted %d %v", offset, err)
+    }
+    if offset, err := write(3, "defg"); err != errUploadLength || offset != 3 {
+        t.Errorf("too long chunk is accepted %d %v", offset, err)
+    }
+    if data, _ := storageBackend.ReadFile(controller.store, dataKey); string(data) != "abc" {
+        t.Errorf("dropped chunk is kept %q", data)
+    }
+
+    controller.removeUpload(id)
+    if _, _, err := controller.readUpload(id); err == nil || storageBackend.FileExists(controller.store, dataKey) {
+        t.Error("upload is not removed")
+    }
+}
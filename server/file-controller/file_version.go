@@ -0,0 +1,83 @@
+package fileController
+
+import (
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+
+    "store/server/acl-model"
+)
+
+type versionForm struct {
+    FileName    string  `form:"filename" json:"filename" binding:"required"`
+    BucketName  string  `form:"bucket"   json:"bucket"`
+    Version     string  `form:"version"  json:"version"`
+}
+
+/* Bind version form, validate the file path and check permission */
+func (this *Controller) bindVersion(context *gin.Context, perm string) (versionForm, string, bool) {
+    var form versionForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return form, "", false
+    }
+    filePath, err := this.ValidateFilePath(form.BucketName, form.FileName)
+    if err != nil {
+        sendError(context, err)
+        return form, "", false
+    }
+    if err := this.allow(context, filePath, perm); err != nil {
+        sendForbidden(context, err)
+        return form, "", false
+    }
+    return form, filePath, true
+}
+
+/* List versions of the file, newest first */
+func (this *Controller) Versions(context *gin.Context) {
+    _, filePath, ok := this.bindVersion(context, aclModel.PermList)
+    if !ok {
+        return
+    }
+    versions, err := this.versions.List(this.storePath(filePath))
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, versions)
+}
+
+/* Make the version current data of the file */
+func (this *Controller) Restore(context *gin.Context) {
+    form, filePath, ok := this.bindVersion(context, aclModel.PermWrite)
+    if !ok {
+        return
+    }
+    /* Restored data counts to quotas as uploaded data */
+    path := this.storePath(filePath)
+    if version, err := this.versions.Find(path, form.Version); err == nil {
+        if _, err := this.quotas.Check(context.GetString("username"), path, version.Size); err != nil {
+            sendStatus(context, quotaStatus(err, http.StatusBadRequest), err)
+            return
+        }
+    }
+    if err := this.versions.Restore(path, form.Version); err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, []File{})
+}
+
+/* Remove the version or all versions of the file */
+func (this *Controller) Purge(context *gin.Context) {
+    form, filePath, ok := this.bindVersion(context, aclModel.PermDelete)
+    if !ok {
+        return
+    }
+    count, err := this.versions.Purge(this.storePath(filePath), form.Version)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, count)
+}
@@ -0,0 +1,353 @@
+package lifecycleModel
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/jmoiron/sqlx"
+
+    "store/config"
+    "store/server/bucket-model"
+    "store/server/storage-backend"
+    "store/server/version-model"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS lifecycle (
+        id          INTEGER PRIMARY KEY,
+        bucket      VARCHAR(1024) NOT NULL,
+        pattern     VARCHAR(1024) NOT NULL DEFAULT '',
+        expiredays  INTEGER NOT NULL DEFAULT 0,
+        keeplast    INTEGER NOT NULL DEFAULT 0,
+        deleteempty BOOLEAN NOT NULL DEFAULT FALSE
+    );`
+
+const (
+    KindObject      string = "object"
+    KindBucket      string = "bucket"
+
+    ReasonExpired   string = "expired"
+    ReasonKeepLast  string = "keeplast"
+    ReasonEmpty     string = "empty"
+)
+
+/* Runs of the scheduler and requests do not overlap */
+var runLock sync.Mutex
+
+type Model struct {
+    config *config.Config
+    db *sqlx.DB
+    store storageBackend.Backend
+    buckets *bucketModel.Model
+    versions *versionModel.Model
+}
+
+/* Deletion planned by the rule, name of the bucket kind is empty */
+type Action struct {
+    Kind        string  `json:"kind"`
+    Bucket      string  `json:"bucket"`
+    Name        string  `json:"name,omitempty"`
+    Size        int64   `json:"size"`
+    ModTime     string  `json:"modtime"`
+    Reason      string  `json:"reason"`
+}
+
+/* Pattern is a glob of the file base name, empty matches all files */
+func Validate(rule config.LifecycleRule) error {
+    if _, err := bucketModel.CleanName(rule.Bucket); err != nil {
+        return errors.New("wrong bucket of lifecycle rule " + rule.Bucket)
+    }
+    if _, err := filepath.Match(rule.Pattern, ""); err != nil || strings.Contains(rule.Pattern, "/") {
+        return errors.New("wrong pattern of lifecycle rule " + rule.Pattern)
+    }
+    if rule.ExpireDays < 0 || rule.KeepLast < 0 {
+        return errors.New("wrong limits of lifecycle rule")
+    }
+    if rule.ExpireDays == 0 && rule.KeepLast == 0 && !rule.DeleteEmpty {
+        return errors.New("lifecycle rule does nothing")
+    }
+    return nil
+}
+
+func (this *Model) Migrate() error {
+    _, err := this.db.Exec(schema)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Rules of the bucket set by API, rules of the config are not included */
+func (this *Model) List(bucket string) ([]config.LifecycleRule, error) {
+    rules := []config.LifecycleRule{}
+    request := `SELECT bucket, pattern, expiredays, keeplast, deleteempty FROM lifecycle
+                WHERE bucket = $1 ORDER BY id`
+    if err := this.db.Select(&rules, request, strings.Trim(bucket, "/")); err != nil {
+        log.Println(err)
+        return rules, err
+    }
+    return rules, nil
+}
+
+/* Rules of the config and the API for all buckets */
+func (this *Model) All() ([]config.LifecycleRule, error) {
+    rules := []config.LifecycleRule{}
+    for _, rule := range this.config.Lifecycle {
+        if err := Validate(rule); err != nil {
+            log.Printf("config: %s\n", err)
+            continue
+        }
+        rule.Bucket = strings.Trim(rule.Bucket, "/")
+        rules = append(rules, rule)
+    }
+    list := []config.LifecycleRule{}
+    request := `SELECT bucket, pattern, expiredays, keeplast, deleteempty FROM lifecycle ORDER BY bucket, id`
+    if err := this.db.Select(&list, request); err != nil {
+        log.Println(err)
+        return rules, err
+    }
+    return append(rules, list...), nil
+}
+
+/* Replace rules of the bucket, empty list removes the rules */
+func (this *Model) Set(bucket string, rules []config.LifecycleRule) error {
+    bucket, err := bucketModel.CleanName(bucket)
+    if err != nil {
+        return err
+    }
+    for i := range rules {
+        rules[i].Bucket = bucket
+        if err := Validate(rules[i]); err != nil {
+            return err
+        }
+    }
+    tx, err := this.db.Begin()
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec(`DELETE FROM lifecycle WHERE bucket = $1`, bucket)
+    for _, rule := range rules {
+        if err != nil {
+            break
+        }
+        request := `INSERT INTO lifecycle(bucket, pattern, expiredays, keeplast, deleteempty)
+                    VALUES ($1, $2, $3, $4, $5)`
+        _, err = tx.Exec(request, bucket, rule.Pattern, rule.ExpireDays, rule.KeepLast, rule.DeleteEmpty)
+    }
+    if err != nil {
+        log.Println(err)
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit()
+}
+
+/* Move rules of the bucket and nested buckets to the new name */
+func (this *Model) Rename(oldBucket, newBucket string) error {
+    oldBucket = strings.Trim(oldBucket, "/")
+    newBucket = strings.Trim(newBucket, "/")
+    request := `UPDATE lifecycle SET bucket = $1 || substr(bucket, length($2) + 1)
+                WHERE bucket = $2 OR substr(bucket, 1, length($2) + 1) = $2 || '/'`
+    if _, err := this.db.Exec(request, newBucket, oldBucket); err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func newAction(kind, bucket string, fileInfo os.FileInfo, reason string) Action {
+    action := Action{
+        Kind: kind,
+        Bucket: bucket,
+        ModTime: fileInfo.ModTime().Format(time.RFC3339),
+        Reason: reason,
+    }
+    if kind == KindObject {
+        action.Name = fileInfo.Name()
+        action.Size = fileInfo.Size()
+    }
+    return action
+}
+
+/* Plan deletions of the rule in the directory of the bucket and nested buckets,
+ * return true if the directory is empty after deletions */
+func (this *Model) planDir(rule config.LifecycleRule, bucket string,
+                                    now time.Time, actions *[]Action) (bool, error) {
+    files, err := storageBackend.ReadDir(this.store, bucket)
+    if err != nil {
+        return false, err
+    }
+    left := len(files)
+    var matched []os.FileInfo
+    for _, file := range files {
+        if file.IsDir() {
+            if config.IsSystemName(filepath.Join(bucket, file.Name())) {
+                continue
+            }
+            empty, err := this.planDir(rule, filepath.Join(bucket, file.Name()), now, actions)
+            if err != nil {
+                return false, err
+            }
+            if empty && rule.DeleteEmpty {
+                *actions = append(*actions, newAction(KindBucket, filepath.Join(bucket, file.Name()), file, ReasonEmpty))
+                left--
+            }
+            continue
+        }
+        if !file.Mode().IsRegular() {
+            continue
+        }
+        if match, _ := filepath.Match(rule.Pattern, file.Name()); match || len(rule.Pattern) == 0 {
+            matched = append(matched, file)
+        }
+    }
+
+    /* Newest files first */
+    sort.Slice(matched, func(i, j int) bool {
+        return matched[i].ModTime().After(matched[j].ModTime())
+    })
+    expire := now.AddDate(0, 0, -rule.ExpireDays)
+    for i, file := range matched {
+        var reason string
+        if rule.KeepLast > 0 && i >= rule.KeepLast {
+            reason = ReasonKeepLast
+        }
+        if rule.ExpireDays > 0 && file.ModTime().Before(expire) {
+            reason = ReasonExpired
+        }
+        if len(reason) > 0 {
+            *actions = append(*actions, newAction(KindObject, bucket, file, reason))
+            left--
+        }
+    }
+    return left == 0, nil
+}
+
+/* Plan deletions of the rules, the bucket of the rule is not deleted.
+ * Deletion planned by several rules is listed once */
+func (this *Model) Plan(rules []config.LifecycleRule) ([]Action, error) {
+    actions := []Action{}
+    now := time.Now()
+    planned := make(map[string]bool)
+    for _, rule := range rules {
+        var list []Action
+        /* Rules stored before names were checked may point out of the bucket */
+        bucket, err := bucketModel.CleanName(rule.Bucket)
+        if err != nil {
+            log.Printf("lifecycle: %s\n", err)
+            continue
+        }
+        _, err = this.planDir(rule, bucket, now, &list)
+        if os.IsNotExist(err) {
+            continue
+        }
+        if err != nil {
+            return actions, err
+        }
+        for _, action := range list {
+            key := action.Kind + ":" + filepath.Join(action.Bucket, action.Name)
+            if !planned[key] {
+                planned[key] = true
+                actions = append(actions, action)
+            }
+        }
+    }
+    return actions, nil
+}
+
+/* Write audit log entries of deletions */
+func (this *Model) audit(actions []Action) {
+    if len(actions) == 0 {
+        return
+    }
+    file, err := os.OpenFile(this.config.AuditLogPath, os.O_WRONLY | os.O_CREATE | os.O_APPEND, 0640)
+    if err != nil {
+        log.Println(err)
+        return
+    }
+    defer file.Close()
+    for _, action := range actions {
+        fmt.Fprintf(file, "%s lifecycle delete %s %s size %d modtime %s reason %s\n",
+                        time.Now().Format(time.RFC3339), action.Kind, filepath.Join(action.Bucket, action.Name),
+                        action.Size, action.ModTime, action.Reason)
+    }
+}
+
+/* Delete files and empty buckets of the plan, files are deleted as by
+ * file delete. Files and buckets changed after planning are skipped.
+ * Return completed actions */
+func (this *Model) Apply(actions []Action) ([]Action, error) {
+    done := []Action{}
+    defer func() { this.audit(done) }()
+    for _, action := range actions {
+        path := filepath.Join(action.Bucket, action.Name)
+        if action.Kind == KindBucket {
+            /* Bucket is not empty if new files are stored */
+            if err := this.store.Delete(path); err != nil {
+                continue
+            }
+            if err := this.buckets.Delete(action.Bucket); err != nil {
+                return done, err
+            }
+            done = append(done, action)
+            continue
+        }
+        fileInfo, err := this.store.Stat(path)
+        if err != nil || fileInfo.ModTime().Format(time.RFC3339) != action.ModTime {
+            continue
+        }
+        if err := this.versions.Remove(path); err != nil {
+            return done, err
+        }
+        done = append(done, action)
+    }
+    return done, nil
+}
+
+/* Apply all rules */
+func (this *Model) Run() ([]Action, error) {
+    runLock.Lock()
+    defer runLock.Unlock()
+    rules, err := this.All()
+    if err != nil {
+        return nil, err
+    }
+    actions, err := this.Plan(rules)
+    if err != nil {
+        return nil, err
+    }
+    return this.Apply(actions)
+}
+
+/* Apply lifecycle rules periodically */
+func (this *Model) Scheduler(period time.Duration) {
+    for {
+        done, err := this.Run()
+        if err != nil {
+            log.Println(err)
+        }
+        if len(done) > 0 {
+            log.Printf("lifecycle rules deleted %d files and buckets\n", len(done))
+        }
+        time.Sleep(period)
+    }
+}
+
+func New(config *config.Config, db *sqlx.DB) *Model {
+    model := Model{
+        config: config,
+        db: db,
+        store: storageBackend.Open(config, db),
+        buckets: bucketModel.New(db),
+        versions: versionModel.New(config, db),
+    }
+    return &model
+}
@@ -0,0 +1,92 @@
+package lifecycleModel
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "store/config"
+)
+
+func TestPlan(t *testing.T) {
+    storeDir, err := ioutil.TempDir("", "store-test")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(storeDir)
+    now := time.Now()
+    files := map[string]int{
+        "nightly/app-1.tgz": 10,
+        "nightly/app-2.tgz": 5,
+        "nightly/app-3.tgz": 1,
+        "nightly/notes.txt": 30,
+        "nightly/old/app-0.tgz": 30,
+    }
+    for name, days := range files {
+        filePath := filepath.Join(storeDir, name)
+        os.MkdirAll(filepath.Dir(filePath), 0750)
+        if err := ioutil.WriteFile(filePath, []byte(name), 0640); err != nil {
+            t.Fatal(err)
+        }
+        modTime := now.AddDate(0, 0, -days)
+        os.Chtimes(filePath, modTime, modTime)
+    }
+    model := New(&config.Config{ StoreDir: storeDir }, nil)
+
+    rules := []config.LifecycleRule{
+        { Bucket: "nightly", Pattern: "*.tgz", KeepLast: 1 },
+        { Bucket: "nightly", Pattern: "*.tgz", ExpireDays: 7, DeleteEmpty: true },
+    }
+    actions, err := model.Plan(rules)
+    if err != nil {
+        t.Fatal(err)
+    }
+    planned := make(map[string]string)
+    for _, action := range actions {
+        planned[action.Kind + ":" + filepath.Join(action.Bucket, action.Name)] = action.Reason
+    }
+    expected := map[string]string{
+        "object:nightly/app-1.tgz": ReasonKeepLast,
+        "object:nightly/app-2.tgz": ReasonKeepLast,
+        "object:nightly/old/app-0.tgz": ReasonExpired,
+        "bucket:nightly/old": ReasonEmpty,
+    }
+    if len(actions) != len(expected) {
+        t.Errorf("wrong count of planned deletions %d: %v", len(actions), planned)
+    }
+    for key, reason := range expected {
+        if planned[key] != reason {
+            t.Errorf("%s is planned with reason %q, expected %q", key, planned[key], reason)
+        }
+    }
+
+    /* Rule of the bucket with dot components does not reach other buckets */
+    dotRules := []config.LifecycleRule{ { Bucket: "old/../nightly", ExpireDays: 7 } }
+    if err := model.Set("old/../nightly", dotRules); err == nil {
+        t.Error("rule of the bucket old/../nightly is set")
+    }
+    actions, err = model.Plan(dotRules)
+    if err != nil || len(actions) > 0 {
+        t.Errorf("rule of the bucket old/../nightly plans deletions %v: %v", actions, err)
+    }
+}
+
+func TestValidate(t *testing.T) {
+    valid := config.LifecycleRule{ Bucket: "nightly", Pattern: "*.tgz", ExpireDays: 7 }
+    if err := Validate(valid); err != nil {
+        t.Error(err)
+    }
+    for _, rule := range []config.LifecycleRule{
+            { Bucket: "", ExpireDays: 7 },
+            { Bucket: "old/../nightly", ExpireDays: 7 },
+            { Bucket: "nightly", Pattern: "[", ExpireDays: 7 },
+            { Bucket: "nightly", Pattern: "a/*", ExpireDays: 7 },
+            { Bucket: "nightly", KeepLast: -1 },
+            { Bucket: "nightly" } } {
+        if Validate(rule) == nil {
+            t.Errorf("wrong rule %v is valid", rule)
+        }
+    }
+}
@@ -0,0 +1,236 @@
+package objectModel
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "net/url"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+const attrSchema = `
+    CREATE TABLE IF NOT EXISTS attributes (
+        id          INTEGER PRIMARY KEY,
+        bucket      VARCHAR(1024) NOT NULL,
+        name        VARCHAR(1024) NOT NULL,
+        kind        VARCHAR(16) NOT NULL,
+        key         VARCHAR(128) NOT NULL,
+        value       VARCHAR(1024) NOT NULL DEFAULT '',
+        UNIQUE (bucket, name, kind, key)
+    );`
+
+const (
+    kindMeta        string = "meta"
+    kindTag         string = "tag"
+
+    MetaHeaderPrefix    string = "X-Meta-"
+    TagsHeader          string = "X-Tags"
+
+    maxAttributes       int = 32
+    maxKeyLength        int = 128
+    maxValueLength      int = 1024
+)
+
+var keyRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+/* User defined metadata and tags of the file */
+type Attributes struct {
+    Meta    map[string]string   `json:"meta,omitempty"`
+    Tags    map[string]string   `json:"tags,omitempty"`
+}
+
+type attribute struct {
+    Id          int     `db:"id"`
+    Bucket      string  `db:"bucket"`
+    Name        string  `db:"name"`
+    Kind        string  `db:"kind"`
+    Key         string  `db:"key"`
+    Value       string  `db:"value"`
+}
+
+/* Keys are lower case, values are limited in size */
+func ValidateAttributes(attrs map[string]string) error {
+    if len(attrs) > maxAttributes {
+        return errors.New("too many metadata keys or tags")
+    }
+    for key, value := range attrs {
+        if len(key) > maxKeyLength || !keyRegexp.MatchString(key) {
+            return errors.New("wrong metadata or tag key " + key)
+        }
+        if len(value) > maxValueLength {
+            return errors.New("too long value of " + key)
+        }
+    }
+    return nil
+}
+
+func (this Attributes) Validate() error {
+    if err := ValidateAttributes(this.Meta); err != nil {
+        return err
+    }
+    return ValidateAttributes(this.Tags)
+}
+
+/* Parse tags in query format: channel=stable&build=42 */
+func ParseTags(value string) (map[string]string, error) {
+    tags := make(map[string]string)
+    if len(value) == 0 {
+        return tags, nil
+    }
+    query, err := url.ParseQuery(value)
+    if err != nil {
+        return tags, errors.New("wrong tags")
+    }
+    for key, values := range query {
+        tags[strings.ToLower(key)] = values[len(values) - 1]
+    }
+    return tags, ValidateAttributes(tags)
+}
+
+func FormatTags(tags map[string]string) string {
+    query := url.Values{}
+    for key, value := range tags {
+        query.Set(key, value)
+    }
+    return query.Encode()
+}
+
+/* Collect metadata from X-Meta-* headers */
+func ParseMetaHeaders(header http.Header, prefix string) map[string]string {
+    meta := make(map[string]string)
+    for name, values := range header {
+        if len(name) > len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+            meta[strings.ToLower(name[len(prefix):])] = values[0]
+        }
+    }
+    return meta
+}
+
+/* Set X-Meta-* and X-Tags headers of the response */
+func (this Attributes) SetHeaders(header http.Header, prefix string) {
+    keys := make([]string, 0, len(this.Meta))
+    for key := range this.Meta {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        header.Set(prefix + key, this.Meta[key])
+    }
+    if len(this.Tags) > 0 {
+        header.Set(TagsHeader, FormatTags(this.Tags))
+    }
+}
+
+/* Match tag filter: key=value, or key for any value, empty filter matches all */
+func (this Attributes) Match(filter string) bool {
+    if len(filter) == 0 {
+        return true
+    }
+    index := strings.Index(filter, "=")
+    if index < 0 {
+        _, exists := this.Tags[strings.ToLower(filter)]
+        return exists
+    }
+    value, exists := this.Tags[strings.ToLower(filter[:index])]
+    return exists && value == filter[index + 1:]
+}
+
+func (this *Model) setAttributes(path, kind string, attrs map[string]string) error {
+    bucket, name := Split(path)
+    tx, err := this.db.Begin()
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec(`DELETE FROM attributes WHERE bucket = $1 AND name = $2 AND kind = $3`, bucket, name, kind)
+    for key, value := range attrs {
+        if err != nil {
+            break
+        }
+        _, err = tx.Exec(`INSERT INTO attributes(bucket, name, kind, key, value) VALUES ($1, $2, $3, $4, $5)`,
+                                bucket, name, kind, key, value)
+    }
+    if err != nil {
+        log.Println(err)
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit()
+}
+
+/* Replace metadata and tags of the file */
+func (this *Model) SetAttributes(path string, attrs Attributes) error {
+    if err := attrs.Validate(); err != nil {
+        return err
+    }
+    if err := this.setAttributes(path, kindMeta, attrs.Meta); err != nil {
+        return err
+    }
+    return this.setAttributes(path, kindTag, attrs.Tags)
+}
+
+/* Replace tags of the file, metadata is not changed */
+func (this *Model) SetTags(path string, tags map[string]string) error {
+    if err := ValidateAttributes(tags); err != nil {
+        return err
+    }
+    return this.setAttributes(path, kindTag, tags)
+}
+
+func appendAttribute(attrs Attributes, attr attribute) Attributes {
+    switch attr.Kind {
+        case kindMeta:
+            if attrs.Meta == nil {
+                attrs.Meta = make(map[string]string)
+            }
+            attrs.Meta[attr.Key] = attr.Value
+        case kindTag:
+            if attrs.Tags == nil {
+                attrs.Tags = make(map[string]string)
+            }
+            attrs.Tags[attr.Key] = attr.Value
+    }
+    return attrs
+}
+
+func (this *Model) Attributes(path string) (Attributes, error) {
+    var attrs Attributes
+    list := []attribute{}
+    bucket, name := Split(path)
+    request := `SELECT * FROM attributes WHERE bucket = $1 AND name = $2`
+    if err := this.db.Select(&list, request, bucket, name); err != nil {
+        log.Println(err)
+        return attrs, err
+    }
+    for _, attr := range list {
+        attrs = appendAttribute(attrs, attr)
+    }
+    return attrs, nil
+}
+
+/* Attributes of files in the bucket by name */
+func (this *Model) ListAttributes(bucket string) (map[string]Attributes, error) {
+    result := make(map[string]Attributes)
+    list := []attribute{}
+    request := `SELECT * FROM attributes WHERE bucket = $1`
+    if err := this.db.Select(&list, request, strings.Trim(bucket, "/")); err != nil {
+        log.Println(err)
+        return result, err
+    }
+    for _, attr := range list {
+        result[attr.Name] = appendAttribute(result[attr.Name], attr)
+    }
+    return result, nil
+}
+
+func (this *Model) deleteAttributes(path string) error {
+    bucket, name := Split(path)
+    request := `DELETE FROM attributes WHERE bucket = $1 AND name = $2`
+    _, err := this.db.Exec(request, bucket, name)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
@@ -0,0 +1,452 @@
+package objectModel
+
+import (
+    "crypto/md5"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "hash"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/jmoiron/sqlx"
+
+    "store/tools"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS objects (
+        id          INTEGER PRIMARY KEY,
+        bucket      VARCHAR(1024) NOT NULL,
+        name        VARCHAR(1024) NOT NULL,
+        size        INTEGER NOT NULL DEFAULT 0,
+        modtime     INTEGER NOT NULL DEFAULT 0,
+        sha256      VARCHAR(64) NOT NULL DEFAULT '',
+        md5         VARCHAR(32) NOT NULL DEFAULT '',
+        contenttype VARCHAR(256) NOT NULL DEFAULT '',
+        owner       VARCHAR(255) NOT NULL DEFAULT '',
+        stored      INTEGER NOT NULL DEFAULT 0,
+        UNIQUE (bucket, name)
+    );`
+
+/* Usage is updated with metadata of files, owner is the user stored the file */
+const usageSchema = `
+    CREATE TABLE IF NOT EXISTS usage (
+        id          INTEGER PRIMARY KEY,
+        bucket      VARCHAR(1024) NOT NULL,
+        owner       VARCHAR(255) NOT NULL DEFAULT '',
+        bytes       INTEGER NOT NULL DEFAULT 0,
+        objects     INTEGER NOT NULL DEFAULT 0,
+        storedbytes INTEGER NOT NULL DEFAULT 0,
+        UNIQUE (bucket, owner)
+    );`
+
+/* Count usage of existing metadata once */
+const usageInit = `
+    INSERT INTO usage(bucket, owner, bytes, objects, storedbytes)
+        SELECT bucket, owner, sum(size), count(*), sum(stored) FROM objects
+        WHERE NOT EXISTS (SELECT 1 FROM usage)
+        GROUP BY bucket, owner`
+
+/* Files stored before compression are stored as is */
+var storedColumns = []struct {
+    table, column, init string
+}{
+    { "objects", "stored", `UPDATE objects SET stored = size` },
+    { "usage", "storedbytes", `UPDATE usage SET storedbytes = bytes` },
+}
+
+type Model struct {
+    db *sqlx.DB
+}
+
+/* Metadata of the file in the store. Bucket is the store relative
+ * directory of the file, name is the file base name */
+type Object struct {
+    Id          int     `db:"id"         json:"-"`
+    Bucket      string  `db:"bucket"     json:"bucket"`
+    Name        string  `db:"name"       json:"name"`
+    Size        int64   `db:"size"       json:"size"`
+    ModTime     int64   `db:"modtime"    json:"-"`
+    SHA256      string  `db:"sha256"     json:"sha256"`
+    MD5         string  `db:"md5"        json:"md5"`
+    ContentType string  `db:"contenttype" json:"contenttype"`
+    Owner       string  `db:"owner"      json:"owner"`
+    Stored      int64   `db:"stored"     json:"stored"`
+}
+
+/* Total size and count of files, stored bytes are taken by compressed data */
+type Usage struct {
+    Bytes       int64   `db:"bytes"      json:"bytes"`
+    Objects     int64   `db:"objects"    json:"objects"`
+    StoredBytes int64   `db:"storedbytes" json:"storedbytes"`
+}
+
+/* Size of the stored data of the file, smaller than the size if compressed */
+func StoredSize(fileInfo os.FileInfo) int64 {
+    if encoded, ok := fileInfo.(interface{ StoredSize() int64 }); ok {
+        return encoded.StoredSize()
+    }
+    return fileInfo.Size()
+}
+
+/* Content coding of the stored data, empty if the data is not compressed */
+func Encoding(fileInfo os.FileInfo) string {
+    if encoded, ok := fileInfo.(interface{ Encoding() string }); ok {
+        return encoded.Encoding()
+    }
+    return ""
+}
+
+/* Split store relative path to bucket and name */
+func Split(path string) (string, string) {
+    path = strings.Trim(filepath.Clean("/" + path), "/")
+    bucket, name := filepath.Split(path)
+    return strings.Trim(bucket, "/"), name
+}
+
+/* Metadata is valid while the file is not changed outside of the store */
+func (this Object) Valid(fileInfo os.FileInfo) bool {
+    return this.Matches(fileInfo.Size(), fileInfo.ModTime())
+}
+
+func (this Object) Matches(size int64, modTime time.Time) bool {
+    return this.Size == size && this.ModTime == modTime.UnixNano()
+}
+
+/* Value of Digest response header, RFC 3230 */
+func (this Object) Digest() string {
+    var list []string
+    if sum, err := hex.DecodeString(this.SHA256); err == nil && len(sum) > 0 {
+        list = append(list, "sha-256=" + base64.StdEncoding.EncodeToString(sum))
+    }
+    if sum, err := hex.DecodeString(this.MD5); err == nil && len(sum) > 0 {
+        list = append(list, "md5=" + base64.StdEncoding.EncodeToString(sum))
+    }
+    return strings.Join(list, ",")
+}
+
+func (this Object) ETag() string {
+    if len(this.MD5) == 0 {
+        return ""
+    }
+    return `"` + this.MD5 + `"`
+}
+
+/* Entity tag of the file without checksum, changes with size or modification time */
+func StatETag(fileInfo os.FileInfo) string {
+    return fmt.Sprintf(`"%x-%x"`, fileInfo.Size(), fileInfo.ModTime().UnixNano())
+}
+
+/* Parse Digest request header to hex encoded SHA256 and MD5 sums */
+func ParseDigest(header string) (string, string, error) {
+    var sha256Sum, md5Sum string
+    for _, item := range strings.Split(header, ",") {
+        item = strings.TrimSpace(item)
+        index := strings.Index(item, "=")
+        if index < 0 {
+            continue
+        }
+        sum, err := base64.StdEncoding.DecodeString(item[index + 1:])
+        if err != nil {
+            return "", "", errors.New("wrong digest value")
+        }
+        switch strings.ToLower(item[:index]) {
+            case "sha-256":
+                sha256Sum = hex.EncodeToString(sum)
+            case "md5":
+                md5Sum = hex.EncodeToString(sum)
+        }
+    }
+    return sha256Sum, md5Sum, nil
+}
+
+/* Size of the data head kept for content type sniffing */
+const headSize int = 512
+
+/* Writer computing checksums of the data */
+type Hash struct {
+    sha256  hash.Hash
+    md5     hash.Hash
+    head    []byte
+}
+
+func NewHash() *Hash {
+    return &Hash{
+        sha256: sha256.New(),
+        md5: md5.New(),
+    }
+}
+
+func (this *Hash) Write(data []byte) (int, error) {
+    this.sha256.Write(data)
+    this.md5.Write(data)
+    if rest := headSize - len(this.head); rest > 0 {
+        if rest > len(data) {
+            rest = len(data)
+        }
+        this.head = append(this.head, data[:rest]...)
+    }
+    return len(data), nil
+}
+
+/* First bytes of the data */
+func (this *Hash) Head() []byte {
+    return this.head
+}
+
+func (this *Hash) SHA256() string {
+    return hex.EncodeToString(this.sha256.Sum(nil))
+}
+
+func (this *Hash) MD5() string {
+    return hex.EncodeToString(this.md5.Sum(nil))
+}
+
+/* Check hex encoded expected sums, empty sum is not checked */
+func (this *Hash) Verify(sha256Sum, md5Sum string) error {
+    if len(sha256Sum) > 0 && !strings.EqualFold(sha256Sum, this.SHA256()) {
+        return errors.New("sha256 checksum mismatch")
+    }
+    if len(md5Sum) > 0 && !strings.EqualFold(md5Sum, this.MD5()) {
+        return errors.New("md5 checksum mismatch")
+    }
+    return nil
+}
+
+/* Compute checksums of the data */
+func HashReader(reader io.Reader) (*Hash, error) {
+    hash := NewHash()
+    if _, err := io.Copy(hash, reader); err != nil {
+        return nil, err
+    }
+    return hash, nil
+}
+
+func (this *Model) Migrate() error {
+    for _, request := range []string{ schema, attrSchema, usageSchema } {
+        _, err := this.db.Exec(request)
+        if err != nil {
+            log.Println(err)
+            return err
+        }
+    }
+    for _, item := range storedColumns {
+        added, err := tools.AddColumn(this.db, item.table, item.column, "INTEGER NOT NULL DEFAULT 0")
+        if err == nil && added {
+            _, err = this.db.Exec(item.init)
+        }
+        if err != nil {
+            log.Println(err)
+            return err
+        }
+    }
+    if _, err := this.db.Exec(usageInit); err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Add the difference to usage of the bucket by the owner */
+func addUsage(tx *sqlx.Tx, bucket, owner string, bytes, stored, objects int64) error {
+    request := `INSERT INTO usage(bucket, owner, bytes, objects, storedbytes) VALUES ($1, $2, $3, $4, $5)
+                ON CONFLICT(bucket, owner) DO UPDATE SET
+                    bytes = bytes + excluded.bytes, objects = objects + excluded.objects,
+                    storedbytes = storedbytes + excluded.storedbytes`
+    if _, err := tx.Exec(request, bucket, owner, bytes, objects, stored); err != nil {
+        return err
+    }
+    request = `DELETE FROM usage WHERE bucket = $1 AND owner = $2 AND objects <= 0`
+    _, err := tx.Exec(request, bucket, owner)
+    return err
+}
+
+/* Create or replace metadata of the file and update usage,
+ * owner of the replaced file is kept if the owner is empty */
+func (this *Model) Save(object Object) error {
+    tx, err := this.db.Beginx()
+    if err != nil {
+        return err
+    }
+    var old Object
+    request := `SELECT * FROM objects WHERE bucket = $1 AND name = $2 LIMIT 1`
+    found := tx.Get(&old, request, object.Bucket, object.Name) == nil
+    if found && len(object.Owner) == 0 {
+        object.Owner = old.Owner
+    }
+
+    request = `INSERT INTO objects(bucket, name, size, modtime, sha256, md5, contenttype, owner, stored)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+                ON CONFLICT(bucket, name) DO UPDATE SET
+                    size = excluded.size, modtime = excluded.modtime,
+                    sha256 = excluded.sha256, md5 = excluded.md5,
+                    contenttype = excluded.contenttype, owner = excluded.owner,
+                    stored = excluded.stored`
+    _, err = tx.Exec(request, object.Bucket, object.Name, object.Size, object.ModTime,
+                                object.SHA256, object.MD5, object.ContentType, object.Owner, object.Stored)
+    if err == nil && found {
+        err = addUsage(tx, old.Bucket, old.Owner, -old.Size, -old.Stored, -1)
+    }
+    if err == nil {
+        err = addUsage(tx, object.Bucket, object.Owner, object.Size, object.Stored, 1)
+    }
+    if err != nil {
+        log.Println(err)
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit()
+}
+
+/* Save metadata of the stored file with computed checksums,
+ * content type is detected if the provided one is empty */
+func (this *Model) SaveFile(path string, fileInfo os.FileInfo, hash *Hash, contentType, owner string) (Object, error) {
+    var object Object
+    object.Bucket, object.Name = Split(path)
+    object.Size = fileInfo.Size()
+    object.Stored = StoredSize(fileInfo)
+    object.ModTime = fileInfo.ModTime().UnixNano()
+    object.SHA256 = hash.SHA256()
+    object.MD5 = hash.MD5()
+    object.ContentType = DetectContentType(path, contentType, hash.Head())
+    object.Owner = owner
+    return object, this.Save(object)
+}
+
+func (this *Model) Find(path string) (Object, error) {
+    var object Object
+    bucket, name := Split(path)
+    request := `SELECT * FROM objects WHERE bucket = $1 AND name = $2 LIMIT 1`
+    err := this.db.Get(&object, request, bucket, name)
+    return object, err
+}
+
+/* Return valid metadata of the file */
+func (this *Model) Stat(path string, fileInfo os.FileInfo) (Object, bool) {
+    object, err := this.Find(path)
+    if err != nil || !object.Valid(fileInfo) {
+        return object, false
+    }
+    return object, true
+}
+
+/* Metadata of files in the bucket by name */
+func (this *Model) List(bucket string) (map[string]Object, error) {
+    objects := []Object{}
+    bucket = strings.Trim(bucket, "/")
+    request := `SELECT * FROM objects WHERE bucket = $1`
+    err := this.db.Select(&objects, request, bucket)
+    list := make(map[string]Object)
+    if err != nil {
+        log.Println(err)
+        return list, err
+    }
+    for _, object := range objects {
+        list[object.Name] = object
+    }
+    return list, nil
+}
+
+/* Buckets having metadata of files */
+func (this *Model) Buckets() ([]string, error) {
+    buckets := []string{}
+    request := `SELECT DISTINCT bucket FROM objects ORDER BY bucket`
+    if err := this.db.Select(&buckets, request); err != nil {
+        log.Println(err)
+        return buckets, err
+    }
+    return buckets, nil
+}
+
+/* Move metadata and attributes of the bucket and nested buckets to the new name */
+func (this *Model) Rename(oldBucket, newBucket string) error {
+    oldBucket = strings.Trim(oldBucket, "/")
+    newBucket = strings.Trim(newBucket, "/")
+    for _, table := range []string{ "objects", "attributes", "usage" } {
+        request := `UPDATE ` + table + ` SET bucket = $1 || substr(bucket, length($2) + 1)
+                    WHERE bucket = $2 OR substr(bucket, 1, length($2) + 1) = $2 || '/'`
+        if _, err := this.db.Exec(request, newBucket, oldBucket); err != nil {
+            log.Println(err)
+            return err
+        }
+    }
+    return nil
+}
+
+/* Delete metadata and attributes of the file, update usage */
+func (this *Model) Delete(path string) error {
+    bucket, name := Split(path)
+    tx, err := this.db.Beginx()
+    if err != nil {
+        return err
+    }
+    var old Object
+    request := `SELECT * FROM objects WHERE bucket = $1 AND name = $2 LIMIT 1`
+    if tx.Get(&old, request, bucket, name) == nil {
+        request = `DELETE FROM objects WHERE id = $1`
+        _, err = tx.Exec(request, old.Id)
+        if err == nil {
+            err = addUsage(tx, old.Bucket, old.Owner, -old.Size, -old.Stored, -1)
+        }
+    }
+    if err != nil {
+        log.Println(err)
+        tx.Rollback()
+        return err
+    }
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+    return this.deleteAttributes(path)
+}
+
+/* Usage of the bucket with nested buckets */
+func (this *Model) BucketUsage(bucket string) (Usage, error) {
+    var usage Usage
+    bucket = strings.Trim(bucket, "/")
+    request := `SELECT coalesce(sum(bytes), 0) AS bytes, coalesce(sum(objects), 0) AS objects,
+                    coalesce(sum(storedbytes), 0) AS storedbytes FROM usage
+                WHERE bucket = $1 OR substr(bucket, 1, length($1) + 1) = $1 || '/'`
+    err := this.db.Get(&usage, request, bucket)
+    return usage, err
+}
+
+/* Usage of files of each bucket without nested buckets */
+func (this *Model) ListUsage() (map[string]Usage, error) {
+    var rows []struct {
+        Bucket  string  `db:"bucket"`
+        Usage
+    }
+    request := `SELECT bucket, sum(bytes) AS bytes, sum(objects) AS objects,
+                    sum(storedbytes) AS storedbytes FROM usage GROUP BY bucket`
+    err := this.db.Select(&rows, request)
+    usage := make(map[string]Usage)
+    for _, row := range rows {
+        usage[row.Bucket] = row.Usage
+    }
+    return usage, err
+}
+
+/* Usage of files stored by the user in all buckets */
+func (this *Model) UserUsage(owner string) (Usage, error) {
+    var usage Usage
+    request := `SELECT coalesce(sum(bytes), 0) AS bytes, coalesce(sum(objects), 0) AS objects,
+                    coalesce(sum(storedbytes), 0) AS storedbytes FROM usage
+                WHERE owner = $1`
+    err := this.db.Get(&usage, request, owner)
+    return usage, err
+}
+
+func New(db *sqlx.DB) *Model {
+    model := Model{
+        db: db,
+    }
+    return &model
+}
@@ -0,0 +1,107 @@
+package objectModel
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestSplit(t *testing.T) {
+    tests := map[string][2]string{
+        "foobar/data.bin":      { "foobar", "data.bin" },
+        "/foo/bar/data.bin":    { "foo/bar", "data.bin" },
+        "data.bin":             { "", "data.bin" },
+    }
+    for path, want := range tests {
+        bucket, name := Split(path)
+        if bucket != want[0] || name != want[1] {
+            t.Errorf("split %s: got %s %s", path, bucket, name)
+        }
+    }
+}
+
+func TestDigest(t *testing.T) {
+    hash := NewHash()
+    hash.Write([]byte("hello"))
+    object := Object{ SHA256: hash.SHA256(), MD5: hash.MD5() }
+
+    sha256Sum, md5Sum, err := ParseDigest(object.Digest())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if sha256Sum != object.SHA256 || md5Sum != object.MD5 {
+        t.Errorf("wrong parsed digest %s %s", sha256Sum, md5Sum)
+    }
+    if err := hash.Verify(strings.ToUpper(sha256Sum), ""); err != nil {
+        t.Error(err)
+    }
+    if err := hash.Verify("", "00"); err == nil {
+        t.Error("wrong md5 is verified")
+    }
+    if object.ETag() != `"5d41402abc4b2a76b9719d911017c592"` {
+        t.Errorf("wrong etag %s", object.ETag())
+    }
+}
+
+func TestAttributes(t *testing.T) {
+    tags, err := ParseTags("channel=stable&Build=42")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if tags["channel"] != "stable" || tags["build"] != "42" {
+        t.Errorf("wrong parsed tags %v", tags)
+    }
+    if _, err := ParseTags("bad%20key=1"); err == nil {
+        t.Error("wrong tag key is accepted")
+    }
+
+    attrs := Attributes{ Tags: tags }
+    for filter, want := range map[string]bool{
+        "":                 true,
+        "channel=stable":   true,
+        "channel=beta":     false,
+        "build":            true,
+        "commit":           false,
+    } {
+        if attrs.Match(filter) != want {
+            t.Errorf("match %s: want %v", filter, want)
+        }
+    }
+}
+
+func TestContentType(t *testing.T) {
+    if DetectContentType("data.bin", "text/plain; charset=utf-8", nil) != "text/plain; charset=utf-8" {
+        t.Error("provided content type is not used")
+    }
+    if DetectContentType("image.png", "application/octet-stream", nil) != "image/png" {
+        t.Error("content type is not detected by extension")
+    }
+    hash := NewHash()
+    hash.Write([]byte("%PDF-1.4"))
+    hash.Write(make([]byte, 1024))
+    if len(hash.Head()) != 512 || DetectContentType("data", "", hash.Head()) != "application/pdf" {
+        t.Error("content type is not sniffed from the head")
+    }
+    for contentType, want := range map[string]bool{
+        "text/html; charset=utf-8":     true,
+        "image/svg+xml":                true,
+        "":                             true,
+        "image/png":                    false,
+        "text/plain":                   false,
+    } {
+        if IsActiveContentType(contentType) != want {
+            t.Errorf("active %s: want %v", contentType, want)
+        }
+    }
+    for contentType, want := range map[string]bool{
+        "application/zip":              true,
+        "video/mp4":                    true,
+        "image/jpeg":                   true,
+        "image/svg+xml":                false,
+        "text/plain; charset=utf-8":    false,
+        "application/json":             false,
+    } {
+        if IsCompressedContentType(contentType) != want {
+            t.Errorf("compressed %s: want %v", contentType, want)
+        }
+    }
+}
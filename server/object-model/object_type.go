@@ -0,0 +1,80 @@
+package objectModel
+
+import (
+    "mime"
+    "net/http"
+    "path"
+    "strings"
+)
+
+const defaultContentType string = "application/octet-stream"
+
+/* Types executed by browser in the origin of the store */
+var activeContentTypes = map[string]bool{
+    "text/html":                true,
+    "application/xhtml+xml":    true,
+    "image/svg+xml":            true,
+    "text/xml":                 true,
+    "application/xml":          true,
+    "text/javascript":          true,
+    "application/javascript":   true,
+}
+
+/* Types of data compressed by the format, not worth compressing again */
+var compressedContentTypes = map[string]bool{
+    "application/gzip":                 true,
+    "application/x-gzip":               true,
+    "application/zstd":                 true,
+    "application/x-bzip2":              true,
+    "application/x-xz":                 true,
+    "application/x-7z-compressed":      true,
+    "application/x-rar-compressed":     true,
+    "application/vnd.rar":              true,
+    "application/zip":                  true,
+    "application/java-archive":         true,
+    "application/x-java-archive":       true,
+    "application/vnd.android.package-archive": true,
+    "application/epub+zip":             true,
+    "application/pdf":                  true,
+}
+
+/* Return the provided content type if it is valid and specific, otherwise
+ * the type by file extension or sniffed from the first bytes of the data */
+func DetectContentType(name, provided string, head []byte) string {
+    if mediaType, params, err := mime.ParseMediaType(provided); err == nil && mediaType != defaultContentType {
+        return mime.FormatMediaType(mediaType, params)
+    }
+    if contentType := mime.TypeByExtension(path.Ext(name)); len(contentType) > 0 {
+        return contentType
+    }
+    return http.DetectContentType(head)
+}
+
+/* Return true if the content can run scripts when shown inline */
+func IsActiveContentType(contentType string) bool {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return true
+    }
+    return activeContentTypes[strings.ToLower(mediaType)]
+}
+
+/* Return true if the content is compressed by its format: archives,
+ * images, audio and video except uncompressed bitmaps and wave */
+func IsCompressedContentType(contentType string) bool {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return false
+    }
+    mediaType = strings.ToLower(mediaType)
+    if compressedContentTypes[mediaType] || strings.HasSuffix(mediaType, "+zip") ||
+            strings.HasPrefix(mediaType, "application/vnd.openxmlformats-officedocument.") {
+        return true
+    }
+    switch mediaType {
+        case "image/bmp", "image/x-ms-bmp", "image/svg+xml", "image/tiff", "audio/wav", "audio/x-wav", "audio/wave":
+            return false
+    }
+    kind := strings.SplitN(mediaType, "/", 2)[0]
+    return kind == "image" || kind == "audio" || kind == "video"
+}
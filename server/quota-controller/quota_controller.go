@@ -0,0 +1,190 @@
+package quotaController
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+
+    "store/config"
+    "store/server/acl-model"
+    "store/server/apikey-model"
+    "store/server/quota-model"
+)
+
+type Response struct {
+    Error       bool        `json:"error"`
+    Message     string      `json:"message,omitempty"`
+    Result      interface{} `json:"result,omitempty"`
+}
+
+/* Usage versus quotas of the user and buckets */
+type Usage struct {
+    User        quotaModel.Quota    `json:"user"`
+    Buckets     []quotaModel.Quota  `json:"buckets"`
+}
+
+type Controller struct {
+    config *config.Config
+    db *sqlx.DB
+    acl *aclModel.Model
+    quotas *quotaModel.Model
+}
+
+func sendError(context *gin.Context, err error) {
+    if err == nil {
+        err = errors.New("undefined")
+    }
+    log.Printf("%s\n", err)
+    response := Response{
+        Error: true,
+        Message: fmt.Sprintf("%s", err),
+        Result: nil,
+    }
+    context.JSON(http.StatusBadRequest, response)
+}
+
+func sendForbidden(context *gin.Context, bucketName string) {
+    context.JSON(http.StatusForbidden, Response{
+        Error: true,
+        Message: fmt.Sprintf("access denied to bucket %s", bucketName),
+    })
+}
+
+func sendResult(context *gin.Context, result interface{}) {
+    response := Response{
+        Error: false,
+        Message: "",
+        Result: result,
+    }
+    context.JSON(http.StatusOK, response)
+}
+
+/* Return true if the authenticated user can list the bucket */
+func (this *Controller) allow(context *gin.Context, bucketName string) bool {
+    if value, exists := context.Get("apikey"); exists {
+        if !value.(apikeyModel.Key).Allow(bucketName, aclModel.PermList) {
+            return false
+        }
+    }
+    if !this.config.ACLEnable {
+        return true
+    }
+    ok, err := this.acl.Check(context.GetString("username"), bucketName, aclModel.PermList)
+    if err != nil {
+        return false
+    }
+    return ok
+}
+
+type usageForm struct {
+    BucketName  string  `form:"bucket"   json:"bucket"`
+}
+
+/* Usage and quota of the authenticated user and quotas of the bucket,
+ * without the bucket quotas of all buckets allowed to list */
+func (this *Controller) Usage(context *gin.Context) {
+    var form usageForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    var usage Usage
+    var err error
+    usage.User, err = this.quotas.Find(quotaModel.KindUser, context.GetString("username"))
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+
+    bucketName := strings.Trim(form.BucketName, "/")
+    if len(bucketName) > 0 {
+        if !this.allow(context, bucketName) {
+            sendForbidden(context, bucketName)
+            return
+        }
+        bucket, err := this.quotas.Find(quotaModel.KindBucket, bucketName)
+        if err != nil {
+            sendError(context, err)
+            return
+        }
+        usage.Buckets, err = this.quotas.BucketQuotas(bucketName)
+        if err != nil {
+            sendError(context, err)
+            return
+        }
+        if bucket.MaxBytes == 0 && bucket.MaxObjects == 0 {
+            usage.Buckets = append([]quotaModel.Quota{ bucket }, usage.Buckets...)
+        }
+        sendResult(context, usage)
+        return
+    }
+
+    quotas, err := this.quotas.List()
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    usage.Buckets = []quotaModel.Quota{}
+    for _, quota := range quotas {
+        if quota.Kind == quotaModel.KindBucket && this.allow(context, quota.Name) {
+            usage.Buckets = append(usage.Buckets, quota)
+        }
+    }
+    sendResult(context, usage)
+}
+
+/* List all quotas with usage */
+func (this *Controller) List(context *gin.Context) {
+    quotas, err := this.quotas.List()
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, quotas)
+}
+
+type setForm struct {
+    Kind        string  `form:"kind"         json:"kind"         binding:"required"`
+    Name        string  `form:"name"         json:"name"         binding:"required"`
+    MaxBytes    int64   `form:"maxbytes"     json:"maxbytes"`
+    MaxObjects  int64   `form:"maxobjects"   json:"maxobjects"`
+}
+
+/* Set limits of the user or the bucket, zero limits remove the quota */
+func (this *Controller) Set(context *gin.Context) {
+    var form setForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    quota := quotaModel.Quota{
+        Kind: form.Kind,
+        Name: form.Name,
+        MaxBytes: form.MaxBytes,
+        MaxObjects: form.MaxObjects,
+    }
+    if err := this.quotas.Set(quota); err != nil {
+        sendError(context, err)
+        return
+    }
+    quota, err := this.quotas.Find(quota.Kind, quota.Name)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, quota)
+}
+
+func New(config *config.Config, db *sqlx.DB) *Controller {
+    return &Controller{
+        config: config,
+        db: db,
+        acl: aclModel.New(db),
+        quotas: quotaModel.New(db),
+    }
+}
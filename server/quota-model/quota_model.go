@@ -0,0 +1,353 @@
+package quotaModel
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "strings"
+
+    "github.com/jmoiron/sqlx"
+
+    "store/server/object-model"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS quotas (
+        id          INTEGER PRIMARY KEY,
+        kind        VARCHAR(16) NOT NULL,
+        name        VARCHAR(1024) NOT NULL,
+        maxbytes    INTEGER NOT NULL DEFAULT 0,
+        maxobjects  INTEGER NOT NULL DEFAULT 0,
+        UNIQUE (kind, name)
+    );`
+
+const (
+    KindUser    string = "user"
+    KindBucket  string = "bucket"
+)
+
+type Model struct {
+    db *sqlx.DB
+    objects *objectModel.Model
+}
+
+/* Limits of the user or the bucket with nested buckets, zero is unlimited.
+ * Bytes and objects are current usage */
+type Quota struct {
+    Id          int     `db:"id"         json:"-"`
+    Kind        string  `db:"kind"       json:"kind"`
+    Name        string  `db:"name"       json:"name"`
+    MaxBytes    int64   `db:"maxbytes"   json:"maxbytes"`
+    MaxObjects  int64   `db:"maxobjects" json:"maxobjects"`
+    Bytes       int64   `db:"-"          json:"bytes"`
+    Objects     int64   `db:"-"          json:"objects"`
+}
+
+/* Write does not fit in the quota, too large write never fits */
+type ExceededError struct {
+    Quota       Quota
+    TooLarge    bool
+}
+
+func (this ExceededError) Error() string {
+    quota := this.Quota
+    if this.TooLarge {
+        return fmt.Sprintf("size exceeds quota of %s %s, %d bytes", quota.Kind, quota.Name, quota.MaxBytes)
+    }
+    return fmt.Sprintf("quota of %s %s exceeded, used %d of %d bytes, %d of %d objects",
+                        quota.Kind, quota.Name, quota.Bytes, quota.MaxBytes, quota.Objects, quota.MaxObjects)
+}
+
+/* Bytes the write may take, negative is unlimited */
+type Allowance struct {
+    Bytes       int64
+    Err         error
+}
+
+/* Reader failing with the quota error when data exceeds the allowance */
+type LimitReader struct {
+    reader      io.Reader
+    limited     bool
+    left        int64
+    quotaErr    error
+    exceeded    bool
+}
+
+func (this *LimitReader) Read(data []byte) (int, error) {
+    if this.exceeded {
+        return 0, this.quotaErr
+    }
+    size, err := this.reader.Read(data)
+    if this.limited {
+        this.left -= int64(size)
+        if this.left < 0 {
+            this.exceeded = true
+            return size, this.quotaErr
+        }
+    }
+    return size, err
+}
+
+func (this *LimitReader) Close() error {
+    if closer, ok := this.reader.(io.Closer); ok {
+        return closer.Close()
+    }
+    return nil
+}
+
+/* Quota error if data exceeded the allowance. Readers reading
+ * through the limit reader do not always pass the error as is */
+func (this *LimitReader) Err() error {
+    if this.exceeded {
+        return this.quotaErr
+    }
+    return nil
+}
+
+func (this Allowance) Reader(reader io.Reader) *LimitReader {
+    return &LimitReader{
+        reader: reader,
+        limited: this.Bytes >= 0,
+        left: this.Bytes,
+        quotaErr: this.Err,
+    }
+}
+
+func (this *Model) Migrate() error {
+    _, err := this.db.Exec(schema)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Set limits of the quota, zero limits remove the quota */
+func (this *Model) Set(quota Quota) error {
+    quota.Name = strings.Trim(quota.Name, "/")
+    if quota.Kind != KindUser && quota.Kind != KindBucket {
+        return errors.New("wrong quota kind " + quota.Kind)
+    }
+    if len(quota.Name) == 0 {
+        return errors.New("empty quota name")
+    }
+    if quota.MaxBytes < 0 || quota.MaxObjects < 0 {
+        return errors.New("wrong quota limits")
+    }
+    if quota.MaxBytes == 0 && quota.MaxObjects == 0 {
+        request := `DELETE FROM quotas WHERE kind = $1 AND name = $2`
+        _, err := this.db.Exec(request, quota.Kind, quota.Name)
+        return err
+    }
+    request := `INSERT INTO quotas(kind, name, maxbytes, maxobjects) VALUES ($1, $2, $3, $4)
+                ON CONFLICT(kind, name) DO UPDATE SET
+                    maxbytes = excluded.maxbytes, maxobjects = excluded.maxobjects`
+    _, err := this.db.Exec(request, quota.Kind, quota.Name, quota.MaxBytes, quota.MaxObjects)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Fill current usage of the quota */
+func (this *Model) usage(quota Quota) (Quota, error) {
+    var usage objectModel.Usage
+    var err error
+    if quota.Kind == KindUser {
+        usage, err = this.objects.UserUsage(quota.Name)
+    } else {
+        usage, err = this.objects.BucketUsage(quota.Name)
+    }
+    quota.Bytes = usage.Bytes
+    quota.Objects = usage.Objects
+    return quota, err
+}
+
+/* Quota of the user or the bucket with usage, without limits if not set */
+func (this *Model) Find(kind, name string) (Quota, error) {
+    quota := Quota{ Kind: kind, Name: strings.Trim(name, "/") }
+    request := `SELECT * FROM quotas WHERE kind = $1 AND name = $2 LIMIT 1`
+    this.db.Get(&quota, request, quota.Kind, quota.Name)
+    return this.usage(quota)
+}
+
+/* List quotas with usage */
+func (this *Model) List() ([]Quota, error) {
+    quotas := []Quota{}
+    request := `SELECT * FROM quotas ORDER BY kind, name`
+    if err := this.db.Select(&quotas, request); err != nil {
+        log.Println(err)
+        return quotas, err
+    }
+    for i := range quotas {
+        var err error
+        if quotas[i], err = this.usage(quotas[i]); err != nil {
+            return quotas, err
+        }
+    }
+    return quotas, nil
+}
+
+/* Quotas of the bucket and parent buckets */
+func (this *Model) bucketQuotas(bucket string) ([]Quota, error) {
+    quotas := []Quota{}
+    request := `SELECT * FROM quotas WHERE kind = $1
+                    AND (name = $2 OR substr($2, 1, length(name) + 1) = name || '/')`
+    if err := this.db.Select(&quotas, request, KindBucket, strings.Trim(bucket, "/")); err != nil {
+        log.Println(err)
+        return quotas, err
+    }
+    return quotas, nil
+}
+
+/* Bucket quotas applied to writes in the bucket */
+func (this *Model) BucketQuotas(bucket string) ([]Quota, error) {
+    quotas, err := this.bucketQuotas(bucket)
+    if err != nil {
+        return quotas, err
+    }
+    for i := range quotas {
+        if quotas[i], err = this.usage(quotas[i]); err != nil {
+            return quotas, err
+        }
+    }
+    return quotas, nil
+}
+
+/* Byte limit of the user for any write, the request larger
+ * than the limit is rejected before reading */
+func (this *Model) UserLimit(username string) (Allowance, error) {
+    allowance := Allowance{ Bytes: -1 }
+    quota, err := this.Find(KindUser, username)
+    if err != nil {
+        return allowance, err
+    }
+    if quota.MaxBytes > 0 {
+        allowance = Allowance{ Bytes: quota.MaxBytes, Err: ExceededError{ Quota: quota, TooLarge: true } }
+    }
+    return allowance, nil
+}
+
+/* Check the write of the file by the user against quotas of the user and
+ * the bucket, size is negative if unknown. Replaced file is freed by the write */
+func (this *Model) Check(username, path string, size int64) (Allowance, error) {
+    allowance := Allowance{ Bytes: -1 }
+    bucket, _ := objectModel.Split(path)
+    quotas, err := this.bucketQuotas(bucket)
+    if err != nil {
+        return allowance, err
+    }
+    userQuota, err := this.Find(KindUser, username)
+    if err != nil {
+        return allowance, err
+    }
+    if userQuota.MaxBytes > 0 || userQuota.MaxObjects > 0 {
+        quotas = append(quotas, userQuota)
+    }
+    replaced, err := this.objects.Find(path)
+    found := err == nil
+
+    for _, quota := range quotas {
+        if quota, err = this.usage(quota); err != nil {
+            return allowance, err
+        }
+        var freeBytes, freeObjects int64
+        if found && (quota.Kind == KindBucket || replaced.Owner == quota.Name) {
+            freeBytes = replaced.Size
+            freeObjects = 1
+        }
+        if quota.MaxObjects > 0 && quota.Objects - freeObjects + 1 > quota.MaxObjects {
+            return allowance, ExceededError{ Quota: quota }
+        }
+        if quota.MaxBytes == 0 {
+            continue
+        }
+        if size > quota.MaxBytes {
+            return allowance, ExceededError{ Quota: quota, TooLarge: true }
+        }
+        left := quota.MaxBytes - quota.Bytes + freeBytes
+        if size > left || left < 0 {
+            return allowance, ExceededError{ Quota: quota }
+        }
+        if allowance.Bytes < 0 || left < allowance.Bytes {
+            allowance = Allowance{ Bytes: left, Err: ExceededError{ Quota: quota } }
+        }
+    }
+    return allowance, nil
+}
+
+/* Check the write of several files by the user, sizes are keyed by path.
+ * Quotas apply to the sum of the files, replaced files are freed by the write */
+func (this *Model) CheckFiles(username string, sizes map[string]int64) error {
+    quotas := make(map[string]Quota)
+    for path := range sizes {
+        bucket, _ := objectModel.Split(path)
+        list, err := this.bucketQuotas(bucket)
+        if err != nil {
+            return err
+        }
+        for _, quota := range list {
+            quotas[quota.Kind + ":" + quota.Name] = quota
+        }
+    }
+    userQuota, err := this.Find(KindUser, username)
+    if err != nil {
+        return err
+    }
+    if userQuota.MaxBytes > 0 || userQuota.MaxObjects > 0 {
+        quotas[KindUser + ":" + userQuota.Name] = userQuota
+    }
+    if len(quotas) == 0 {
+        return nil
+    }
+
+    for _, quota := range quotas {
+        if quota, err = this.usage(quota); err != nil {
+            return err
+        }
+        var addBytes, addObjects int64
+        for path, size := range sizes {
+            bucket, _ := objectModel.Split(path)
+            if quota.Kind == KindBucket && bucket != quota.Name && !strings.HasPrefix(bucket, quota.Name + "/") {
+                continue
+            }
+            addBytes += size
+            addObjects++
+            replaced, err := this.objects.Find(path)
+            if err == nil && (quota.Kind == KindBucket || replaced.Owner == quota.Name) {
+                addBytes -= replaced.Size
+                addObjects--
+            }
+        }
+        if quota.MaxObjects > 0 && quota.Objects + addObjects > quota.MaxObjects && addObjects > 0 {
+            return ExceededError{ Quota: quota }
+        }
+        if quota.MaxBytes > 0 && quota.Bytes + addBytes > quota.MaxBytes && addBytes > 0 {
+            return ExceededError{ Quota: quota }
+        }
+    }
+    return nil
+}
+
+/* Move bucket quotas of the bucket and nested buckets to the new name */
+func (this *Model) Rename(oldBucket, newBucket string) error {
+    oldBucket = strings.Trim(oldBucket, "/")
+    newBucket = strings.Trim(newBucket, "/")
+    request := `UPDATE quotas SET name = $1 || substr(name, length($2) + 1)
+                WHERE kind = $3 AND (name = $2 OR substr(name, 1, length($2) + 1) = $2 || '/')`
+    if _, err := this.db.Exec(request, newBucket, oldBucket, KindBucket); err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func New(db *sqlx.DB) *Model {
+    model := Model{
+        db: db,
+        objects: objectModel.New(db),
+    }
+    return &model
+}
@@ -0,0 +1,37 @@
+package quotaModel
+
+import (
+    "bytes"
+    "io/ioutil"
+    "strings"
+    "testing"
+)
+
+func TestLimitReader(t *testing.T) {
+    quotaErr := ExceededError{ Quota: Quota{ Kind: KindBucket, Name: "foobar", MaxBytes: 4 } }
+
+    reader := Allowance{ Bytes: 4, Err: quotaErr }.Reader(strings.NewReader("data"))
+    if data, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(data, []byte("data")) {
+        t.Errorf("data within allowance is not read: %v", err)
+    }
+
+    reader = Allowance{ Bytes: 4, Err: quotaErr }.Reader(strings.NewReader("data1"))
+    if _, err := ioutil.ReadAll(reader); err != quotaErr || reader.Err() != quotaErr {
+        t.Errorf("data over allowance is read: %v", err)
+    }
+
+    reader = Allowance{ Bytes: -1 }.Reader(strings.NewReader("data1"))
+    if _, err := ioutil.ReadAll(reader); err != nil || reader.Err() != nil {
+        t.Errorf("unlimited reader fails: %v", err)
+    }
+}
+
+func TestExceededError(t *testing.T) {
+    quota := Quota{ Kind: KindUser, Name: "bob", MaxBytes: 100, Bytes: 90 }
+    if !strings.Contains(ExceededError{ Quota: quota }.Error(), "used 90 of 100 bytes") {
+        t.Errorf("wrong message of exceeded quota")
+    }
+    if !strings.Contains(ExceededError{ Quota: quota, TooLarge: true }.Error(), "size exceeds quota of user bob") {
+        t.Errorf("wrong message of too large write")
+    }
+}
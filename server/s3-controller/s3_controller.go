@@ -0,0 +1,1080 @@
+package s3Controller
+
+import (
+    "bytes"
+    "crypto/md5"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+
+    "store/config"
+    "store/server/acl-model"
+    "store/server/object-model"
+    "store/server/quota-model"
+    "store/server/s3key-model"
+    "store/server/storage-backend"
+    "store/server/version-model"
+    "store/tools"
+)
+
+const (
+    s3Namespace     string = "http://s3.amazonaws.com/doc/2006-03-01/"
+    defaultMaxKeys  int = 1000
+    maxDeleteKeys   int = 1000
+    /* Body of multi-object delete, enough for the key limit */
+    maxDeleteBody   int64 = 2 << 20
+)
+
+/* Bucket and object subresources which are not supported */
+var unsupportedQuery = []string{
+    "accelerate", "acl", "analytics", "cors", "encryption", "intelligent-tiering",
+    "inventory", "legal-hold", "lifecycle", "logging", "metrics", "notification",
+    "object-lock", "ownershipControls", "partNumber", "policy", "policyStatus",
+    "publicAccessBlock", "replication", "requestPayment", "restore", "retention",
+    "select", "tagging", "torrent", "uploadId", "uploads", "versionId", "versioning",
+    "versions", "website",
+}
+
+type Controller struct {
+    config *config.Config
+    db *sqlx.DB
+    store storageBackend.Backend
+    keys *s3keyModel.Model
+    acl *aclModel.Model
+    objects *objectModel.Model
+    versions *versionModel.Model
+    quotas *quotaModel.Model
+}
+
+type apiError struct {
+    Status      int
+    Code        string
+    Message     string
+}
+
+func (this apiError) Error() string {
+    return this.Message
+}
+
+var (
+    errAccessDenied     = apiError{ http.StatusForbidden, "AccessDenied", "Access Denied" }
+    errSignature        = apiError{ http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided" }
+    errInvalidKeyId     = apiError{ http.StatusForbidden, "InvalidAccessKeyId", "The access key Id you provided does not exist in our records" }
+    errSkewed           = apiError{ http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large" }
+    errNoSuchBucket     = apiError{ http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist" }
+    errNoSuchKey        = apiError{ http.StatusNotFound, "NoSuchKey", "The specified key does not exist" }
+    errBucketExists     = apiError{ http.StatusConflict, "BucketAlreadyOwnedByYou", "The bucket you tried to create already exists" }
+    errBucketNotEmpty   = apiError{ http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty" }
+    errBucketName       = apiError{ http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid" }
+    errKeyName          = apiError{ http.StatusBadRequest, "InvalidArgument", "The specified key is not valid" }
+    errBadDigest        = apiError{ http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received" }
+    errContentSHA256    = apiError{ http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided x-amz-content-sha256 header does not match what was computed" }
+    errMalformedXML     = apiError{ http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed" }
+    errInvalidArgument  = apiError{ http.StatusBadRequest, "InvalidArgument", "Invalid argument" }
+    errPrecondition     = apiError{ http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold" }
+    errNotImplemented   = apiError{ http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented" }
+    errTooLarge         = apiError{ http.StatusRequestEntityTooLarge, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size" }
+    errQuotaExceeded    = apiError{ http.StatusInsufficientStorage, "QuotaExceeded", "The storage quota of the user or the bucket is exceeded" }
+    errIncompleteBody   = apiError{ http.StatusBadRequest, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header" }
+    errInternal         = apiError{ http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again" }
+)
+
+type errorResponse struct {
+    XMLName     xml.Name    `xml:"Error"`
+    Code        string      `xml:"Code"`
+    Message     string      `xml:"Message"`
+    Resource    string      `xml:"Resource"`
+    RequestId   string      `xml:"RequestId"`
+}
+
+type owner struct {
+    ID              string  `xml:"ID"`
+    DisplayName     string  `xml:"DisplayName"`
+}
+
+type bucketItem struct {
+    Name            string  `xml:"Name"`
+    CreationDate    string  `xml:"CreationDate"`
+}
+
+type listBucketsResponse struct {
+    XMLName     xml.Name        `xml:"ListAllMyBucketsResult"`
+    Xmlns       string          `xml:"xmlns,attr"`
+    Owner       owner           `xml:"Owner"`
+    Buckets     []bucketItem    `xml:"Buckets>Bucket"`
+}
+
+type objectItem struct {
+    Key             string  `xml:"Key"`
+    LastModified    string  `xml:"LastModified"`
+    ETag            string  `xml:"ETag"`
+    Size            int64   `xml:"Size"`
+    StorageClass    string  `xml:"StorageClass"`
+    Owner           *owner  `xml:"Owner,omitempty"`
+}
+
+type commonPrefix struct {
+    Prefix          string  `xml:"Prefix"`
+}
+
+type listObjectsResponse struct {
+    XMLName                 xml.Name        `xml:"ListBucketResult"`
+    Xmlns                   string          `xml:"xmlns,attr"`
+    Name                    string          `xml:"Name"`
+    Prefix                  string          `xml:"Prefix"`
+    Marker                  *string         `xml:"Marker,omitempty"`
+    NextMarker              string          `xml:"NextMarker,omitempty"`
+    StartAfter              string          `xml:"StartAfter,omitempty"`
+    ContinuationToken       string          `xml:"ContinuationToken,omitempty"`
+    NextContinuationToken   string          `xml:"NextContinuationToken,omitempty"`
+    KeyCount                *int            `xml:"KeyCount,omitempty"`
+    MaxKeys                 int             `xml:"MaxKeys"`
+    Delimiter               string          `xml:"Delimiter,omitempty"`
+    EncodingType            string          `xml:"EncodingType,omitempty"`
+    IsTruncated             bool            `xml:"IsTruncated"`
+    Contents                []objectItem    `xml:"Contents"`
+    CommonPrefixes          []commonPrefix  `xml:"CommonPrefixes"`
+}
+
+type locationResponse struct {
+    XMLName     xml.Name    `xml:"LocationConstraint"`
+    Xmlns       string      `xml:"xmlns,attr"`
+    Location    string      `xml:",chardata"`
+}
+
+type deleteRequest struct {
+    XMLName     xml.Name    `xml:"Delete"`
+    Quiet       bool        `xml:"Quiet"`
+    Objects     []struct {
+        Key     string      `xml:"Key"`
+    }                       `xml:"Object"`
+}
+
+type deletedItem struct {
+    Key         string      `xml:"Key"`
+}
+
+type deleteErrorItem struct {
+    Key         string      `xml:"Key"`
+    Code        string      `xml:"Code"`
+    Message     string      `xml:"Message"`
+}
+
+type deleteResponse struct {
+    XMLName     xml.Name            `xml:"DeleteResult"`
+    Xmlns       string              `xml:"xmlns,attr"`
+    Deleted     []deletedItem       `xml:"Deleted"`
+    Errors      []deleteErrorItem   `xml:"Error"`
+}
+
+/* Object found by bucket walk */
+type object struct {
+    Key         string
+    Size        int64
+    ModTime     time.Time
+}
+
+func requestId() string {
+    return strconv.FormatInt(time.Now().UnixNano(), 16)
+}
+
+func sendError(context *gin.Context, err error) {
+    apiErr, ok := err.(apiError)
+    if !ok {
+        log.Printf("s3: %s\n", err)
+        apiErr = errInternal
+    }
+    response := errorResponse{
+        Code: apiErr.Code,
+        Message: apiErr.Message,
+        Resource: context.Request.URL.Path,
+        RequestId: requestId(),
+    }
+    if context.Request.Method == http.MethodHead {
+        context.Status(apiErr.Status)
+        return
+    }
+    sendXML(context, apiErr.Status, response)
+}
+
+func sendXML(context *gin.Context, status int, data interface{}) {
+    out, err := xml.Marshal(data)
+    if err != nil {
+        log.Printf("s3: %s\n", err)
+        context.Status(http.StatusInternalServerError)
+        return
+    }
+    context.Data(status, "application/xml", append([]byte(xml.Header), out...))
+}
+
+const metaHeaderPrefix string = "X-Amz-Meta-"
+
+/* Pseudo ETag for objects without stored digest */
+func objectETag(size int64, modTime time.Time) string {
+    return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+}
+
+/* ETag from stored MD5 of the object, metadata of the directory is cached */
+func (this *Controller) storedETag(cache map[string]map[string]objectModel.Object,
+                                    path string, size int64, modTime time.Time) string {
+    bucket, name := objectModel.Split(path)
+    objects, exists := cache[bucket]
+    if !exists {
+        objects, _ = this.objects.List(bucket)
+        cache[bucket] = objects
+    }
+    if object, exists := objects[name]; exists && object.Matches(size, modTime) && len(object.MD5) > 0 {
+        return object.ETag()
+    }
+    return objectETag(size, modTime)
+}
+
+func validBucketName(name string) bool {
+    if len(name) < 1 || len(name) > 63 {
+        return false
+    }
+    if strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\") {
+        return false
+    }
+    return true
+}
+
+func (this *Controller) bucketKey(bucketName string) (string, error) {
+    if !validBucketName(bucketName) {
+        return "", errBucketName
+    }
+    return bucketName, nil
+}
+
+func (this *Controller) fileKey(bucketName, key string) (string, error) {
+    bucketKey, err := this.bucketKey(bucketName)
+    if err != nil {
+        return "", err
+    }
+    if len(key) == 0 || len(key) > 1024 {
+        return "", errKeyName
+    }
+    /* Keys with empty or dot components are refused, so the ACL
+     * check and the operation use the same clean key */
+    fileKey := storageBackend.Join(bucketKey, key)
+    if fileKey != bucketKey + "/" + strings.TrimSuffix(key, "/") {
+        return "", errKeyName
+    }
+    return fileKey, nil
+}
+
+func (this *Controller) isFile(fileKey string) bool {
+    return storageBackend.FileExists(this.store, fileKey)
+}
+
+func (this *Controller) isDir(dirKey string) bool {
+    fi, err := this.store.Stat(dirKey)
+    return err == nil && fi.IsDir()
+}
+
+/* Return true if the user has the permission on the bucket.
+ * Objects with nested keys belong to the nested bucket directory,
+ * wrong keys are checked with the bucket and refused by the operation */
+func (this *Controller) allow(key s3keyModel.Key, bucketName, objectKey, perm string) bool {
+    if !this.config.ACLEnable || key.IsAdmin {
+        return true
+    }
+    if fileKey, err := this.fileKey(bucketName, objectKey); err == nil {
+        if strings.HasSuffix(objectKey, "/") {
+            bucketName = fileKey
+        } else {
+            bucketName = storageBackend.Dir(fileKey)
+        }
+    }
+    ok, err := this.acl.Check(key.Username, bucketName, perm)
+    if err != nil {
+        return false
+    }
+    return ok
+}
+
+/* Middleware serve SigV4 signed requests as S3 API and pass others */
+func (this *Controller) Middleware(context *gin.Context) {
+    if !this.config.S3Enable || !IsSigned(context.Request) {
+        context.Next()
+        return
+    }
+    this.Serve(context)
+    context.Abort()
+}
+
+func (this *Controller) authenticate(context *gin.Context) (*signature, s3keyModel.Key, error) {
+    var key s3keyModel.Key
+
+    sign, err := parseSignature(context.Request)
+    if err != nil {
+        log.Printf("s3: %s\n", err)
+        return nil, key, errAccessDenied
+    }
+    if sign.Service != "s3" {
+        return nil, key, errAccessDenied
+    }
+    if err := sign.CheckTime(time.Now()); err != nil {
+        log.Printf("s3: %s\n", err)
+        if sign.Presigned {
+            return nil, key, errAccessDenied
+        }
+        return nil, key, errSkewed
+    }
+    key, err = this.keys.Find(sign.AccessKey)
+    if err != nil {
+        return nil, key, errInvalidKeyId
+    }
+    if err := sign.Verify(context.Request, key.SecretKey); err != nil {
+        log.Printf("s3: %s for access key %s\n", err, sign.AccessKey)
+        return nil, key, errSignature
+    }
+    return sign, key, nil
+}
+
+/* Return request body reader verified against the signature */
+func (this *Controller) bodyReader(context *gin.Context, sign *signature, key s3keyModel.Key) io.Reader {
+    request := context.Request
+    switch sign.PayloadHash {
+        case unsignedPayload:
+            return request.Body
+        case streamingPayload:
+            return newChunkReader(request.Body, sign, key.SecretKey)
+    }
+    return newPayloadReader(request.Body, sign.PayloadHash)
+}
+
+func (this *Controller) Serve(context *gin.Context) {
+    sign, key, err := this.authenticate(context)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    context.Set("username", key.Username)
+
+    request := context.Request
+    query := request.URL.Query()
+    for _, name := range unsupportedQuery {
+        if _, exists := query[name]; exists {
+            sendError(context, errNotImplemented)
+            return
+        }
+    }
+
+    path := strings.TrimPrefix(request.URL.Path, "/")
+    parts := strings.SplitN(path, "/", 2)
+    bucketName := parts[0]
+    objectKey := ""
+    if len(parts) > 1 {
+        objectKey = parts[1]
+    }
+
+    if len(bucketName) == 0 {
+        if request.Method != http.MethodGet {
+            sendError(context, errNotImplemented)
+            return
+        }
+        this.listBuckets(context, key)
+        return
+    }
+
+    perm := aclModel.PermRead
+    switch request.Method {
+        case http.MethodPut:
+            perm = aclModel.PermWrite
+        case http.MethodDelete:
+            perm = aclModel.PermDelete
+        case http.MethodPost:
+            perm = aclModel.PermDelete
+        default:
+            if len(objectKey) == 0 {
+                perm = aclModel.PermList
+            }
+    }
+    if !this.allow(key, bucketName, objectKey, perm) {
+        sendError(context, errAccessDenied)
+        return
+    }
+
+    if len(objectKey) == 0 {
+        switch request.Method {
+            case http.MethodGet:
+                if _, exists := query["location"]; exists {
+                    this.getBucketLocation(context, bucketName)
+                    return
+                }
+                this.listObjects(context, bucketName, key)
+            case http.MethodHead:
+                this.headBucket(context, bucketName)
+            case http.MethodPut:
+                this.createBucket(context, bucketName)
+            case http.MethodDelete:
+                this.deleteBucket(context, bucketName)
+            case http.MethodPost:
+                if _, exists := query["delete"]; exists {
+                    this.deleteObjects(context, bucketName, key, this.bodyReader(context, sign, key))
+                    return
+                }
+                sendError(context, errNotImplemented)
+            default:
+                sendError(context, errNotImplemented)
+        }
+        return
+    }
+
+    switch request.Method {
+        case http.MethodGet, http.MethodHead:
+            this.getObject(context, bucketName, objectKey)
+        case http.MethodPut:
+            if len(request.Header.Get("X-Amz-Copy-Source")) > 0 {
+                sendError(context, errNotImplemented)
+                return
+            }
+            this.putObject(context, bucketName, objectKey, key, this.bodyReader(context, sign, key))
+        case http.MethodDelete:
+            this.deleteObject(context, bucketName, objectKey)
+        default:
+            sendError(context, errNotImplemented)
+    }
+}
+
+func (this *Controller) listBuckets(context *gin.Context, key s3keyModel.Key) {
+    files, err := storageBackend.ReadDir(this.store, "")
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    response := listBucketsResponse{
+        Xmlns: s3Namespace,
+        Owner: owner{ ID: key.Username, DisplayName: key.Username },
+        Buckets: []bucketItem{},
+    }
+    for _, file := range files {
+        if !file.IsDir() || !validBucketName(file.Name()) {
+            continue
+        }
+        if !this.allow(key, file.Name(), "", aclModel.PermList) {
+            continue
+        }
+        response.Buckets = append(response.Buckets, bucketItem{
+            Name: file.Name(),
+            CreationDate: file.ModTime().UTC().Format(time.RFC3339),
+        })
+    }
+    sendXML(context, http.StatusOK, response)
+}
+
+func (this *Controller) getBucketLocation(context *gin.Context, bucketName string) {
+    bucketKey, err := this.bucketKey(bucketName)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if !this.isDir(bucketKey) {
+        sendError(context, errNoSuchBucket)
+        return
+    }
+    location := this.config.S3Region
+    if location == "us-east-1" {
+        location = ""
+    }
+    sendXML(context, http.StatusOK, locationResponse{ Xmlns: s3Namespace, Location: location })
+}
+
+func (this *Controller) headBucket(context *gin.Context, bucketName string) {
+    bucketKey, err := this.bucketKey(bucketName)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if !this.isDir(bucketKey) {
+        sendError(context, errNoSuchBucket)
+        return
+    }
+    context.Status(http.StatusOK)
+}
+
+func (this *Controller) createBucket(context *gin.Context, bucketName string) {
+    bucketKey, err := this.bucketKey(bucketName)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if this.isDir(bucketKey) {
+        sendError(context, errBucketExists)
+        return
+    }
+    if err := this.store.MakeDir(bucketKey); err != nil {
+        sendError(context, err)
+        return
+    }
+    context.Header("Location", "/" + bucketName)
+    context.Status(http.StatusOK)
+}
+
+func (this *Controller) deleteBucket(context *gin.Context, bucketName string) {
+    bucketKey, err := this.bucketKey(bucketName)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if !this.isDir(bucketKey) {
+        sendError(context, errNoSuchBucket)
+        return
+    }
+    objects, err := this.walkBucket(bucketKey, "", "")
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if len(objects) > 0 {
+        sendError(context, errBucketNotEmpty)
+        return
+    }
+    if err := this.store.DeleteAll(bucketKey); err != nil {
+        sendError(context, err)
+        return
+    }
+    context.Status(http.StatusNoContent)
+}
+
+/* Return sorted list of objects in the bucket with key prefix, after the key */
+func (this *Controller) walkBucket(bucketKey, prefix, after string) ([]object, error) {
+    list := []object{}
+
+    /* Start walk from the deepest directory covered by prefix */
+    root := bucketKey
+    if index := strings.LastIndex(prefix, "/"); index >= 0 {
+        root = storageBackend.Join(bucketKey, prefix[:index])
+    }
+    if root != bucketKey && !strings.HasPrefix(root, bucketKey + "/") {
+        return list, nil
+    }
+    if !this.isDir(root) {
+        return list, nil
+    }
+
+    err := storageBackend.Walk(this.store, root,
+        func(fileKey string, info os.FileInfo, err error) error {
+            if err != nil {
+                return err
+            }
+            if !info.Mode().IsRegular() {
+                return nil
+            }
+            key := strings.TrimPrefix(fileKey, bucketKey + "/")
+            if !strings.HasPrefix(key, prefix) || key <= after {
+                return nil
+            }
+            list = append(list, object{
+                Key: key,
+                Size: info.Size(),
+                ModTime: info.ModTime(),
+            })
+            return nil
+        })
+    if err != nil {
+        return list, err
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Key < list[j].Key
+    })
+    return list, nil
+}
+
+func (this *Controller) listObjects(context *gin.Context, bucketName string, key s3keyModel.Key) {
+    query := context.Request.URL.Query()
+
+    bucketKey, err := this.bucketKey(bucketName)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if !this.isDir(bucketKey) {
+        sendError(context, errNoSuchBucket)
+        return
+    }
+
+    prefix := query.Get("prefix")
+    delimiter := query.Get("delimiter")
+    encodingType := query.Get("encoding-type")
+    if len(encodingType) > 0 && encodingType != "url" {
+        sendError(context, errInvalidArgument)
+        return
+    }
+    maxKeys := defaultMaxKeys
+    if value := query.Get("max-keys"); len(value) > 0 {
+        maxKeys, err = strconv.Atoi(value)
+        if err != nil || maxKeys < 0 {
+            sendError(context, errInvalidArgument)
+            return
+        }
+        if maxKeys > defaultMaxKeys {
+            maxKeys = defaultMaxKeys
+        }
+    }
+
+    listV2 := query.Get("list-type") == "2"
+    after := ""
+    if listV2 {
+        after = query.Get("start-after")
+        if token := query.Get("continuation-token"); len(token) > 0 {
+            data, err := base64.RawURLEncoding.DecodeString(token)
+            if err != nil {
+                sendError(context, errInvalidArgument)
+                return
+            }
+            after = string(data)
+        }
+    } else {
+        after = query.Get("marker")
+    }
+
+    objects, err := this.walkBucket(bucketKey, prefix, after)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    etagCache := make(map[string]map[string]objectModel.Object)
+
+    encode := func(str string) string {
+        if encodingType == "url" {
+            return strings.Replace(url.QueryEscape(str), "+", "%20", -1)
+        }
+        return str
+    }
+
+    response := listObjectsResponse{
+        Xmlns: s3Namespace,
+        Name: bucketName,
+        Prefix: encode(prefix),
+        Delimiter: encode(delimiter),
+        MaxKeys: maxKeys,
+        EncodingType: encodingType,
+        Contents: []objectItem{},
+        CommonPrefixes: []commonPrefix{},
+    }
+
+    var fetchOwner *owner
+    if !listV2 || query.Get("fetch-owner") == "true" {
+        fetchOwner = &owner{ ID: key.Username, DisplayName: key.Username }
+    }
+
+    count := 0
+    last := ""
+    seenPrefixes := make(map[string]bool)
+    for _, item := range objects {
+        if len(delimiter) > 0 {
+            rest := strings.TrimPrefix(item.Key, prefix)
+            if index := strings.Index(rest, delimiter); index >= 0 {
+                common := prefix + rest[:index + len(delimiter)]
+                if seenPrefixes[common] || common <= after {
+                    continue
+                }
+                if count >= maxKeys {
+                    response.IsTruncated = true
+                    break
+                }
+                seenPrefixes[common] = true
+                response.CommonPrefixes = append(response.CommonPrefixes, commonPrefix{ Prefix: encode(common) })
+                count++
+                last = common
+                continue
+            }
+        }
+        if count >= maxKeys {
+            response.IsTruncated = true
+            break
+        }
+        response.Contents = append(response.Contents, objectItem{
+            Key: encode(item.Key),
+            LastModified: item.ModTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+            ETag: this.storedETag(etagCache, bucketName + "/" + item.Key, item.Size, item.ModTime),
+            Size: item.Size,
+            StorageClass: "STANDARD",
+            Owner: fetchOwner,
+        })
+        count++
+        last = item.Key
+    }
+
+    if listV2 {
+        response.KeyCount = &count
+        response.StartAfter = encode(query.Get("start-after"))
+        response.ContinuationToken = query.Get("continuation-token")
+        if response.IsTruncated {
+            response.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+        }
+    } else {
+        marker := encode(query.Get("marker"))
+        response.Marker = &marker
+        if response.IsTruncated && len(delimiter) > 0 {
+            response.NextMarker = encode(last)
+        }
+    }
+    sendXML(context, http.StatusOK, response)
+}
+
+func (this *Controller) getObject(context *gin.Context, bucketName, objectKey string) {
+    fileKey, err := this.fileKey(bucketName, objectKey)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    bucketKey, _ := this.bucketKey(bucketName)
+    if !this.isDir(bucketKey) {
+        sendError(context, errNoSuchBucket)
+        return
+    }
+
+    file, err := this.store.Open(fileKey)
+    if err != nil {
+        sendError(context, errNoSuchKey)
+        return
+    }
+    defer file.Close()
+
+    fileInfo, err := file.Stat()
+    if err != nil || !fileInfo.Mode().IsRegular() {
+        sendError(context, errNoSuchKey)
+        return
+    }
+    /* Encrypted data without the key fails before headers are sent */
+    if _, err := file.ReadAt(make([]byte, 1), 0); err != nil && err != io.EOF {
+        log.Println(err)
+        sendError(context, errInternal)
+        return
+    }
+
+    writer := context.Writer
+    etag := objectETag(fileInfo.Size(), fileInfo.ModTime())
+    contentType := "application/octet-stream"
+    if object, err := this.objects.Find(fileKey); err == nil && object.Valid(fileInfo) {
+        etag = object.ETag()
+        writer.Header().Set("Digest", object.Digest())
+        if len(object.ContentType) > 0 {
+            contentType = object.ContentType
+        }
+    }
+    writer.Header().Set("ETag", etag)
+    if attrs, err := this.objects.Attributes(fileKey); err == nil {
+        attrs.SetHeaders(writer.Header(), metaHeaderPrefix)
+    }
+    writer.Header().Set("Content-Type", contentType)
+    writer.Header().Set("X-Content-Type-Options", "nosniff")
+    writer.Header().Set("Accept-Ranges", "bytes")
+    http.ServeContent(writer, context.Request, fileInfo.Name(), fileInfo.ModTime(), file)
+}
+
+/* Request body keeping the read error, so failed reads of the
+ * request are told from failed writes of the store */
+type requestBody struct {
+    reader      io.Reader
+    err         error
+}
+
+func (this *requestBody) Read(data []byte) (int, error) {
+    size, err := this.reader.Read(data)
+    if err != nil && err != io.EOF {
+        this.err = err
+    }
+    return size, err
+}
+
+/* S3 error of the request body read */
+func bodyError(err error) error {
+    if _, ok := err.(payloadError); ok {
+        return errContentSHA256
+    }
+    return errIncompleteBody
+}
+
+/* Key is taken by the directory or a file on the path of the key */
+func (this *Controller) keyConflict(bucketKey, fileKey string) bool {
+    if this.isDir(fileKey) {
+        return true
+    }
+    for dir := storageBackend.Dir(fileKey); strings.HasPrefix(dir, bucketKey + "/"); dir = storageBackend.Dir(dir) {
+        if this.isFile(dir) {
+            return true
+        }
+    }
+    return false
+}
+
+/* S3 error of the quota check */
+func quotaError(err error) error {
+    if quotaErr, ok := err.(quotaModel.ExceededError); ok {
+        log.Printf("s3: %s\n", err)
+        if quotaErr.TooLarge {
+            return errTooLarge
+        }
+        return errQuotaExceeded
+    }
+    return err
+}
+
+func (this *Controller) putObject(context *gin.Context, bucketName, objectKey string,
+                                        key s3keyModel.Key, reader io.Reader) {
+    fileKey, err := this.fileKey(bucketName, objectKey)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    bucketKey, _ := this.bucketKey(bucketName)
+    if !this.isDir(bucketKey) {
+        sendError(context, errNoSuchBucket)
+        return
+    }
+
+    var expectedMD5 []byte
+    if value := context.Request.Header.Get("Content-MD5"); len(value) > 0 {
+        expectedMD5, err = base64.StdEncoding.DecodeString(value)
+        if err != nil || len(expectedMD5) != md5.Size {
+            sendError(context, errBadDigest)
+            return
+        }
+    }
+
+    /* User metadata and tags */
+    var attrs objectModel.Attributes
+    attrs.Meta = objectModel.ParseMetaHeaders(context.Request.Header, metaHeaderPrefix)
+    attrs.Tags, err = objectModel.ParseTags(context.Request.Header.Get("X-Amz-Tagging"))
+    if err == nil {
+        err = attrs.Validate()
+    }
+    if err != nil {
+        sendError(context, errInvalidArgument)
+        return
+    }
+
+    /* Folder placeholder object */
+    if strings.HasSuffix(objectKey, "/") {
+        if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+            sendError(context, bodyError(err))
+            return
+        }
+        if err := this.store.MakeDir(fileKey); err != nil {
+            sendError(context, err)
+            return
+        }
+        context.Header("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+        context.Status(http.StatusOK)
+        return
+    }
+
+    /* Conditional write does not overwrite existing object */
+    noReplace := this.config.WriteOnce
+    if value := context.Request.Header.Get("If-None-Match"); len(value) > 0 {
+        if value != "*" {
+            sendError(context, errNotImplemented)
+            return
+        }
+        noReplace = true
+    }
+    if noReplace && this.isFile(fileKey) {
+        sendError(context, errPrecondition)
+        return
+    }
+
+    /* Check quotas with the declared length, data is limited while received */
+    size := context.Request.ContentLength
+    if value := context.Request.Header.Get("X-Amz-Decoded-Content-Length"); len(value) > 0 {
+        size, _ = strconv.ParseInt(value, 10, 64)
+    }
+    allowance, err := this.quotas.Check(key.Username, fileKey, size)
+    if err != nil {
+        sendError(context, quotaError(err))
+        return
+    }
+    body := &requestBody{ reader: reader }
+    limited := allowance.Reader(body)
+
+    /* Object is written to temporary data and moved when verified */
+    tmpKey, err := storageBackend.TempKey("put-")
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+
+    hash := objectModel.NewHash()
+    _, err = this.store.Put(tmpKey, io.TeeReader(limited, hash), false)
+    if err != nil {
+        this.store.Delete(tmpKey)
+        log.Printf("s3: put %s/%s: %s\n", bucketName, objectKey, err)
+        if limited.Err() != nil {
+            sendError(context, quotaError(limited.Err()))
+            return
+        }
+        if body.err != nil {
+            sendError(context, bodyError(body.err))
+            return
+        }
+        sendError(context, errInternal)
+        return
+    }
+
+    sum, _ := hex.DecodeString(hash.MD5())
+    if expectedMD5 != nil && !bytes.Equal(sum, expectedMD5) {
+        this.store.Delete(tmpKey)
+        sendError(context, errBadDigest)
+        return
+    }
+    /* Keep replaced data as version */
+    commit := func() error {
+        return this.store.Move(tmpKey, fileKey, noReplace)
+    }
+    if noReplace {
+        err = commit()
+    } else {
+        err = this.versions.Replace(commit, fileKey)
+    }
+    if err != nil {
+        this.store.Delete(tmpKey)
+    }
+    if err == tools.ErrFileExists {
+        sendError(context, errPrecondition)
+        return
+    }
+    if err != nil {
+        log.Printf("s3: put %s/%s: %s\n", bucketName, objectKey, err)
+        if this.keyConflict(bucketKey, fileKey) {
+            sendError(context, errKeyName)
+            return
+        }
+        sendError(context, errInternal)
+        return
+    }
+    contentType := context.Request.Header.Get("Content-Type")
+    if fileInfo, err := this.store.Stat(fileKey); err != nil {
+        log.Println(err)
+    } else if _, err := this.objects.SaveFile(fileKey, fileInfo, hash, contentType, key.Username); err != nil {
+        log.Println(err)
+    }
+    if err := this.objects.SetAttributes(fileKey, attrs); err != nil {
+        log.Println(err)
+    }
+    context.Header("ETag", `"` + hex.EncodeToString(sum) + `"`)
+    context.Status(http.StatusOK)
+}
+
+/* Remove empty directories between the file and the bucket */
+func (this *Controller) pruneDirs(bucketKey, fileKey string) {
+    dir := storageBackend.Dir(fileKey)
+    for strings.HasPrefix(dir, bucketKey + "/") {
+        if this.store.Delete(dir) != nil {
+            break
+        }
+        dir = storageBackend.Dir(dir)
+    }
+}
+
+func (this *Controller) removeObject(bucketName, objectKey string) error {
+    fileKey, err := this.fileKey(bucketName, objectKey)
+    if err != nil {
+        return err
+    }
+    bucketKey, _ := this.bucketKey(bucketName)
+    if !this.isDir(bucketKey) {
+        return errNoSuchBucket
+    }
+    fileInfo, err := this.store.Stat(fileKey)
+    if err != nil {
+        /* Deleting missing object is not an error in S3 */
+        return nil
+    }
+    if fileInfo.IsDir() {
+        if strings.HasSuffix(objectKey, "/") {
+            _ = this.store.Delete(fileKey)
+            this.pruneDirs(bucketKey, fileKey)
+        }
+        return nil
+    }
+    if err := this.versions.Remove(fileKey); err != nil {
+        return err
+    }
+    this.pruneDirs(bucketKey, fileKey)
+    return nil
+}
+
+func (this *Controller) deleteObject(context *gin.Context, bucketName, objectKey string) {
+    if err := this.removeObject(bucketName, objectKey); err != nil {
+        sendError(context, err)
+        return
+    }
+    context.Status(http.StatusNoContent)
+}
+
+func (this *Controller) deleteObjects(context *gin.Context, bucketName string, key s3keyModel.Key, reader io.Reader) {
+    /* Body over the limit is refused, so only data read to
+     * the end with the verified checksum is parsed */
+    data, err := ioutil.ReadAll(io.LimitReader(reader, maxDeleteBody + 1))
+    if err != nil {
+        sendError(context, bodyError(err))
+        return
+    }
+    if int64(len(data)) > maxDeleteBody {
+        sendError(context, errTooLarge)
+        return
+    }
+    var form deleteRequest
+    if err := xml.Unmarshal(data, &form); err != nil || len(form.Objects) > maxDeleteKeys {
+        sendError(context, errMalformedXML)
+        return
+    }
+
+    response := deleteResponse{
+        Xmlns: s3Namespace,
+    }
+    for _, item := range form.Objects {
+        var err error
+        if this.allow(key, bucketName, item.Key, aclModel.PermDelete) {
+            err = this.removeObject(bucketName, item.Key)
+        } else {
+            err = errAccessDenied
+        }
+        if err != nil {
+            apiErr, ok := err.(apiError)
+            if !ok {
+                apiErr = errInternal
+            }
+            response.Errors = append(response.Errors, deleteErrorItem{
+                Key: item.Key,
+                Code: apiErr.Code,
+                Message: apiErr.Message,
+            })
+            continue
+        }
+        if !form.Quiet {
+            response.Deleted = append(response.Deleted, deletedItem{ Key: item.Key })
+        }
+    }
+    sendXML(context, http.StatusOK, response)
+}
+
+func New(config *config.Config, db *sqlx.DB) *Controller {
+    return &Controller{
+        config: config,
+        db: db,
+        store: storageBackend.Open(config, db),
+        keys: s3keyModel.New(db),
+        acl: aclModel.New(db),
+        objects: objectModel.New(db),
+        versions: versionModel.New(config, db),
+        quotas: quotaModel.New(db),
+    }
+}
@@ -0,0 +1,23 @@
+package s3Controller
+
+import (
+    "testing"
+)
+
+func TestFileKey(t *testing.T) {
+    controller := &Controller{}
+    for objectKey, expected := range map[string]string{
+            "file.txt": "team/file.txt",
+            "sub/file.txt": "team/sub/file.txt",
+            "sub/": "team/sub" } {
+        fileKey, err := controller.fileKey("team", objectKey)
+        if err != nil || fileKey != expected {
+            t.Errorf("key %s: wrong file key %s: %v", objectKey, fileKey, err)
+        }
+    }
+    for _, objectKey := range []string{ "", "sub/../secret", "../other/file", "./file", "sub//file", "/file", "sub/." } {
+        if fileKey, err := controller.fileKey("team", objectKey); err == nil {
+            t.Errorf("wrong key %s is file key %s", objectKey, fileKey)
+        }
+    }
+}
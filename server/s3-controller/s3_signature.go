@@ -0,0 +1,380 @@
+package s3Controller
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "hash"
+    "io"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    signAlgorithm       string = "AWS4-HMAC-SHA256"
+    signChunkAlgorithm  string = "AWS4-HMAC-SHA256-PAYLOAD"
+    signTerminator      string = "aws4_request"
+    amzDateFormat       string = "20060102T150405Z"
+    unsignedPayload     string = "UNSIGNED-PAYLOAD"
+    streamingPayload    string = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+    emptySHA256         string = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+    maxClockSkew        = 15 * time.Minute
+    maxPresignExpires   = 7 * 24 * 3600
+    /* Chunk is kept in memory until the signature is verified */
+    maxChunkSize        = 8 << 20
+)
+
+/* Parsed SigV4 authorization, from header or presigned query */
+type signature struct {
+    AccessKey       string
+    Date            string
+    Region          string
+    Service         string
+    SignedHeaders   []string
+    Signature       string
+    AmzDate         time.Time
+    PayloadHash     string
+    Presigned       bool
+    Expires         int
+}
+
+func (this *signature) Scope() string {
+    return strings.Join([]string{ this.Date, this.Region, this.Service, signTerminator }, "/")
+}
+
+/* Return true if the request carries AWS SigV4 credentials */
+func IsSigned(request *http.Request) bool {
+    if strings.HasPrefix(request.Header.Get("Authorization"), signAlgorithm) {
+        return true
+    }
+    return request.URL.Query().Get("X-Amz-Algorithm") == signAlgorithm
+}
+
+func parseSignature(request *http.Request) (*signature, error) {
+    var sign signature
+    var err error
+
+    query := request.URL.Query()
+    if query.Get("X-Amz-Algorithm") == signAlgorithm {
+        sign.Presigned = true
+        err = parseCredential(&sign, query.Get("X-Amz-Credential"))
+        if err != nil {
+            return nil, err
+        }
+        sign.SignedHeaders = strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+        sign.Signature = query.Get("X-Amz-Signature")
+        sign.Expires, err = strconv.Atoi(query.Get("X-Amz-Expires"))
+        if err != nil || sign.Expires < 0 || sign.Expires > maxPresignExpires {
+            return nil, errors.New("wrong presign expiration")
+        }
+        sign.AmzDate, err = time.Parse(amzDateFormat, query.Get("X-Amz-Date"))
+        if err != nil {
+            return nil, errors.New("wrong presign date")
+        }
+        sign.PayloadHash = unsignedPayload
+    } else {
+        header := strings.TrimPrefix(request.Header.Get("Authorization"), signAlgorithm)
+        for _, item := range strings.Split(header, ",") {
+            pair := strings.SplitN(strings.TrimSpace(item), "=", 2)
+            if len(pair) < 2 {
+                return nil, errors.New("wrong authorization header")
+            }
+            switch pair[0] {
+                case "Credential":
+                    err = parseCredential(&sign, pair[1])
+                    if err != nil {
+                        return nil, err
+                    }
+                case "SignedHeaders":
+                    sign.SignedHeaders = strings.Split(pair[1], ";")
+                case "Signature":
+                    sign.Signature = pair[1]
+            }
+        }
+        amzDate := request.Header.Get("X-Amz-Date")
+        if len(amzDate) == 0 {
+            amzDate = request.Header.Get("Date")
+        }
+        sign.AmzDate, err = time.Parse(amzDateFormat, amzDate)
+        if err != nil {
+            sign.AmzDate, err = http.ParseTime(amzDate)
+            if err != nil {
+                return nil, errors.New("wrong request date")
+            }
+        }
+        sign.PayloadHash = request.Header.Get("X-Amz-Content-Sha256")
+        if len(sign.PayloadHash) == 0 {
+            sign.PayloadHash = emptySHA256
+        }
+    }
+
+    if len(sign.AccessKey) == 0 || len(sign.Signature) == 0 || len(sign.SignedHeaders) == 0 {
+        return nil, errors.New("incomplete authorization")
+    }
+    return &sign, nil
+}
+
+func parseCredential(sign *signature, credential string) error {
+    parts := strings.Split(credential, "/")
+    if len(parts) != 5 || parts[4] != signTerminator {
+        return errors.New("wrong credential scope")
+    }
+    sign.AccessKey = parts[0]
+    sign.Date = parts[1]
+    sign.Region = parts[2]
+    sign.Service = parts[3]
+    return nil
+}
+
+/* Check request date against clock skew or presign expiration */
+func (this *signature) CheckTime(now time.Time) error {
+    if this.Date != this.AmzDate.Format("20060102") {
+        return errors.New("credential date mismatch")
+    }
+    if this.Presigned {
+        if now.Before(this.AmzDate.Add(-maxClockSkew)) {
+            return errors.New("request is not yet valid")
+        }
+        if now.After(this.AmzDate.Add(time.Duration(this.Expires) * time.Second)) {
+            return errors.New("request has expired")
+        }
+        return nil
+    }
+    diff := now.Sub(this.AmzDate)
+    if diff > maxClockSkew || diff < -maxClockSkew {
+        return errors.New("request time too skewed")
+    }
+    return nil
+}
+
+/* Encode string by AWS rules: everything except unreserved chars */
+func uriEncode(str string, encodeSlash bool) string {
+    var buffer bytes.Buffer
+    for i := 0; i < len(str); i++ {
+        c := str[i]
+        if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+                c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+            buffer.WriteByte(c)
+        } else {
+            fmt.Fprintf(&buffer, "%%%02X", c)
+        }
+    }
+    return buffer.String()
+}
+
+func canonicalQuery(query url.Values) string {
+    var list []string
+    for key, values := range query {
+        if key == "X-Amz-Signature" {
+            continue
+        }
+        for _, value := range values {
+            list = append(list, uriEncode(key, true) + "=" + uriEncode(value, true))
+        }
+    }
+    sort.Strings(list)
+    return strings.Join(list, "&")
+}
+
+func canonicalHeaders(request *http.Request, signedHeaders []string) string {
+    var buffer bytes.Buffer
+    for _, name := range signedHeaders {
+        var value string
+        switch name {
+            case "host":
+                value = request.Host
+            case "content-length":
+                value = request.Header.Get("Content-Length")
+                if len(value) == 0 && request.ContentLength >= 0 {
+                    value = strconv.FormatInt(request.ContentLength, 10)
+                }
+            default:
+                var values []string
+                for _, item := range request.Header[http.CanonicalHeaderKey(name)] {
+                    values = append(values, strings.Join(strings.Fields(item), " "))
+                }
+                value = strings.Join(values, ",")
+        }
+        buffer.WriteString(name + ":" + value + "\n")
+    }
+    return buffer.String()
+}
+
+func canonicalRequest(request *http.Request, sign *signature) string {
+    return strings.Join([]string{
+        request.Method,
+        uriEncode(request.URL.Path, false),
+        canonicalQuery(request.URL.Query()),
+        canonicalHeaders(request, sign.SignedHeaders),
+        strings.Join(sign.SignedHeaders, ";"),
+        sign.PayloadHash,
+    }, "\n")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
+
+func signingKey(secret, date, region, service string) []byte {
+    key := hmacSHA256([]byte("AWS4" + secret), date)
+    key = hmacSHA256(key, region)
+    key = hmacSHA256(key, service)
+    return hmacSHA256(key, signTerminator)
+}
+
+func stringToSign(sign *signature, canonical string) string {
+    return strings.Join([]string{
+        signAlgorithm,
+        sign.AmzDate.UTC().Format(amzDateFormat),
+        sign.Scope(),
+        sha256Hex([]byte(canonical)),
+    }, "\n")
+}
+
+/* Compute request signature with the secret key */
+func (this *signature) Compute(request *http.Request, secret string) string {
+    key := signingKey(secret, this.Date, this.Region, this.Service)
+    canonical := canonicalRequest(request, this)
+    return hex.EncodeToString(hmacSHA256(key, stringToSign(this, canonical)))
+}
+
+func (this *signature) Verify(request *http.Request, secret string) error {
+    expected := this.Compute(request, secret)
+    if !hmac.Equal([]byte(expected), []byte(this.Signature)) {
+        return errors.New("signature does not match")
+    }
+    return nil
+}
+
+/* Payload does not match the signed checksum or chunk signatures */
+type payloadError string
+
+func (this payloadError) Error() string {
+    return string(this)
+}
+
+/* Reader verifying SHA256 of the signed payload at EOF */
+type payloadReader struct {
+    reader      io.Reader
+    hash        hash.Hash
+    expected    string
+}
+
+func newPayloadReader(reader io.Reader, expected string) *payloadReader {
+    return &payloadReader{
+        reader: reader,
+        hash: sha256.New(),
+        expected: expected,
+    }
+}
+
+func (this *payloadReader) Read(data []byte) (int, error) {
+    size, err := this.reader.Read(data)
+    this.hash.Write(data[:size])
+    if err == io.EOF {
+        if hex.EncodeToString(this.hash.Sum(nil)) != this.expected {
+            return size, payloadError("payload checksum mismatch")
+        }
+    }
+    return size, err
+}
+
+/* Reader decoding aws-chunked body and verifying chunk signatures */
+type chunkReader struct {
+    reader      *bufio.Reader
+    key         []byte
+    sign        *signature
+    prevSign    string
+    chunk       []byte
+    done        bool
+}
+
+func newChunkReader(reader io.Reader, sign *signature, secret string) *chunkReader {
+    return &chunkReader{
+        reader: bufio.NewReader(reader),
+        key: signingKey(secret, sign.Date, sign.Region, sign.Service),
+        sign: sign,
+        prevSign: sign.Signature,
+    }
+}
+
+func (this *chunkReader) readChunk() error {
+    /* Header is limited by the buffer of the reader */
+    header, err := this.reader.ReadSlice('\n')
+    if err == bufio.ErrBufferFull {
+        return payloadError("wrong chunk header")
+    }
+    if err != nil {
+        return err
+    }
+    line := strings.TrimRight(string(header), "\r\n")
+    parts := strings.SplitN(line, ";", 2)
+    if len(parts) != 2 || !strings.HasPrefix(parts[1], "chunk-signature=") {
+        return payloadError("wrong chunk header")
+    }
+    size, err := strconv.ParseInt(parts[0], 16, 64)
+    if err != nil || size < 0 || size > maxChunkSize {
+        return payloadError("wrong chunk size")
+    }
+    chunkSign := strings.TrimPrefix(parts[1], "chunk-signature=")
+
+    chunk := make([]byte, size + 2)
+    if _, err := io.ReadFull(this.reader, chunk); err != nil {
+        return err
+    }
+    if string(chunk[size:]) != "\r\n" {
+        return payloadError("wrong chunk trailer")
+    }
+    chunk = chunk[:size]
+
+    toSign := strings.Join([]string{
+        signChunkAlgorithm,
+        this.sign.AmzDate.UTC().Format(amzDateFormat),
+        this.sign.Scope(),
+        this.prevSign,
+        emptySHA256,
+        sha256Hex(chunk),
+    }, "\n")
+    expected := hex.EncodeToString(hmacSHA256(this.key, toSign))
+    if !hmac.Equal([]byte(expected), []byte(chunkSign)) {
+        return payloadError("chunk signature does not match")
+    }
+    this.prevSign = chunkSign
+    this.chunk = chunk
+    if size == 0 {
+        this.done = true
+    }
+    return nil
+}
+
+func (this *chunkReader) Read(data []byte) (int, error) {
+    for len(this.chunk) == 0 {
+        if this.done {
+            return 0, io.EOF
+        }
+        if err := this.readChunk(); err != nil {
+            if err == io.EOF {
+                err = io.ErrUnexpectedEOF
+            }
+            return 0, err
+        }
+    }
+    size := copy(data, this.chunk)
+    this.chunk = this.chunk[size:]
+    return size, nil
+}
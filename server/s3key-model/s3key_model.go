@@ -0,0 +1,126 @@
+package s3keyModel
+
+import (
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/hex"
+    "log"
+    "strings"
+
+    "github.com/jmoiron/sqlx"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS s3keys (
+        id          INTEGER PRIMARY KEY,
+        userid      INTEGER NOT NULL,
+        accesskey   VARCHAR(255) NOT NULL UNIQUE,
+        secretkey   VARCHAR(255) NOT NULL
+    );`
+
+type Model struct {
+    db *sqlx.DB
+}
+
+/* S3 access key pair bound to the user.
+ * SigV4 needs the secret itself for HMAC, so it is stored as is */
+type Key struct {
+    Id          int     `db:"id"         json:"id"`
+    UserId      int     `db:"userid"     json:"userid"`
+    Username    string  `db:"username"   json:"username"`
+    IsAdmin     bool    `db:"isadmin"    json:"isadmin"`
+    AccessKey   string  `db:"accesskey"  json:"accesskey"`
+    SecretKey   string  `db:"secretkey"  json:"secretkey,omitempty"`
+}
+
+func randKey(size int) (string, error) {
+    arr := make([]byte, size)
+    if _, err := rand.Read(arr); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(arr), nil
+}
+
+func randSecret(size int) (string, error) {
+    arr := make([]byte, size)
+    if _, err := rand.Read(arr); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(arr), nil
+}
+
+func (this *Model) Migrate() error {
+    _, err := this.db.Exec(schema)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func (this *Model) Create(userId int) (Key, error) {
+    var key Key
+    var err error
+
+    key.UserId = userId
+    key.AccessKey, err = randKey(10)
+    if err != nil {
+        return key, err
+    }
+    key.AccessKey = strings.ToUpper(key.AccessKey)
+    key.SecretKey, err = randSecret(30)
+    if err != nil {
+        return key, err
+    }
+
+    request := `INSERT INTO s3keys(userid, accesskey, secretkey) VALUES ($1, $2, $3)`
+    _, err = this.db.Exec(request, key.UserId, key.AccessKey, key.SecretKey)
+    if err != nil {
+        log.Println(err)
+        return key, err
+    }
+    return key, nil
+}
+
+func (this *Model) Find(accessKey string) (Key, error) {
+    request := `SELECT k.id, k.userid, u.username, u.isadmin, k.accesskey, k.secretkey
+                FROM s3keys k JOIN users u ON u.id = k.userid
+                WHERE k.accesskey = $1 LIMIT 1`
+    var out Key
+    err := this.db.Get(&out, request, accessKey)
+    if err != nil {
+        log.Println(err)
+        return out, err
+    }
+    return out, nil
+}
+
+func (this *Model) List(userId int) ([]Key, error) {
+    request := `SELECT k.id, k.userid, u.username, u.isadmin, k.accesskey, '' as secretkey
+                FROM s3keys k JOIN users u ON u.id = k.userid
+                WHERE k.userid = $1 ORDER BY k.id`
+    keys := []Key{}
+    err := this.db.Select(&keys, request, userId)
+    if err != nil {
+        log.Println(err)
+        return keys, err
+    }
+    return keys, nil
+}
+
+func (this *Model) Delete(accessKey string) error {
+    request := `DELETE FROM s3keys WHERE accesskey = $1`
+    _, err := this.db.Exec(request, accessKey)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func New(db *sqlx.DB) *Model {
+    model := Model{
+        db: db,
+    }
+    return &model
+}
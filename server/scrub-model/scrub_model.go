@@ -0,0 +1,533 @@
+package scrubModel
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/jmoiron/sqlx"
+
+    "store/config"
+    "store/server/object-model"
+    "store/server/storage-backend"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS scrubs (
+        id          INTEGER PRIMARY KEY,
+        manual      BOOLEAN NOT NULL DEFAULT FALSE,
+        status      VARCHAR(16) NOT NULL DEFAULT '',
+        started     INTEGER NOT NULL DEFAULT 0,
+        finished    INTEGER NOT NULL DEFAULT 0,
+        files       INTEGER NOT NULL DEFAULT 0,
+        bytes       INTEGER NOT NULL DEFAULT 0,
+        verified    INTEGER NOT NULL DEFAULT 0,
+        mismatched  INTEGER NOT NULL DEFAULT 0,
+        unreadable  INTEGER NOT NULL DEFAULT 0,
+        changed     INTEGER NOT NULL DEFAULT 0,
+        missing     INTEGER NOT NULL DEFAULT 0,
+        unknown     INTEGER NOT NULL DEFAULT 0,
+        quarantined INTEGER NOT NULL DEFAULT 0,
+        errors      INTEGER NOT NULL DEFAULT 0,
+        message     VARCHAR(1024) NOT NULL DEFAULT ''
+    );
+    CREATE TABLE IF NOT EXISTS scrubissues (
+        id          INTEGER PRIMARY KEY,
+        scrub       INTEGER NOT NULL,
+        kind        VARCHAR(16) NOT NULL,
+        path        VARCHAR(2048) NOT NULL,
+        detail      VARCHAR(1024) NOT NULL DEFAULT ''
+    );
+    CREATE INDEX IF NOT EXISTS scrubissues_scrub ON scrubissues (scrub);`
+
+const (
+    StatusRunning       string = "running"
+    StatusDone          string = "done"
+    StatusFailed        string = "failed"
+    StatusInterrupted   string = "interrupted"
+
+    /* Data differs from the checksum of metadata */
+    KindMismatched      string = "mismatched"
+    /* Data is not read, encrypted data fails authentication */
+    KindUnreadable      string = "unreadable"
+    /* Size or modification time differs from metadata */
+    KindChanged         string = "changed"
+    /* Metadata without the file */
+    KindMissing         string = "missing"
+    /* File without metadata */
+    KindUnknown         string = "unknown"
+    KindError           string = "error"
+
+    quarantineDirName   string = "quarantine"
+    /* Issues kept for the run, all issues are written to the message log */
+    maxIssues           int = 1000
+    /* Runs kept in the database */
+    keepRuns            int = 10
+)
+
+var ErrRunning = errors.New("scrub is running")
+
+/* Progress of the running scrub, runs do not overlap */
+var (
+    stateLock   sync.Mutex
+    current     *Summary
+)
+
+type Model struct {
+    config *config.Config
+    db *sqlx.DB
+    store storageBackend.Backend
+    objects *objectModel.Model
+}
+
+/* Counts of the scrub run, times are unix seconds */
+type Summary struct {
+    Id          int     `db:"id"             json:"id"`
+    Manual      bool    `db:"manual"         json:"manual"`
+    Status      string  `db:"status"         json:"status"`
+    Started     int64   `db:"started"        json:"started"`
+    Finished    int64   `db:"finished"       json:"finished"`
+    Files       int64   `db:"files"          json:"files"`
+    Bytes       int64   `db:"bytes"          json:"bytes"`
+    Verified    int64   `db:"verified"       json:"verified"`
+    Mismatched  int64   `db:"mismatched"     json:"mismatched"`
+    Unreadable  int64   `db:"unreadable"     json:"unreadable"`
+    Changed     int64   `db:"changed"        json:"changed"`
+    Missing     int64   `db:"missing"        json:"missing"`
+    Unknown     int64   `db:"unknown"        json:"unknown"`
+    Quarantined int64   `db:"quarantined"    json:"quarantined"`
+    Errors      int64   `db:"errors"         json:"errors"`
+    Message     string  `db:"message"        json:"message,omitempty"`
+}
+
+/* File found by the scrub, detail describes the difference */
+type Issue struct {
+    Id          int     `db:"id"             json:"-"`
+    Scrub       int     `db:"scrub"          json:"-"`
+    Kind        string  `db:"kind"           json:"kind"`
+    Path        string  `db:"path"           json:"path"`
+    Detail      string  `db:"detail"         json:"detail,omitempty"`
+}
+
+/* Reads sleep to keep the average rate of the run, zero rate is unlimited */
+type throttle struct {
+    rate        int64
+    start       time.Time
+    bytes       int64
+}
+
+func (this *throttle) wait(size int) {
+    if this.rate <= 0 {
+        return
+    }
+    this.bytes += int64(size)
+    due := time.Duration(float64(this.bytes) / float64(this.rate) * float64(time.Second))
+    if elapsed := time.Since(this.start); due > elapsed {
+        time.Sleep(due - elapsed)
+    }
+}
+
+type throttledReader struct {
+    reader      io.Reader
+    throttle    *throttle
+}
+
+func (this *throttledReader) Read(data []byte) (int, error) {
+    size, err := this.reader.Read(data)
+    this.throttle.wait(size)
+    return size, err
+}
+
+/* State of the run */
+type scan struct {
+    started     time.Time
+    throttle    *throttle
+    issues      []Issue
+}
+
+func (this *Model) Migrate() error {
+    _, err := this.db.Exec(schema)
+    if err == nil {
+        /* Runs stopped by the server exit */
+        request := `UPDATE scrubs SET status = $1 WHERE status = $2`
+        _, err = this.db.Exec(request, StatusInterrupted, StatusRunning)
+    }
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func update(change func(summary *Summary)) {
+    stateLock.Lock()
+    defer stateLock.Unlock()
+    change(current)
+}
+
+/* Count the issue, write it to the message log and keep it for the run */
+func (this *Model) report(run *scan, kind, path, detail string) {
+    log.Printf("scrub: %s %s %s\n", kind, path, detail)
+    update(func(summary *Summary) {
+        switch kind {
+            case KindMismatched:
+                summary.Mismatched++
+            case KindUnreadable:
+                summary.Unreadable++
+            case KindChanged:
+                summary.Changed++
+            case KindMissing:
+                summary.Missing++
+            case KindUnknown:
+                summary.Unknown++
+            case KindError:
+                summary.Errors++
+        }
+    })
+    if len(run.issues) < maxIssues {
+        run.issues = append(run.issues, Issue{ Kind: kind, Path: path, Detail: detail })
+    }
+}
+
+/* Metadata and the file are not changed since the check, so the
+ * difference is not made by a write in progress */
+func (this *Model) unchanged(path string, object objectModel.Object, fileInfo os.FileInfo) bool {
+    fresh, err := this.objects.Find(path)
+    if err != nil || fresh.Id != object.Id || fresh.ModTime != object.ModTime || fresh.SHA256 != object.SHA256 {
+        return false
+    }
+    if fileInfo == nil {
+        return !storageBackend.FileExists(this.store, path)
+    }
+    now, err := this.store.Stat(path)
+    return err == nil && now.Size() == fileInfo.Size() && now.ModTime().Equal(fileInfo.ModTime())
+}
+
+/* Move the file out of the bucket to the internal area, metadata
+ * of the file is removed, so the file is not served anymore */
+func (this *Model) quarantine(path string) (string, error) {
+    dstKey := storageBackend.Join(config.SystemDirName, quarantineDirName, path)
+    if err := this.store.Move(path, dstKey, false); err != nil {
+        return "", err
+    }
+    return dstKey, this.objects.Delete(path)
+}
+
+/* Compare the file with metadata, data is hashed when size and
+ * modification time match */
+func (this *Model) checkFile(run *scan, path string, fileInfo os.FileInfo, object objectModel.Object, known bool) {
+    update(func(summary *Summary) { summary.Files++ })
+    if !known {
+        /* Metadata is saved after the file is written */
+        if fileInfo.ModTime().After(run.started) {
+            return
+        }
+        if _, err := this.objects.Find(path); err == nil {
+            return
+        }
+        this.report(run, KindUnknown, path, fmt.Sprintf("size %d", fileInfo.Size()))
+        return
+    }
+    if !object.Valid(fileInfo) {
+        if this.unchanged(path, object, fileInfo) {
+            this.report(run, KindChanged, path, fmt.Sprintf("size %d modtime %s, expected size %d modtime %s",
+                            fileInfo.Size(), fileInfo.ModTime().Format(time.RFC3339),
+                            object.Size, time.Unix(0, object.ModTime).Format(time.RFC3339)))
+        }
+        return
+    }
+    if len(object.SHA256) == 0 && len(object.MD5) == 0 {
+        return
+    }
+
+    file, err := this.store.Open(path)
+    if os.IsNotExist(err) {
+        return
+    }
+    var hash *objectModel.Hash
+    if err == nil {
+        counter := &throttledReader{ reader: file, throttle: run.throttle }
+        hash, err = objectModel.HashReader(counter)
+        file.Close()
+    }
+    update(func(summary *Summary) { summary.Bytes += fileInfo.Size() })
+    kind, detail := KindMismatched, ""
+    switch {
+        case err != nil:
+            kind, detail = KindUnreadable, err.Error()
+        case len(object.SHA256) > 0 && hash.SHA256() != object.SHA256:
+            detail = fmt.Sprintf("sha256 %s, expected %s", hash.SHA256(), object.SHA256)
+        case len(object.SHA256) == 0 && hash.MD5() != object.MD5:
+            detail = fmt.Sprintf("md5 %s, expected %s", hash.MD5(), object.MD5)
+        default:
+            update(func(summary *Summary) { summary.Verified++ })
+            return
+    }
+    if !this.unchanged(path, object, fileInfo) {
+        return
+    }
+    /* Unreadable data is quarantined only if it is corrupted for sure */
+    if this.config.ScrubQuarantine && (kind == KindMismatched || errors.Is(err, storageBackend.ErrCorrupted)) {
+        dstKey, err := this.quarantine(path)
+        if err != nil {
+            detail += fmt.Sprintf(", quarantine failed: %s", err)
+        } else {
+            detail += ", quarantined to " + dstKey
+            update(func(summary *Summary) { summary.Quarantined++ })
+        }
+    }
+    this.report(run, kind, path, detail)
+}
+
+func (this *Model) checkMissing(run *scan, path string, object objectModel.Object) {
+    if this.unchanged(path, object, nil) {
+        this.report(run, KindMissing, path, fmt.Sprintf("size %d", object.Size))
+    }
+}
+
+/* Check files of the directory and nested directories,
+ * metadata of files not found in the directory are missing */
+func (this *Model) scanDir(run *scan, key string) error {
+    files, err := storageBackend.ReadDir(this.store, key)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        this.report(run, KindError, key, err.Error())
+        return nil
+    }
+    objects, err := this.objects.List(key)
+    if err != nil {
+        return err
+    }
+    for _, fileInfo := range files {
+        path := storageBackend.Join(key, fileInfo.Name())
+        if fileInfo.IsDir() {
+            if config.IsSystemName(path) {
+                continue
+            }
+            if err := this.scanDir(run, path); err != nil {
+                return err
+            }
+            continue
+        }
+        if !fileInfo.Mode().IsRegular() {
+            continue
+        }
+        object, known := objects[fileInfo.Name()]
+        delete(objects, fileInfo.Name())
+        this.checkFile(run, path, fileInfo, object, known)
+    }
+    return this.checkObjects(run, key, objects)
+}
+
+func (this *Model) checkObjects(run *scan, key string, objects map[string]objectModel.Object) error {
+    names := make([]string, 0, len(objects))
+    for name := range objects {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        this.checkMissing(run, storageBackend.Join(key, name), objects[name])
+    }
+    return nil
+}
+
+/* Check all buckets, metadata of removed buckets is missing */
+func (this *Model) scanStore(run *scan) error {
+    if err := this.scanDir(run, ""); err != nil {
+        return err
+    }
+    buckets, err := this.objects.Buckets()
+    if err != nil {
+        return err
+    }
+    for _, bucket := range buckets {
+        if fileInfo, err := this.store.Stat(bucket); err == nil && fileInfo.IsDir() {
+            continue
+        }
+        objects, err := this.objects.List(bucket)
+        if err != nil {
+            return err
+        }
+        if err := this.checkObjects(run, bucket, objects); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+/* Record the new run, drop old runs, ErrRunning is returned if a scrub is running */
+func (this *Model) begin(manual bool) (*Summary, error) {
+    stateLock.Lock()
+    if current != nil {
+        stateLock.Unlock()
+        return nil, ErrRunning
+    }
+    summary := &Summary{ Manual: manual, Status: StatusRunning, Started: time.Now().Unix() }
+    current = summary
+    stateLock.Unlock()
+
+    request := `DELETE FROM scrubissues WHERE scrub NOT IN (SELECT id FROM scrubs ORDER BY id DESC LIMIT $1)`
+    _, err := this.db.Exec(request, keepRuns - 1)
+    if err == nil {
+        request = `DELETE FROM scrubs WHERE id NOT IN (SELECT id FROM scrubs ORDER BY id DESC LIMIT $1)`
+        _, err = this.db.Exec(request, keepRuns - 1)
+    }
+    var result sql.Result
+    if err == nil {
+        request = `INSERT INTO scrubs(manual, status, started) VALUES ($1, $2, $3)`
+        result, err = this.db.Exec(request, summary.Manual, summary.Status, summary.Started)
+    }
+    var id int64
+    if err == nil {
+        id, err = result.LastInsertId()
+    }
+
+    stateLock.Lock()
+    defer stateLock.Unlock()
+    if err != nil {
+        log.Println(err)
+        current = nil
+        return nil, err
+    }
+    summary.Id = int(id)
+    return summary, nil
+}
+
+func (this *Model) save(summary Summary, issues []Issue) error {
+    tx, err := this.db.Beginx()
+    if err != nil {
+        return err
+    }
+    request := `UPDATE scrubs SET status = $1, finished = $2, files = $3, bytes = $4, verified = $5,
+                    mismatched = $6, unreadable = $7, changed = $8, missing = $9, unknown = $10,
+                    quarantined = $11, errors = $12, message = $13
+                WHERE id = $14`
+    _, err = tx.Exec(request, summary.Status, summary.Finished, summary.Files, summary.Bytes, summary.Verified,
+                        summary.Mismatched, summary.Unreadable, summary.Changed, summary.Missing, summary.Unknown,
+                        summary.Quarantined, summary.Errors, summary.Message, summary.Id)
+    for _, issue := range issues {
+        if err != nil {
+            break
+        }
+        request = `INSERT INTO scrubissues(scrub, kind, path, detail) VALUES ($1, $2, $3, $4)`
+        _, err = tx.Exec(request, summary.Id, issue.Kind, issue.Path, issue.Detail)
+    }
+    if err != nil {
+        log.Println(err)
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit()
+}
+
+/* Scan the store and save the result of the run */
+func (this *Model) run(summary *Summary) (Summary, error) {
+    now := time.Now()
+    run := &scan{ started: now, throttle: &throttle{ rate: this.config.ScrubRate, start: now } }
+    log.Printf("scrub: started, rate %d bytes per second\n", this.config.ScrubRate)
+    err := this.scanStore(run)
+
+    stateLock.Lock()
+    summary.Finished = time.Now().Unix()
+    summary.Status = StatusDone
+    if err != nil {
+        summary.Status, summary.Message = StatusFailed, err.Error()
+    }
+    done := *summary
+    current = nil
+    stateLock.Unlock()
+
+    log.Printf("scrub: %s, %d files, %d bytes, %d verified, %d mismatched, %d unreadable, %d changed, " +
+                    "%d missing, %d unknown, %d quarantined, %d errors\n",
+                    done.Status, done.Files, done.Bytes, done.Verified, done.Mismatched, done.Unreadable,
+                    done.Changed, done.Missing, done.Unknown, done.Quarantined, done.Errors)
+    if saveErr := this.save(done, run.issues); err == nil {
+        err = saveErr
+    }
+    return done, err
+}
+
+/* Scrub the store now, ErrRunning is returned if a scrub is running */
+func (this *Model) Run(manual bool) (Summary, error) {
+    summary, err := this.begin(manual)
+    if err != nil {
+        return Summary{}, err
+    }
+    return this.run(summary)
+}
+
+/* Start the scrub in background, ErrRunning is returned if a scrub is running */
+func (this *Model) Start(manual bool) error {
+    summary, err := this.begin(manual)
+    if err != nil {
+        return err
+    }
+    go this.run(summary)
+    return nil
+}
+
+/* Progress of the running scrub, false if no scrub is running */
+func Current() (Summary, bool) {
+    stateLock.Lock()
+    defer stateLock.Unlock()
+    if current == nil {
+        return Summary{}, false
+    }
+    return *current, true
+}
+
+/* Last finished run with its issues, false if no run is finished */
+func (this *Model) Last() (Summary, []Issue, bool, error) {
+    summaries := []Summary{}
+    issues := []Issue{}
+    request := `SELECT * FROM scrubs WHERE status <> $1 ORDER BY id DESC LIMIT 1`
+    if err := this.db.Select(&summaries, request, StatusRunning); err != nil {
+        log.Println(err)
+        return Summary{}, issues, false, err
+    }
+    if len(summaries) == 0 {
+        return Summary{}, issues, false, nil
+    }
+    request = `SELECT * FROM scrubissues WHERE scrub = $1 ORDER BY id`
+    if err := this.db.Select(&issues, request, summaries[0].Id); err != nil {
+        log.Println(err)
+        return summaries[0], issues, true, err
+    }
+    return summaries[0], issues, true, nil
+}
+
+/* Scrub the store when the last run started before the interval of
+ * the config, the time is checked every period */
+func (this *Model) Scheduler(period time.Duration) {
+    interval := time.Duration(this.config.ScrubInterval) * time.Hour
+    for {
+        var started []int64
+        request := `SELECT started FROM scrubs ORDER BY id DESC LIMIT 1`
+        err := this.db.Select(&started, request)
+        if err != nil {
+            log.Println(err)
+        } else if len(started) == 0 || time.Since(time.Unix(started[0], 0)) >= interval {
+            if _, err := this.Run(false); err != nil && err != ErrRunning {
+                log.Println(err)
+            }
+        }
+        time.Sleep(period)
+    }
+}
+
+func New(config *config.Config, db *sqlx.DB) *Model {
+    return &Model{
+        config: config,
+        db: db,
+        store: storageBackend.Open(config, db),
+        objects: objectModel.New(db),
+    }
+}
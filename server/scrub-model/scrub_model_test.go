@@ -0,0 +1,155 @@
+package scrubModel
+
+import (
+    "bytes"
+    "io/ioutil"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/jmoiron/sqlx"
+    _ "github.com/mattn/go-sqlite3"
+
+    "store/config"
+    "store/server/object-model"
+    "store/server/storage-backend"
+)
+
+func TestThrottle(t *testing.T) {
+    data := bytes.Repeat([]byte("x"), 64 * 1024)
+    start := time.Now()
+    reader := &throttledReader{
+        reader: bytes.NewReader(data),
+        throttle: &throttle{ rate: 256 * 1024, start: start },
+    }
+    read, err := ioutil.ReadAll(reader)
+    if err != nil || len(read) != len(data) {
+        t.Fatalf("read %d bytes, %v", len(read), err)
+    }
+    /* 64 KiB at 256 KiB per second */
+    if elapsed := time.Since(start); elapsed < 240 * time.Millisecond {
+        t.Errorf("read in %s, expected 250ms", elapsed)
+    }
+
+    start = time.Now()
+    reader = &throttledReader{ reader: bytes.NewReader(data), throttle: &throttle{ start: start } }
+    if _, err := ioutil.ReadAll(reader); err != nil {
+        t.Fatal(err)
+    }
+    if elapsed := time.Since(start); elapsed > 100 * time.Millisecond {
+        t.Errorf("unlimited read in %s", elapsed)
+    }
+}
+
+/* Model over the memory store and the database in memory */
+func testModel(t *testing.T, quarantine bool) *Model {
+    db, err := sqlx.Open("sqlite3", ":memory:")
+    if err != nil {
+        t.Fatal(err)
+    }
+    db.SetMaxOpenConns(1)
+    model := New(&config.Config{ Storage: storageBackend.StorageMemory, ScrubQuarantine: quarantine }, db)
+    for _, migrate := range []func() error{ model.Migrate, model.objects.Migrate } {
+        if err := migrate(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return model
+}
+
+/* Write the file with metadata of the checksum of sum data */
+func putFile(t *testing.T, model *Model, path, data, sum string) {
+    if _, err := model.store.Put(path, strings.NewReader(data), false); err != nil {
+        t.Fatal(err)
+    }
+    fileInfo, _ := model.store.Stat(path)
+    hash, _ := objectModel.HashReader(strings.NewReader(sum))
+    if _, err := model.objects.SaveFile(path, fileInfo, hash, "", "alice"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestScrub(t *testing.T) {
+    model := testModel(t, true)
+    putFile(t, model, "docs/good.txt", "good data", "good data")
+    putFile(t, model, "docs/bad.txt", "bad data", "bad dat!")
+    putFile(t, model, "docs/resized.txt", "data", "data")
+    model.store.Put("docs/resized.txt", strings.NewReader("longer data"), false)
+    putFile(t, model, "docs/gone.txt", "gone", "gone")
+    model.store.Delete("docs/gone.txt")
+    putFile(t, model, "old/lost.txt", "lost", "lost")
+    model.store.DeleteAll("old")
+    model.store.Put("docs/stray.txt", strings.NewReader("stray"), false)
+
+    summary, err := model.Run(true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if summary.Status != StatusDone || !summary.Manual || summary.Files != 4 || summary.Verified != 1 ||
+            summary.Mismatched != 1 || summary.Changed != 1 || summary.Missing != 2 || summary.Unknown != 1 ||
+            summary.Quarantined != 1 || summary.Unreadable != 0 || summary.Errors != 0 {
+        t.Errorf("wrong summary %+v", summary)
+    }
+    if _, running := Current(); running {
+        t.Error("finished scrub is running")
+    }
+
+    /* Issues are saved with the run */
+    last, issues, found, err := model.Last()
+    if err != nil || !found || last.Id != summary.Id || last.Mismatched != 1 {
+        t.Fatalf("wrong last run %+v %v %v", last, found, err)
+    }
+    reported := []string{}
+    for _, issue := range issues {
+        reported = append(reported, issue.Kind + " " + issue.Path)
+    }
+    expected := []string{
+        "mismatched docs/bad.txt",
+        "changed docs/resized.txt",
+        "unknown docs/stray.txt",
+        "missing docs/gone.txt",
+        "missing old/lost.txt" }
+    if !reflect.DeepEqual(reported, expected) {
+        t.Errorf("wrong issues %v", reported)
+    }
+    if !strings.Contains(issues[0].Detail, "quarantined to") {
+        t.Errorf("wrong detail of the mismatched file %s", issues[0].Detail)
+    }
+
+    /* Corrupted file is moved out of the bucket without metadata */
+    if storageBackend.FileExists(model.store, "docs/bad.txt") {
+        t.Error("corrupted file is kept in the bucket")
+    }
+    if _, err := model.objects.Find("docs/bad.txt"); err == nil {
+        t.Error("metadata of the corrupted file is kept")
+    }
+    dstKey := storageBackend.Join(config.SystemDirName, quarantineDirName, "docs/bad.txt")
+    if data, _ := storageBackend.ReadFile(model.store, dstKey); string(data) != "bad data" {
+        t.Errorf("wrong quarantined data %q", data)
+    }
+}
+
+func TestScrubNoQuarantine(t *testing.T) {
+    model := testModel(t, false)
+    putFile(t, model, "docs/bad.txt", "bad data", "bad dat!")
+    summary, err := model.Run(false)
+    if err != nil || summary.Mismatched != 1 || summary.Quarantined != 0 {
+        t.Fatalf("wrong summary %+v %v", summary, err)
+    }
+    if !storageBackend.FileExists(model.store, "docs/bad.txt") {
+        t.Error("corrupted file is quarantined")
+    }
+    if err := model.Start(false); err != nil {
+        t.Fatal(err)
+    }
+    /* Run is saved after the progress is cleared */
+    var last Summary
+    for i := 0; i < 100 && last.Id != summary.Id + 1; i++ {
+        time.Sleep(10 * time.Millisecond)
+        last, _, _, _ = model.Last()
+    }
+    if last.Id != summary.Id + 1 || last.Manual || last.Mismatched != 1 {
+        t.Errorf("wrong background run %+v", last)
+    }
+}
@@ -39,6 +39,22 @@ import (
     "store/server/file-controller"
     "store/server/bucket-controller"
     "store/server/status-controller"
+    "store/server/s3-controller"
+    "store/server/s3key-model"
+    "store/server/acl-model"
+    "store/server/apikey-model"
+    "store/server/apikey-controller"
+    "store/server/object-model"
+    "store/server/bucket-model"
+    "store/server/trash-model"
+    "store/server/version-model"
+    "store/server/quota-model"
+    "store/server/quota-controller"
+    "store/server/lifecycle-model"
+    "store/server/storage-backend"
+    "store/server/blob-model"
+    "store/server/datakey-model"
+    "store/server/scrub-model"
 
 
     "store/daemon"
@@ -145,6 +161,11 @@ func (this *Server) Start() {
         os.Exit(1)
     }
 
+    if !storageBackend.Valid(this.Config.Storage) {
+        log.Printf("wrong storage backend %s\n", this.Config.Storage)
+        os.Exit(1)
+    }
+
     /* Make store directory */
     err = os.MkdirAll(this.Config.StoreDir, 0750)
     if err != nil {
@@ -221,6 +242,72 @@ func (this *Server) Run() error {
         return err
     }
 
+    /* Create tables added after initial migration */
+    err = s3keyModel.New(this.db).Migrate()
+    if err != nil {
+        return err
+    }
+    err = aclModel.New(this.db).Migrate()
+    if err != nil {
+        return err
+    }
+    err = apikeyModel.New(this.db).Migrate()
+    if err != nil {
+        return err
+    }
+    err = objectModel.New(this.db).Migrate()
+    if err != nil {
+        return err
+    }
+    err = bucketModel.New(this.db).Migrate()
+    if err != nil {
+        return err
+    }
+    err = versionModel.New(this.Config, this.db).Migrate()
+    if err != nil {
+        return err
+    }
+    err = trashModel.New(this.Config, this.db).Migrate()
+    if err != nil {
+        return err
+    }
+    err = quotaModel.New(this.db).Migrate()
+    if err != nil {
+        return err
+    }
+    err = lifecycleModel.New(this.Config, this.db).Migrate()
+    if err != nil {
+        return err
+    }
+    err = blobModel.New(this.db).Migrate()
+    if err != nil {
+        return err
+    }
+    err = datakeyModel.New(this.db).Migrate()
+    if err != nil {
+        return err
+    }
+    err = scrubModel.New(this.Config, this.db).Migrate()
+    if err != nil {
+        return err
+    }
+
+    /* Encrypted files are not readable without the master key */
+    if len(this.Config.MasterKeyPath) > 0 {
+        if _, err := storageBackend.LoadKeyring(this.Config.MasterKeyPath); err != nil {
+            return err
+        }
+    }
+
+    /* Remove temporary files of writes interrupted by crash */
+    count, err := storageBackend.CleanTemp(storageBackend.Open(this.Config, this.db))
+    if err != nil {
+        return err
+    }
+    if count > 0 {
+        log.Printf("removed %d orphaned temporary files\n", count)
+    }
+
     //fmt.Println("debug mode:", this.Config.Debug)
     if this.Config.Debug{
         gin.SetMode(gin.DebugMode)
@@ -269,6 +356,9 @@ func (this *Server) Run() error {
     })
     router.Use(sessions.Sessions("session", store))
 
+    /* S3 compatible API for SigV4 signed requests */
+    s3Controller := s3Controller.New(this.Config, this.db)
+    router.Use(s3Controller.Middleware)
 
     router.GET("/", this.Index)
 
@@ -280,30 +370,101 @@ func (this *Server) Run() error {
     humanGroup := router.Group("/api/v2")
     humanGroup.Use(this.sessionAuthMiddleware)
 
-    humanGroup.POST("/user/list", userController.List)
-    humanGroup.POST("/user/create", userController.Create)
-    humanGroup.POST("/user/delete", userController.Delete)
-    humanGroup.POST("/user/update", userController.Update)
+    humanGroup.GET("/user/whoami", userController.Whoami)
+    humanGroup.POST("/user/whoami", userController.Whoami)
+    humanGroup.POST("/user/password", userController.Password)
+
+    apikeyController := apikeyController.New(this.Config, this.db)
+    humanGroup.POST("/key/create", apikeyController.Create)
+    humanGroup.POST("/key/list", apikeyController.List)
+    humanGroup.POST("/key/revoke", apikeyController.Revoke)
+
+    adminGroup := humanGroup.Group("/")
+    adminGroup.Use(this.adminAuthMiddleware)
+
+    adminGroup.POST("/user/list", userController.List)
+    adminGroup.POST("/user/create", userController.Create)
+    adminGroup.POST("/user/delete", userController.Delete)
+    adminGroup.POST("/user/update", userController.Update)
 
     botGroup := router.Group("/api/v1")
     botGroup.Use(this.uniAuthMiddleware)
 
-    bucketController := bucketController.New(this.Config)
+    bucketController := bucketController.New(this.Config, this.db)
     botGroup.GET("/bucket/list", bucketController.List)
     botGroup.POST("/bucket/list", bucketController.List)
     botGroup.POST("/bucket/pagelist", bucketController.PageList)
-
-    fileController := fileController.New(this.Config)
+    botGroup.POST("/bucket/versioning", bucketController.Versioning)
+    botGroup.POST("/bucket/compression", bucketController.Compression)
+    botGroup.POST("/bucket/encryption", bucketController.Encryption)
+    botGroup.POST("/bucket/create", bucketController.Create)
+    botGroup.POST("/bucket/delete", bucketController.Delete)
+    botGroup.POST("/bucket/rename", bucketController.Rename)
+    botGroup.POST("/bucket/info", bucketController.Info)
+    botGroup.POST("/bucket/lifecycle", bucketController.Lifecycle)
+    botGroup.POST("/bucket/lifecycle/dryrun", bucketController.LifecycleDryRun)
+    go lifecycleModel.New(this.Config, this.db).Scheduler(time.Hour)
+
+    fileController := fileController.New(this.Config, this.db)
     botGroup.POST("/file/list", fileController.List)
     botGroup.POST("/file/pagelist", fileController.PageList)
     botGroup.POST("/file/put", fileController.Put)
     botGroup.POST("/file/get", fileController.Get)
+    botGroup.GET("/file/get", fileController.Get)
+    botGroup.HEAD("/file/get", fileController.Get)
     botGroup.POST("/file/delete", fileController.Delete)
     botGroup.GET("/file/down/*path", fileController.Down)
-
-    statusController := statusController.New(this.Config)
+    botGroup.HEAD("/file/down/*path", fileController.Down)
+    botGroup.POST("/file/tags", fileController.Tags)
+    botGroup.POST("/file/versions", fileController.Versions)
+    botGroup.POST("/file/restore", fileController.Restore)
+    botGroup.POST("/file/purge", fileController.Purge)
+    botGroup.GET("/file/archive", fileController.Archive)
+    botGroup.POST("/file/archive", fileController.Archive)
+    botGroup.POST("/file/extract", fileController.Extract)
+    botGroup.POST("/file/copy", fileController.Copy)
+    botGroup.POST("/file/move", fileController.Move)
+
+    botGroup.POST("/trash/list", fileController.Trash)
+    botGroup.POST("/trash/restore", fileController.TrashRestore)
+    botGroup.POST("/trash/empty", fileController.TrashEmpty)
+    go trashModel.New(this.Config, this.db).Janitor(time.Hour)
+
+    /* Resumable uploads */
+    botGroup.OPTIONS("/upload", fileController.UploadOptions)
+    botGroup.POST("/upload", fileController.UploadCreate)
+    botGroup.HEAD("/upload/:id", fileController.UploadHead)
+    botGroup.PATCH("/upload/:id", fileController.UploadPatch)
+    botGroup.DELETE("/upload/:id", fileController.UploadDelete)
+    go fileController.UploadJanitor(time.Hour)
+
+    /* Version retention */
+    go versionModel.New(this.Config, this.db).Janitor(time.Hour)
+
+    quotaController := quotaController.New(this.Config, this.db)
+    botGroup.POST("/quota/usage", quotaController.Usage)
+    botGroup.POST("/quota/list", this.adminAuthMiddleware, quotaController.List)
+    botGroup.POST("/quota/set", this.adminAuthMiddleware, quotaController.Set)
+
+    statusController := statusController.New(this.Config, this.db)
     botGroup.GET("/status/hello", statusController.Hello)
     botGroup.GET("/status/disk", statusController.Disk)
+    botGroup.GET("/status/dedup", this.adminAuthMiddleware, statusController.Dedup)
+    botGroup.POST("/status/dedup", this.adminAuthMiddleware, statusController.Dedup)
+    botGroup.GET("/status/encryption", this.adminAuthMiddleware, statusController.Encryption)
+    botGroup.POST("/status/encryption", this.adminAuthMiddleware, statusController.Encryption)
+    botGroup.GET("/status/scrub", this.adminAuthMiddleware, statusController.Scrub)
+    botGroup.POST("/status/scrub", this.adminAuthMiddleware, statusController.Scrub)
+
+    /* Integrity scrub of stored files, zero interval disables the schedule */
+    if this.Config.ScrubInterval > 0 {
+        go scrubModel.New(this.Config, this.db).Scheduler(time.Hour)
+    }
+
+    /* Unreferenced blobs of the dedup store */
+    if dedup, ok := storageBackend.FindDedup(storageBackend.Open(this.Config, this.db)); ok {
+        go dedup.Janitor(time.Hour, storageBackend.CollectGrace)
+    }
 
     /* No route handler */
     router.NoRoute(this.NoRoute)
@@ -382,6 +543,38 @@ func (this *Server) sessionAuthMiddleware(context *gin.Context) {
         context.Abort()
         return
     }
+    context.Set("username", username.(string))
+    context.Next()
+}
+
+/* Pass only session users with admin flag, must follow sessionAuthMiddleware */
+func (this *Server) adminAuthMiddleware(context *gin.Context) {
+    username := context.GetString("username")
+
+    /* Scoped API key does not get admin rights of the owner */
+    if value, exists := context.Get("apikey"); exists && !value.(apikeyModel.Key).Unscoped() {
+        result := Result{
+            Error: true,
+            Message: fmt.Sprintf("api key of user %s is scoped", username),
+            Result: "",
+        }
+        context.JSON(http.StatusForbidden, result)
+        context.Abort()
+        return
+    }
+
+    user := userModel.New(this.db)
+    theUser, err := user.Find(userModel.User{ Username: username })
+    if err != nil || !theUser.IsAdmin {
+        result := Result{
+            Error: true,
+            Message: fmt.Sprintf("user %s is not admin", username),
+            Result: "",
+        }
+        context.JSON(http.StatusForbidden, result)
+        context.Abort()
+        return
+    }
     context.Next()
 }
 
@@ -416,6 +609,7 @@ func (this *Server) basicAuthMiddleware(context *gin.Context) {
         context.Abort()
         return
     }
+    context.Set("username", userName)
     context.Next()
 }
 
@@ -425,12 +619,33 @@ func (this *Server) uniAuthMiddleware(context *gin.Context) {
     session := sessions.Default(context)
     username := session.Get("username")
     if username != nil && len(username.(string)) > 0 {
+        context.Set("username", username.(string))
         context.Next()
         return
     }
 
     authHeader := context.Request.Header.Get("Authorization")
 
+    /* API key of bot */
+    if token, err := parseAuthBearerHeader(authHeader); err == nil {
+        key, err := apikeyModel.New(this.db).Authenticate(token)
+        if err != nil {
+            log.Printf("autentification error: %s", err)
+            result := Result{
+                Error: true,
+                Message: fmt.Sprintf("wrong api key"),
+                Result: "",
+            }
+            context.JSON(http.StatusUnauthorized, result)
+            context.Abort()
+            return
+        }
+        context.Set("username", key.Username)
+        context.Set("apikey", key)
+        context.Next()
+        return
+    }
+
     userName, password, err := parseAuthBasicHeader(authHeader)
     if err != nil {
         result := Result{
@@ -453,6 +668,7 @@ func (this *Server) uniAuthMiddleware(context *gin.Context) {
         context.Abort()
         return
     }
+    context.Set("username", userName)
     context.Next()
 }
 
@@ -471,6 +687,18 @@ func (this *Server) authenticateUser(username string, password string) bool {
     return true
 }
 
+func parseAuthBearerHeader(header string) (string, error) {
+    auth := strings.SplitN(header, " ", 2)
+    if len(auth) < 2 || strings.TrimSpace(auth[0]) != "Bearer" {
+        return "", errors.New("authentification type is different from bearer")
+    }
+    token := strings.TrimSpace(auth[1])
+    if len(token) == 0 {
+        return "", errors.New("autentification token is null")
+    }
+    return token, nil
+}
+
 func parseAuthBasicHeader(header string) (string, string, error) {
     auth := strings.SplitN(header, " ", 2)
     authType := strings.TrimSpace(auth[0])
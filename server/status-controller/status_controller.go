@@ -9,8 +9,13 @@ import (
     "syscall"
 
     "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
 
     "store/config"
+    "store/server/blob-model"
+    "store/server/datakey-model"
+    "store/server/scrub-model"
+    "store/server/storage-backend"
 )
 
 const (
@@ -30,6 +35,10 @@ type Response struct {
 
 type Controller struct {
     config *config.Config
+    db *sqlx.DB
+    blobs *blobModel.Model
+    datakeys *datakeyModel.Model
+    scrubs *scrubModel.Model
 }
 
 func sendError(context *gin.Context, err error) {
@@ -85,8 +94,137 @@ func (this *Controller) Disk(context *gin.Context) {
 }
 
 
-func New(config *config.Config) *Controller {
+type DedupForm struct {
+    Collect     bool    `json:"collect"    form:"collect"`
+}
+
+/* Blobs and bytes of the dedup store, removed by collect */
+type Dedup struct {
+    Enabled         bool    `json:"enabled"`
+    blobModel.Stats
+    Removed         int     `json:"removed"`
+    RemovedBytes    int64   `json:"removedbytes"`
+}
+
+/* Logical versus physical bytes of the dedup store, collect
+ * removes unreferenced blobs now instead of waiting the janitor */
+func (this *Controller) Dedup(context *gin.Context) {
+    var form DedupForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    var result Dedup
+    store, enabled := storageBackend.FindDedup(storageBackend.Open(this.config, this.db))
+    result.Enabled = enabled
+    if enabled && form.Collect {
+        var err error
+        result.Removed, result.RemovedBytes, err = store.Collect(storageBackend.CollectGrace)
+        if err != nil {
+            sendError(context, err)
+            return
+        }
+    }
+    stats, err := this.blobs.Stats()
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    result.Stats = stats
+    sendResult(context, result)
+}
+
+type EncryptionForm struct {
+    Rotate      bool    `json:"rotate"     form:"rotate"`
+}
+
+/* Master key and data keys of encrypted files, stale keys
+ * are wrapped by old master keys, rotated by rotate */
+type Encryption struct {
+    Enabled         bool    `json:"enabled"`
+    Master          string  `json:"master,omitempty"`
+    datakeyModel.Stats
+    Rotated         int     `json:"rotated"`
+}
+
+/* Status of encryption, rotate replaces the master key
+ * and wraps data keys by the new key */
+func (this *Controller) Encryption(context *gin.Context) {
+    var form EncryptionForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    var result Encryption
+    store, _ := storageBackend.FindEncrypt(storageBackend.Open(this.config, this.db))
+    result.Enabled = store.Ready()
+    if form.Rotate {
+        var err error
+        result.Rotated, err = store.Rotate()
+        if err != nil {
+            sendError(context, err)
+            return
+        }
+    }
+    result.Master = store.Master()
+    stats, err := this.datakeys.Stats(result.Master)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    result.Stats = stats
+    sendResult(context, result)
+}
+
+type ScrubForm struct {
+    Run         bool    `json:"run"        form:"run"`
+}
+
+/* Progress of the running scrub, summary and issues of the last finished run */
+type Scrub struct {
+    Running         bool                `json:"running"`
+    Current         *scrubModel.Summary `json:"current,omitempty"`
+    Last            *scrubModel.Summary `json:"last,omitempty"`
+    Issues          []scrubModel.Issue  `json:"issues"`
+}
+
+/* Status of the integrity scrub, run starts the scrub now
+ * instead of waiting the schedule */
+func (this *Controller) Scrub(context *gin.Context) {
+    var form ScrubForm
+    if err := context.ShouldBind(&form); err != nil {
+        sendError(context, err)
+        return
+    }
+    if form.Run {
+        if err := this.scrubs.Start(true); err != nil {
+            sendError(context, err)
+            return
+        }
+    }
+    var result Scrub
+    if current, running := scrubModel.Current(); running {
+        result.Running = true
+        result.Current = &current
+    }
+    last, issues, found, err := this.scrubs.Last()
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if found {
+        result.Last = &last
+    }
+    result.Issues = issues
+    sendResult(context, result)
+}
+
+func New(config *config.Config, db *sqlx.DB) *Controller {
     return &Controller{
         config: config,
+        db: db,
+        blobs: blobModel.New(db),
+        datakeys: datakeyModel.New(db),
+        scrubs: scrubModel.New(config, db),
     }
 }
@@ -0,0 +1,320 @@
+package storageBackend
+
+/* Storage of bucket files and internal data of the store. Files are
+ * addressed by keys, slash separated paths relative to the store,
+ * the empty key is the store root. Directories are buckets and
+ * directories of the internal area */
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "io"
+    "io/ioutil"
+    "log"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+    "sync"
+
+    "github.com/jmoiron/sqlx"
+
+    "store/config"
+    "store/server/blob-model"
+    "store/server/datakey-model"
+)
+
+const (
+    StorageLocal    string = "local"
+    StorageMemory   string = "memory"
+    tempDirName     string = "tmp"
+)
+
+var (
+    ErrOffset   = errors.New("offset does not match file size")
+    ErrNotFile  = errors.New("not a regular file")
+)
+
+/* Data of the stored file, seeks and reads at offsets for ranges */
+type File interface {
+    io.Reader
+    io.ReaderAt
+    io.Seeker
+    io.Closer
+    Stat() (os.FileInfo, error)
+}
+
+type Backend interface {
+    /* Write the stream to the file through temporary data, so readers never
+     * see partial data. With noReplace an existing file is kept and
+     * tools.ErrFileExists is returned. Parent directories are created */
+    Put(key string, reader io.Reader, noReplace bool) (int64, error)
+    /* Append the stream to the file of the offset size, ErrOffset
+     * is returned with the current size on mismatch */
+    Append(key string, offset int64, reader io.Reader) (int64, error)
+    Truncate(key string, size int64) error
+    /* Open the regular file for reading */
+    Open(key string) (File, error)
+    /* Reader of length bytes from offset, negative length reads to the end */
+    Get(key string, offset, length int64) (io.ReadCloser, error)
+    /* Stat of the file or directory, entries are not followed */
+    Stat(key string) (os.FileInfo, error)
+    /* Sorted names of the directory starting with the prefix */
+    List(key, prefix string) ([]string, error)
+    /* Create the directory with parents */
+    MakeDir(key string) error
+    /* Remove the file or empty directory */
+    Delete(key string) error
+    /* Remove the directory tree, missing key is not an error */
+    DeleteAll(key string) error
+    /* Copy the file, data may be shared with the source */
+    Copy(srcKey, dstKey string, noReplace bool) error
+    /* Move the file or the directory tree, directory
+     * is merged into the existing directory */
+    Move(srcKey, dstKey string, noReplace bool) error
+}
+
+var (
+    storeLock       sync.Mutex
+    stores          = make(map[*config.Config]Backend)
+)
+
+/* Backend of the config storage, shared by controllers and models of the
+ * same config. Files of buckets are compressed and encrypted by settings
+ * of the buckets, reference counts of the dedup store and data keys of
+ * encrypted files are kept in the database */
+func Open(config *config.Config, db *sqlx.DB) Backend {
+    storeLock.Lock()
+    defer storeLock.Unlock()
+    if store, exists := stores[config]; exists {
+        return store
+    }
+    var store Backend
+    if config.Storage == StorageMemory {
+        store = NewMemory()
+    } else {
+        store = NewLocal(config)
+    }
+    if config.Dedup {
+        store = NewDedup(store, blobModel.New(db))
+    }
+    var keyring *Keyring
+    if len(config.MasterKeyPath) > 0 {
+        var err error
+        if keyring, err = LoadKeyring(config.MasterKeyPath); err != nil {
+            log.Println(err)
+        }
+    }
+    var keys DataKeys
+    var encryptPolicy EncryptPolicy
+    var compressPolicy CompressPolicy
+    if db != nil {
+        keys = datakeyModel.New(db)
+        encryptPolicy = BucketEncryption(db)
+        compressPolicy = BucketPolicy(db)
+    }
+    store = NewEncrypt(store, keys, keyring, encryptPolicy)
+    stores[config] = NewCompress(store, compressPolicy)
+    return stores[config]
+}
+
+/* Backend wrapped by the layer, nil for the base backend */
+func baseOf(store Backend) Backend {
+    switch layer := store.(type) {
+        case *Compress:
+            return layer.base
+        case *Encrypt:
+            return layer.base
+        case *Dedup:
+            return layer.base
+    }
+    return nil
+}
+
+/* Dedup store of the backend, false if dedup is off */
+func FindDedup(store Backend) (*Dedup, bool) {
+    for ; store != nil; store = baseOf(store) {
+        if dedup, ok := store.(*Dedup); ok {
+            return dedup, true
+        }
+    }
+    return nil, false
+}
+
+/* Encryption layer of the backend */
+func FindEncrypt(store Backend) (*Encrypt, bool) {
+    for ; store != nil; store = baseOf(store) {
+        if encrypt, ok := store.(*Encrypt); ok {
+            return encrypt, true
+        }
+    }
+    return nil, false
+}
+
+/* Return true if the storage name is known, empty name is local */
+func Valid(storage string) bool {
+    return len(storage) == 0 || storage == StorageLocal || storage == StorageMemory
+}
+
+/* Clean key of the store relative path */
+func Key(name string) string {
+    return strings.TrimLeft(path.Clean("/" + filepath.ToSlash(name)), "/")
+}
+
+/* Key of the file in the directory key */
+func Join(elem ...string) string {
+    return Key(path.Join(elem...))
+}
+
+/* Directory key of the key, empty for the store root */
+func Dir(key string) string {
+    return Key(path.Dir(Key(key)))
+}
+
+/* Unique key of temporary data in the internal area */
+func TempKey(prefix string) (string, error) {
+    arr := make([]byte, 8)
+    if _, err := rand.Read(arr); err != nil {
+        return "", err
+    }
+    return Join(config.SystemDirName, tempDirName, prefix + hex.EncodeToString(arr)), nil
+}
+
+/* Remove temporary data left by interrupted writes */
+func CleanTemp(store Backend) (int, error) {
+    tmpKey := Join(config.SystemDirName, tempDirName)
+    names, err := store.List(tmpKey, "")
+    if os.IsNotExist(err) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    for i, name := range names {
+        if err := store.DeleteAll(Join(tmpKey, name)); err != nil {
+            return i, err
+        }
+    }
+    return len(names), nil
+}
+
+/* Return true if the regular file exists */
+func FileExists(store Backend, key string) bool {
+    fileInfo, err := store.Stat(key)
+    return err == nil && fileInfo.Mode().IsRegular()
+}
+
+/* Read the whole file */
+func ReadFile(store Backend, key string) ([]byte, error) {
+    file, err := store.Open(key)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return ioutil.ReadAll(file)
+}
+
+/* Stat of the directory entries in name order, entries
+ * removed while listing are skipped */
+func ReadDir(store Backend, key string) ([]os.FileInfo, error) {
+    names, err := store.List(key, "")
+    if err != nil {
+        return nil, err
+    }
+    files := make([]os.FileInfo, 0, len(names))
+    for _, name := range names {
+        fileInfo, err := store.Stat(Join(key, name))
+        if os.IsNotExist(err) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        files = append(files, fileInfo)
+    }
+    return files, nil
+}
+
+/* Visitor of the walk, filepath.SkipDir skips the directory */
+type WalkFunc func(key string, fileInfo os.FileInfo, err error) error
+
+/* Walk the tree of the key in name order like filepath.Walk */
+func Walk(store Backend, key string, walkFn WalkFunc) error {
+    key = Key(key)
+    fileInfo, err := store.Stat(key)
+    if err != nil {
+        err = walkFn(key, nil, err)
+    } else {
+        err = walk(store, key, fileInfo, walkFn)
+    }
+    if err == filepath.SkipDir {
+        return nil
+    }
+    return err
+}
+
+func walk(store Backend, key string, fileInfo os.FileInfo, walkFn WalkFunc) error {
+    if err := walkFn(key, fileInfo, nil); err != nil || !fileInfo.IsDir() {
+        return err
+    }
+    names, err := store.List(key, "")
+    if err != nil {
+        return walkFn(key, fileInfo, err)
+    }
+    for _, name := range names {
+        nameKey := Join(key, name)
+        nameInfo, err := store.Stat(nameKey)
+        if err != nil {
+            if err := walkFn(nameKey, nil, err); err != nil && err != filepath.SkipDir {
+                return err
+            }
+            continue
+        }
+        err = walk(store, nameKey, nameInfo, walkFn)
+        if err != nil && (!nameInfo.IsDir() || err != filepath.SkipDir) {
+            return err
+        }
+    }
+    return nil
+}
+
+/* Put the data written by the function through the pipe,
+ * return size of the data written by the function */
+func putPiped(store Backend, key string, noReplace bool, write func(io.Writer) (int64, error)) (int64, error) {
+    pipeReader, pipeWriter := io.Pipe()
+    var size int64
+    done := make(chan bool)
+    go func() {
+        var err error
+        size, err = write(pipeWriter)
+        pipeWriter.CloseWithError(err)
+        close(done)
+    }()
+    _, err := store.Put(key, pipeReader, noReplace)
+    pipeReader.CloseWithError(io.ErrClosedPipe)
+    <-done
+    return size, err
+}
+
+/* Range of the opened file, the file is closed by the reader */
+type rangeReader struct {
+    io.Reader
+    io.Closer
+}
+
+func newRangeReader(file File, offset, length int64) (io.ReadCloser, error) {
+    if _, err := file.Seek(offset, io.SeekStart); err != nil {
+        file.Close()
+        return nil, err
+    }
+    if length < 0 {
+        return file, nil
+    }
+    return rangeReader{ io.LimitReader(file, length), file }, nil
+}
+
+/* Not found error of the key like os errors */
+func notFound(op, key string) error {
+    return &os.PathError{ Op: op, Path: key, Err: os.ErrNotExist }
+}
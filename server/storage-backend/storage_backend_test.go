@@ -0,0 +1,702 @@
+package storageBackend
+
+import (
+    "bytes"
+    "compress/gzip"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "store/config"
+    "store/server/blob-model"
+    "store/server/datakey-model"
+    "store/server/object-model"
+    "store/tools"
+)
+
+/* Same checks run against every backend */
+func testBackend(t *testing.T, store Backend) {
+    read := func(key string, offset, length int64) string {
+        reader, err := store.Get(key, offset, length)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer reader.Close()
+        data, _ := ioutil.ReadAll(reader)
+        return string(data)
+    }
+
+    /* Put, get range and stat */
+    if size, err := store.Put("site/docs/index.html", strings.NewReader("hello world"), false); err != nil || size != 11 {
+        t.Fatalf("put failed %d %v", size, err)
+    }
+    if data := read("site/docs/index.html", 6, 3); data != "wor" {
+        t.Errorf("wrong range %q", data)
+    }
+    if data := read("/site/docs/../docs/index.html", 6, -1); data != "world" {
+        t.Errorf("wrong tail %q", data)
+    }
+    if fileInfo, err := store.Stat("site/docs"); err != nil || !fileInfo.IsDir() {
+        t.Errorf("parent is not created %v", err)
+    }
+    if fileInfo, err := store.Stat("site/docs/index.html"); err != nil || fileInfo.Size() != 11 || fileInfo.Name() != "index.html" {
+        t.Errorf("wrong stat %v", err)
+    }
+    if _, err := store.Stat("site/none"); !os.IsNotExist(err) {
+        t.Errorf("missing file is found %v", err)
+    }
+    if _, err := store.Open("site/docs"); err == nil {
+        t.Error("directory is opened as file")
+    }
+
+    /* Failed or refused write keeps the file */
+    if _, err := store.Put("site/docs/index.html", strings.NewReader("new"), true); err != tools.ErrFileExists {
+        t.Errorf("file is replaced with noReplace %v", err)
+    }
+    failing := ioutil.NopCloser(&failReader{ strings.NewReader("partial") })
+    if _, err := store.Put("site/docs/index.html", failing, false); err == nil {
+        t.Error("failed read is not reported")
+    }
+    if data := read("site/docs/index.html", 0, -1); data != "hello world" {
+        t.Errorf("file is damaged %q", data)
+    }
+
+    /* List with prefix */
+    store.Put("site/docs/about.html", strings.NewReader(""), false)
+    store.MakeDir("site/docs/api")
+    if names, err := store.List("site/docs", ""); err != nil || !reflect.DeepEqual(names, []string{ "about.html", "api", "index.html" }) {
+        t.Errorf("wrong list %v %v", names, err)
+    }
+    if names, _ := store.List("site/docs", "a"); !reflect.DeepEqual(names, []string{ "about.html", "api" }) {
+        t.Errorf("wrong list of prefix %v", names)
+    }
+    if names, _ := store.List("", "s"); !reflect.DeepEqual(names, []string{ "site" }) {
+        t.Errorf("wrong list of root %v", names)
+    }
+
+    /* Append at offset and truncate */
+    store.Put("up/data", strings.NewReader(""), true)
+    if size, err := store.Append("up/data", 0, strings.NewReader("abc")); err != nil || size != 3 {
+        t.Errorf("append failed %d %v", size, err)
+    }
+    if size, err := store.Append("up/data", 1, strings.NewReader("x")); err != ErrOffset || size != 3 {
+        t.Errorf("wrong offset is accepted %d %v", size, err)
+    }
+    store.Append("up/data", 3, strings.NewReader("def"))
+    if err := store.Truncate("up/data", 4); err != nil {
+        t.Error(err)
+    }
+    if data := read("up/data", 0, -1); data != "abcd" {
+        t.Errorf("wrong appended data %q", data)
+    }
+
+    /* Copy is independent of the source */
+    if err := store.Copy("up/data", "site/copy", false); err != nil {
+        t.Fatal(err)
+    }
+    if err := store.Copy("up/data", "site/copy", true); err != tools.ErrFileExists {
+        t.Errorf("copy replaced file with noReplace %v", err)
+    }
+    store.Put("up/data", strings.NewReader("changed"), false)
+    if data := read("site/copy", 0, -1); data != "abcd" {
+        t.Errorf("copy is changed %q", data)
+    }
+
+    /* Move file and merge directory tree */
+    if err := store.Move("site/copy", "site/docs/index.html", true); err != tools.ErrFileExists {
+        t.Errorf("move replaced file with noReplace %v", err)
+    }
+    if err := store.Move("site/copy", "site/moved", false); err != nil || FileExists(store, "site/copy") {
+        t.Errorf("file is not moved %v", err)
+    }
+    store.Put("www/docs/new.html", strings.NewReader("new"), false)
+    if err := store.Move("www", "site", false); err != nil {
+        t.Fatal(err)
+    }
+    if !FileExists(store, "site/docs/new.html") || !FileExists(store, "site/docs/index.html") {
+        t.Error("directory is not merged")
+    }
+    if _, err := store.Stat("www"); !os.IsNotExist(err) {
+        t.Error("moved directory is kept")
+    }
+
+    /* Walk in name order */
+    var keys []string
+    Walk(store, "site", func(key string, fileInfo os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        keys = append(keys, key)
+        if key == "site/docs/api" {
+            return filepath.SkipDir
+        }
+        return nil
+    })
+    expected := []string{ "site", "site/docs", "site/docs/about.html", "site/docs/api",
+                            "site/docs/index.html", "site/docs/new.html", "site/moved" }
+    if !reflect.DeepEqual(keys, expected) {
+        t.Errorf("wrong walk %v", keys)
+    }
+
+    /* Delete file, empty directory and tree */
+    if err := store.Delete("site/docs"); err == nil {
+        t.Error("not empty directory is deleted")
+    }
+    if err := store.Delete("site/docs/api"); err != nil {
+        t.Error(err)
+    }
+    if err := store.DeleteAll("site"); err != nil || FileExists(store, "site/moved") {
+        t.Errorf("tree is not deleted %v", err)
+    }
+    if err := store.DeleteAll("none"); err != nil {
+        t.Errorf("missing tree is not deleted %v", err)
+    }
+}
+
+type failReader struct {
+    reader *strings.Reader
+}
+
+func (this *failReader) Read(data []byte) (int, error) {
+    if this.reader.Len() == 0 {
+        return 0, errors.New("read failed")
+    }
+    return this.reader.Read(data)
+}
+
+func TestLocal(t *testing.T) {
+    storeDir, err := ioutil.TempDir("", "store-")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(storeDir)
+    testBackend(t, NewLocal(&config.Config{ StoreDir: storeDir }))
+
+    /* Layout of the store directory */
+    store := NewLocal(&config.Config{ StoreDir: storeDir })
+    store.Put("bucket/file.txt", bytes.NewReader([]byte("data")), false)
+    if data, err := ioutil.ReadFile(filepath.Join(storeDir, "bucket/file.txt")); err != nil || string(data) != "data" {
+        t.Errorf("wrong local file %q %v", data, err)
+    }
+}
+
+func TestMemory(t *testing.T) {
+    testBackend(t, NewMemory())
+}
+
+/* Reference counts of the dedup store in memory */
+type testRefs map[string]*blobModel.Blob
+
+func (this testRefs) AddRef(sum string, size int64) error {
+    if _, exists := this[sum]; !exists {
+        this[sum] = &blobModel.Blob{ SHA256: sum, Size: size }
+    }
+    this[sum].Refs += 1
+    return nil
+}
+
+func (this testRefs) Release(sum string) error {
+    if blob, exists := this[sum]; exists && blob.Refs > 0 {
+        blob.Refs -= 1
+    }
+    return nil
+}
+
+func (this testRefs) Find(sum string) (blobModel.Blob, bool, error) {
+    if blob, exists := this[sum]; exists {
+        return *blob, true, nil
+    }
+    return blobModel.Blob{}, false, nil
+}
+
+func (this testRefs) Unreferenced(before time.Time) ([]blobModel.Blob, error) {
+    blobs := []blobModel.Blob{}
+    for _, blob := range this {
+        if blob.Refs == 0 {
+            blobs = append(blobs, *blob)
+        }
+    }
+    return blobs, nil
+}
+
+func (this testRefs) Delete(sum string) (bool, error) {
+    if blob, exists := this[sum]; exists && blob.Refs == 0 {
+        delete(this, sum)
+        return true, nil
+    }
+    return false, nil
+}
+
+func TestDedup(t *testing.T) {
+    testBackend(t, NewDedup(NewMemory(), make(testRefs)))
+
+    base := NewMemory()
+    refs := make(testRefs)
+    store := NewDedup(base, refs)
+    data := strings.Repeat("library ", 100)
+    store.Put("builds/1/lib.jar", strings.NewReader(data), false)
+    store.Put("builds/2/lib.jar", strings.NewReader(data), false)
+    store.Copy("builds/1/lib.jar", ".m2store/versions/builds/1/lib.jar/v1", false)
+
+    /* One blob with references of the logical size */
+    hash := sha256.Sum256([]byte(data))
+    sum := hex.EncodeToString(hash[:])
+    if len(refs) != 1 || refs[sum].Refs != 3 {
+        t.Fatalf("data is not deduplicated %v", refs)
+    }
+    if fileInfo, err := base.Stat("builds/2/lib.jar"); err != nil || fileInfo.Size() > refMaxSize {
+        t.Errorf("file is not a reference %v", err)
+    }
+    if fileInfo, err := store.Stat("builds/2/lib.jar"); err != nil || fileInfo.Size() != int64(len(data)) {
+        t.Errorf("wrong size of reference %v", err)
+    }
+    if read, _ := ReadFile(store, "builds/2/lib.jar"); string(read) != data {
+        t.Error("wrong data of reference")
+    }
+
+    /* Forged reference is raw data */
+    forged, _ := ReadFile(base, "builds/1/lib.jar")
+    forged = bytes.Replace(forged, []byte(" 800 "), []byte(" 801 "), 1)
+    store.Put("evil/ref", bytes.NewReader(forged), false)
+    if read, _ := ReadFile(store, "evil/ref"); !bytes.Equal(read, forged) {
+        t.Error("uploaded data is taken as a reference")
+    }
+
+    /* Upload data is raw and converted when moved in a bucket */
+    store.Put(".m2store/uploads/up1", strings.NewReader(""), true)
+    store.Append(".m2store/uploads/up1", 0, strings.NewReader(data))
+    if err := store.Move(".m2store/uploads/up1", "builds/3/lib.jar", false); err != nil {
+        t.Fatal(err)
+    }
+    if refs[sum].Refs != 4 || FileExists(store, ".m2store/uploads/up1") {
+        t.Errorf("upload is not deduplicated %d", refs[sum].Refs)
+    }
+
+    /* Replace, move over and delete release references */
+    store.Put("builds/1/lib.jar", strings.NewReader("new"), false)
+    store.Move("builds/3/lib.jar", "builds/2/lib.jar", false)
+    store.DeleteAll("builds")
+    if refs[sum].Refs != 1 {
+        t.Errorf("references are not released %d", refs[sum].Refs)
+    }
+    store.Delete(".m2store/versions/builds/1/lib.jar/v1")
+
+    /* Unreferenced and unknown blobs are collected */
+    base.Put(blobKey(strings.Repeat("ab", 32)), strings.NewReader("orphan"), false)
+    time.Sleep(10 * time.Millisecond)
+    count, size, err := store.Collect(time.Millisecond)
+    if err != nil || count != 3 || size != int64(len(data) + len("new") + len("orphan")) {
+        t.Errorf("wrong collect %d %d %v", count, size, err)
+    }
+    if FileExists(base, blobKey(sum)) || len(refs) != 1 {
+        t.Errorf("blob is not collected %v", refs)
+    }
+}
+
+func TestCompress(t *testing.T) {
+    testBackend(t, NewCompress(NewMemory(), nil))
+
+    base := NewMemory()
+    policy := func(key string, head []byte) string {
+        if strings.HasPrefix(key, "logs/") {
+            return EncodingZstd
+        }
+        if strings.HasPrefix(key, "docs/") {
+            return EncodingGzip
+        }
+        return ""
+    }
+    store := NewCompress(base, policy)
+    data := strings.Repeat("2020-01-01 request served\n", 1000)
+
+    /* Both codings are decoded by reads and ranges */
+    for _, key := range []string{ "logs/app.log", "docs/app.log" } {
+        store.Put(key, strings.NewReader(data), false)
+        stored, _ := base.Stat(key)
+        fileInfo, err := store.Stat(key)
+        if err != nil || fileInfo.Size() != int64(len(data)) || stored.Size() >= int64(len(data)) {
+            t.Fatalf("%s is not compressed %v", key, err)
+        }
+        if objectModel.StoredSize(fileInfo) != stored.Size() {
+            t.Errorf("wrong stored size of %s", key)
+        }
+        if read, _ := ReadFile(store, key); string(read) != data {
+            t.Errorf("wrong data of %s", key)
+        }
+        reader, err := store.Get(key, 26 * 500, 26)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if part, _ := ioutil.ReadAll(reader); string(part) != "2020-01-01 request served\n" {
+            t.Errorf("wrong range of %s %q", key, part)
+        }
+        reader.Close()
+    }
+    if err := store.Truncate("logs/app.log", 0); !errors.Is(err, ErrEncoded) {
+        t.Errorf("compressed file is truncated %v", err)
+    }
+
+    /* Coded payload is sent with the coding */
+    file, encoding, err := OpenEncoded(store, "docs/app.log")
+    if err != nil || encoding != EncodingGzip {
+        t.Fatalf("wrong encoding %s %v", encoding, err)
+    }
+    gzipReader, err := gzip.NewReader(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if read, _ := ioutil.ReadAll(gzipReader); string(read) != data {
+        t.Error("wrong coded payload")
+    }
+    file.Close()
+
+    /* Raw data looking like a frame is escaped */
+    forged := string(encodedMagic) + "\x01\x01data"
+    store.Put("other/forged", strings.NewReader(forged), false)
+    if read, _ := ReadFile(store, "other/forged"); string(read) != forged {
+        t.Errorf("raw data is taken as a frame %q", read)
+    }
+    if _, encoding, _ := OpenEncoded(store, "other/forged"); len(encoding) > 0 {
+        t.Error("escaped data is encoded")
+    }
+
+    /* Uploads are raw and compressed when moved in the bucket,
+     * incompressible data is kept raw */
+    store.Put(".m2store/uploads/up1", strings.NewReader(""), true)
+    store.Append(".m2store/uploads/up1", 0, strings.NewReader(data))
+    if err := store.Move(".m2store/uploads/up1", "logs/up.log", false); err != nil {
+        t.Fatal(err)
+    }
+    if fileInfo, _ := store.Stat("logs/up.log"); objectModel.StoredSize(fileInfo) >= int64(len(data)) {
+        t.Error("upload is not compressed")
+    }
+    random := make([]byte, 4096)
+    rand.Read(random)
+    store.Put(".m2store/uploads/up2", bytes.NewReader(random), true)
+    store.Move(".m2store/uploads/up2", "logs/random", false)
+    if fileInfo, _ := store.Stat("logs/random"); objectModel.StoredSize(fileInfo) != int64(len(random)) {
+        t.Error("incompressible data is framed")
+    }
+    if read, _ := ReadFile(store, "logs/random"); !bytes.Equal(read, random) {
+        t.Error("wrong incompressible data")
+    }
+}
+
+type testKeys map[string]*datakeyModel.DataKey
+
+func (this testKeys) Save(keyId, master, wrapped string) error {
+    this[keyId] = &datakeyModel.DataKey{ KeyId: keyId, Master: master, Wrapped: wrapped, Refs: 1 }
+    return nil
+}
+
+func (this testKeys) AddRef(keyId string) error {
+    if key, exists := this[keyId]; exists {
+        key.Refs++
+    }
+    return nil
+}
+
+func (this testKeys) Release(keyId string) (bool, error) {
+    key, exists := this[keyId]
+    if !exists {
+        return false, nil
+    }
+    if key.Refs--; key.Refs > 0 {
+        return false, nil
+    }
+    delete(this, keyId)
+    return true, nil
+}
+
+func (this testKeys) Find(keyId string) (datakeyModel.DataKey, bool, error) {
+    if key, exists := this[keyId]; exists {
+        return *key, true, nil
+    }
+    return datakeyModel.DataKey{}, false, nil
+}
+
+func (this testKeys) Stale(master string, limit int) ([]datakeyModel.DataKey, error) {
+    keys := []datakeyModel.DataKey{}
+    for _, key := range this {
+        if key.Master != master && len(keys) < limit {
+            keys = append(keys, *key)
+        }
+    }
+    return keys, nil
+}
+
+func (this testKeys) Rewrap(keyId, master, wrapped string) error {
+    this[keyId].Master, this[keyId].Wrapped = master, wrapped
+    return nil
+}
+
+func TestEncrypt(t *testing.T) {
+    testBackend(t, NewEncrypt(NewMemory(), nil, nil, nil))
+
+    dir, err := ioutil.TempDir("", "encrypt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    keyPath := filepath.Join(dir, "master.key")
+    ioutil.WriteFile(keyPath, []byte("# master key\n" + strings.Repeat("a1", 32) + "\n"), 0600)
+    keyring, err := LoadKeyring(keyPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    base := NewMemory()
+    keys := make(testKeys)
+    policy := func(key string) bool {
+        return strings.HasPrefix(key, "secret/")
+    }
+    store := NewEncrypt(base, keys, keyring, policy)
+
+    /* Chunks of the full size, the partial and the empty one */
+    data := make([]byte, 3 * sealedChunkSize + 100)
+    rand.Read(data)
+    sizes := []int64{ int64(len(data)), 2 * sealedChunkSize, 10, 0 }
+    for _, size := range sizes {
+        key := fmt.Sprintf("secret/data%d", size)
+        store.Put(key, bytes.NewReader(data[:size]), false)
+        stored, _ := ReadFile(base, key)
+        if size > 0 && bytes.Contains(stored, data[:10]) {
+            t.Errorf("data of %d bytes is stored plain", size)
+        }
+        fileInfo, err := store.Stat(key)
+        if err != nil || fileInfo.Size() != size || objectModel.StoredSize(fileInfo) != int64(len(stored)) {
+            t.Errorf("wrong size of %d bytes %v", size, err)
+        }
+        if read, err := ReadFile(store, key); err != nil || !bytes.Equal(read, data[:size]) {
+            t.Errorf("wrong data of %d bytes %v", size, err)
+        }
+    }
+    reader, err := store.Get("secret/data" + fmt.Sprint(len(data)), sealedChunkSize - 5, 10)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if part, _ := ioutil.ReadAll(reader); !bytes.Equal(part, data[sealedChunkSize - 5:sealedChunkSize + 5]) {
+        t.Error("wrong range over chunks")
+    }
+    reader.Close()
+    if _, err := store.Append("secret/data10", 10, strings.NewReader("more")); !errors.Is(err, ErrEncrypted) {
+        t.Errorf("encrypted file is appended %v", err)
+    }
+
+    /* Changed and cut data is not read */
+    stored, _ := ReadFile(base, "secret/data10")
+    stored[len(stored) - 1] ^= 1
+    base.Put("secret/changed", bytes.NewReader(stored), false)
+    stored, _ = ReadFile(base, fmt.Sprintf("secret/data%d", 2 * sealedChunkSize))
+    base.Put("secret/cut", bytes.NewReader(stored[:sealedHeaderSize + sealedChunkSize + sealedTagSize]), false)
+    for _, key := range []string{ "secret/changed", "secret/cut" } {
+        if _, err := ReadFile(store, key); !errors.Is(err, ErrCorrupted) {
+            t.Errorf("%s is read %v", key, err)
+        }
+    }
+
+    /* Raw data looking like a header is escaped */
+    forged := string(sealedMagic) + "\x01\x01data"
+    store.Put("plain/forged", strings.NewReader(forged), false)
+    if read, _ := ReadFile(store, "plain/forged"); string(read) != forged {
+        t.Errorf("raw data is taken as encrypted %q", read)
+    }
+
+    /* Uploads are raw and encrypted when moved in the bucket */
+    store.Put(".m2store/uploads/up1", strings.NewReader(""), true)
+    store.Append(".m2store/uploads/up1", 0, bytes.NewReader(data[:1000]))
+    if err := store.Move(".m2store/uploads/up1", "secret/up", false); err != nil {
+        t.Fatal(err)
+    }
+    if stored, _ := ReadFile(base, "secret/up"); !bytes.HasPrefix(stored, sealedMagic) {
+        t.Error("upload is not encrypted")
+    }
+
+    /* Rotation wraps data keys by the new master key */
+    old := &Keyring{ path: keyPath, keys: keyring.keys }
+    count, err := store.Rotate()
+    if err != nil || count != len(keys) {
+        t.Fatalf("wrong rotation %d of %d %v", count, len(keys), err)
+    }
+    rotated, err := LoadKeyring(keyPath)
+    if err != nil || len(rotated.keys) != 1 || MasterId(rotated.keys[0]) == MasterId(old.keys[0]) {
+        t.Fatalf("key file is not rotated %v", err)
+    }
+    store = NewEncrypt(base, keys, rotated, policy)
+    if read, err := ReadFile(store, "secret/up"); err != nil || !bytes.Equal(read, data[:1000]) {
+        t.Errorf("data is not read after rotation %v", err)
+    }
+    if _, err := ReadFile(NewEncrypt(base, keys, old, policy), "secret/up"); err == nil {
+        t.Error("data is read by the old master key")
+    }
+
+    /* Data keys are counted by copies and deleted with the last file */
+    count = len(keys)
+    store.Put("secret/shared", strings.NewReader("shared"), false)
+    store.Copy("secret/shared", "secret/copy", false)
+    store.Copy("secret/shared", "secret/nested/copy", false)
+    if len(keys) != count + 1 {
+        t.Fatalf("copy has own data key, %d of %d keys", len(keys), count + 1)
+    }
+    store.Delete("secret/shared")
+    store.Move("secret/copy", "secret/moved", false)
+    if read, err := ReadFile(store, "secret/moved"); err != nil || string(read) != "shared" {
+        t.Fatalf("copy is not read after delete %v", err)
+    }
+    store.Put("secret/moved", strings.NewReader("replaced"), false)
+    if len(keys) != count + 2 {
+        t.Errorf("data key of the replaced copy is released early, %d of %d keys", len(keys), count + 2)
+    }
+    store.DeleteAll("secret/nested")
+    store.Delete("secret/moved")
+    if len(keys) != count {
+        t.Errorf("data keys of removed files are kept, %d of %d keys", len(keys), count)
+    }
+    if _, err := store.Put("secret/up", strings.NewReader("exists"), true); err == nil || len(keys) != count {
+        t.Errorf("data key of the file not written is kept %v", err)
+    }
+
+    /* Compressed data is encrypted */
+    layered := NewCompress(store, func(key string, head []byte) string {
+        return EncodingZstd
+    })
+    text := strings.Repeat("compressed and encrypted ", 10000)
+    layered.Put("secret/text", strings.NewReader(text), false)
+    if read, _ := ReadFile(layered, "secret/text"); string(read) != text {
+        t.Error("wrong compressed data")
+    }
+    if stored, _ := ReadFile(base, "secret/text"); !bytes.HasPrefix(stored, sealedMagic) || len(stored) > len(text) / 10 {
+        t.Error("compressed data is not encrypted")
+    }
+}
+
+/* Data keys shared by concurrent writes and rotation,
+ * saving is slow to let rotation run in between */
+type lockedKeys struct {
+    lock        sync.Mutex
+    keys        testKeys
+}
+
+func (this *lockedKeys) Save(keyId, master, wrapped string) error {
+    time.Sleep(time.Millisecond)
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    return this.keys.Save(keyId, master, wrapped)
+}
+
+func (this *lockedKeys) AddRef(keyId string) error {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    return this.keys.AddRef(keyId)
+}
+
+func (this *lockedKeys) Release(keyId string) (bool, error) {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    return this.keys.Release(keyId)
+}
+
+func (this *lockedKeys) Find(keyId string) (datakeyModel.DataKey, bool, error) {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    return this.keys.Find(keyId)
+}
+
+func (this *lockedKeys) Stale(master string, limit int) ([]datakeyModel.DataKey, error) {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    return this.keys.Stale(master, limit)
+}
+
+func (this *lockedKeys) Rewrap(keyId, master, wrapped string) error {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    return this.keys.Rewrap(keyId, master, wrapped)
+}
+
+func TestEncryptRotate(t *testing.T) {
+    dir, err := ioutil.TempDir("", "encrypt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    keyPath := filepath.Join(dir, "master.key")
+    ioutil.WriteFile(keyPath, []byte(strings.Repeat("b2", 32) + "\n"), 0600)
+    keyring, err := LoadKeyring(keyPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    base := NewMemory()
+    keys := &lockedKeys{ keys: make(testKeys) }
+    policy := func(key string) bool {
+        return true
+    }
+    store := NewEncrypt(base, keys, keyring, policy)
+
+    /* Files written during rotations are read by the last master key */
+    var wait sync.WaitGroup
+    for i := 0; i < 4; i++ {
+        wait.Add(1)
+        go func(writer int) {
+            defer wait.Done()
+            for j := 0; j < 50; j++ {
+                key := fmt.Sprintf("secret/file%d-%d", writer, j)
+                if _, err := store.Put(key, strings.NewReader(key), false); err != nil {
+                    t.Error(err)
+                }
+            }
+        }(i)
+    }
+    done := make(chan struct{})
+    go func() {
+        wait.Wait()
+        close(done)
+    }()
+    for rotating := true; rotating; {
+        select {
+            case <-done:
+                rotating = false
+            default:
+                if _, err := store.Rotate(); err != nil {
+                    t.Fatal(err)
+                }
+        }
+    }
+
+    rotated, err := LoadKeyring(keyPath)
+    if err != nil || len(rotated.keys) != 1 {
+        t.Fatalf("key file is not rotated %v", err)
+    }
+    store = NewEncrypt(base, keys, rotated, policy)
+    for i := 0; i < 4; i++ {
+        for j := 0; j < 50; j++ {
+            key := fmt.Sprintf("secret/file%d-%d", i, j)
+            if read, err := ReadFile(store, key); err != nil || string(read) != key {
+                t.Errorf("%s is not read after rotation %v", key, err)
+            }
+        }
+    }
+}
+
+func TestOpen(t *testing.T) {
+    conf := &config.Config{ Storage: StorageMemory }
+    if Open(conf, nil) != Open(conf, nil) {
+        t.Error("memory store is not shared")
+    }
+    if _, ok := Open(&config.Config{ StoreDir: "/tmp" }, nil).(*Compress).base.(*Encrypt).base.(*Local); !ok {
+        t.Error("local store is not default")
+    }
+    conf = &config.Config{ Storage: StorageMemory, Dedup: true }
+    if _, ok := FindDedup(Open(conf, nil)); !ok {
+        t.Error("dedup store is not found")
+    }
+}
@@ -0,0 +1,533 @@
+package storageBackend
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "encoding/binary"
+    "errors"
+    "io"
+    "io/ioutil"
+    "os"
+    "path"
+
+    "github.com/jmoiron/sqlx"
+    "github.com/klauspost/compress/zstd"
+
+    "store/config"
+    "store/server/bucket-model"
+    "store/server/object-model"
+)
+
+const (
+    EncodingGzip    string = "gzip"
+    EncodingZstd    string = "zstd"
+    codingNone      byte = 0
+    codingGzip      byte = 1
+    codingZstd      byte = 2
+    encodedVersion  byte = 1
+    /* Magic, format version and coding of the data */
+    encodedHeaderSize   int64 = 8
+    /* Size of the decoded data, big endian */
+    encodedTrailerSize  int64 = 8
+    /* Data head passed to the policy for content type detection */
+    policyHeadSize  int = 512
+)
+
+var (
+    ErrEncoded      = errors.New("file is stored compressed")
+    encodedMagic    = []byte("\x89M2Z\r\n")
+)
+
+/* Coding of new data of the bucket file by the name
+ * and the head of the data, empty to store as is */
+type CompressPolicy func(key string, head []byte) string
+
+/* Compression of files at rest over the base backend. Compressed data is
+ * framed by the header and the trailer with the size, so files are read
+ * without metadata and compressed files moved to versions or trash stay
+ * compressed. Raw data starting with the magic is framed without coding.
+ * Upload data written in place is never framed */
+type Compress struct {
+    base        Backend
+    policy      CompressPolicy
+}
+
+/* Stat of the compressed file with the decoded size */
+type encodedInfo struct {
+    os.FileInfo
+    size        int64
+    encoding    string
+}
+
+func (this encodedInfo) Size() int64 {
+    return this.size
+}
+
+/* Size of the data at rest, encrypted frame is larger than the frame */
+func (this encodedInfo) StoredSize() int64 {
+    if stored, ok := this.FileInfo.(interface{ StoredSize() int64 }); ok {
+        return stored.StoredSize()
+    }
+    return this.FileInfo.Size()
+}
+
+func (this encodedInfo) Encoding() string {
+    return this.encoding
+}
+
+/* Stat of the stored coded data without the frame */
+type payloadInfo struct {
+    os.FileInfo
+    size        int64
+}
+
+func (this payloadInfo) Size() int64 {
+    return this.size
+}
+
+type payloadFile struct {
+    *io.SectionReader
+    file        File
+    info        payloadInfo
+}
+
+func (this *payloadFile) Close() error {
+    return this.file.Close()
+}
+
+func (this *payloadFile) Stat() (os.FileInfo, error) {
+    return this.info, nil
+}
+
+func encodingName(coding byte) string {
+    switch coding {
+        case codingGzip:
+            return EncodingGzip
+        case codingZstd:
+            return EncodingZstd
+    }
+    return ""
+}
+
+/* Upload data is appended in place and is always raw */
+func rawKey(key string) bool {
+    return key == Join(config.SystemDirName, uploadDirName) ||
+                    Dir(key) == Join(config.SystemDirName, uploadDirName)
+}
+
+/* Coding and decoded size of the framed file */
+func readFrame(file io.ReaderAt, fileInfo os.FileInfo) (byte, int64, bool) {
+    if !fileInfo.Mode().IsRegular() || fileInfo.Size() < encodedHeaderSize + encodedTrailerSize {
+        return codingNone, 0, false
+    }
+    header := make([]byte, encodedHeaderSize)
+    if _, err := file.ReadAt(header, 0); err != nil || !bytes.HasPrefix(header, encodedMagic) {
+        return codingNone, 0, false
+    }
+    version, coding := header[len(encodedMagic)], header[len(encodedMagic) + 1]
+    if version != encodedVersion || coding > codingZstd {
+        return codingNone, 0, false
+    }
+    trailer := make([]byte, encodedTrailerSize)
+    if _, err := file.ReadAt(trailer, fileInfo.Size() - encodedTrailerSize); err != nil {
+        return codingNone, 0, false
+    }
+    return coding, int64(binary.BigEndian.Uint64(trailer)), true
+}
+
+type nopWriteCloser struct {
+    io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+    return nil
+}
+
+/* Write the framed coded stream, return size of the data */
+func encode(writer io.Writer, reader io.Reader, coding byte) (int64, error) {
+    header := append(append([]byte{}, encodedMagic...), encodedVersion, coding)
+    if _, err := writer.Write(header); err != nil {
+        return 0, err
+    }
+    var encoder io.WriteCloser = nopWriteCloser{ writer }
+    var err error
+    switch coding {
+        case codingGzip:
+            encoder = gzip.NewWriter(writer)
+        case codingZstd:
+            encoder, err = zstd.NewWriter(writer, zstd.WithEncoderConcurrency(1))
+    }
+    if err != nil {
+        return 0, err
+    }
+    size, err := io.Copy(encoder, reader)
+    if closeErr := encoder.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        return size, err
+    }
+    trailer := make([]byte, encodedTrailerSize)
+    binary.BigEndian.PutUint64(trailer, uint64(size))
+    _, err = writer.Write(trailer)
+    return size, err
+}
+
+func newDecoder(coding byte, reader io.Reader) (io.Reader, func(), error) {
+    switch coding {
+        case codingGzip:
+            decoder, err := gzip.NewReader(reader)
+            if err != nil {
+                return nil, nil, err
+            }
+            return decoder, func() { decoder.Close() }, nil
+        case codingZstd:
+            decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+            if err != nil {
+                return nil, nil, err
+            }
+            return decoder, decoder.Close, nil
+    }
+    return reader, func() {}, nil
+}
+
+/* Decoded data of the framed file. Seeks are lazy, reads after
+ * a seek back decode the data from the start again */
+type decodedFile struct {
+    file        File
+    info        encodedInfo
+    coding      byte
+    decoder     io.Reader
+    closer      func()
+    offset      int64
+    decoded     int64
+}
+
+func (this *decodedFile) closeDecoder() {
+    if this.closer != nil {
+        this.closer()
+    }
+    this.decoder, this.closer = nil, nil
+}
+
+func (this *decodedFile) reset() error {
+    this.closeDecoder()
+    payload := io.NewSectionReader(this.file, encodedHeaderSize,
+                    this.info.FileInfo.Size() - encodedHeaderSize - encodedTrailerSize)
+    decoder, closer, err := newDecoder(this.coding, payload)
+    if err != nil {
+        return err
+    }
+    this.decoder, this.closer, this.decoded = decoder, closer, 0
+    return nil
+}
+
+func (this *decodedFile) Read(data []byte) (int, error) {
+    if this.offset >= this.info.size {
+        return 0, io.EOF
+    }
+    if this.decoder == nil || this.offset < this.decoded {
+        if err := this.reset(); err != nil {
+            return 0, err
+        }
+    }
+    if this.offset > this.decoded {
+        skipped, err := io.CopyN(ioutil.Discard, this.decoder, this.offset - this.decoded)
+        this.decoded += skipped
+        if err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        if err != nil {
+            return 0, err
+        }
+    }
+    if rest := this.info.size - this.offset; int64(len(data)) > rest {
+        data = data[:rest]
+    }
+    size, err := this.decoder.Read(data)
+    this.offset += int64(size)
+    this.decoded += int64(size)
+    if err == io.EOF && this.offset < this.info.size {
+        err = io.ErrUnexpectedEOF
+    }
+    return size, err
+}
+
+func (this *decodedFile) ReadAt(data []byte, offset int64) (int, error) {
+    saved := this.offset
+    defer func() { this.offset = saved }()
+    this.offset = offset
+    size, err := io.ReadFull(this, data)
+    if err == io.ErrUnexpectedEOF && this.offset >= this.info.size {
+        err = io.EOF
+    }
+    return size, err
+}
+
+func (this *decodedFile) Seek(offset int64, whence int) (int64, error) {
+    switch whence {
+        case io.SeekCurrent:
+            offset += this.offset
+        case io.SeekEnd:
+            offset += this.info.size
+    }
+    if offset < 0 {
+        return this.offset, &os.PathError{ Op: "seek", Path: this.info.Name(), Err: os.ErrInvalid }
+    }
+    this.offset = offset
+    return offset, nil
+}
+
+func (this *decodedFile) Close() error {
+    this.closeDecoder()
+    return this.file.Close()
+}
+
+func (this *decodedFile) Stat() (os.FileInfo, error) {
+    return this.info, nil
+}
+
+/* Coding of new data of the key by the policy */
+func (this *Compress) coding(key string, head []byte) byte {
+    if this.policy == nil || rawKey(key) {
+        return codingNone
+    }
+    switch this.policy(key, head) {
+        case EncodingGzip:
+            return codingGzip
+        case EncodingZstd:
+            return codingZstd
+    }
+    return codingNone
+}
+
+func (this *Compress) putEncoded(key string, reader io.Reader, coding byte, noReplace bool) (int64, error) {
+    return putPiped(this.base, key, noReplace, func(writer io.Writer) (int64, error) {
+        return encode(writer, reader, coding)
+    })
+}
+
+func (this *Compress) Put(key string, reader io.Reader, noReplace bool) (int64, error) {
+    key = Key(key)
+    if rawKey(key) {
+        return this.base.Put(key, reader, noReplace)
+    }
+    buffered := bufio.NewReaderSize(reader, policyHeadSize)
+    head, _ := buffered.Peek(policyHeadSize)
+    coding := this.coding(key, head)
+    if coding == codingNone && !bytes.HasPrefix(head, encodedMagic) {
+        return this.base.Put(key, buffered, noReplace)
+    }
+    return this.putEncoded(key, buffered, coding, noReplace)
+}
+
+/* Compressed files are not written in place */
+func (this *Compress) checkRaw(op, key string) error {
+    key = Key(key)
+    if rawKey(key) {
+        return nil
+    }
+    fileInfo, err := this.Stat(key)
+    if err != nil {
+        return err
+    }
+    if _, encoded := fileInfo.(encodedInfo); encoded {
+        return &os.PathError{ Op: op, Path: key, Err: ErrEncoded }
+    }
+    return nil
+}
+
+func (this *Compress) Append(key string, offset int64, reader io.Reader) (int64, error) {
+    if err := this.checkRaw("append", key); err != nil {
+        return 0, err
+    }
+    return this.base.Append(key, offset, reader)
+}
+
+func (this *Compress) Truncate(key string, size int64) error {
+    if err := this.checkRaw("truncate", key); err != nil {
+        return err
+    }
+    return this.base.Truncate(key, size)
+}
+
+func (this *Compress) Open(key string) (File, error) {
+    key = Key(key)
+    file, err := this.base.Open(key)
+    if err != nil || rawKey(key) {
+        return file, err
+    }
+    fileInfo, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    coding, size, encoded := readFrame(file, fileInfo)
+    if !encoded {
+        return file, nil
+    }
+    info := encodedInfo{ FileInfo: fileInfo, size: size, encoding: encodingName(coding) }
+    return &decodedFile{ file: file, info: info, coding: coding }, nil
+}
+
+/* Open the coded data of the compressed file to send it with the
+ * content coding, empty coding if the file is not compressed */
+func (this *Compress) OpenEncoded(key string) (File, string, error) {
+    key = Key(key)
+    if rawKey(key) {
+        return nil, "", nil
+    }
+    file, err := this.base.Open(key)
+    if err != nil {
+        return nil, "", err
+    }
+    fileInfo, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, "", err
+    }
+    coding, _, encoded := readFrame(file, fileInfo)
+    if !encoded || coding == codingNone {
+        file.Close()
+        return nil, "", nil
+    }
+    size := fileInfo.Size() - encodedHeaderSize - encodedTrailerSize
+    payload := &payloadFile{
+        SectionReader: io.NewSectionReader(file, encodedHeaderSize, size),
+        file: file,
+        info: payloadInfo{ FileInfo: fileInfo, size: size },
+    }
+    return payload, encodingName(coding), nil
+}
+
+func (this *Compress) Get(key string, offset, length int64) (io.ReadCloser, error) {
+    file, err := this.Open(key)
+    if err != nil {
+        return nil, err
+    }
+    return newRangeReader(file, offset, length)
+}
+
+func (this *Compress) Stat(key string) (os.FileInfo, error) {
+    key = Key(key)
+    fileInfo, err := this.base.Stat(key)
+    if err != nil || rawKey(key) || !fileInfo.Mode().IsRegular() ||
+                    fileInfo.Size() < encodedHeaderSize + encodedTrailerSize {
+        return fileInfo, err
+    }
+    file, err := this.base.Open(key)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    coding, size, encoded := readFrame(file, fileInfo)
+    if !encoded {
+        return fileInfo, nil
+    }
+    return encodedInfo{ FileInfo: fileInfo, size: size, encoding: encodingName(coding) }, nil
+}
+
+func (this *Compress) List(key, prefix string) ([]string, error) {
+    return this.base.List(key, prefix)
+}
+
+func (this *Compress) MakeDir(key string) error {
+    return this.base.MakeDir(key)
+}
+
+func (this *Compress) Delete(key string) error {
+    return this.base.Delete(key)
+}
+
+func (this *Compress) DeleteAll(key string) error {
+    return this.base.DeleteAll(key)
+}
+
+/* Copy keeps the stored data as is */
+func (this *Compress) Copy(srcKey, dstKey string, noReplace bool) error {
+    return this.base.Copy(srcKey, dstKey, noReplace)
+}
+
+/* Raw file moved to the bucket is compressed by the policy of the bucket,
+ * kept raw if compressed data is not smaller. Compressed files are moved
+ * as is. Upload data starting with the magic is framed */
+func (this *Compress) Move(srcKey, dstKey string, noReplace bool) error {
+    srcKey, dstKey = Key(srcKey), Key(dstKey)
+    if rawKey(dstKey) || srcKey == dstKey {
+        return this.base.Move(srcKey, dstKey, noReplace)
+    }
+    fileInfo, err := this.base.Stat(srcKey)
+    if err != nil || !fileInfo.Mode().IsRegular() {
+        return this.base.Move(srcKey, dstKey, noReplace)
+    }
+    file, err := this.base.Open(srcKey)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    head := make([]byte, policyHeadSize)
+    size, _ := file.ReadAt(head, 0)
+    head = head[:size]
+    framed := false
+    if !rawKey(srcKey) {
+        _, _, framed = readFrame(file, fileInfo)
+    }
+    coding := codingNone
+    if !framed {
+        coding = this.coding(dstKey, head)
+    }
+    escape := rawKey(srcKey) && bytes.HasPrefix(head, encodedMagic)
+    if coding == codingNone && !escape {
+        return this.base.Move(srcKey, dstKey, noReplace)
+    }
+
+    if _, err := file.Seek(0, io.SeekStart); err != nil {
+        return err
+    }
+    if _, err := this.putEncoded(dstKey, file, coding, noReplace); err != nil {
+        return err
+    }
+    if !escape {
+        stored, err := this.base.Stat(dstKey)
+        if err == nil && stored.Size() >= fileInfo.Size() {
+            return this.base.Move(srcKey, dstKey, false)
+        }
+    }
+    return this.base.Delete(srcKey)
+}
+
+/* Policy compressing files of buckets by settings of the
+ * buckets, except content compressed by the format */
+func BucketPolicy(db *sqlx.DB) CompressPolicy {
+    buckets := bucketModel.New(db)
+    return func(key string, head []byte) string {
+        if config.IsSystemName(key) {
+            return ""
+        }
+        compression := buckets.Settings(Dir(key)).Compression
+        if len(compression) == 0 {
+            return ""
+        }
+        if objectModel.IsCompressedContentType(objectModel.DetectContentType(path.Base(key), "", head)) {
+            return ""
+        }
+        return compression
+    }
+}
+
+/* Open the coded data of the compressed file of the store */
+func OpenEncoded(store Backend, key string) (File, string, error) {
+    compress, ok := store.(*Compress)
+    if !ok {
+        return nil, "", nil
+    }
+    return compress.OpenEncoded(key)
+}
+
+func NewCompress(base Backend, policy CompressPolicy) *Compress {
+    return &Compress{
+        base: base,
+        policy: policy,
+    }
+}
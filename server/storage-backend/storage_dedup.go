@@ -0,0 +1,530 @@
+package storageBackend
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "store/config"
+    "store/server/blob-model"
+    "store/tools"
+)
+
+const (
+    blobDirName     string = "blobs"
+    uploadDirName   string = "uploads"
+    secretName      string = "secret"
+    refMagic        string = "m2ref"
+    /* Larger files are never references */
+    refMaxSize      int64 = 192
+)
+
+/* Unreferenced blobs are kept for a while for reads and writes in progress */
+const CollectGrace time.Duration = time.Hour
+
+/* Reference counts of the blobs */
+type BlobRefs interface {
+    AddRef(sum string, size int64) error
+    Release(sum string) error
+    Find(sum string) (blobModel.Blob, bool, error)
+    Unreferenced(before time.Time) ([]blobModel.Blob, error)
+    /* Remove the blob if it has no references */
+    Delete(sum string) (bool, error)
+}
+
+/* Content addressed store over the base backend. Data is kept once by
+ * SHA-256 in blobs of the internal area, files of buckets, versions and
+ * trash are small references to the blobs, signed with the secret of the
+ * store so uploaded data is never taken as a reference. Files written in
+ * place by resumable uploads stay raw, like files stored without dedup */
+type Dedup struct {
+    lock        sync.Mutex
+    base        Backend
+    refs        BlobRefs
+    secretLock  sync.Mutex
+    secret      []byte
+}
+
+type blobRef struct {
+    sum         string
+    size        int64
+}
+
+/* Stat of the reference with the size of the blob */
+type dedupInfo struct {
+    os.FileInfo
+    size        int64
+}
+
+func (this dedupInfo) Size() int64 {
+    return this.size
+}
+
+type dedupFile struct {
+    File
+    info        dedupInfo
+}
+
+func (this *dedupFile) Stat() (os.FileInfo, error) {
+    return this.info, nil
+}
+
+func blobKey(sum string) string {
+    return Join(config.SystemDirName, blobDirName, sum[:2], sum)
+}
+
+/* Files of uploads and blobs are never references */
+func (this *Dedup) shared(key string) bool {
+    for _, dirName := range []string{ blobDirName, uploadDirName } {
+        if strings.HasPrefix(key + "/", Join(config.SystemDirName, dirName) + "/") {
+            return false
+        }
+    }
+    return true
+}
+
+/* Secret of reference signatures, created on first use */
+func (this *Dedup) loadSecret() ([]byte, error) {
+    this.secretLock.Lock()
+    defer this.secretLock.Unlock()
+    if this.secret != nil {
+        return this.secret, nil
+    }
+    secretKey := Join(config.SystemDirName, blobDirName, secretName)
+    data, err := ReadFile(this.base, secretKey)
+    if os.IsNotExist(err) {
+        arr := make([]byte, 32)
+        if _, err := rand.Read(arr); err != nil {
+            return nil, err
+        }
+        data = []byte(hex.EncodeToString(arr))
+        _, err = this.base.Put(secretKey, bytes.NewReader(data), true)
+        if err == tools.ErrFileExists {
+            data, err = ReadFile(this.base, secretKey)
+        }
+    }
+    if err != nil {
+        return nil, err
+    }
+    if len(data) == 0 {
+        return nil, errors.New("empty dedup secret " + secretKey)
+    }
+    this.secret = data
+    return data, nil
+}
+
+func (this *Dedup) signature(ref blobRef) ([]byte, error) {
+    secret, err := this.loadSecret()
+    if err != nil {
+        return nil, err
+    }
+    mac := hmac.New(sha256.New, secret)
+    fmt.Fprintf(mac, "%s %d", ref.sum, ref.size)
+    return mac.Sum(nil), nil
+}
+
+func (this *Dedup) formatRef(ref blobRef) ([]byte, error) {
+    signature, err := this.signature(ref)
+    if err != nil {
+        return nil, err
+    }
+    return []byte(fmt.Sprintf("%s %s %d %s\n", refMagic, ref.sum, ref.size, hex.EncodeToString(signature))), nil
+}
+
+/* Reference of the file, empty sum if the file is raw or a directory */
+func (this *Dedup) readRef(key string) (blobRef, os.FileInfo, error) {
+    var ref blobRef
+    fileInfo, err := this.base.Stat(key)
+    if err != nil {
+        return ref, nil, err
+    }
+    if !this.shared(key) || !fileInfo.Mode().IsRegular() || fileInfo.Size() > refMaxSize {
+        return ref, fileInfo, nil
+    }
+    data, err := ReadFile(this.base, key)
+    if err != nil {
+        return ref, nil, err
+    }
+    fields := strings.Fields(string(data))
+    if len(fields) != 4 || fields[0] != refMagic || len(fields[1]) != sha256.Size * 2 {
+        return ref, fileInfo, nil
+    }
+    size, err := strconv.ParseInt(fields[2], 10, 64)
+    signature, hexErr := hex.DecodeString(fields[3])
+    if err != nil || hexErr != nil {
+        return ref, fileInfo, nil
+    }
+    expected, err := this.signature(blobRef{ sum: fields[1], size: size })
+    if err != nil {
+        return ref, nil, err
+    }
+    if !hmac.Equal(signature, expected) {
+        return ref, fileInfo, nil
+    }
+    return blobRef{ sum: fields[1], size: size }, fileInfo, nil
+}
+
+/* Write the stream to the blob of its hash and count the reference */
+func (this *Dedup) storeBlob(reader io.Reader) (blobRef, int64, error) {
+    var ref blobRef
+    tempKey, err := TempKey("blob-")
+    if err != nil {
+        return ref, 0, err
+    }
+    hash := sha256.New()
+    size, err := this.base.Put(tempKey, io.TeeReader(reader, hash), false)
+    if err != nil {
+        this.base.Delete(tempKey)
+        return ref, size, err
+    }
+    ref = blobRef{ sum: hex.EncodeToString(hash.Sum(nil)), size: size }
+
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    if FileExists(this.base, blobKey(ref.sum)) {
+        this.base.Delete(tempKey)
+    } else if err := this.base.Move(tempKey, blobKey(ref.sum), false); err != nil {
+        this.base.Delete(tempKey)
+        return ref, size, err
+    }
+    return ref, size, this.refs.AddRef(ref.sum, ref.size)
+}
+
+/* Write the counted reference to the file, the reference is released
+ * on failure and the replaced reference on success, lock is held */
+func (this *Dedup) putRef(key string, ref blobRef, noReplace bool) error {
+    data, err := this.formatRef(ref)
+    if err == nil {
+        var old blobRef
+        old, _, _ = this.readRef(key)
+        if _, err = this.base.Put(key, bytes.NewReader(data), noReplace); err == nil {
+            if len(old.sum) > 0 {
+                return this.refs.Release(old.sum)
+            }
+            return nil
+        }
+    }
+    if releaseErr := this.refs.Release(ref.sum); releaseErr != nil {
+        log.Println(releaseErr)
+    }
+    return err
+}
+
+/* Replace the reference with raw data of the blob for writes in place */
+func (this *Dedup) materialize(key string) error {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    ref, _, err := this.readRef(key)
+    if err != nil || len(ref.sum) == 0 {
+        return err
+    }
+    file, err := this.base.Open(blobKey(ref.sum))
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    if _, err := this.base.Put(key, file, false); err != nil {
+        return err
+    }
+    return this.refs.Release(ref.sum)
+}
+
+func (this *Dedup) Put(key string, reader io.Reader, noReplace bool) (int64, error) {
+    key = Key(key)
+    if !this.shared(key) {
+        return this.base.Put(key, reader, noReplace)
+    }
+    ref, size, err := this.storeBlob(reader)
+    if err != nil {
+        return size, err
+    }
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    return size, this.putRef(key, ref, noReplace)
+}
+
+func (this *Dedup) Append(key string, offset int64, reader io.Reader) (int64, error) {
+    if err := this.materialize(Key(key)); err != nil {
+        return 0, err
+    }
+    return this.base.Append(key, offset, reader)
+}
+
+func (this *Dedup) Truncate(key string, size int64) error {
+    if err := this.materialize(Key(key)); err != nil {
+        return err
+    }
+    return this.base.Truncate(key, size)
+}
+
+func (this *Dedup) Open(key string) (File, error) {
+    key = Key(key)
+    ref, fileInfo, err := this.readRef(key)
+    if err != nil {
+        return nil, err
+    }
+    if len(ref.sum) == 0 {
+        return this.base.Open(key)
+    }
+    file, err := this.base.Open(blobKey(ref.sum))
+    if err != nil {
+        return nil, err
+    }
+    return &dedupFile{ File: file, info: dedupInfo{ fileInfo, ref.size } }, nil
+}
+
+func (this *Dedup) Get(key string, offset, length int64) (io.ReadCloser, error) {
+    file, err := this.Open(key)
+    if err != nil {
+        return nil, err
+    }
+    return newRangeReader(file, offset, length)
+}
+
+func (this *Dedup) Stat(key string) (os.FileInfo, error) {
+    ref, fileInfo, err := this.readRef(Key(key))
+    if err != nil || len(ref.sum) == 0 {
+        return fileInfo, err
+    }
+    return dedupInfo{ fileInfo, ref.size }, nil
+}
+
+func (this *Dedup) List(key, prefix string) ([]string, error) {
+    return this.base.List(key, prefix)
+}
+
+func (this *Dedup) MakeDir(key string) error {
+    return this.base.MakeDir(key)
+}
+
+func (this *Dedup) Delete(key string) error {
+    key = Key(key)
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    ref, _, err := this.readRef(key)
+    if err != nil {
+        return err
+    }
+    if err := this.base.Delete(key); err != nil {
+        return err
+    }
+    if len(ref.sum) > 0 {
+        return this.refs.Release(ref.sum)
+    }
+    return nil
+}
+
+/* References of the tree are released when their files are removed,
+ * so a partly removed tree keeps counts of the rest */
+func (this *Dedup) DeleteAll(key string) error {
+    key = Key(key)
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    refs := make(map[string]blobRef)
+    err := Walk(this.base, key, func(fileKey string, fileInfo os.FileInfo, err error) error {
+        if err != nil {
+            if os.IsNotExist(err) {
+                return nil
+            }
+            return err
+        }
+        if fileInfo.Mode().IsRegular() {
+            ref, _, err := this.readRef(fileKey)
+            if err != nil {
+                return err
+            }
+            if len(ref.sum) > 0 {
+                refs[fileKey] = ref
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    err = this.base.DeleteAll(key)
+    for fileKey, ref := range refs {
+        if _, statErr := this.base.Stat(fileKey); os.IsNotExist(statErr) {
+            if releaseErr := this.refs.Release(ref.sum); releaseErr != nil {
+                log.Println(releaseErr)
+            }
+        }
+    }
+    return err
+}
+
+/* Copy of the reference counts the blob again, raw data is copied
+ * by reading, so files copied into buckets are deduplicated */
+func (this *Dedup) Copy(srcKey, dstKey string, noReplace bool) error {
+    srcKey, dstKey = Key(srcKey), Key(dstKey)
+    this.lock.Lock()
+    ref, fileInfo, err := this.readRef(srcKey)
+    if err == nil && len(ref.sum) > 0 && this.shared(dstKey) {
+        defer this.lock.Unlock()
+        if err := this.refs.AddRef(ref.sum, ref.size); err != nil {
+            return err
+        }
+        return this.putRef(dstKey, ref, noReplace)
+    }
+    this.lock.Unlock()
+    if err != nil {
+        return err
+    }
+    if !fileInfo.Mode().IsRegular() {
+        return &os.PathError{ Op: "copy", Path: srcKey, Err: ErrNotFile }
+    }
+    return this.copyData(srcKey, dstKey, noReplace)
+}
+
+func (this *Dedup) copyData(srcKey, dstKey string, noReplace bool) error {
+    file, err := this.Open(srcKey)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    _, err = this.Put(dstKey, file, noReplace)
+    return err
+}
+
+/* Moved reference keeps its count, raw data moved into
+ * the shared area and references moved out are converted */
+func (this *Dedup) Move(srcKey, dstKey string, noReplace bool) error {
+    srcKey, dstKey = Key(srcKey), Key(dstKey)
+    this.lock.Lock()
+    ref, fileInfo, err := this.readRef(srcKey)
+    if err != nil || fileInfo.IsDir() || srcKey == dstKey ||
+                        (len(ref.sum) > 0) == this.shared(dstKey) {
+        defer this.lock.Unlock()
+        if err != nil {
+            return err
+        }
+        var old blobRef
+        if !fileInfo.IsDir() {
+            old, _, _ = this.readRef(dstKey)
+        }
+        if err := this.base.Move(srcKey, dstKey, noReplace); err != nil {
+            return err
+        }
+        if len(old.sum) > 0 && srcKey != dstKey {
+            return this.refs.Release(old.sum)
+        }
+        return nil
+    }
+    this.lock.Unlock()
+    if err := this.copyData(srcKey, dstKey, noReplace); err != nil {
+        return err
+    }
+    return this.Delete(srcKey)
+}
+
+/* Remove blobs without references released before the grace period and
+ * blob files unknown to the counts, left by interrupted writes */
+func (this *Dedup) Collect(grace time.Duration) (int, int64, error) {
+    var count int
+    var size int64
+    before := time.Now().Add(-grace)
+    blobs, err := this.refs.Unreferenced(before)
+    if err != nil {
+        return count, size, err
+    }
+    for _, blob := range blobs {
+        removed, err := this.removeBlob(blob.SHA256, true)
+        if err != nil {
+            return count, size, err
+        }
+        if removed {
+            count += 1
+            size += blob.Size
+        }
+    }
+
+    blobDir := Join(config.SystemDirName, blobDirName)
+    dirNames, err := this.base.List(blobDir, "")
+    if os.IsNotExist(err) {
+        return count, size, nil
+    }
+    if err != nil {
+        return count, size, err
+    }
+    for _, dirName := range dirNames {
+        if len(dirName) != 2 {
+            continue
+        }
+        sums, err := this.base.List(Join(blobDir, dirName), "")
+        if err != nil {
+            continue
+        }
+        for _, sum := range sums {
+            if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size * 2 || sum[:2] != dirName {
+                continue
+            }
+            fileInfo, err := this.base.Stat(blobKey(sum))
+            if err != nil || !fileInfo.Mode().IsRegular() || !fileInfo.ModTime().Before(before) {
+                continue
+            }
+            removed, err := this.removeBlob(sum, false)
+            if err != nil {
+                return count, size, err
+            }
+            if removed {
+                count += 1
+                size += fileInfo.Size()
+            }
+        }
+    }
+    return count, size, nil
+}
+
+/* Remove the blob if it is still unreferenced or unknown */
+func (this *Dedup) removeBlob(sum string, known bool) (bool, error) {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    if known {
+        removed, err := this.refs.Delete(sum)
+        if err != nil || !removed {
+            return false, err
+        }
+    } else {
+        _, exists, err := this.refs.Find(sum)
+        if err != nil || exists {
+            return false, err
+        }
+    }
+    err := this.base.Delete(blobKey(sum))
+    if os.IsNotExist(err) {
+        return known, nil
+    }
+    return err == nil, err
+}
+
+/* Collect garbage periodically */
+func (this *Dedup) Janitor(period, grace time.Duration) {
+    for {
+        count, size, err := this.Collect(grace)
+        if err != nil {
+            log.Println(err)
+        }
+        if count > 0 {
+            log.Printf("%d unreferenced blobs of %d bytes are removed\n", count, size)
+        }
+        time.Sleep(period)
+    }
+}
+
+func NewDedup(base Backend, refs BlobRefs) *Dedup {
+    return &Dedup{
+        base: base,
+        refs: refs,
+    }
+}
@@ -0,0 +1,933 @@
+package storageBackend
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+
+    "github.com/jmoiron/sqlx"
+
+    "store/config"
+    "store/server/bucket-model"
+    "store/server/datakey-model"
+    "store/tools"
+)
+
+const (
+    cipherNone          byte = 0
+    cipherAES256GCM     byte = 1
+    sealedVersion       byte = 1
+    /* Magic, format version and cipher */
+    sealedPrefixSize    int64 = 8
+    /* Prefix, data key id and nonce prefix of the chunks */
+    sealedHeaderSize    int64 = 32
+    dataKeyIdSize       int = 16
+    noncePrefixSize     int = 7
+    /* Plain data of the chunk sealed with own tag, so ranges
+     * are read without decrypting the file from the start */
+    sealedChunkSize     int64 = 64 * 1024
+    sealedTagSize       int64 = 16
+    masterKeySize       int = 32
+    /* Unwrapped data keys kept in memory */
+    dataKeyCacheSize    int = 4096
+    rotateBatchSize     int = 100
+)
+
+var (
+    ErrEncrypted    = errors.New("file is stored encrypted")
+    ErrNoMasterKey  = errors.New("master key is not loaded")
+    ErrCorrupted    = errors.New("encrypted data is corrupted")
+    sealedMagic     = []byte("\x89M2E\r\n")
+)
+
+/* Wrapped data keys of encrypted files */
+type DataKeys interface {
+    Save(keyId, master, wrapped string) error
+    Find(keyId string) (datakeyModel.DataKey, bool, error)
+    /* Keys wrapped by master keys other than the master */
+    Stale(master string, limit int) ([]datakeyModel.DataKey, error)
+    Rewrap(keyId, master, wrapped string) error
+    /* Count the file sharing the key */
+    AddRef(keyId string) error
+    /* Drop the reference, return true if the key is deleted */
+    Release(keyId string) (bool, error)
+}
+
+/* Return true if new data of the bucket file is encrypted */
+type EncryptPolicy func(key string) bool
+
+/* Master keys of the key file, one per line in hex. The first key wraps
+ * new data keys, following keys are kept to unwrap data keys wrapped
+ * before the rotation */
+type Keyring struct {
+    lock        sync.RWMutex
+    path        string
+    keys        [][]byte
+}
+
+/* Id of the master key stored with data keys wrapped by it */
+func MasterId(key []byte) string {
+    sum := sha256.Sum256(key)
+    return hex.EncodeToString(sum[:8])
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    keyring := &Keyring{ path: path }
+    for _, line := range strings.Split(string(data), "\n") {
+        line = strings.TrimSpace(line)
+        if len(line) == 0 || strings.HasPrefix(line, "#") {
+            continue
+        }
+        key, err := hex.DecodeString(line)
+        if err != nil || len(key) != masterKeySize {
+            return nil, errors.New(fmt.Sprintf("wrong master key in %s, %d hex digits expected", path, masterKeySize * 2))
+        }
+        keyring.keys = append(keyring.keys, key)
+    }
+    if len(keyring.keys) == 0 {
+        return nil, errors.New(fmt.Sprintf("no master key in %s", path))
+    }
+    return keyring, nil
+}
+
+/* Current master key and its id */
+func (this *Keyring) Current() ([]byte, string) {
+    this.lock.RLock()
+    defer this.lock.RUnlock()
+    return this.keys[0], MasterId(this.keys[0])
+}
+
+func (this *Keyring) find(master string) ([]byte, bool) {
+    this.lock.RLock()
+    defer this.lock.RUnlock()
+    for _, key := range this.keys {
+        if MasterId(key) == master {
+            return key, true
+        }
+    }
+    return nil, false
+}
+
+/* Replace keys of the key file, the file is written through
+ * the temporary file readable only by the owner */
+func (this *Keyring) write(keys [][]byte) error {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    var data bytes.Buffer
+    for _, key := range keys {
+        data.WriteString(hex.EncodeToString(key) + "\n")
+    }
+    file, err := tools.CreateTemp(filepath.Dir(this.path))
+    if err != nil {
+        return err
+    }
+    _, err = file.Write(data.Bytes())
+    if err == nil {
+        err = file.Chmod(0600)
+    }
+    if err == nil {
+        err = file.Sync()
+    }
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = tools.CommitFile(file.Name(), this.path, false)
+    }
+    if err != nil {
+        os.Remove(file.Name())
+        return err
+    }
+    this.keys = keys
+    return nil
+}
+
+/* Encryption of files at rest over the base backend. Data is sealed by
+ * AES-256-GCM in chunks with the random data key of the file, the data
+ * key is wrapped by the master key and kept in the database by the key id
+ * of the file header, so rotation of the master key wraps data keys again
+ * without rewriting files. Files moved to versions or trash stay encrypted.
+ * Raw data starting with the magic is framed without cipher. Upload data
+ * written in place is never encrypted */
+type Encrypt struct {
+    base        Backend
+    keys        DataKeys
+    keyring     *Keyring
+    policy      EncryptPolicy
+    cacheLock   sync.Mutex
+    cache       map[string]cipher.AEAD
+    /* Data keys are saved under the read lock, so rotation
+     * does not miss keys wrapped by the old master key */
+    rotateLock  sync.RWMutex
+    /* Guards references of data keys */
+    lock        sync.Mutex
+}
+
+/* Stat of the encrypted file with the plain size */
+type sealedInfo struct {
+    os.FileInfo
+    size        int64
+}
+
+func (this sealedInfo) Size() int64 {
+    return this.size
+}
+
+func (this sealedInfo) StoredSize() int64 {
+    return this.FileInfo.Size()
+}
+
+/* Cipher and header of the sealed file */
+func readSeal(file io.ReaderAt, fileInfo os.FileInfo) (byte, []byte, bool) {
+    if !fileInfo.Mode().IsRegular() || fileInfo.Size() < sealedPrefixSize {
+        return cipherNone, nil, false
+    }
+    header := make([]byte, sealedHeaderSize)
+    if _, err := file.ReadAt(header[:sealedPrefixSize], 0); err != nil || !bytes.HasPrefix(header, sealedMagic) {
+        return cipherNone, nil, false
+    }
+    version, cipherId := header[len(sealedMagic)], header[len(sealedMagic) + 1]
+    if version != sealedVersion || cipherId > cipherAES256GCM {
+        return cipherNone, nil, false
+    }
+    if cipherId == cipherNone {
+        return cipherId, header[:sealedPrefixSize], true
+    }
+    if fileInfo.Size() < sealedHeaderSize + sealedTagSize {
+        return cipherNone, nil, false
+    }
+    if _, err := file.ReadAt(header[sealedPrefixSize:], sealedPrefixSize); err != nil {
+        return cipherNone, nil, false
+    }
+    return cipherId, header, true
+}
+
+/* Plain size of the sealed data of the stored size, the last
+ * chunk is never empty except the tag of the empty file */
+func sealedSize(stored int64) (int64, bool) {
+    body := stored - sealedHeaderSize
+    chunks := (body + sealedChunkSize + sealedTagSize - 1) / (sealedChunkSize + sealedTagSize)
+    if chunks == 0 || body - (chunks - 1) * (sealedChunkSize + sealedTagSize) < sealedTagSize {
+        return 0, false
+    }
+    return body - chunks * sealedTagSize, true
+}
+
+/* Nonce of the chunk, the last chunk is flagged so
+ * the file cut at the chunk boundary is detected */
+func chunkNonce(header []byte, index int64, last bool) []byte {
+    nonce := make([]byte, 12)
+    copy(nonce[:noncePrefixSize], header[sealedPrefixSize + int64(dataKeyIdSize):])
+    binary.BigEndian.PutUint32(nonce[noncePrefixSize:], uint32(index))
+    if last {
+        nonce[11] = 1
+    }
+    return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+/* Data key sealed by the master key, the key id is authenticated */
+func wrapKey(master []byte, keyId string, key []byte) (string, error) {
+    aead, err := newGCM(master)
+    if err != nil {
+        return "", err
+    }
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(aead.Seal(nonce, nonce, key, []byte(keyId))), nil
+}
+
+func unwrapKey(master []byte, keyId string, wrapped string) ([]byte, error) {
+    aead, err := newGCM(master)
+    if err != nil {
+        return nil, err
+    }
+    data, err := hex.DecodeString(wrapped)
+    if err != nil || len(data) < aead.NonceSize() {
+        return nil, errors.New(fmt.Sprintf("wrong wrapped data key %s", keyId))
+    }
+    key, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(keyId))
+    if err != nil {
+        return nil, errors.New(fmt.Sprintf("data key %s is not unwrapped by master key", keyId))
+    }
+    return key, nil
+}
+
+/* Writer of sealed chunks, the full chunk is sealed when more data
+ * comes, the rest is sealed as the last chunk by Close */
+type sealWriter struct {
+    writer      io.Writer
+    aead        cipher.AEAD
+    header      []byte
+    buffer      []byte
+    sealed      []byte
+    index       int64
+}
+
+func (this *sealWriter) flush(last bool) error {
+    this.sealed = this.aead.Seal(this.sealed[:0], chunkNonce(this.header, this.index, last), this.buffer, this.header)
+    this.buffer = this.buffer[:0]
+    this.index++
+    _, err := this.writer.Write(this.sealed)
+    return err
+}
+
+func (this *sealWriter) Write(data []byte) (int, error) {
+    written := 0
+    for len(data) > 0 {
+        if int64(len(this.buffer)) == sealedChunkSize {
+            if err := this.flush(false); err != nil {
+                return written, err
+            }
+        }
+        size := int(sealedChunkSize) - len(this.buffer)
+        if size > len(data) {
+            size = len(data)
+        }
+        this.buffer = append(this.buffer, data[:size]...)
+        data = data[size:]
+        written += size
+    }
+    return written, nil
+}
+
+func (this *sealWriter) Close() error {
+    return this.flush(true)
+}
+
+/* Plain data of the sealed file, chunks are decrypted on reads, the
+ * data key is unwrapped on first read, so stat does not need it */
+type sealedFile struct {
+    store       *Encrypt
+    file        File
+    info        sealedInfo
+    header      []byte
+    aead        cipher.AEAD
+    chunk       int64
+    sealed      []byte
+    plain       []byte
+    offset      int64
+}
+
+func (this *sealedFile) load(index int64) error {
+    if index == this.chunk {
+        return nil
+    }
+    if this.aead == nil {
+        aead, err := this.store.dataCipher(hex.EncodeToString(this.header[sealedPrefixSize:sealedPrefixSize + int64(dataKeyIdSize)]))
+        if err != nil {
+            return err
+        }
+        this.aead = aead
+    }
+    stored := this.info.StoredSize()
+    offset := sealedHeaderSize + index * (sealedChunkSize + sealedTagSize)
+    length := stored - offset
+    if length > sealedChunkSize + sealedTagSize {
+        length = sealedChunkSize + sealedTagSize
+    }
+    if cap(this.sealed) < int(length) {
+        this.sealed = make([]byte, sealedChunkSize + sealedTagSize)
+    }
+    this.sealed = this.sealed[:length]
+    if size, err := this.file.ReadAt(this.sealed, offset); int64(size) < length {
+        if err == nil || err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        return err
+    }
+    last := offset + length >= stored
+    plain, err := this.aead.Open(this.plain[:0], chunkNonce(this.header, index, last), this.sealed, this.header)
+    if err != nil {
+        this.chunk = -1
+        return &os.PathError{ Op: "decrypt", Path: this.info.Name(), Err: ErrCorrupted }
+    }
+    this.plain, this.chunk = plain, index
+    return nil
+}
+
+func (this *sealedFile) ReadAt(data []byte, offset int64) (int, error) {
+    if offset < 0 {
+        return 0, &os.PathError{ Op: "read", Path: this.info.Name(), Err: os.ErrInvalid }
+    }
+    read := 0
+    for read < len(data) {
+        position := offset + int64(read)
+        if position >= this.info.size {
+            return read, io.EOF
+        }
+        index := position / sealedChunkSize
+        if err := this.load(index); err != nil {
+            return read, err
+        }
+        read += copy(data[read:], this.plain[position - index * sealedChunkSize:])
+    }
+    return read, nil
+}
+
+func (this *sealedFile) Read(data []byte) (int, error) {
+    size, err := this.ReadAt(data, this.offset)
+    this.offset += int64(size)
+    if size > 0 && err == io.EOF {
+        err = nil
+    }
+    return size, err
+}
+
+func (this *sealedFile) Seek(offset int64, whence int) (int64, error) {
+    switch whence {
+        case io.SeekCurrent:
+            offset += this.offset
+        case io.SeekEnd:
+            offset += this.info.size
+    }
+    if offset < 0 {
+        return this.offset, &os.PathError{ Op: "seek", Path: this.info.Name(), Err: os.ErrInvalid }
+    }
+    this.offset = offset
+    return offset, nil
+}
+
+func (this *sealedFile) Close() error {
+    return this.file.Close()
+}
+
+func (this *sealedFile) Stat() (os.FileInfo, error) {
+    return this.info, nil
+}
+
+/* Return true if the store has the master key and data keys */
+func (this *Encrypt) Ready() bool {
+    return this != nil && this.keyring != nil && this.keys != nil
+}
+
+/* Current master key id, empty without the key */
+func (this *Encrypt) Master() string {
+    if !this.Ready() {
+        return ""
+    }
+    _, master := this.keyring.Current()
+    return master
+}
+
+func (this *Encrypt) encrypts(key string) bool {
+    return this.policy != nil && !rawKey(key) && this.policy(key)
+}
+
+/* Cipher of the unwrapped data key of the id */
+func (this *Encrypt) dataCipher(keyId string) (cipher.AEAD, error) {
+    this.cacheLock.Lock()
+    aead, exists := this.cache[keyId]
+    this.cacheLock.Unlock()
+    if exists {
+        return aead, nil
+    }
+    if !this.Ready() {
+        return nil, ErrNoMasterKey
+    }
+    dataKey, exists, err := this.keys.Find(keyId)
+    if err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, errors.New(fmt.Sprintf("data key %s is not found", keyId))
+    }
+    master, exists := this.keyring.find(dataKey.Master)
+    if !exists {
+        return nil, errors.New(fmt.Sprintf("master key %s of data key %s is not found", dataKey.Master, keyId))
+    }
+    key, err := unwrapKey(master, keyId, dataKey.Wrapped)
+    if err != nil {
+        return nil, err
+    }
+    if aead, err = newGCM(key); err != nil {
+        return nil, err
+    }
+    this.cacheLock.Lock()
+    if len(this.cache) >= dataKeyCacheSize {
+        this.cache = make(map[string]cipher.AEAD)
+    }
+    this.cache[keyId] = aead
+    this.cacheLock.Unlock()
+    return aead, nil
+}
+
+/* Wrap the data key by the current master key and save it */
+func (this *Encrypt) saveKey(keyId string, key []byte) error {
+    this.rotateLock.RLock()
+    defer this.rotateLock.RUnlock()
+    master, masterId := this.keyring.Current()
+    wrapped, err := wrapKey(master, keyId, key)
+    if err != nil {
+        return err
+    }
+    return this.keys.Save(keyId, masterId, wrapped)
+}
+
+/* Write the sealed stream with the new data key, or the stream framed
+ * without cipher, return size of the data and id of the saved data key */
+func (this *Encrypt) seal(writer io.Writer, reader io.Reader, encrypt bool) (int64, string, error) {
+    header := append(append([]byte{}, sealedMagic...), sealedVersion, cipherNone)
+    if !encrypt {
+        if _, err := writer.Write(header); err != nil {
+            return 0, "", err
+        }
+        size, err := io.Copy(writer, reader)
+        return size, "", err
+    }
+    if !this.Ready() {
+        return 0, "", ErrNoMasterKey
+    }
+    header[len(sealedMagic) + 1] = cipherAES256GCM
+    random := make([]byte, masterKeySize + dataKeyIdSize + noncePrefixSize + 1)
+    if _, err := rand.Read(random); err != nil {
+        return 0, "", err
+    }
+    key := random[:masterKeySize]
+    header = append(header, random[masterKeySize:]...)
+    header[sealedHeaderSize - 1] = 0
+    keyId := hex.EncodeToString(header[sealedPrefixSize:sealedPrefixSize + int64(dataKeyIdSize)])
+
+    if err := this.saveKey(keyId, key); err != nil {
+        return 0, "", err
+    }
+    aead, err := newGCM(key)
+    if err != nil {
+        return 0, keyId, err
+    }
+    if _, err := writer.Write(header); err != nil {
+        return 0, keyId, err
+    }
+    sealer := &sealWriter{ writer: writer, aead: aead, header: header }
+    size, err := io.Copy(sealer, reader)
+    if err != nil {
+        return size, keyId, err
+    }
+    return size, keyId, sealer.Close()
+}
+
+/* Data key id of the sealed file, empty for plain files
+ * and without data keys, so nothing is counted */
+func (this *Encrypt) dataKeyId(key string) string {
+    key = Key(key)
+    if this.keys == nil || rawKey(key) {
+        return ""
+    }
+    fileInfo, err := this.base.Stat(key)
+    if err != nil || !fileInfo.Mode().IsRegular() {
+        return ""
+    }
+    file, err := this.base.Open(key)
+    if err != nil {
+        return ""
+    }
+    defer file.Close()
+    cipherId, header, sealed := readSeal(file, fileInfo)
+    if !sealed || cipherId == cipherNone {
+        return ""
+    }
+    return hex.EncodeToString(header[sealedPrefixSize:sealedPrefixSize + int64(dataKeyIdSize)])
+}
+
+/* Drop references of removed files, keys without references are deleted */
+func (this *Encrypt) release(keyIds ...string) {
+    for _, keyId := range keyIds {
+        if len(keyId) == 0 || this.keys == nil {
+            continue
+        }
+        deleted, err := this.keys.Release(keyId)
+        if err != nil {
+            log.Println(err)
+            continue
+        }
+        if deleted {
+            this.cacheLock.Lock()
+            delete(this.cache, keyId)
+            this.cacheLock.Unlock()
+        }
+    }
+}
+
+/* Write the file by write, the data key of the replaced sealed file is
+ * released. Data replacing the sealed file is written to a temporary key
+ * and committed under the lock, so concurrent writes of the key do not
+ * release the same data key twice */
+func (this *Encrypt) replace(key string, noReplace bool, write func(key string, noReplace bool) error) error {
+    if noReplace || len(this.dataKeyId(key)) == 0 {
+        return write(key, noReplace)
+    }
+    tempKey, err := TempKey("seal-")
+    if err != nil {
+        return err
+    }
+    if err := write(tempKey, true); err != nil {
+        this.base.Delete(tempKey)
+        return err
+    }
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    old := this.dataKeyId(key)
+    if err := this.base.Move(tempKey, key, false); err != nil {
+        this.base.Delete(tempKey)
+        return err
+    }
+    this.release(old)
+    return nil
+}
+
+/* The new data key is released if the file is not written */
+func (this *Encrypt) putSealed(key string, reader io.Reader, encrypt bool, noReplace bool) (int64, error) {
+    var size int64
+    var keyId string
+    err := this.replace(key, noReplace, func(dstKey string, noReplace bool) error {
+        var err error
+        size, err = putPiped(this.base, dstKey, noReplace, func(writer io.Writer) (int64, error) {
+            var written int64
+            var err error
+            written, keyId, err = this.seal(writer, reader, encrypt)
+            return written, err
+        })
+        return err
+    })
+    if err != nil {
+        this.release(keyId)
+    }
+    return size, err
+}
+
+func (this *Encrypt) Put(key string, reader io.Reader, noReplace bool) (int64, error) {
+    key = Key(key)
+    if rawKey(key) {
+        return this.base.Put(key, reader, noReplace)
+    }
+    buffered := bufio.NewReader(reader)
+    head, _ := buffered.Peek(len(sealedMagic))
+    encrypt := this.encrypts(key)
+    if !encrypt && !bytes.HasPrefix(head, sealedMagic) {
+        var size int64
+        err := this.replace(key, noReplace, func(dstKey string, noReplace bool) error {
+            var err error
+            size, err = this.base.Put(dstKey, buffered, noReplace)
+            return err
+        })
+        return size, err
+    }
+    return this.putSealed(key, buffered, encrypt, noReplace)
+}
+
+/* Sealed files are not written in place */
+func (this *Encrypt) checkPlain(op, key string) error {
+    key = Key(key)
+    if rawKey(key) {
+        return nil
+    }
+    fileInfo, err := this.base.Stat(key)
+    if err != nil || !fileInfo.Mode().IsRegular() {
+        return err
+    }
+    file, err := this.base.Open(key)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    if _, _, sealed := readSeal(file, fileInfo); sealed {
+        return &os.PathError{ Op: op, Path: key, Err: ErrEncrypted }
+    }
+    return nil
+}
+
+func (this *Encrypt) Append(key string, offset int64, reader io.Reader) (int64, error) {
+    if err := this.checkPlain("append", key); err != nil {
+        return 0, err
+    }
+    return this.base.Append(key, offset, reader)
+}
+
+func (this *Encrypt) Truncate(key string, size int64) error {
+    if err := this.checkPlain("truncate", key); err != nil {
+        return err
+    }
+    return this.base.Truncate(key, size)
+}
+
+func (this *Encrypt) Open(key string) (File, error) {
+    key = Key(key)
+    file, err := this.base.Open(key)
+    if err != nil || rawKey(key) {
+        return file, err
+    }
+    fileInfo, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    cipherId, header, sealed := readSeal(file, fileInfo)
+    if !sealed {
+        return file, nil
+    }
+    if cipherId == cipherNone {
+        size := fileInfo.Size() - sealedPrefixSize
+        return &payloadFile{
+            SectionReader: io.NewSectionReader(file, sealedPrefixSize, size),
+            file: file,
+            info: payloadInfo{ FileInfo: fileInfo, size: size },
+        }, nil
+    }
+    size, valid := sealedSize(fileInfo.Size())
+    if !valid {
+        file.Close()
+        return nil, &os.PathError{ Op: "open", Path: key, Err: ErrCorrupted }
+    }
+    info := sealedInfo{ FileInfo: fileInfo, size: size }
+    return &sealedFile{ store: this, file: file, info: info, header: header, chunk: -1 }, nil
+}
+
+func (this *Encrypt) Get(key string, offset, length int64) (io.ReadCloser, error) {
+    file, err := this.Open(key)
+    if err != nil {
+        return nil, err
+    }
+    return newRangeReader(file, offset, length)
+}
+
+func (this *Encrypt) Stat(key string) (os.FileInfo, error) {
+    key = Key(key)
+    fileInfo, err := this.base.Stat(key)
+    if err != nil || rawKey(key) || !fileInfo.Mode().IsRegular() || fileInfo.Size() < sealedPrefixSize {
+        return fileInfo, err
+    }
+    file, err := this.base.Open(key)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    cipherId, _, sealed := readSeal(file, fileInfo)
+    if !sealed {
+        return fileInfo, nil
+    }
+    if cipherId == cipherNone {
+        return payloadInfo{ FileInfo: fileInfo, size: fileInfo.Size() - sealedPrefixSize }, nil
+    }
+    size, _ := sealedSize(fileInfo.Size())
+    return sealedInfo{ FileInfo: fileInfo, size: size }, nil
+}
+
+func (this *Encrypt) List(key, prefix string) ([]string, error) {
+    return this.base.List(key, prefix)
+}
+
+func (this *Encrypt) MakeDir(key string) error {
+    return this.base.MakeDir(key)
+}
+
+/* Data key of the removed sealed file is released */
+func (this *Encrypt) Delete(key string) error {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    keyId := this.dataKeyId(key)
+    if err := this.base.Delete(key); err != nil {
+        return err
+    }
+    this.release(keyId)
+    return nil
+}
+
+/* Data keys of the tree are released when their files are removed,
+ * so a partly removed tree keeps counts of the rest */
+func (this *Encrypt) DeleteAll(key string) error {
+    if this.keys == nil {
+        return this.base.DeleteAll(key)
+    }
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    keyIds := make(map[string]string)
+    Walk(this.base, key, func(fileKey string, fileInfo os.FileInfo, err error) error {
+        if err == nil && fileInfo.Mode().IsRegular() {
+            if keyId := this.dataKeyId(fileKey); len(keyId) > 0 {
+                keyIds[fileKey] = keyId
+            }
+        }
+        return nil
+    })
+    err := this.base.DeleteAll(key)
+    for fileKey, keyId := range keyIds {
+        if _, statErr := this.base.Stat(fileKey); os.IsNotExist(statErr) {
+            this.release(keyId)
+        }
+    }
+    return err
+}
+
+/* Copy keeps the stored data as is, the copy shares
+ * the data key and counts it again */
+func (this *Encrypt) Copy(srcKey, dstKey string, noReplace bool) error {
+    this.lock.Lock()
+    keyId := this.dataKeyId(srcKey)
+    if len(keyId) > 0 {
+        if err := this.keys.AddRef(keyId); err != nil {
+            this.lock.Unlock()
+            return err
+        }
+    }
+    this.lock.Unlock()
+    err := this.replace(dstKey, noReplace, func(dstKey string, noReplace bool) error {
+        return this.base.Copy(srcKey, dstKey, noReplace)
+    })
+    if err != nil {
+        this.release(keyId)
+    }
+    return err
+}
+
+/* Move the file by the base, the data key of the replaced sealed file is released */
+func (this *Encrypt) moveBase(srcKey, dstKey string, noReplace bool) error {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    var old string
+    if !noReplace {
+        old = this.dataKeyId(dstKey)
+    }
+    if err := this.base.Move(srcKey, dstKey, noReplace); err != nil {
+        return err
+    }
+    this.release(old)
+    return nil
+}
+
+/* Plain file moved to the bucket is encrypted by the policy of the
+ * bucket, sealed files are moved as is. Upload data starting with
+ * the magic is framed */
+func (this *Encrypt) Move(srcKey, dstKey string, noReplace bool) error {
+    srcKey, dstKey = Key(srcKey), Key(dstKey)
+    if rawKey(dstKey) || srcKey == dstKey {
+        return this.base.Move(srcKey, dstKey, noReplace)
+    }
+    fileInfo, err := this.base.Stat(srcKey)
+    if err != nil || !fileInfo.Mode().IsRegular() {
+        return this.base.Move(srcKey, dstKey, noReplace)
+    }
+    file, err := this.base.Open(srcKey)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    if !rawKey(srcKey) {
+        if _, _, sealed := readSeal(file, fileInfo); sealed {
+            return this.moveBase(srcKey, dstKey, noReplace)
+        }
+    }
+    head := make([]byte, len(sealedMagic))
+    size, _ := file.ReadAt(head, 0)
+    encrypt := this.encrypts(dstKey)
+    escape := rawKey(srcKey) && bytes.HasPrefix(head[:size], sealedMagic)
+    if !encrypt && !escape {
+        return this.moveBase(srcKey, dstKey, noReplace)
+    }
+    if _, err := file.Seek(0, io.SeekStart); err != nil {
+        return err
+    }
+    if _, err := this.putSealed(dstKey, file, encrypt, noReplace); err != nil {
+        return err
+    }
+    return this.base.Delete(srcKey)
+}
+
+/* Wrap all data keys by the new master key, file data is not rewritten.
+ * The new key is written first to the key file before old keys, old keys
+ * are removed after all data keys are wrapped again, so interrupted
+ * rotation is completed by the next one. Return count of wrapped keys */
+func (this *Encrypt) Rotate() (int, error) {
+    if !this.Ready() {
+        return 0, ErrNoMasterKey
+    }
+    this.rotateLock.Lock()
+    defer this.rotateLock.Unlock()
+    key := make([]byte, masterKeySize)
+    if _, err := rand.Read(key); err != nil {
+        return 0, err
+    }
+    this.keyring.lock.RLock()
+    keys := append([][]byte{ key }, this.keyring.keys...)
+    this.keyring.lock.RUnlock()
+    if err := this.keyring.write(keys); err != nil {
+        return 0, err
+    }
+    master := MasterId(key)
+    count := 0
+    for {
+        stale, err := this.keys.Stale(master, rotateBatchSize)
+        if err != nil {
+            return count, err
+        }
+        if len(stale) == 0 {
+            break
+        }
+        for _, dataKey := range stale {
+            old, exists := this.keyring.find(dataKey.Master)
+            if !exists {
+                return count, errors.New(fmt.Sprintf("master key %s of data key %s is not found", dataKey.Master, dataKey.KeyId))
+            }
+            plain, err := unwrapKey(old, dataKey.KeyId, dataKey.Wrapped)
+            if err != nil {
+                return count, err
+            }
+            wrapped, err := wrapKey(key, dataKey.KeyId, plain)
+            if err != nil {
+                return count, err
+            }
+            if err := this.keys.Rewrap(dataKey.KeyId, master, wrapped); err != nil {
+                return count, err
+            }
+            count++
+        }
+    }
+    return count, this.keyring.write([][]byte{ key })
+}
+
+/* Policy encrypting files of buckets by settings of the buckets */
+func BucketEncryption(db *sqlx.DB) EncryptPolicy {
+    buckets := bucketModel.New(db)
+    return func(key string) bool {
+        if config.IsSystemName(key) {
+            return false
+        }
+        return len(buckets.Settings(Dir(key)).Encryption) > 0
+    }
+}
+
+func NewEncrypt(base Backend, keys DataKeys, keyring *Keyring, policy EncryptPolicy) *Encrypt {
+    return &Encrypt{
+        base: base,
+        keys: keys,
+        keyring: keyring,
+        policy: policy,
+        cache: make(map[string]cipher.AEAD),
+    }
+}
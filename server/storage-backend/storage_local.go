@@ -0,0 +1,167 @@
+package storageBackend
+
+import (
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "store/config"
+    "store/tools"
+)
+
+/* Files in the store directory, keys are paths of the directory. Temporary
+ * data is written in the internal area on the same filesystem and moved
+ * into place by rename. Symbolic links are not followed */
+type Local struct {
+    config *config.Config
+}
+
+func (this *Local) path(key string) string {
+    storeDir, _ := this.config.GetStoreDir()
+    return filepath.Join(storeDir, filepath.FromSlash(Key(key)))
+}
+
+func (this *Local) Put(key string, reader io.Reader, noReplace bool) (int64, error) {
+    tmpDir, _ := this.config.GetTempDir()
+    return tools.AtomicWrite(tmpDir, this.path(key), reader, noReplace)
+}
+
+func (this *Local) Append(key string, offset int64, reader io.Reader) (int64, error) {
+    if _, err := this.regular("open", key); err != nil {
+        return 0, err
+    }
+    file, err := os.OpenFile(this.path(key), os.O_WRONLY, 0640)
+    if err != nil {
+        return 0, err
+    }
+    defer file.Close()
+    fileInfo, err := file.Stat()
+    if err != nil {
+        return 0, err
+    }
+    size := fileInfo.Size()
+    if offset != size {
+        return size, ErrOffset
+    }
+    if _, err := file.Seek(offset, io.SeekStart); err != nil {
+        return size, err
+    }
+    written, err := io.Copy(file, reader)
+    if syncErr := file.Sync(); syncErr != nil && err == nil {
+        err = syncErr
+    }
+    return size + written, err
+}
+
+func (this *Local) Truncate(key string, size int64) error {
+    if _, err := this.regular("truncate", key); err != nil {
+        return err
+    }
+    return os.Truncate(this.path(key), size)
+}
+
+/* Stat of the regular file */
+func (this *Local) regular(op, key string) (os.FileInfo, error) {
+    fileInfo, err := this.Stat(key)
+    if err != nil {
+        return nil, err
+    }
+    if !fileInfo.Mode().IsRegular() {
+        return nil, &os.PathError{ Op: op, Path: key, Err: ErrNotFile }
+    }
+    return fileInfo, nil
+}
+
+func (this *Local) Open(key string) (File, error) {
+    if _, err := this.regular("open", key); err != nil {
+        return nil, err
+    }
+    return os.Open(this.path(key))
+}
+
+func (this *Local) Get(key string, offset, length int64) (io.ReadCloser, error) {
+    file, err := this.Open(key)
+    if err != nil {
+        return nil, err
+    }
+    return newRangeReader(file, offset, length)
+}
+
+/* Store directory itself may be a link */
+func (this *Local) Stat(key string) (os.FileInfo, error) {
+    if len(Key(key)) == 0 {
+        return os.Stat(this.path(key))
+    }
+    return os.Lstat(this.path(key))
+}
+
+func (this *Local) List(key, prefix string) ([]string, error) {
+    dir, err := os.Open(this.path(key))
+    if err != nil {
+        return nil, err
+    }
+    defer dir.Close()
+    names, err := dir.Readdirnames(-1)
+    if err != nil {
+        return nil, err
+    }
+    list := []string{}
+    for _, name := range names {
+        if strings.HasPrefix(name, prefix) {
+            list = append(list, name)
+        }
+    }
+    sort.Strings(list)
+    return list, nil
+}
+
+func (this *Local) MakeDir(key string) error {
+    return os.MkdirAll(this.path(key), os.ModeDir | 0750)
+}
+
+func (this *Local) Delete(key string) error {
+    return os.Remove(this.path(key))
+}
+
+func (this *Local) DeleteAll(key string) error {
+    return os.RemoveAll(this.path(key))
+}
+
+/* Data is shared by hard link if possible */
+func (this *Local) Copy(srcKey, dstKey string, noReplace bool) error {
+    if _, err := this.regular("copy", srcKey); err != nil {
+        return err
+    }
+    tmpKey, err := TempKey("copy-")
+    if err != nil {
+        return err
+    }
+    tmpPath := this.path(tmpKey)
+    if err := tools.LinkFile(this.path(srcKey), tmpPath); err != nil {
+        return err
+    }
+    if err := tools.CommitFile(tmpPath, this.path(dstKey), noReplace); err != nil {
+        os.Remove(tmpPath)
+        return err
+    }
+    return nil
+}
+
+func (this *Local) Move(srcKey, dstKey string, noReplace bool) error {
+    fileInfo, err := this.Stat(srcKey)
+    if err != nil {
+        return err
+    }
+    if fileInfo.IsDir() {
+        return tools.MoveTree(this.path(srcKey), this.path(dstKey))
+    }
+    return tools.CommitFile(this.path(srcKey), this.path(dstKey), noReplace)
+}
+
+func NewLocal(config *config.Config) *Local {
+    return &Local{
+        config: config,
+    }
+}
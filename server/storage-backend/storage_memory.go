@@ -0,0 +1,347 @@
+package storageBackend
+
+import (
+    "bytes"
+    "io"
+    "io/ioutil"
+    "os"
+    "path"
+    "sort"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+
+    "store/tools"
+)
+
+/* Files kept in memory, for tests and trials. Data of a file is never
+ * modified in place, so copies and open readers share it */
+type Memory struct {
+    lock        sync.RWMutex
+    entries     map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+    dir         bool
+    data        []byte
+    modTime     time.Time
+}
+
+type memoryInfo struct {
+    name        string
+    entry       memoryEntry
+}
+
+func (this memoryInfo) Name() string {
+    return this.name
+}
+
+func (this memoryInfo) Size() int64 {
+    return int64(len(this.entry.data))
+}
+
+func (this memoryInfo) Mode() os.FileMode {
+    if this.entry.dir {
+        return os.ModeDir | 0750
+    }
+    return 0640
+}
+
+func (this memoryInfo) ModTime() time.Time {
+    return this.entry.modTime
+}
+
+func (this memoryInfo) IsDir() bool {
+    return this.entry.dir
+}
+
+func (this memoryInfo) Sys() interface{} {
+    return nil
+}
+
+type memoryFile struct {
+    *bytes.Reader
+    info        memoryInfo
+}
+
+func (this *memoryFile) Close() error {
+    return nil
+}
+
+func (this *memoryFile) Stat() (os.FileInfo, error) {
+    return this.info, nil
+}
+
+func newInfo(key string, entry *memoryEntry) memoryInfo {
+    return memoryInfo{ name: path.Base("/" + key), entry: *entry }
+}
+
+/* Create the directory with parents, lock is held */
+func (this *Memory) makeDir(key string) error {
+    if len(key) == 0 {
+        return nil
+    }
+    if err := this.makeDir(Dir(key)); err != nil {
+        return err
+    }
+    if entry, exists := this.entries[key]; exists {
+        if !entry.dir {
+            return &os.PathError{ Op: "mkdir", Path: key, Err: syscall.ENOTDIR }
+        }
+        return nil
+    }
+    this.entries[key] = &memoryEntry{ dir: true, modTime: time.Now() }
+    return nil
+}
+
+/* Set the file data, lock is held */
+func (this *Memory) setFile(key string, entry *memoryEntry, noReplace bool) error {
+    if len(key) == 0 {
+        return &os.PathError{ Op: "write", Path: key, Err: syscall.EISDIR }
+    }
+    if err := this.makeDir(Dir(key)); err != nil {
+        return err
+    }
+    if old, exists := this.entries[key]; exists {
+        if old.dir {
+            return &os.PathError{ Op: "write", Path: key, Err: syscall.EISDIR }
+        }
+        if noReplace {
+            return tools.ErrFileExists
+        }
+    }
+    this.entries[key] = entry
+    return nil
+}
+
+/* Regular file of the key, lock is held */
+func (this *Memory) regular(op, key string) (*memoryEntry, error) {
+    entry, exists := this.entries[key]
+    if !exists {
+        return nil, notFound(op, key)
+    }
+    if entry.dir {
+        return nil, &os.PathError{ Op: op, Path: key, Err: ErrNotFile }
+    }
+    return entry, nil
+}
+
+func (this *Memory) Put(key string, reader io.Reader, noReplace bool) (int64, error) {
+    key = Key(key)
+    data, err := ioutil.ReadAll(reader)
+    if err != nil {
+        return int64(len(data)), err
+    }
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    return int64(len(data)), this.setFile(key, &memoryEntry{ data: data, modTime: time.Now() }, noReplace)
+}
+
+/* Data read before an error is appended like written to a file */
+func (this *Memory) Append(key string, offset int64, reader io.Reader) (int64, error) {
+    key = Key(key)
+    this.lock.RLock()
+    entry, err := this.regular("open", key)
+    var size int64
+    if err == nil {
+        size = int64(len(entry.data))
+    }
+    this.lock.RUnlock()
+    if err != nil {
+        return 0, err
+    }
+    if offset != size {
+        return size, ErrOffset
+    }
+    data, readErr := ioutil.ReadAll(reader)
+
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    entry, err = this.regular("open", key)
+    if err != nil {
+        return 0, err
+    }
+    if int64(len(entry.data)) != offset {
+        return int64(len(entry.data)), ErrOffset
+    }
+    old := entry.data
+    this.entries[key] = &memoryEntry{ data: append(old[:len(old):len(old)], data...), modTime: time.Now() }
+    return offset + int64(len(data)), readErr
+}
+
+func (this *Memory) Truncate(key string, size int64) error {
+    key = Key(key)
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    entry, err := this.regular("truncate", key)
+    if err != nil {
+        return err
+    }
+    data := make([]byte, size)
+    copy(data, entry.data)
+    this.entries[key] = &memoryEntry{ data: data, modTime: time.Now() }
+    return nil
+}
+
+func (this *Memory) Open(key string) (File, error) {
+    key = Key(key)
+    this.lock.RLock()
+    defer this.lock.RUnlock()
+    entry, err := this.regular("open", key)
+    if err != nil {
+        return nil, err
+    }
+    return &memoryFile{ Reader: bytes.NewReader(entry.data), info: newInfo(key, entry) }, nil
+}
+
+func (this *Memory) Get(key string, offset, length int64) (io.ReadCloser, error) {
+    file, err := this.Open(key)
+    if err != nil {
+        return nil, err
+    }
+    return newRangeReader(file, offset, length)
+}
+
+func (this *Memory) Stat(key string) (os.FileInfo, error) {
+    key = Key(key)
+    this.lock.RLock()
+    defer this.lock.RUnlock()
+    entry, exists := this.entries[key]
+    if !exists {
+        return nil, notFound("stat", key)
+    }
+    return newInfo(key, entry), nil
+}
+
+/* Keys of the directory tree without the directory, lock is held */
+func (this *Memory) tree(key string) []string {
+    keys := []string{}
+    for entryKey := range this.entries {
+        if len(entryKey) > 0 && (len(key) == 0 || strings.HasPrefix(entryKey, key + "/")) {
+            keys = append(keys, entryKey)
+        }
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func (this *Memory) List(key, prefix string) ([]string, error) {
+    key = Key(key)
+    this.lock.RLock()
+    defer this.lock.RUnlock()
+    entry, exists := this.entries[key]
+    if !exists {
+        return nil, notFound("open", key)
+    }
+    if !entry.dir {
+        return nil, &os.PathError{ Op: "readdirent", Path: key, Err: syscall.ENOTDIR }
+    }
+    names := []string{}
+    for entryKey := range this.entries {
+        if len(entryKey) > 0 && entryKey != key && Dir(entryKey) == key {
+            if name := path.Base(entryKey); strings.HasPrefix(name, prefix) {
+                names = append(names, name)
+            }
+        }
+    }
+    sort.Strings(names)
+    return names, nil
+}
+
+func (this *Memory) MakeDir(key string) error {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    return this.makeDir(Key(key))
+}
+
+func (this *Memory) Delete(key string) error {
+    key = Key(key)
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    entry, exists := this.entries[key]
+    if !exists {
+        return notFound("remove", key)
+    }
+    if len(key) == 0 || (entry.dir && len(this.tree(key)) > 0) {
+        return &os.PathError{ Op: "remove", Path: key, Err: syscall.ENOTEMPTY }
+    }
+    delete(this.entries, key)
+    return nil
+}
+
+func (this *Memory) DeleteAll(key string) error {
+    key = Key(key)
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    for _, entryKey := range this.tree(key) {
+        delete(this.entries, entryKey)
+    }
+    if len(key) > 0 {
+        delete(this.entries, key)
+    }
+    return nil
+}
+
+func (this *Memory) Copy(srcKey, dstKey string, noReplace bool) error {
+    srcKey, dstKey = Key(srcKey), Key(dstKey)
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    entry, err := this.regular("copy", srcKey)
+    if err != nil {
+        return err
+    }
+    return this.setFile(dstKey, &memoryEntry{ data: entry.data, modTime: entry.modTime }, noReplace)
+}
+
+/* Directory tree is checked for conflicts before any entry is moved */
+func (this *Memory) Move(srcKey, dstKey string, noReplace bool) error {
+    srcKey, dstKey = Key(srcKey), Key(dstKey)
+    this.lock.Lock()
+    defer this.lock.Unlock()
+    entry, exists := this.entries[srcKey]
+    if !exists {
+        return notFound("rename", srcKey)
+    }
+    if srcKey == dstKey {
+        return nil
+    }
+    if !entry.dir {
+        if err := this.setFile(dstKey, entry, noReplace); err != nil {
+            return err
+        }
+        delete(this.entries, srcKey)
+        return nil
+    }
+
+    if len(srcKey) == 0 || strings.HasPrefix(dstKey + "/", srcKey + "/") {
+        return &os.PathError{ Op: "rename", Path: srcKey, Err: syscall.EINVAL }
+    }
+    keys := append([]string{ srcKey }, this.tree(srcKey)...)
+    for _, key := range keys {
+        if target, exists := this.entries[Join(dstKey, strings.TrimPrefix(key, srcKey))]; exists {
+            if !target.dir || !this.entries[key].dir {
+                return tools.ErrFileExists
+            }
+        }
+    }
+    if err := this.makeDir(Dir(dstKey)); err != nil {
+        return err
+    }
+    for _, key := range keys {
+        targetKey := Join(dstKey, strings.TrimPrefix(key, srcKey))
+        if _, exists := this.entries[targetKey]; !exists {
+            this.entries[targetKey] = this.entries[key]
+        }
+        delete(this.entries, key)
+    }
+    return nil
+}
+
+func NewMemory() *Memory {
+    return &Memory{
+        entries: map[string]*memoryEntry{
+            "": &memoryEntry{ dir: true, modTime: time.Now() },
+        },
+    }
+}
@@ -0,0 +1,306 @@
+package trashModel
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/jmoiron/sqlx"
+
+    "store/config"
+    "store/server/object-model"
+    "store/server/storage-backend"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS trash (
+        id          INTEGER PRIMARY KEY,
+        bucket      VARCHAR(1024) NOT NULL,
+        name        VARCHAR(1024) NOT NULL,
+        trashid     VARCHAR(32) NOT NULL UNIQUE,
+        size        INTEGER NOT NULL DEFAULT 0,
+        sha256      VARCHAR(64) NOT NULL DEFAULT '',
+        md5         VARCHAR(32) NOT NULL DEFAULT '',
+        deleted     INTEGER NOT NULL DEFAULT 0,
+        attributes  TEXT NOT NULL DEFAULT '',
+        owner       VARCHAR(255) NOT NULL DEFAULT ''
+    );`
+
+const trashDirName string = "trash"
+
+type Model struct {
+    config *config.Config
+    db *sqlx.DB
+    store storageBackend.Backend
+    objects *objectModel.Model
+}
+
+/* Deleted file kept in the trash of the bucket until expiration */
+type Item struct {
+    Id          int     `db:"id"         json:"-"`
+    Bucket      string  `db:"bucket"     json:"bucket"`
+    Name        string  `db:"name"       json:"name"`
+    TrashId     string  `db:"trashid"    json:"id"`
+    Size        int64   `db:"size"       json:"size"`
+    SHA256      string  `db:"sha256"     json:"sha256,omitempty"`
+    MD5         string  `db:"md5"        json:"md5,omitempty"`
+    Deleted     int64   `db:"deleted"    json:"deleted"`
+    Attributes  string  `db:"attributes" json:"-"`
+    Owner       string  `db:"owner"      json:"owner,omitempty"`
+}
+
+/* Trash ids are sorted by time */
+func newTrashId() (string, error) {
+    arr := make([]byte, 2)
+    if _, err := rand.Read(arr); err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(arr)), nil
+}
+
+func ValidTrashId(trashId string) bool {
+    if len(trashId) != 20 {
+        return false
+    }
+    _, err := hex.DecodeString(trashId)
+    return err == nil
+}
+
+func (this *Model) Migrate() error {
+    _, err := this.db.Exec(schema)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func (this *Model) dataKey(item Item) string {
+    return storageBackend.Join(config.SystemDirName, trashDirName, item.Bucket, item.TrashId)
+}
+
+/* Trash is disabled with zero expiration */
+func (this *Model) Enabled() bool {
+    return this.config.TrashExpire > 0
+}
+
+/* Move the file to the trash, without trash the file is removed */
+func (this *Model) Move(path string) error {
+    key := storageBackend.Key(path)
+    if !this.Enabled() {
+        if err := this.store.Delete(key); err != nil {
+            return err
+        }
+        this.objects.Delete(path)
+        return nil
+    }
+
+    fileInfo, err := this.store.Stat(key)
+    if err != nil {
+        return err
+    }
+    if !fileInfo.Mode().IsRegular() {
+        return errors.New("not a regular file")
+    }
+
+    var item Item
+    item.Bucket, item.Name = objectModel.Split(path)
+    item.TrashId, err = newTrashId()
+    if err != nil {
+        return err
+    }
+    item.Size = fileInfo.Size()
+    item.Deleted = time.Now().Unix()
+    if object, valid := this.objects.Stat(path, fileInfo); valid {
+        item.SHA256 = object.SHA256
+        item.MD5 = object.MD5
+    }
+    if object, err := this.objects.Find(path); err == nil {
+        item.Owner = object.Owner
+    }
+    /* Keep user metadata and tags for restore */
+    if attrs, err := this.objects.Attributes(path); err == nil {
+        data, _ := json.Marshal(attrs)
+        item.Attributes = string(data)
+    }
+
+    request := `INSERT INTO trash(bucket, name, trashid, size, sha256, md5, deleted, attributes, owner)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+    _, err = this.db.Exec(request, item.Bucket, item.Name, item.TrashId, item.Size,
+                                item.SHA256, item.MD5, item.Deleted, item.Attributes, item.Owner)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    if err := this.store.Move(key, this.dataKey(item), false); err != nil {
+        this.db.Exec(`DELETE FROM trash WHERE trashid = $1`, item.TrashId)
+        return err
+    }
+    this.objects.Delete(path)
+    return nil
+}
+
+/* List trash of the bucket, newest first */
+func (this *Model) List(bucket string) ([]Item, error) {
+    items := []Item{}
+    request := `SELECT * FROM trash WHERE bucket = $1 ORDER BY trashid DESC`
+    err := this.db.Select(&items, request, strings.Trim(bucket, "/"))
+    if err != nil {
+        log.Println(err)
+        return items, err
+    }
+    return items, nil
+}
+
+func (this *Model) Find(bucket, trashId string) (Item, error) {
+    var item Item
+    if !ValidTrashId(trashId) {
+        return item, errors.New("wrong trash id")
+    }
+    request := `SELECT * FROM trash WHERE bucket = $1 AND trashid = $2 LIMIT 1`
+    err := this.db.Get(&item, request, strings.Trim(bucket, "/"), trashId)
+    if err != nil {
+        return item, errors.New("trash item not found")
+    }
+    return item, nil
+}
+
+/* Move the item back to the bucket, existing file is not replaced */
+func (this *Model) Restore(bucket, trashId string) (Item, error) {
+    item, err := this.Find(bucket, trashId)
+    if err != nil {
+        return item, err
+    }
+    key := storageBackend.Join(item.Bucket, item.Name)
+    if err := this.store.Move(this.dataKey(item), key, true); err != nil {
+        return item, err
+    }
+    if err := this.remove(item); err != nil {
+        return item, err
+    }
+
+    fileInfo, err := this.store.Stat(key)
+    if err != nil {
+        return item, err
+    }
+    object := objectModel.Object{
+        Bucket: item.Bucket,
+        Name: item.Name,
+        Size: fileInfo.Size(),
+        Stored: objectModel.StoredSize(fileInfo),
+        ModTime: fileInfo.ModTime().UnixNano(),
+        SHA256: item.SHA256,
+        MD5: item.MD5,
+        Owner: item.Owner,
+    }
+    if err := this.objects.Save(object); err != nil {
+        return item, err
+    }
+    var attrs objectModel.Attributes
+    if err := json.Unmarshal([]byte(item.Attributes), &attrs); err == nil {
+        return item, this.objects.SetAttributes(key, attrs)
+    }
+    return item, nil
+}
+
+func (this *Model) remove(item Item) error {
+    request := `DELETE FROM trash WHERE id = $1`
+    _, err := this.db.Exec(request, item.Id)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Remove the item data permanently */
+func (this *Model) purge(item Item) error {
+    dataKey := this.dataKey(item)
+    if err := this.store.Delete(dataKey); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    /* Remove empty trash directory of the bucket */
+    this.store.Delete(storageBackend.Dir(dataKey))
+    return this.remove(item)
+}
+
+/* Remove all items of the bucket trash permanently */
+func (this *Model) Empty(bucket string) (int, error) {
+    items, err := this.List(bucket)
+    if err != nil {
+        return 0, err
+    }
+    for i, item := range items {
+        if err := this.purge(item); err != nil {
+            return i, err
+        }
+    }
+    return len(items), nil
+}
+
+/* Move trash of the bucket and nested buckets to the new name */
+func (this *Model) Rename(oldBucket, newBucket string) error {
+    oldBucket = strings.Trim(oldBucket, "/")
+    newBucket = strings.Trim(newBucket, "/")
+    trashDir := storageBackend.Join(config.SystemDirName, trashDirName)
+    err := this.store.Move(storageBackend.Join(trashDir, oldBucket), storageBackend.Join(trashDir, newBucket), false)
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    request := `UPDATE trash SET bucket = $1 || substr(bucket, length($2) + 1)
+                WHERE bucket = $2 OR substr(bucket, 1, length($2) + 1) = $2 || '/'`
+    if _, err := this.db.Exec(request, newBucket, oldBucket); err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Remove items deleted before the time */
+func (this *Model) Expire(before time.Time) (int, error) {
+    items := []Item{}
+    request := `SELECT * FROM trash WHERE deleted < $1`
+    if err := this.db.Select(&items, request, before.Unix()); err != nil {
+        log.Println(err)
+        return 0, err
+    }
+    for i, item := range items {
+        if err := this.purge(item); err != nil {
+            return i, err
+        }
+    }
+    return len(items), nil
+}
+
+/* Purge expired trash periodically */
+func (this *Model) Janitor(period time.Duration) {
+    for {
+        if this.Enabled() {
+            before := time.Now().AddDate(0, 0, -this.config.TrashExpire)
+            count, err := this.Expire(before)
+            if err != nil {
+                log.Println(err)
+            }
+            if count > 0 {
+                log.Printf("%d expired trash items are removed\n", count)
+            }
+        }
+        time.Sleep(period)
+    }
+}
+
+func New(config *config.Config, db *sqlx.DB) *Model {
+    model := Model{
+        config: config,
+        db: db,
+        store: storageBackend.Open(config, db),
+        objects: objectModel.New(db),
+    }
+    return &model
+}
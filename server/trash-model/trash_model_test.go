@@ -0,0 +1,132 @@
+package trashModel
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/jmoiron/sqlx"
+    _ "github.com/mattn/go-sqlite3"
+
+    "store/config"
+    "store/server/bucket-model"
+    "store/server/object-model"
+    "store/server/storage-backend"
+)
+
+/* Model over the memory store and the database in memory */
+func testModel(t *testing.T, expire int) *Model {
+    db, err := sqlx.Open("sqlite3", ":memory:")
+    if err != nil {
+        t.Fatal(err)
+    }
+    db.SetMaxOpenConns(1)
+    model := New(&config.Config{ Storage: storageBackend.StorageMemory, TrashExpire: expire }, db)
+    for _, migrate := range []func() error{ model.Migrate, model.objects.Migrate, bucketModel.New(db).Migrate } {
+        if err := migrate(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return model
+}
+
+func putFile(t *testing.T, model *Model, path, data string) {
+    if _, err := model.store.Put(path, strings.NewReader(data), false); err != nil {
+        t.Fatal(err)
+    }
+    fileInfo, _ := model.store.Stat(path)
+    hash, _ := objectModel.HashReader(strings.NewReader(data))
+    if _, err := model.objects.SaveFile(path, fileInfo, hash, "", "alice"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestTrash(t *testing.T) {
+    model := testModel(t, 7)
+    putFile(t, model, "docs/report.txt", "report")
+    model.objects.SetAttributes("docs/report.txt", objectModel.Attributes{ Meta: map[string]string{ "project": "x" } })
+
+    /* Deleted file is kept in the trash with metadata */
+    if err := model.Move("docs/report.txt"); err != nil {
+        t.Fatal(err)
+    }
+    if storageBackend.FileExists(model.store, "docs/report.txt") {
+        t.Error("deleted file is kept in the bucket")
+    }
+    if _, err := model.objects.Find("docs/report.txt"); err == nil {
+        t.Error("metadata of the deleted file is kept")
+    }
+    items, err := model.List("docs")
+    if err != nil || len(items) != 1 {
+        t.Fatalf("wrong trash %v %v", items, err)
+    }
+    item := items[0]
+    if item.Name != "report.txt" || item.Size != 6 || item.Owner != "alice" || len(item.SHA256) == 0 {
+        t.Errorf("wrong trash item %v", item)
+    }
+    if !storageBackend.FileExists(model.store, model.dataKey(item)) {
+        t.Error("data of the trash item is not kept")
+    }
+
+    /* Restore does not replace the file written since */
+    putFile(t, model, "docs/report.txt", "new report")
+    if _, err := model.Restore("docs", item.TrashId); err == nil {
+        t.Error("restore replaces the existing file")
+    }
+    model.store.Delete("docs/report.txt")
+    model.objects.Delete("docs/report.txt")
+    if _, err := model.Restore("docs", item.TrashId); err != nil {
+        t.Fatal(err)
+    }
+    if data, _ := storageBackend.ReadFile(model.store, "docs/report.txt"); string(data) != "report" {
+        t.Errorf("wrong restored data %q", data)
+    }
+    object, err := model.objects.Find("docs/report.txt")
+    if err != nil || object.Owner != "alice" || object.SHA256 != item.SHA256 {
+        t.Errorf("wrong metadata of the restored file %v %v", object, err)
+    }
+    if attrs, _ := model.objects.Attributes("docs/report.txt"); attrs.Meta["project"] != "x" {
+        t.Errorf("attributes are not restored %v", attrs)
+    }
+    if items, _ := model.List("docs"); len(items) != 0 {
+        t.Errorf("restored item is kept in the trash %v", items)
+    }
+    if _, err := model.Restore("docs", item.TrashId); err == nil {
+        t.Error("item is restored twice")
+    }
+
+    /* Empty and expiration remove the data */
+    model.Move("docs/report.txt")
+    items, _ = model.List("docs")
+    if count, err := model.Empty("docs"); err != nil || count != 1 {
+        t.Errorf("wrong count %d of emptied items %v", count, err)
+    }
+    if storageBackend.FileExists(model.store, model.dataKey(items[0])) {
+        t.Error("data of the emptied item is kept")
+    }
+    putFile(t, model, "docs/old.txt", "old")
+    model.Move("docs/old.txt")
+    if count, _ := model.Expire(time.Now().Add(-time.Hour)); count != 0 {
+        t.Errorf("fresh item is expired")
+    }
+    if count, _ := model.Expire(time.Now().Add(time.Second)); count != 1 {
+        t.Errorf("old item is not expired")
+    }
+    if items, _ := model.List("docs"); len(items) != 0 {
+        t.Errorf("expired item is kept %v", items)
+    }
+}
+
+func TestTrashDisabled(t *testing.T) {
+    model := testModel(t, 0)
+    putFile(t, model, "docs/report.txt", "report")
+    if err := model.Move("docs/report.txt"); err != nil {
+        t.Fatal(err)
+    }
+    if storageBackend.FileExists(model.store, "docs/report.txt") {
+        t.Error("deleted file is kept in the bucket")
+    }
+    if items, _ := model.List("docs"); len(items) != 0 {
+        t.Errorf("file is moved to disabled trash %v", items)
+    }
+}
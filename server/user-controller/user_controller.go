@@ -19,6 +19,8 @@ import (
     "store/server/user-model"
 )
 
+const minPasswordLength int = 5
+
 type UserController struct {
     config *config.Config
     db *sqlx.DB
@@ -161,9 +163,60 @@ func (this *UserController) Login(context *gin.Context) {
 
     //var list []userModel.User
     //list = append(list, user)
+    user.Password = ""
     sendResult(context, &user)
 }
 
+/* Return the session user, web UI use it for admin actions */
+func (this *UserController) Whoami(context *gin.Context) {
+    user := userModel.User{
+        Username: context.GetString("username"),
+    }
+    out, err := this.user.Find(user)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendResult(context, &out)
+}
+
+type passwordForm struct {
+    Password    string  `form:"password"    json:"password"    binding:"required"`
+    NewPassword string  `form:"newpassword" json:"newpassword" binding:"required"`
+}
+
+/* Change password of the session user */
+func (this *UserController) Password(context *gin.Context) {
+    var form passwordForm
+    err := context.ShouldBind(&form)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    if len(form.NewPassword) < minPasswordLength {
+        sendError(context, errors.New(fmt.Sprintf("password is less %d chars", minPasswordLength)))
+        return
+    }
+
+    user := userModel.User{
+        Username: context.GetString("username"),
+        Password: form.Password,
+    }
+    err = this.user.Check(&user)
+    if err != nil {
+        sendError(context, errors.New("current password is incorrect"))
+        return
+    }
+
+    user.Password = form.NewPassword
+    err = this.user.UpdatePassword(user)
+    if err != nil {
+        sendError(context, err)
+        return
+    }
+    sendOk(context)
+}
+
 func (this *UserController) Logout(context *gin.Context) {
     var user userModel.User
     var err error
@@ -132,15 +132,28 @@ func (this *Model) Create(user User) error {
     return nil
 }
 
+/* Delete the user with API and S3 keys of the user, ids of
+ * deleted users are reused, so keys must not outlive the user */
 func (this *Model) Delete(user User) error {
-    request := `DELETE FROM users WHERE id = $1`
-    _, err := this.db.Exec(request, user.Id)
+    tx, err := this.db.Begin()
     if err != nil {
         log.Println(err)
         return err
-
     }
-    return nil
+    for _, request := range []string{
+                `DELETE FROM apikeys WHERE userid = $1`,
+                `DELETE FROM s3keys WHERE userid = $1`,
+                `DELETE FROM users WHERE id = $1` } {
+        if _, err = tx.Exec(request, user.Id); err != nil {
+            break
+        }
+    }
+    if err != nil {
+        log.Println(err)
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit()
 }
 
 func (this *Model) Find(user User) (User, error) {
@@ -171,6 +184,23 @@ func (this *Model) Update(user User) error {
     return nil
 }
 
+func (this *Model) UpdatePassword(user User) error {
+    if len(user.Password) == 0 {
+        return errors.New("password is empty")
+    }
+    password, err := createHash(user.Password)
+    if err != nil {
+        return err
+    }
+    request := `UPDATE users SET password = $1 WHERE id = $2`
+    _, err = this.db.Exec(request, password, user.Id)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
 func (this *Model) Check(user *User) error {
     username := user.Username
     password := user.Password
@@ -0,0 +1,424 @@
+package versionModel
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/jmoiron/sqlx"
+
+    "store/config"
+    "store/server/bucket-model"
+    "store/server/object-model"
+    "store/server/storage-backend"
+    "store/server/trash-model"
+)
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS versions (
+        id          INTEGER PRIMARY KEY,
+        bucket      VARCHAR(1024) NOT NULL,
+        name        VARCHAR(1024) NOT NULL,
+        versionid   VARCHAR(32) NOT NULL,
+        size        INTEGER NOT NULL DEFAULT 0,
+        sha256      VARCHAR(64) NOT NULL DEFAULT '',
+        md5         VARCHAR(32) NOT NULL DEFAULT '',
+        deleted     BOOLEAN NOT NULL DEFAULT FALSE,
+        created     INTEGER NOT NULL DEFAULT 0,
+        UNIQUE (bucket, name, versionid)
+    );`
+
+const versionDirName string = "versions"
+
+type Model struct {
+    config *config.Config
+    db *sqlx.DB
+    store storageBackend.Backend
+    buckets *bucketModel.Model
+    objects *objectModel.Model
+    trash *trashModel.Model
+}
+
+/* Previous version of the file or delete marker. Created is the time
+ * when the version was replaced or deleted */
+type Version struct {
+    Id          int     `db:"id"         json:"-"`
+    Bucket      string  `db:"bucket"     json:"bucket"`
+    Name        string  `db:"name"       json:"name"`
+    VersionId   string  `db:"versionid"  json:"versionid"`
+    Size        int64   `db:"size"       json:"size"`
+    SHA256      string  `db:"sha256"     json:"sha256,omitempty"`
+    MD5         string  `db:"md5"        json:"md5,omitempty"`
+    Deleted     bool    `db:"deleted"    json:"deleted"`
+    Created     int64   `db:"created"    json:"created"`
+}
+
+/* Version ids are sorted by time */
+func newVersionId() (string, error) {
+    arr := make([]byte, 2)
+    if _, err := rand.Read(arr); err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(arr)), nil
+}
+
+func ValidVersionId(versionId string) bool {
+    if len(versionId) != 20 {
+        return false
+    }
+    _, err := hex.DecodeString(versionId)
+    return err == nil
+}
+
+/* Retention keeps last N versions or versions younger than D days,
+ * all versions are kept without retention */
+func Expired(bucket bucketModel.Bucket, index int, created int64, now time.Time) bool {
+    if bucket.KeepVersions == 0 && bucket.KeepDays == 0 {
+        return false
+    }
+    if bucket.KeepVersions > 0 && index < bucket.KeepVersions {
+        return false
+    }
+    if bucket.KeepDays > 0 && created > now.AddDate(0, 0, -bucket.KeepDays).Unix() {
+        return false
+    }
+    return true
+}
+
+func (this *Model) Migrate() error {
+    _, err := this.db.Exec(schema)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+func (this *Model) dataKey(version Version) string {
+    return storageBackend.Join(config.SystemDirName, versionDirName, version.Bucket, version.Name, version.VersionId)
+}
+
+/* Return true if versioning is enabled for the bucket of the store relative path */
+func (this *Model) Enabled(path string) bool {
+    bucket, _ := objectModel.Split(path)
+    return this.buckets.Settings(bucket).Versioning
+}
+
+func (this *Model) create(version *Version) error {
+    request := `INSERT INTO versions(bucket, name, versionid, size, sha256, md5, deleted, created)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+    result, err := this.db.Exec(request, version.Bucket, version.Name, version.VersionId, version.Size,
+                                version.SHA256, version.MD5, version.Deleted, version.Created)
+    var id int64
+    if err == nil {
+        id, err = result.LastInsertId()
+    }
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    version.Id = int(id)
+    return nil
+}
+
+func (this *Model) newVersion(path string) (Version, error) {
+    var version Version
+    var err error
+    version.Bucket, version.Name = objectModel.Split(path)
+    version.VersionId, err = newVersionId()
+    version.Created = time.Now().Unix()
+    return version, err
+}
+
+/* Copy current data of the file to the new version, false
+ * if versioning is disabled or the file does not exist */
+func (this *Model) archive(path string) (Version, bool, error) {
+    var version Version
+    if !this.Enabled(path) {
+        return version, false, nil
+    }
+    key := storageBackend.Key(path)
+    fileInfo, err := this.store.Stat(key)
+    if err != nil || !fileInfo.Mode().IsRegular() {
+        return version, false, nil
+    }
+    version, err = this.newVersion(path)
+    if err != nil {
+        return version, false, err
+    }
+    version.Size = fileInfo.Size()
+    if object, valid := this.objects.Stat(path, fileInfo); valid {
+        version.SHA256 = object.SHA256
+        version.MD5 = object.MD5
+    }
+    if err := this.store.Copy(key, this.dataKey(version), false); err != nil {
+        return version, false, err
+    }
+    if err := this.create(&version); err != nil {
+        this.store.Delete(this.dataKey(version))
+        return version, false, err
+    }
+    return version, true, nil
+}
+
+/* Keep current data of the file as a version before it is replaced.
+ * Does nothing if versioning is disabled or the file does not exist */
+func (this *Model) Archive(path string) error {
+    if _, archived, err := this.archive(path); err != nil || !archived {
+        return err
+    }
+    return this.Prune(path)
+}
+
+/* Keep current data of the files as versions and replace the files by
+ * commit. If commit fails the new versions are removed and older ones
+ * are not pruned, so the failed write leaves versions as they were */
+func (this *Model) Replace(commit func() error, paths ...string) error {
+    archived := make(map[string]Version)
+    var err error
+    for _, path := range paths {
+        version, ok, archiveErr := this.archive(path)
+        if err = archiveErr; err != nil {
+            break
+        }
+        if ok {
+            archived[path] = version
+        }
+    }
+    if err == nil {
+        err = commit()
+    }
+    if err != nil {
+        for _, version := range archived {
+            if purgeErr := this.purge(version); purgeErr != nil {
+                log.Println(purgeErr)
+            }
+        }
+        return err
+    }
+    for path := range archived {
+        if pruneErr := this.Prune(path); pruneErr != nil {
+            log.Println(pruneErr)
+        }
+    }
+    return nil
+}
+
+/* Move the file to the trash, with versioning the data is also kept
+ * and delete marker is created */
+func (this *Model) Remove(path string) error {
+    if this.Enabled(path) {
+        if err := this.Archive(path); err != nil {
+            return err
+        }
+        marker, err := this.newVersion(path)
+        if err != nil {
+            return err
+        }
+        marker.Deleted = true
+        if err := this.create(&marker); err != nil {
+            return err
+        }
+    }
+    return this.trash.Move(path)
+}
+
+/* List versions of the file, newest first */
+func (this *Model) List(path string) ([]Version, error) {
+    versions := []Version{}
+    bucket, name := objectModel.Split(path)
+    request := `SELECT * FROM versions WHERE bucket = $1 AND name = $2 ORDER BY versionid DESC`
+    err := this.db.Select(&versions, request, bucket, name)
+    if err != nil {
+        log.Println(err)
+        return versions, err
+    }
+    return versions, nil
+}
+
+func (this *Model) Find(path, versionId string) (Version, error) {
+    var version Version
+    if !ValidVersionId(versionId) {
+        return version, errors.New("wrong version id")
+    }
+    bucket, name := objectModel.Split(path)
+    request := `SELECT * FROM versions WHERE bucket = $1 AND name = $2 AND versionid = $3 LIMIT 1`
+    err := this.db.Get(&version, request, bucket, name, versionId)
+    if err != nil {
+        return version, errors.New("version not found")
+    }
+    return version, nil
+}
+
+/* Return store key of the version data */
+func (this *Model) Open(path, versionId string) (Version, string, error) {
+    version, err := this.Find(path, versionId)
+    if err != nil {
+        return version, "", err
+    }
+    if version.Deleted {
+        return version, "", errors.New("version is delete marker")
+    }
+    return version, this.dataKey(version), nil
+}
+
+/* Make the version current data of the file, current data is archived */
+func (this *Model) Restore(path, versionId string) error {
+    version, dataKey, err := this.Open(path, versionId)
+    if err != nil {
+        return err
+    }
+    tmpKey, err := storageBackend.TempKey("version-")
+    if err != nil {
+        return err
+    }
+    if err := this.store.Copy(dataKey, tmpKey, true); err != nil {
+        return err
+    }
+
+    key := storageBackend.Key(path)
+    err = this.Replace(func() error {
+        return this.store.Move(tmpKey, key, false)
+    }, path)
+    if err != nil {
+        this.store.Delete(tmpKey)
+        return err
+    }
+
+    fileInfo, err := this.store.Stat(key)
+    if err != nil {
+        return err
+    }
+    object := objectModel.Object{
+        Bucket: version.Bucket,
+        Name: version.Name,
+        Size: fileInfo.Size(),
+        Stored: objectModel.StoredSize(fileInfo),
+        ModTime: fileInfo.ModTime().UnixNano(),
+        SHA256: version.SHA256,
+        MD5: version.MD5,
+    }
+    return this.objects.Save(object)
+}
+
+func (this *Model) purge(version Version) error {
+    if !version.Deleted {
+        dataKey := this.dataKey(version)
+        if err := this.store.Delete(dataKey); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        /* Remove empty directory of the file versions */
+        this.store.Delete(storageBackend.Dir(dataKey))
+    }
+    request := `DELETE FROM versions WHERE id = $1`
+    _, err := this.db.Exec(request, version.Id)
+    if err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Remove the version, all versions of the file if version id is empty */
+func (this *Model) Purge(path, versionId string) (int, error) {
+    var versions []Version
+    var err error
+    if len(versionId) == 0 {
+        versions, err = this.List(path)
+    } else {
+        var version Version
+        version, err = this.Find(path, versionId)
+        versions = append(versions, version)
+    }
+    if err != nil {
+        return 0, err
+    }
+    for i, version := range versions {
+        if err := this.purge(version); err != nil {
+            return i, err
+        }
+    }
+    return len(versions), nil
+}
+
+/* Remove versions of the file expired by bucket retention */
+func (this *Model) Prune(path string) error {
+    bucket, _ := objectModel.Split(path)
+    settings := this.buckets.Settings(bucket)
+    if settings.KeepVersions == 0 && settings.KeepDays == 0 {
+        return nil
+    }
+    versions, err := this.List(path)
+    if err != nil {
+        return err
+    }
+    now := time.Now()
+    for i, version := range versions {
+        if !Expired(settings, i, version.Created, now) {
+            continue
+        }
+        if err := this.purge(version); err != nil {
+            return err
+        }
+        log.Printf("version %s of %s is expired and removed\n", version.VersionId, path)
+    }
+    return nil
+}
+
+/* Move versions of the bucket and nested buckets to the new name */
+func (this *Model) Rename(oldBucket, newBucket string) error {
+    oldBucket = strings.Trim(oldBucket, "/")
+    newBucket = strings.Trim(newBucket, "/")
+    versionDir := storageBackend.Join(config.SystemDirName, versionDirName)
+    err := this.store.Move(storageBackend.Join(versionDir, oldBucket), storageBackend.Join(versionDir, newBucket), false)
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    request := `UPDATE versions SET bucket = $1 || substr(bucket, length($2) + 1)
+                WHERE bucket = $2 OR substr(bucket, 1, length($2) + 1) = $2 || '/'`
+    if _, err := this.db.Exec(request, newBucket, oldBucket); err != nil {
+        log.Println(err)
+        return err
+    }
+    return nil
+}
+
+/* Apply retention to all versioned files */
+func (this *Model) PruneAll() {
+    var paths []Version
+    request := `SELECT DISTINCT bucket, name FROM versions`
+    if err := this.db.Select(&paths, request); err != nil {
+        log.Println(err)
+        return
+    }
+    for _, path := range paths {
+        if err := this.Prune(storageBackend.Join(path.Bucket, path.Name)); err != nil {
+            log.Println(err)
+        }
+    }
+}
+
+/* Run retention periodically */
+func (this *Model) Janitor(period time.Duration) {
+    for {
+        this.PruneAll()
+        time.Sleep(period)
+    }
+}
+
+func New(config *config.Config, db *sqlx.DB) *Model {
+    model := Model{
+        config: config,
+        db: db,
+        store: storageBackend.Open(config, db),
+        buckets: bucketModel.New(db),
+        objects: objectModel.New(db),
+        trash: trashModel.New(config, db),
+    }
+    return &model
+}
@@ -0,0 +1,51 @@
+package versionModel
+
+import (
+    "testing"
+    "time"
+
+    "store/server/bucket-model"
+)
+
+func TestVersionId(t *testing.T) {
+    first, err := newVersionId()
+    if err != nil {
+        t.Fatal(err)
+    }
+    time.Sleep(time.Millisecond)
+    second, _ := newVersionId()
+    if !ValidVersionId(first) || !ValidVersionId(second) {
+        t.Errorf("wrong version ids %s %s", first, second)
+    }
+    if second <= first {
+        t.Errorf("version ids are not sorted by time %s %s", first, second)
+    }
+    for _, versionId := range []string{ "", "../../etc/passwd", "0123456789abcdef012z" } {
+        if ValidVersionId(versionId) {
+            t.Errorf("wrong version id %s is valid", versionId)
+        }
+    }
+}
+
+func TestExpired(t *testing.T) {
+    now := time.Now()
+    old := now.AddDate(0, 0, -10).Unix()
+    fresh := now.Unix()
+
+    bucket := bucketModel.Bucket{ Versioning: true }
+    if Expired(bucket, 100, old, now) {
+        t.Error("version is expired without retention")
+    }
+    bucket.KeepVersions = 2
+    if Expired(bucket, 1, old, now) || !Expired(bucket, 2, old, now) {
+        t.Error("wrong retention by count")
+    }
+    bucket.KeepDays = 5
+    if Expired(bucket, 2, fresh, now) || !Expired(bucket, 2, old, now) {
+        t.Error("wrong retention by count or age")
+    }
+    bucket.KeepVersions = 0
+    if Expired(bucket, 0, fresh, now) || !Expired(bucket, 0, old, now) {
+        t.Error("wrong retention by age")
+    }
+}
@@ -0,0 +1,23 @@
+package tools
+
+import (
+    "github.com/jmoiron/sqlx"
+)
+
+/* Add the column missing in the table created by an earlier version,
+ * return true if the column is added */
+func AddColumn(db *sqlx.DB, table, column, definition string) (bool, error) {
+    var columns []struct {
+        Name    string  `db:"name"`
+    }
+    if err := db.Select(&columns, `SELECT name FROM pragma_table_info($1)`, table); err != nil {
+        return false, err
+    }
+    for _, item := range columns {
+        if item.Name == column {
+            return false, nil
+        }
+    }
+    _, err := db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+    return err == nil, err
+}
@@ -7,19 +7,20 @@ package tools
 import (
     "os"
     "fmt"
+    "io"
     "path/filepath"
     "strings"
     "io/ioutil"
     "errors"
 )
 
+var ErrFileExists = errors.New("file already exists")
+
 /* Return true if file exists and not directory */
 func FileExists(name string) bool {
     fi, err := os.Stat(name)
     if err != nil {
-        if os.IsNotExist(err) {
-            return false
-        }
+        return false
     }
     return !fi.IsDir()
 }
@@ -121,3 +122,188 @@ func BucketSizeGlob(directoryPath string, pattern string) (int64, error) {
     }
     return size, nil
 }
+
+/* Flush directory entries to disk */
+func SyncDir(path string) error {
+    dir, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer dir.Close()
+    return dir.Sync()
+}
+
+/* Create temporary file in the directory */
+func CreateTemp(tmpDir string) (*os.File, error) {
+    if err := os.MkdirAll(tmpDir, os.ModeDir | 0750); err != nil {
+        return nil, err
+    }
+    return ioutil.TempFile(tmpDir, "put-")
+}
+
+/* Move synced file to the file path by rename. With noReplace existing
+ * file is not overwritten and ErrFileExists is returned. Both paths
+ * must be on the same filesystem */
+func CommitFile(tmpPath, filePath string, noReplace bool) error {
+    dirPath := filepath.Dir(filePath)
+    if err := os.MkdirAll(dirPath, os.ModeDir | 0750); err != nil {
+        return err
+    }
+    if noReplace {
+        /* Link fails if the file exists */
+        if err := os.Link(tmpPath, filePath); err != nil {
+            if os.IsExist(err) {
+                return ErrFileExists
+            }
+            return err
+        }
+        os.Remove(tmpPath)
+    } else {
+        if err := os.Rename(tmpPath, filePath); err != nil {
+            return err
+        }
+    }
+    return SyncDir(dirPath)
+}
+
+/* Sync and close temporary file and move it to the file path,
+ * temporary file is removed on error */
+func CommitTemp(file *os.File, filePath string, noReplace bool) error {
+    err := file.Chmod(0640)
+    if err == nil {
+        err = file.Sync()
+    }
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = CommitFile(file.Name(), filePath, noReplace)
+    }
+    if err != nil {
+        os.Remove(file.Name())
+    }
+    return err
+}
+
+/* Write data to temporary file and move it to the file path,
+ * readers of the file path never see partially written data */
+func AtomicWrite(tmpDir, filePath string, reader io.Reader, noReplace bool) (int64, error) {
+    if noReplace && FileExists(filePath) {
+        return 0, ErrFileExists
+    }
+    file, err := CreateTemp(tmpDir)
+    if err != nil {
+        return 0, err
+    }
+    size, err := io.Copy(file, reader)
+    if err != nil {
+        file.Close()
+        os.Remove(file.Name())
+        return size, err
+    }
+    return size, CommitTemp(file, filePath, noReplace)
+}
+
+/* Remove all files in the directory */
+func CleanDir(path string) (int, error) {
+    files, err := ioutil.ReadDir(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return 0, nil
+        }
+        return 0, err
+    }
+    var count int
+    for _, file := range files {
+        if err := os.RemoveAll(filepath.Join(path, file.Name())); err != nil {
+            return count, err
+        }
+        count++
+    }
+    return count, nil
+}
+
+/* Move directory tree by rename, into existing destination
+ * directory entries are moved one by one */
+func MoveTree(srcPath, dstPath string) error {
+    srcInfo, err := os.Stat(srcPath)
+    if err != nil {
+        return err
+    }
+    dstInfo, err := os.Stat(dstPath)
+    if os.IsNotExist(err) {
+        if err := os.MkdirAll(filepath.Dir(dstPath), os.ModeDir | 0750); err != nil {
+            return err
+        }
+        return os.Rename(srcPath, dstPath)
+    }
+    if err != nil {
+        return err
+    }
+    if !srcInfo.IsDir() || !dstInfo.IsDir() {
+        return ErrFileExists
+    }
+    files, err := ioutil.ReadDir(srcPath)
+    if err != nil {
+        return err
+    }
+    for _, file := range files {
+        if err := MoveTree(filepath.Join(srcPath, file.Name()), filepath.Join(dstPath, file.Name())); err != nil {
+            return err
+        }
+    }
+    return os.Remove(srcPath)
+}
+
+/* Return count and total size of files in the directory */
+func BucketStat(directoryPath string) (int, int64, error) {
+    files, err := ioutil.ReadDir(directoryPath)
+    if err != nil {
+        return 0, 0, err
+    }
+    var count int
+    var size int64
+    for _, file := range files {
+        if file.Mode().IsRegular() {
+            count++
+            size += file.Size()
+        }
+    }
+    return count, size, nil
+}
+
+/* Make hard link of the file, copy the file if link is not possible */
+func LinkFile(srcPath, dstPath string) error {
+    if err := os.MkdirAll(filepath.Dir(dstPath), os.ModeDir | 0750); err != nil {
+        return err
+    }
+    if err := os.Link(srcPath, dstPath); err == nil {
+        return nil
+    } else if os.IsExist(err) {
+        return ErrFileExists
+    }
+
+    src, err := os.Open(srcPath)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+    dst, err := os.OpenFile(dstPath, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0640)
+    if err != nil {
+        if os.IsExist(err) {
+            return ErrFileExists
+        }
+        return err
+    }
+    _, err = io.Copy(dst, src)
+    if err == nil {
+        err = dst.Sync()
+    }
+    if closeErr := dst.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(dstPath)
+    }
+    return err
+}
@@ -0,0 +1,74 @@
+package tools
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestAtomicWrite(t *testing.T) {
+    baseDir, err := ioutil.TempDir("", "store-test")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(baseDir)
+    tmpDir := filepath.Join(baseDir, "tmp")
+    filePath := filepath.Join(baseDir, "bucket", "data.txt")
+
+    if _, err := AtomicWrite(tmpDir, filePath, strings.NewReader("first"), true); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := AtomicWrite(tmpDir, filePath, strings.NewReader("second"), true); err != ErrFileExists {
+        t.Errorf("existing file is overwritten in no replace mode: %v", err)
+    }
+    if _, err := AtomicWrite(tmpDir, filePath, strings.NewReader("third"), false); err != nil {
+        t.Fatal(err)
+    }
+    data, _ := ioutil.ReadFile(filePath)
+    if string(data) != "third" {
+        t.Errorf("wrong file data %s", data)
+    }
+
+    count, err := CleanDir(tmpDir)
+    if err != nil || count != 0 {
+        t.Errorf("temporary files are left: %d %v", count, err)
+    }
+}
+
+func TestMoveTree(t *testing.T) {
+    baseDir, err := ioutil.TempDir("", "store-test")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(baseDir)
+    srcDir := filepath.Join(baseDir, "src")
+    dstDir := filepath.Join(baseDir, "dst")
+    for _, path := range []string{ filepath.Join(srcDir, "a", "1"), filepath.Join(srcDir, "b"), filepath.Join(dstDir, "a", "2") } {
+        if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+            t.Fatal(err)
+        }
+        if err := ioutil.WriteFile(path, []byte(path), 0640); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if err := MoveTree(srcDir, dstDir); err != nil {
+        t.Fatal(err)
+    }
+    for _, name := range []string{ "a/1", "a/2", "b" } {
+        if _, err := os.Stat(filepath.Join(dstDir, name)); err != nil {
+            t.Errorf("%s is not moved: %s", name, err)
+        }
+    }
+    if _, err := os.Stat(srcDir); !os.IsNotExist(err) {
+        t.Errorf("source directory is not removed")
+    }
+
+    os.MkdirAll(filepath.Join(srcDir, "a"), 0750)
+    ioutil.WriteFile(filepath.Join(srcDir, "a", "1"), []byte("new"), 0640)
+    if err := MoveTree(srcDir, dstDir); err != ErrFileExists {
+        t.Errorf("existing file is replaced: %v", err)
+    }
+}